@@ -0,0 +1,256 @@
+package broker
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// NewAPI returns the HTTP handler for the broker: the Open Service Broker API
+// routes of brokerapi, plus the endpoints brokerapi does not provide.
+// All routes require basic auth, as the single platform user of brokerCredentials.
+func NewAPI(kBroker *KafkaServiceBroker, logger lager.Logger, brokerCredentials brokerapi.BrokerCredentials) (http.Handler, error) {
+	user, err := StaticAPIUser(brokerCredentials.Username, brokerCredentials.Password, RolePlatform)
+	if err != nil {
+		return nil, err
+	}
+	users, err := NewAPIUsers("", []APIUser{user}, logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewAPIWithUsers(kBroker, logger, users), nil
+}
+
+// NewAPIWithUsers returns the HTTP handler for the broker, for several users whose role grants
+// them access to some endpoints: the catalog and GET endpoints need the read permission, the
+// other Open Service Broker endpoints the write permission, and the admin endpoints, and those
+// returning secrets, the admin permission. The metrics of /debug/vars need the read permission.
+// Each request is logged with its user, and attributed to it in the audit log.
+func NewAPIWithUsers(kBroker *KafkaServiceBroker, logger lager.Logger, users *APIUsers) http.Handler {
+	handler := apiHandler{broker: kBroker, logger: logger}
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{instance_id}", handler.require(permissionRead, handler.getInstance)).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/consumer_groups", handler.require(permissionRead, handler.getConsumerGroups)).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/rotated_credentials", handler.require(permissionAdmin, handler.getRotatedCredentials)).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/rotate_credentials", handler.require(permissionAdmin, handler.rotateCredentials)).Methods("POST")
+	router.HandleFunc("/v2/service_instances/{instance_id}/reset_offsets", handler.require(permissionAdmin, handler.resetOffsets)).Methods("POST")
+	router.Handle("/debug/vars", handler.require(permissionRead, expvar.Handler().ServeHTTP)).Methods("GET")
+
+	osbRouter := mux.NewRouter()
+	brokerapi.AttachRoutes(osbRouter, kBroker, logger)
+	router.PathPrefix("/").Handler(handler.require(permissionRead, osbRouter.ServeHTTP)).Methods("GET")
+	router.PathPrefix("/").Handler(handler.require(permissionWrite, handler.retries(osbRouter.ServeHTTP)))
+
+	return handler.authenticate(users, router)
+}
+
+type apiHandler struct {
+	broker *KafkaServiceBroker
+	logger lager.Logger
+}
+
+// statusRecorder records the status of a response, for the request log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// authenticate lets through the requests of users, whom it adds to the request context with the
+// request ID, and logs them
+func (h apiHandler) authenticate(users *APIUsers, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := WithRequestID(req.Context(), requestID(req))
+		w.Header().Set(RequestIDHeader, RequestID(ctx))
+		logger := RequestLogger(ctx, h.logger)
+
+		username, password, ok := req.BasicAuth()
+		user, authenticated := users.Authenticate(username, password)
+		if !ok || !authenticated {
+			logger.Info("api-request-unauthorized", lager.Data{"user": username, "method": req.Method, "path": req.URL.Path})
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, req.WithContext(withAPIUser(ctx, user)))
+		logger.Info("api-request", lager.Data{
+			"user":   user.Username,
+			"role":   user.Role,
+			"method": req.Method,
+			"path":   req.URL.Path,
+			"status": recorder.status,
+		})
+	})
+}
+
+// require refuses the requests of users whose role does not grant them a permission
+func (h apiHandler) require(required permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, _ := req.Context().Value(apiUserKey{}).(APIUser)
+		if !user.allows(required) {
+			h.respond(w, http.StatusForbidden, brokerapi.ErrorResponse{
+				Description: fmt.Sprintf("user '%s' has role '%s', which does not have the %s permission", user.Username, user.Role, required),
+			})
+			return
+		}
+		next(w, req)
+	}
+}
+
+// retries lets the broker flag provision and bind requests that are identical retries, which are
+// responded to with 200 rather than brokerapi's 201
+func (h apiHandler) retries(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx, retry := withRetryFlag(req.Context())
+		next(&retryRecorder{ResponseWriter: w, retry: retry}, req.WithContext(ctx))
+	}
+}
+
+func (h apiHandler) getInstance(w http.ResponseWriter, req *http.Request) {
+	instanceID := mux.Vars(req)["instance_id"]
+	logger := RequestLogger(req.Context(), h.logger).Session("get-instance", lager.Data{"instance-id": instanceID})
+
+	instance, err := h.broker.GetInstance(req.Context(), instanceID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		h.respond(w, http.StatusNotFound, brokerapi.ErrorResponse{Description: err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("unknown-error", err)
+		h.respond(w, http.StatusInternalServerError, brokerapi.ErrorResponse{Description: err.Error()})
+		return
+	}
+	h.respond(w, http.StatusOK, instance)
+}
+
+func (h apiHandler) getConsumerGroups(w http.ResponseWriter, req *http.Request) {
+	instanceID := mux.Vars(req)["instance_id"]
+	logger := RequestLogger(req.Context(), h.logger).Session("get-consumer-groups", lager.Data{"instance-id": instanceID})
+
+	groups, err := h.broker.ConsumerGroupOffsets(req.Context(), instanceID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		h.respond(w, http.StatusNotFound, brokerapi.ErrorResponse{Description: err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("unknown-error", err)
+		h.respond(w, http.StatusInternalServerError, brokerapi.ErrorResponse{Description: err.Error()})
+		return
+	}
+	h.respond(w, http.StatusOK, map[string]interface{}{"consumer_groups": groups})
+}
+
+func (h apiHandler) getRotatedCredentials(w http.ResponseWriter, req *http.Request) {
+	instanceID := mux.Vars(req)["instance_id"]
+	logger := RequestLogger(req.Context(), h.logger).Session("get-rotated-credentials", lager.Data{"instance-id": instanceID})
+
+	rotations, err := h.broker.RotatedCredentials(req.Context(), instanceID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		h.respond(w, http.StatusNotFound, brokerapi.ErrorResponse{Description: err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("unknown-error", err)
+		h.respond(w, http.StatusInternalServerError, brokerapi.ErrorResponse{Description: err.Error()})
+		return
+	}
+	h.respond(w, http.StatusOK, map[string]interface{}{"bindings": rotations})
+}
+
+type rotateCredentialsRequest struct {
+	// GracePeriod is how long the replaced credentials stay valid, e.g. "1h"; it defaults to CREDENTIAL_ROTATION_GRACE_PERIOD
+	GracePeriod string `json:"grace_period"`
+}
+
+func (h apiHandler) rotateCredentials(w http.ResponseWriter, req *http.Request) {
+	instanceID := mux.Vars(req)["instance_id"]
+	logger := RequestLogger(req.Context(), h.logger).Session("rotate-credentials", lager.Data{"instance-id": instanceID, "user": apiUserName(req)})
+
+	body := rotateCredentialsRequest{}
+	if !h.decode(w, req, &body) {
+		return
+	}
+	gracePeriod := h.broker.Config.KafkaConfiguration.CredentialGracePeriod
+	if body.GracePeriod != "" {
+		var err error
+		if gracePeriod, err = time.ParseDuration(body.GracePeriod); err != nil {
+			h.respond(w, http.StatusBadRequest, brokerapi.ErrorResponse{Description: "grace_period must be a duration such as '1h'"})
+			return
+		}
+	}
+
+	rotations, err := h.broker.RotateCredentials(req.Context(), instanceID, gracePeriod, apiActor(req.Context()))
+	if err != nil {
+		h.respondError(w, logger, err)
+		return
+	}
+	h.respond(w, http.StatusOK, map[string]interface{}{"bindings": rotations})
+}
+
+func (h apiHandler) resetOffsets(w http.ResponseWriter, req *http.Request) {
+	instanceID := mux.Vars(req)["instance_id"]
+	logger := RequestLogger(req.Context(), h.logger).Session("reset-offsets", lager.Data{"instance-id": instanceID, "user": apiUserName(req)})
+
+	reset := OffsetReset{}
+	if !h.decode(w, req, &reset) {
+		return
+	}
+	offsets, err := h.broker.ResetOffsets(req.Context(), instanceID, reset, apiActor(req.Context()))
+	if err != nil {
+		h.respondError(w, logger, err)
+		return
+	}
+	h.respond(w, http.StatusOK, map[string]interface{}{"offsets": offsets})
+}
+
+// apiUserName returns the name of the user of a request, for its log entries
+func apiUserName(req *http.Request) string {
+	user, _ := req.Context().Value(apiUserKey{}).(APIUser)
+	return user.Username
+}
+
+// decode decodes the JSON body of a request, if it has one, and responds 400 if it cannot be
+func (h apiHandler) decode(w http.ResponseWriter, req *http.Request, body interface{}) bool {
+	if req.ContentLength == 0 {
+		return true
+	}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		h.respond(w, http.StatusBadRequest, brokerapi.ErrorResponse{Description: "invalid JSON body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// respondError responds with the status of a broker error
+func (h apiHandler) respondError(w http.ResponseWriter, logger lager.Logger, err error) {
+	if failure, ok := err.(*brokerapi.FailureResponse); ok {
+		logger.Error(failure.LoggerAction(), failure)
+		h.respond(w, failure.ValidatedStatusCode(logger), failure.ErrorResponse())
+		return
+	}
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		h.respond(w, http.StatusNotFound, brokerapi.ErrorResponse{Description: err.Error()})
+		return
+	}
+	logger.Error("unknown-error", err)
+	h.respond(w, http.StatusInternalServerError, brokerapi.ErrorResponse{Description: err.Error()})
+}
+
+func (h apiHandler) respond(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Error("encoding-response", err, lager.Data{"status": status, "response": response})
+	}
+}
@@ -0,0 +1,346 @@
+package broker_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+)
+
+type fakeOffsetReporter struct {
+	groups []broker.ConsumerGroupOffsets
+}
+
+func (fakeOffsetReporter *fakeOffsetReporter) ConsumerGroupOffsets(instanceID string) ([]broker.ConsumerGroupOffsets, error) {
+	return fakeOffsetReporter.groups, nil
+}
+
+var _ = Describe("API", func() {
+	var server *httptest.Server
+	var creator *fakeInstanceCreatorAndBinder
+	var kafkaBroker *broker.KafkaServiceBroker
+
+	BeforeEach(func() {
+		unsetBrokerEnvironment()
+		creator = &fakeInstanceCreatorAndBinder{createdInstanceIds: []string{"instanceID"}}
+		kafkaBroker = &broker.KafkaServiceBroker{
+			InstanceCreators: withDefaultPlans(map[string]broker.InstanceCreator{"topic": creator}, creator),
+			InstanceBinders:  map[string]broker.InstanceBinder{"topic": creator},
+			QuotaManager: &fakeQuotaManager{
+				instanceQuotas: map[string]broker.Quota{"instanceID": {ProducerByteRate: 1024}},
+				bindings:       map[string][]string{},
+			},
+			CredentialRotator: &fakeCredentialRotator{},
+			OffsetReporter: &fakeOffsetReporter{groups: []broker.ConsumerGroupOffsets{{
+				Group:      "instanceID.bindingID",
+				Partitions: []broker.PartitionOffsets{{Topic: "instanceID", Partition: 0, Offset: 10}},
+			}}},
+		}
+		Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+		credentials := brokerapi.BrokerCredentials{Username: "broker", Password: "password"}
+		api, err := broker.NewAPI(kafkaBroker, lager.NewLogger("test"), credentials)
+		Expect(err).NotTo(HaveOccurred())
+		server = httptest.NewServer(api)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(path, password string) *http.Response {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth("broker", password)
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return resp
+	}
+
+	It("serves the brokerapi routes", func() {
+		resp := get("/v2/catalog", "password")
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("requires basic auth", func() {
+		resp := get("/v2/service_instances/instanceID", "wrong")
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("serves the metrics of /debug/vars to authenticated users only", func() {
+		resp := get("/debug/vars", "wrong")
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+		resp = get("/debug/vars", "password")
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		vars := map[string]interface{}{}
+		Expect(json.NewDecoder(resp.Body).Decode(&vars)).To(Succeed())
+		Expect(vars).To(HaveKey("config_reloads"))
+	})
+
+	It("identifies each request, keeping the identity the platform gives", func() {
+		req, err := http.NewRequest("GET", server.URL+"/v2/catalog", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth("broker", "password")
+		req.Header.Set(broker.RequestIDHeader, "platform-request")
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.Header.Get(broker.RequestIDHeader)).To(Equal("platform-request"))
+
+		resp = get("/v2/catalog", "password")
+		resp.Body.Close()
+		Expect(resp.Header.Get(broker.RequestIDHeader)).To(MatchRegexp("^[0-9a-f]{32}$"))
+	})
+
+	Describe("GET /v2/service_instances/:instance_id", func() {
+		It("returns the parameters of the instance", func() {
+			resp := get("/v2/service_instances/instanceID", "password")
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var instance struct {
+				Parameters map[string]map[string]interface{} `json:"parameters"`
+			}
+			Expect(json.NewDecoder(resp.Body).Decode(&instance)).To(Succeed())
+			Expect(instance.Parameters["quota"]).To(HaveKeyWithValue("producer_byte_rate", BeNumerically("==", 1024)))
+		})
+
+		It("returns 404 for an unknown instance", func() {
+			resp := get("/v2/service_instances/unknown", "password")
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("GET /v2/service_instances/:instance_id/consumer_groups", func() {
+		It("returns the consumer groups of the instance", func() {
+			resp := get("/v2/service_instances/instanceID/consumer_groups", "password")
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var body struct {
+				ConsumerGroups []broker.ConsumerGroupOffsets `json:"consumer_groups"`
+			}
+			Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+			Expect(body.ConsumerGroups).To(HaveLen(1))
+			Expect(body.ConsumerGroups[0].Group).To(Equal("instanceID.bindingID"))
+			Expect(body.ConsumerGroups[0].Partitions[0].Offset).To(Equal(int64(10)))
+		})
+
+		It("returns 404 for an unknown instance", func() {
+			resp := get("/v2/service_instances/unknown/consumer_groups", "password")
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("GET /v2/service_instances/:instance_id/rotated_credentials", func() {
+		It("returns the latest rotated credentials of each binding", func() {
+			resp := get("/v2/service_instances/instanceID/rotated_credentials", "password")
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var body struct {
+				Bindings []broker.RotatedCredentials `json:"bindings"`
+			}
+			Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+			Expect(body.Bindings).To(HaveLen(1))
+			Expect(body.Bindings[0].BindingID).To(Equal("bindingID"))
+			Expect(body.Bindings[0].SASL.Password).To(Equal("new secret"))
+		})
+
+		It("returns 404 for an unknown instance", func() {
+			resp := get("/v2/service_instances/unknown/rotated_credentials", "password")
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("retries", func() {
+		put := func(path, body string) int {
+			req, err := http.NewRequest("PUT", server.URL+path, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth("broker", "password")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			_ = resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		BeforeEach(func() {
+			creator.createdInstanceIds = nil
+			kafkaBroker.RequestRecorder = &fakeRequestRecorder{
+				instances: map[string]broker.InstanceAttributes{},
+				bindings:  map[string]broker.BindingAttributes{},
+			}
+			kafkaBroker.SecretStore = &fakeSecretStore{secrets: map[string][]byte{}, references: map[string]string{}}
+		})
+
+		It("responds 201 to new instances and bindings, 200 to identical retries and 409 to conflicts", func() {
+			provision := `{"service_id": "serviceID", "plan_id": "4820d23c-360a-11e7-9547-d78770a33c5b", "space_guid": "space"}`
+			Expect(put("/v2/service_instances/instanceID", provision)).To(Equal(http.StatusCreated))
+			Expect(put("/v2/service_instances/instanceID", provision)).To(Equal(http.StatusOK))
+			Expect(put("/v2/service_instances/instanceID", `{"service_id": "serviceID", "plan_id": "4820d23c-360a-11e7-9547-d78770a33c5b", "space_guid": "other"}`)).To(Equal(http.StatusConflict))
+
+			bind := `{"service_id": "serviceID", "plan_id": "4820d23c-360a-11e7-9547-d78770a33c5b", "app_guid": "app"}`
+			Expect(put("/v2/service_instances/instanceID/service_bindings/bindingID", bind)).To(Equal(http.StatusCreated))
+			Expect(put("/v2/service_instances/instanceID/service_bindings/bindingID", bind)).To(Equal(http.StatusOK))
+			Expect(put("/v2/service_instances/instanceID/service_bindings/bindingID", `{"service_id": "serviceID", "plan_id": "4820d23c-360a-11e7-9547-d78770a33c5b", "app_guid": "other"}`)).To(Equal(http.StatusConflict))
+			Expect(put("/v2/service_instances/instanceID/service_bindings/otherBindingID", bind)).To(Equal(http.StatusCreated))
+		})
+	})
+
+	Describe("users and roles", func() {
+		var dir string
+		var usersFile string
+		var users *broker.APIUsers
+		var auditLog *fakeAuditLog
+		var logs *bytes.Buffer
+
+		writeUsers := func(users ...broker.APIUser) {
+			data, err := json.Marshal(map[string]interface{}{"users": users})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(usersFile+".tmp", data, 0600)).To(Succeed())
+			Expect(os.Rename(usersFile+".tmp", usersFile)).To(Succeed())
+		}
+
+		user := func(username, role string, passwords ...string) broker.APIUser {
+			hashes := []string{}
+			for _, password := range passwords {
+				hash, err := broker.HashPassword(password)
+				Expect(err).NotTo(HaveOccurred())
+				hashes = append(hashes, hash)
+			}
+			return broker.APIUser{Username: username, PasswordHashes: hashes, Role: role}
+		}
+
+		request := func(method, path, username, password, body string) int {
+			req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth(username, password)
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			_ = resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "users")
+			Expect(err).NotTo(HaveOccurred())
+			usersFile = filepath.Join(dir, "users.json")
+			writeUsers(
+				user("cf", broker.RolePlatform, "cf-password"),
+				user("ops", broker.RoleOps, "ops-password", "new-ops-password"),
+				user("viewer", broker.RoleReadOnly, "viewer-password"),
+			)
+			logs = &bytes.Buffer{}
+			logger := lager.NewLogger("test")
+			logger.RegisterSink(lager.NewWriterSink(logs, lager.INFO))
+			users, err = broker.NewAPIUsers(usersFile, nil, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			auditLog = &fakeAuditLog{}
+			kafkaBroker.AuditLog = auditLog
+			server.Close()
+			server = httptest.NewServer(broker.NewAPIWithUsers(kafkaBroker, logger, users))
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("lets read-only users read the catalog and service instances, but no secrets", func() {
+			Expect(request("GET", "/v2/catalog", "viewer", "viewer-password", "")).To(Equal(http.StatusOK))
+			Expect(request("GET", "/v2/service_instances/instanceID", "viewer", "viewer-password", "")).To(Equal(http.StatusOK))
+			Expect(request("GET", "/v2/service_instances/instanceID/consumer_groups", "viewer", "viewer-password", "")).To(Equal(http.StatusOK))
+			Expect(request("GET", "/debug/vars", "viewer", "viewer-password", "")).To(Equal(http.StatusOK))
+			Expect(request("GET", "/v2/service_instances/instanceID/rotated_credentials", "viewer", "viewer-password", "")).To(Equal(http.StatusForbidden))
+			Expect(request("POST", "/v2/service_instances/instanceID/rotate_credentials", "viewer", "viewer-password", "")).To(Equal(http.StatusForbidden))
+			Expect(request("DELETE", "/v2/service_instances/instanceID?plan_id=plan&service_id=service", "viewer", "viewer-password", "")).To(Equal(http.StatusForbidden))
+			Expect(creator.destroyedInstanceIds).To(BeEmpty())
+		})
+
+		It("lets ops users call the admin endpoints, attributing them to the user", func() {
+			Expect(request("GET", "/v2/service_instances/instanceID/rotated_credentials", "ops", "ops-password", "")).To(Equal(http.StatusOK))
+			Expect(request("POST", "/v2/service_instances/instanceID/rotate_credentials", "ops", "ops-password", `{"grace_period": "1h"}`)).To(Equal(http.StatusOK))
+			Expect(auditLog.entries).To(HaveLen(1))
+			Expect(auditLog.entries[0].Actor).To(Equal("broker-api:ops"))
+			Expect(auditLog.entries[0].Details["grace_period"]).To(Equal("1h0m0s"))
+			Expect(logs.String()).To(ContainSubstring(`"user":"ops"`))
+
+			Expect(request("POST", "/v2/service_instances/instanceID/rotate_credentials", "ops", "ops-password", `{"grace_period": "soon"}`)).To(Equal(http.StatusBadRequest))
+			Expect(request("POST", "/v2/service_instances/instanceID/reset_offsets", "ops", "ops-password", `{"group": "other"}`)).To(Equal(http.StatusBadRequest))
+			Expect(request("DELETE", "/v2/service_instances/instanceID?plan_id=plan&service_id=service", "ops", "ops-password", "")).To(Equal(http.StatusForbidden))
+		})
+
+		It("lets the platform call every endpoint", func() {
+			Expect(request("GET", "/v2/service_instances/instanceID/rotated_credentials", "cf", "cf-password", "")).To(Equal(http.StatusOK))
+			Expect(request("DELETE", "/v2/service_instances/instanceID?plan_id=4820d23c-360a-11e7-9547-d78770a33c5b&service_id=service", "cf", "cf-password", "")).To(Equal(http.StatusOK))
+			Expect(creator.destroyedInstanceIds).To(ConsistOf("instanceID"))
+		})
+
+		It("accepts every password of a user while it is rotated, and reloads the users file", func() {
+			Expect(request("GET", "/v2/catalog", "ops", "ops-password", "")).To(Equal(http.StatusOK))
+			Expect(request("GET", "/v2/catalog", "ops", "new-ops-password", "")).To(Equal(http.StatusOK))
+			Expect(request("GET", "/v2/catalog", "ops", "wrong", "")).To(Equal(http.StatusUnauthorized))
+			Expect(request("GET", "/v2/catalog", "nobody", "ops-password", "")).To(Equal(http.StatusUnauthorized))
+
+			writeUsers(user("ops", broker.RoleOps, "new-ops-password"))
+			Expect(os.Chtimes(usersFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))).To(Succeed())
+			Expect(users.Reload()).To(BeTrue())
+			Expect(request("GET", "/v2/catalog", "ops", "ops-password", "")).To(Equal(http.StatusUnauthorized))
+			Expect(request("GET", "/v2/catalog", "ops", "new-ops-password", "")).To(Equal(http.StatusOK))
+			Expect(request("GET", "/v2/catalog", "cf", "cf-password", "")).To(Equal(http.StatusUnauthorized))
+			Expect(users.Reload()).To(BeFalse())
+		})
+
+		It("refuses unknown usernames as slowly as wrong passwords, and wrong passwords retried quickly", func() {
+			timed := func(username, password string) time.Duration {
+				start := time.Now()
+				_, ok := users.Authenticate(username, password)
+				Expect(ok).To(BeFalse())
+				return time.Since(start)
+			}
+			wrong := timed("viewer", "wrong")
+			Expect(timed("nobody", "viewer-password")).To(BeNumerically(">", wrong/10))
+			Expect(timed("viewer", "wrong")).To(BeNumerically("<", wrong/10))
+			Expect(timed("nobody", "viewer-password")).To(BeNumerically("<", wrong/10))
+
+			user, ok := users.Authenticate("viewer", "viewer-password")
+			Expect(ok).To(BeTrue())
+			Expect(user.Username).To(Equal("viewer"))
+		})
+
+		It("keeps the previous users when the users file cannot be loaded", func() {
+			writeUsers(broker.APIUser{Username: "ops", PasswordHashes: []string{"plain"}, Role: broker.RoleOps})
+			Expect(os.Chtimes(usersFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))).To(Succeed())
+			_, err := users.Reload()
+			Expect(err).To(MatchError(ContainSubstring("user 'ops': password hashes must be made with 'hash-password'")))
+			Expect(request("GET", "/v2/catalog", "viewer", "viewer-password", "")).To(Equal(http.StatusOK))
+
+			writeUsers(user("ops", "admin", "password"))
+			_, err = broker.NewAPIUsers(usersFile, nil, lager.NewLogger("test"))
+			Expect(err).To(MatchError("user 'ops' must have role 'platform', 'ops' or 'read-only', not 'admin'"))
+			writeUsers(user("ops", broker.RoleOps, "password"), user("ops", broker.RoleReadOnly, "password"))
+			_, err = broker.NewAPIUsers(usersFile, nil, lager.NewLogger("test"))
+			Expect(err).To(MatchError("user 'ops' is defined more than once"))
+		})
+	})
+})
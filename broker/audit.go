@@ -0,0 +1,42 @@
+package broker
+
+import (
+	"context"
+	"time"
+)
+
+// AuditEntry records an operation that changed the state of a service instance outside
+// of the usual provision, bind, unbind and deprovision requests
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	InstanceID string    `json:"instance_id"`
+	// Actor is who asked for the operation, e.g. the broker API or an admin command
+	Actor   string                 `json:"actor"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// AuditLog keeps a durable record of audited operations
+type AuditLog interface {
+	Record(entry AuditEntry) error
+}
+
+// Actors recorded in audit entries
+const (
+	// ActorBrokerAPI is a request to the broker API, e.g. update parameters; it is followed by
+	// the name of the user who made it, e.g. broker-api:cf
+	ActorBrokerAPI = "broker-api"
+	// ActorCredentialRotation is the broker revoking the credentials replaced by a rotation once their grace period ends
+	ActorCredentialRotation = "credential-rotation"
+)
+
+// audit records entry in the audit log, if the broker has one
+func (kBroker *KafkaServiceBroker) audit(ctx context.Context, entry AuditEntry) error {
+	if kBroker.AuditLog == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	return withContext(ctx, kBroker.AuditLog).Record(entry)
+}
@@ -2,11 +2,16 @@ package broker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"sync/atomic"
 
+	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/wvanbergen/kazoo-go"
 )
 
 type InstanceCredentials struct {
@@ -14,14 +19,31 @@ type InstanceCredentials struct {
 	KafkaHostnames  string
 	TopicName       string
 	TopicNamePrefix string
+	// TopicCleanupPolicy is set for topics that are not simply deleted after their retention, e.g. "compact"
+	TopicCleanupPolicy string
+	// Topics maps the logical names of a set of topics to their names on the Kafka cluster
+	Topics map[string]string
+	// RetryTopics and DeadLetterTopic name the companion topics of the instance's topic, if it has any
+	RetryTopics     []string
+	DeadLetterTopic string
 }
 
+// InstanceSettings are what a new service instance is created with, from its plan and the provision parameters
+type InstanceSettings struct {
+	TopicConfig map[string]string
+	// Topics is the set of topics requested with the "topics" parameter, for plans that implement TopicSetUpdater
+	Topics []TopicSpec
+}
+
+// InstanceCreator creates the service instances of a plan. It may also implement ContextInstanceCreator.
 type InstanceCreator interface {
-	Create(instanceID string) error
+	// Create creates the topics of a service instance
+	Create(instanceID string, settings InstanceSettings) error
 	Destroy(instanceID string) error
 	InstanceExists(instanceID string) (bool, error)
 }
 
+// InstanceBinder creates the bindings of a plan. It may also implement ContextInstanceBinder.
 type InstanceBinder interface {
 	Bind(instanceID string, bindingID string) (InstanceCredentials, error)
 	Unbind(instanceID string, bindingID string) error
@@ -31,8 +53,37 @@ type InstanceBinder interface {
 type KafkaServiceBroker struct {
 	InstanceCreators map[string]InstanceCreator
 	InstanceBinders  map[string]InstanceBinder
-	Config           brokerconfig.Config
-	catalog          *Catalog
+	// QuotaManager is optional; without it no client quotas are applied
+	QuotaManager QuotaManager
+	// LimitManager is optional; without it no topic limits are recorded
+	LimitManager LimitManager
+	// TopicConfigManager is optional; without it topic configuration is not checked after creation
+	TopicConfigManager TopicConfigManager
+	// ConsumerGroupManager is optional; without it bindings are not given a consumer group
+	ConsumerGroupManager ConsumerGroupManager
+	// SASLManager is optional; without it bindings are not given SASL credentials
+	SASLManager SASLManager
+	// CertificateIssuer is optional; without it bindings are not issued client certificates
+	CertificateIssuer CertificateIssuer
+	// CredentialRotator is optional; without it the credentials of bindings cannot be rotated
+	CredentialRotator CredentialRotator
+	// OffsetReporter is optional; without it no consumer groups are reported
+	OffsetReporter OffsetReporter
+	// OffsetResetter is optional; without it the offsets of consumer groups cannot be reset
+	OffsetResetter OffsetResetter
+	// SecretStore is optional; without it bindings are always given their credentials, rather than
+	// a reference to them, and rotated credentials are kept in the broker's private records
+	SecretStore SecretStore
+	// AuditLog is optional; without it audited operations are not recorded
+	AuditLog AuditLog
+	// RequestRecorder is optional; without it provisioning an existing instance is always a conflict,
+	// and binding an existing binding ID binds it again, as it does without a SecretStore
+	RequestRecorder RequestRecorder
+	// Logger is optional; without it nothing is logged
+	Logger lager.Logger
+	Config brokerconfig.Config
+	// catalog holds the *Catalog, swapped atomically when it is reloaded
+	catalog atomic.Value
 }
 
 // Services returns the /v2/catalog service catalog
@@ -45,8 +96,12 @@ func (kBroker *KafkaServiceBroker) Services(ctx context.Context) []brokerapi.Ser
 func (kBroker *KafkaServiceBroker) Provision(ctx context.Context, instanceID string, serviceDetails brokerapi.ProvisionDetails, asyncAllowed bool) (spec brokerapi.ProvisionedServiceSpec, err error) {
 	spec = brokerapi.ProvisionedServiceSpec{}
 
-	if kBroker.instanceExists(instanceID) {
-		return spec, brokerapi.ErrInstanceAlreadyExists
+	instanceExists := kBroker.instanceExists(ctx, instanceID)
+	if instanceExists {
+		provisioned, err := kBroker.checkProvisionRetry(ctx, instanceID, serviceDetails)
+		if err != nil || provisioned {
+			return spec, err
+		}
 	}
 
 	if serviceDetails.PlanID == "" {
@@ -63,18 +118,196 @@ func (kBroker *KafkaServiceBroker) Provision(ctx context.Context, instanceID str
 		return spec, errors.New("instance creator not found for plan")
 	}
 
-	err = instanceCreator.Create(instanceID)
+	planSettings := kBroker.Catalog().Plans[planIdentifier]
+	quotaParams := Quota{}
+	if err = parseParameters(serviceDetails.RawParameters, &quotaParams); err != nil {
+		return spec, err
+	}
+	quota, err := planSettings.Quota.Override(quotaParams)
+	if err != nil {
+		return spec, invalidParameters(err)
+	}
+	limitParams := TopicLimits{}
+	if err = parseParameters(serviceDetails.RawParameters, &limitParams); err != nil {
+		return spec, err
+	}
+	limits, err := planSettings.TopicLimits.Override(limitParams)
+	if err != nil {
+		return spec, invalidParameters(err)
+	}
+	topicConfigParams, err := parseTopicConfig(serviceDetails.RawParameters)
 	if err != nil {
 		return spec, err
 	}
+	topicConfig, err := planSettings.TopicConfig.Configure(topicConfigParams)
+	if err != nil {
+		return spec, invalidParameters(err)
+	}
+	topics, err := parseTopicSet(serviceDetails.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+	if _, ok := instanceCreator.(TopicSetUpdater); topics != nil && !ok {
+		return spec, invalidParameters(fmt.Errorf("the '%s' plan does not take a 'topics' parameter", planIdentifier))
+	}
+	companions, err := parseCompanionTopics(planSettings.CompanionTopics, serviceDetails.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+	companionTopicCreator, ok := instanceCreator.(CompanionTopicCreator)
+	if !companions.IsZero() && !ok {
+		return spec, invalidParameters(fmt.Errorf("the '%s' plan does not create retry or dead letter topics", planIdentifier))
+	}
+	if !companions.IsZero() {
+		if err = companions.CheckTopicConfig(planSettings.TopicConfig); err != nil {
+			return spec, invalidParameters(err)
+		}
+	}
+
+	// once the instance is being created, the request runs to completion even if it is cancelled
+	if ctx, err = detach(ctx); err != nil {
+		return spec, err
+	}
+	// the attributes are recorded before anything is created, so that an identical retry of a
+	// provision that failed part way completes it, rather than conflicting with its instance, and
+	// so that of concurrent requests for the same instance ID, only identical ones go on
+	attributes := provisionAttributes(serviceDetails)
+	recorded := false
+	if kBroker.RequestRecorder != nil {
+		attributes.Incomplete = true
+		existing, err := withContext(ctx, kBroker.RequestRecorder).CreateInstanceAttributes(instanceID, attributes)
+		if err != nil {
+			return spec, err
+		}
+		if existing != nil && !existing.Matches(attributes) {
+			return spec, brokerapi.ErrInstanceAlreadyExists
+		}
+		recorded = existing == nil
+	}
+	if !instanceExists {
+		err = createInstance(ctx, instanceCreator, ProvisionRequest{
+			InstanceID: instanceID,
+			Plan:       planIdentifier,
+			Settings:   InstanceSettings{TopicConfig: topicConfig, Topics: topics},
+			Details:    serviceDetails,
+		})
+		if err == kazoo.ErrTopicExists {
+			// created by a concurrent request
+			return spec, brokerapi.ErrInstanceAlreadyExists
+		}
+		if err != nil {
+			if recorded {
+				kBroker.forgetProvision(ctx, instanceID)
+			}
+			return spec, err
+		}
+	}
+	if !companions.IsZero() {
+		if err = withContext(ctx, companionTopicCreator).CreateCompanionTopics(instanceID, companions); err != nil {
+			rollbackErr := destroyInstance(ctx, instanceCreator, DeprovisionRequest{InstanceID: instanceID, Plan: planIdentifier})
+			if rollbackErr != nil {
+				kBroker.logger(ctx).Error("provision-instance.rollback", rollbackErr, lager.Data{
+					"instance-id": instanceID,
+					"message":     "Failed to destroy the service instance whose companion topics could not be created",
+				})
+			} else if kBroker.RequestRecorder != nil {
+				kBroker.forgetProvision(ctx, instanceID)
+			}
+			return spec, err
+		}
+	}
+
+	if kBroker.QuotaManager != nil {
+		if err = withContext(ctx, kBroker.QuotaManager).SetInstanceQuota(instanceID, quota); err != nil {
+			return spec, err
+		}
+	}
+	if kBroker.LimitManager != nil && !limits.IsZero() {
+		if err = withContext(ctx, kBroker.LimitManager).SetInstanceLimits(instanceID, limits); err != nil {
+			return spec, err
+		}
+	}
+	if kBroker.TopicConfigManager != nil && !planSettings.TopicConfig.IsZero() {
+		policy := planSettings.TopicConfig
+		if defaulter, ok := instanceCreator.(TopicConfigDefaulter); ok {
+			policy = policy.WithDefaults(defaulter.TopicConfigDefaults())
+		}
+		if err = withContext(ctx, kBroker.TopicConfigManager).SetInstanceTopicConfigPolicy(instanceID, policy); err != nil {
+			return spec, err
+		}
+	}
+	if kBroker.RequestRecorder != nil {
+		attributes.Incomplete = false
+		if err = withContext(ctx, kBroker.RequestRecorder).SetInstanceAttributes(instanceID, attributes); err != nil {
+			return spec, err
+		}
+	}
 
 	return spec, nil
 }
 
+// checkProvisionRetry returns true, and flags the request as a retry, if the request to provision
+// an existing instance is identical to the one that provisioned it, and false if it is identical
+// to one that failed part way, which the request then completes. It returns
+// ErrInstanceAlreadyExists if the instance was provisioned by another request.
+func (kBroker *KafkaServiceBroker) checkProvisionRetry(ctx context.Context, instanceID string, details brokerapi.ProvisionDetails) (bool, error) {
+	if kBroker.RequestRecorder == nil {
+		return false, brokerapi.ErrInstanceAlreadyExists
+	}
+	attributes, err := withContext(ctx, kBroker.RequestRecorder).InstanceAttributes(instanceID)
+	if err != nil {
+		return false, err
+	}
+	if attributes == nil || !attributes.Matches(provisionAttributes(details)) {
+		return false, brokerapi.ErrInstanceAlreadyExists
+	}
+	if attributes.Incomplete {
+		kBroker.logger(ctx).Info("provision-resume", lager.Data{
+			"instance-id": instanceID,
+			"message":     "The service instance was partly provisioned by an identical request; completing it",
+		})
+		return false, nil
+	}
+	kBroker.logger(ctx).Info("provision-retry", lager.Data{
+		"instance-id": instanceID,
+		"message":     "The service instance was already provisioned by an identical request",
+	})
+	flagRetry(ctx)
+	return true, nil
+}
+
+// forgetProvision removes the attributes recorded by a provision that failed without leaving an
+// instance behind, so that any request can provision the instance ID again
+func (kBroker *KafkaServiceBroker) forgetProvision(ctx context.Context, instanceID string) {
+	if err := withContext(ctx, kBroker.RequestRecorder).RemoveInstance(instanceID); err != nil {
+		kBroker.logger(ctx).Error("provision-instance.forget", err, lager.Data{
+			"instance-id": instanceID,
+			"message":     "Failed to remove the attributes of a failed provision",
+		})
+	}
+}
+
+// parseParameters reads the parameters of a request into parameters
+func parseParameters(rawParameters json.RawMessage, parameters interface{}) error {
+	if len(rawParameters) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(rawParameters, parameters); err != nil {
+		return brokerapi.ErrRawParamsInvalid
+	}
+	return nil
+}
+
+func invalidParameters(err error) error {
+	return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-parameters")
+}
+
 func (kBroker *KafkaServiceBroker) planIdentifier(ctx context.Context, planID string) (string, error) {
-	for _, plan := range kBroker.Services(ctx)[0].Plans {
-		if plan.ID == planID {
-			return plan.Name, nil
+	for _, service := range kBroker.Services(ctx) {
+		for _, plan := range service.Plans {
+			if plan.ID == planID {
+				return plan.Name, nil
+			}
 		}
 	}
 	return "", errors.New("plan_id not recognized")
@@ -84,10 +317,54 @@ func (kBroker *KafkaServiceBroker) planIdentifier(ctx context.Context, planID st
 func (kBroker *KafkaServiceBroker) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (brokerapi.DeprovisionServiceSpec, error) {
 	spec := brokerapi.DeprovisionServiceSpec{}
 
-	for _, instanceCreator := range kBroker.InstanceCreators {
-		instanceExists, _ := instanceCreator.InstanceExists(instanceID)
+	for plan, instanceCreator := range kBroker.InstanceCreators {
+		instanceExists, _ := withContext(ctx, instanceCreator).InstanceExists(instanceID)
 		if instanceExists {
-			return spec, instanceCreator.Destroy(instanceID)
+			ctx, err := detach(ctx)
+			if err != nil {
+				return spec, err
+			}
+			// the credentials of the bindings are revoked before the topics are deleted, so that a
+			// failure leaves an instance that exists, and can be deprovisioned again, rather than
+			// credentials that outlive it
+			if kBroker.CredentialRotator != nil {
+				if err := withContext(ctx, kBroker.CredentialRotator).RemoveInstance(instanceID); err != nil {
+					return spec, err
+				}
+			}
+			if kBroker.CertificateIssuer != nil {
+				if err := withContext(ctx, kBroker.CertificateIssuer).RemoveInstance(instanceID); err != nil {
+					return spec, err
+				}
+			}
+			if kBroker.SASLManager != nil {
+				if err := withContext(ctx, kBroker.SASLManager).RemoveInstance(instanceID); err != nil {
+					return spec, err
+				}
+			}
+			if kBroker.ConsumerGroupManager != nil {
+				if err := withContext(ctx, kBroker.ConsumerGroupManager).RemoveInstance(instanceID); err != nil {
+					return spec, err
+				}
+			}
+			request := DeprovisionRequest{InstanceID: instanceID, Plan: plan, Details: details}
+			if err := destroyInstance(ctx, instanceCreator, request); err != nil {
+				return spec, err
+			}
+			if kBroker.RequestRecorder != nil {
+				if err := withContext(ctx, kBroker.RequestRecorder).RemoveInstance(instanceID); err != nil {
+					return spec, err
+				}
+			}
+			if kBroker.LimitManager != nil {
+				if err := withContext(ctx, kBroker.LimitManager).RemoveInstance(instanceID); err != nil {
+					return spec, err
+				}
+			}
+			if kBroker.QuotaManager != nil {
+				return spec, withContext(ctx, kBroker.QuotaManager).RemoveInstance(instanceID)
+			}
+			return spec, nil
 		}
 	}
 	return spec, brokerapi.ErrInstanceDoesNotExist
@@ -111,33 +388,130 @@ func (kBroker *KafkaServiceBroker) Bind(ctx context.Context, instanceID, binding
 		return binding, errors.New("instance binder not found for plan")
 	}
 
-	instanceExists, _ := instanceBinder.InstanceExists(instanceID)
+	planSettings := kBroker.Catalog().Plans[planIdentifier]
+	layout, err := parseCredentialLayout(planSettings.CredentialLayout, serviceDetails.RawParameters)
+	if err != nil {
+		return binding, err
+	}
+	clientConfigs, err := parseClientConfigs(serviceDetails.RawParameters)
+	if err != nil {
+		return binding, err
+	}
+	includeZookeeperPeers, err := parseIncludeZookeeperPeers(layout, serviceDetails.RawParameters)
+	if err != nil {
+		return binding, err
+	}
+	if includeZookeeperPeers {
+		kBroker.logger(ctx).Info("deprecated-include-zk-peers", lager.Data{
+			"instance-id": instanceID,
+			"binding-id":  bindingID,
+			"warning":     "zkPeers give applications write access to the cluster metadata; connect with hostname instead",
+		})
+	}
+
+	instanceExists, _ := withContext(ctx, instanceBinder).InstanceExists(instanceID)
 	if instanceExists {
-		instanceCredentials, err := instanceBinder.Bind(instanceID, bindingID)
+		retry, retried, err := kBroker.checkBindRetry(ctx, instanceID, bindingID, serviceDetails)
+		if err != nil || retry {
+			return retried, err
+		}
+		if ctx, err = detach(ctx); err != nil {
+			return binding, err
+		}
+		instanceCredentials, err := bindInstance(ctx, instanceBinder, BindRequest{
+			InstanceID: instanceID,
+			BindingID:  bindingID,
+			Plan:       planIdentifier,
+			Details:    serviceDetails,
+		})
 		if err != nil {
 			return binding, err
 		}
-		credentialsMap := map[string]interface{}{
-			"zkPeers":  instanceCredentials.ZookeeperPeers,
-			"hostname": instanceCredentials.KafkaHostnames,
+		credentials := bindingCredentials{
+			InstanceCredentials: instanceCredentials,
+			SecurityProtocol:    kBroker.Config.KafkaConfiguration.SecurityProtocol,
+			ClientConfigs:       clientConfigs,
+			OmitZookeeperPeers:  planSettings.OmitZookeeperPeers && !includeZookeeperPeers,
 		}
-
-		if instanceCredentials.TopicName != "" {
-			credentialsMap["topicName"] = instanceCredentials.TopicName
-			credentialsMap["uri"] = fmt.Sprintf("kafka://%s/%s", instanceCredentials.KafkaHostnames, instanceCredentials.TopicName)
+		if kBroker.QuotaManager != nil {
+			if credentials.ClientID, err = withContext(ctx, kBroker.QuotaManager).AddBinding(instanceID, bindingID); err != nil {
+				return binding, err
+			}
+		}
+		if kBroker.ConsumerGroupManager != nil {
+			credentials.ConsumerGroup, err = withContext(ctx, kBroker.ConsumerGroupManager).AddBinding(instanceID, bindingID, planSettings.ConsumerGroupScope)
+			if err != nil {
+				return binding, err
+			}
+		}
+		if kBroker.SASLManager != nil {
+			sasl, err := withContext(ctx, kBroker.SASLManager).AddBinding(instanceID, bindingID)
+			if err != nil {
+				return binding, err
+			}
+			credentials.SASL = &sasl
 		}
-		if instanceCredentials.TopicNamePrefix != "" {
-			credentialsMap["topicNamePrefix"] = instanceCredentials.TopicNamePrefix
-			credentialsMap["uri"] = fmt.Sprintf("kafka://%s", instanceCredentials.KafkaHostnames)
+		if kBroker.CertificateIssuer != nil {
+			certificate, err := withContext(ctx, kBroker.CertificateIssuer).AddBinding(instanceID, bindingID)
+			if err != nil {
+				return binding, err
+			}
+			credentials.Certificate = &certificate
 		}
 
-		binding.Credentials = credentialsMap
+		// what the binding is given is kept before its attributes are recorded, so that an
+		// identical retry of the request is given the same credentials
+		if kBroker.SecretStore != nil {
+			stored := storedBinding{Layout: layout, Credentials: credentials}
+			if kBroker.Config.SecretStore.CredentialReferences {
+				stored.AppGUID = bindingAppGUID(serviceDetails)
+			}
+			if binding.Credentials, err = kBroker.storeBinding(instanceID, bindingID, stored); err != nil {
+				return brokerapi.Binding{}, err
+			}
+		} else {
+			binding.Credentials = credentials.layout(layout)
+		}
+		// without a secret store the credentials cannot be given again, so a retry binds again
+		if kBroker.RequestRecorder != nil && kBroker.SecretStore != nil {
+			if err = withContext(ctx, kBroker.RequestRecorder).SetBindingAttributes(instanceID, bindingID, bindAttributes(serviceDetails)); err != nil {
+				return brokerapi.Binding{}, err
+			}
+		}
 		return binding, nil
 	}
 
 	return brokerapi.Binding{}, brokerapi.ErrInstanceDoesNotExist
 }
 
+// checkBindRetry returns true, with the credentials the binding was given, if a binding with the
+// ID of the request was created by an identical request, and flags the request as a retry. It
+// returns ErrBindingAlreadyExists if the binding was created by another request. Without a secret
+// store, requests are never retries, and bind again.
+func (kBroker *KafkaServiceBroker) checkBindRetry(ctx context.Context, instanceID, bindingID string, details brokerapi.BindDetails) (bool, brokerapi.Binding, error) {
+	if kBroker.RequestRecorder == nil || kBroker.SecretStore == nil {
+		return false, brokerapi.Binding{}, nil
+	}
+	attributes, err := withContext(ctx, kBroker.RequestRecorder).BindingAttributes(instanceID, bindingID)
+	if err != nil || attributes == nil {
+		return false, brokerapi.Binding{}, err
+	}
+	if !attributes.Matches(bindAttributes(details)) {
+		return false, brokerapi.Binding{}, brokerapi.ErrBindingAlreadyExists
+	}
+	credentials, err := kBroker.retriedCredentials(instanceID, bindingID)
+	if err != nil {
+		return false, brokerapi.Binding{}, err
+	}
+	kBroker.logger(ctx).Info("bind-retry", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+		"message":     "The binding was already created by an identical request; giving it the same credentials",
+	})
+	flagRetry(ctx)
+	return true, brokerapi.Binding{Credentials: credentials}, nil
+}
+
 // Unbind would cancel the binding credentials
 func (kBroker *KafkaServiceBroker) Unbind(ctx context.Context, instanceID, bindingID string, serviceDetails brokerapi.UnbindDetails) error {
 	if serviceDetails.PlanID == "" {
@@ -154,21 +528,71 @@ func (kBroker *KafkaServiceBroker) Unbind(ctx context.Context, instanceID, bindi
 		return errors.New("instance binder not found for plan")
 	}
 
-	instanceExists, _ := instanceBinder.InstanceExists(instanceID)
+	instanceExists, _ := withContext(ctx, instanceBinder).InstanceExists(instanceID)
 	if instanceExists {
-		err := instanceBinder.Unbind(instanceID, bindingID)
+		ctx, err := detach(ctx)
+		if err != nil {
+			return err
+		}
+		err = unbindInstance(ctx, instanceBinder, UnbindRequest{
+			InstanceID: instanceID,
+			BindingID:  bindingID,
+			Plan:       planIdentifier,
+			Details:    serviceDetails,
+		})
 		if err != nil {
 			return brokerapi.ErrBindingDoesNotExist
 		}
+		if kBroker.RequestRecorder != nil {
+			if err = withContext(ctx, kBroker.RequestRecorder).RemoveBinding(instanceID, bindingID); err != nil {
+				return err
+			}
+		}
+		if kBroker.SecretStore != nil {
+			if err = kBroker.deleteReferencedCredentials(instanceID, bindingID); err != nil {
+				return err
+			}
+		}
+		if kBroker.CredentialRotator != nil {
+			if err = withContext(ctx, kBroker.CredentialRotator).RemoveBinding(instanceID, bindingID); err != nil {
+				return err
+			}
+		}
+		if kBroker.CertificateIssuer != nil {
+			if err = withContext(ctx, kBroker.CertificateIssuer).RemoveBinding(instanceID, bindingID); err != nil {
+				return err
+			}
+		}
+		if kBroker.SASLManager != nil {
+			if err = withContext(ctx, kBroker.SASLManager).RemoveBinding(instanceID, bindingID); err != nil {
+				return err
+			}
+		}
+		if kBroker.ConsumerGroupManager != nil {
+			if err = withContext(ctx, kBroker.ConsumerGroupManager).RemoveBinding(instanceID, bindingID); err != nil {
+				return err
+			}
+		}
+		if kBroker.QuotaManager != nil {
+			return withContext(ctx, kBroker.QuotaManager).RemoveBinding(instanceID, bindingID)
+		}
 		return nil
 	}
 
 	return brokerapi.ErrInstanceDoesNotExist
 }
 
-func (kBroker *KafkaServiceBroker) instanceExists(instanceID string) bool {
+// logger returns the Logger, or one that discards everything, logging the request ID of ctx
+func (kBroker *KafkaServiceBroker) logger(ctx context.Context) lager.Logger {
+	if kBroker.Logger == nil {
+		return lager.NewLogger("kafka-service-broker")
+	}
+	return RequestLogger(ctx, kBroker.Logger)
+}
+
+func (kBroker *KafkaServiceBroker) instanceExists(ctx context.Context, instanceID string) bool {
 	for _, instanceCreator := range kBroker.InstanceCreators {
-		instanceExists, _ := instanceCreator.InstanceExists(instanceID)
+		instanceExists, _ := withContext(ctx, instanceCreator).InstanceExists(instanceID)
 		if instanceExists {
 			return true
 		}
@@ -183,7 +607,192 @@ func (kBroker *KafkaServiceBroker) LastOperation(ctx context.Context, instanceID
 	return brokerapi.LastOperation{}, nil
 }
 
-// Update would allow a service instance to be updated.
+// InstanceDetails is returned by GET /v2/service_instances/:instance_id
+type InstanceDetails struct {
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// GetInstance reports the quotas and topic limits of a service instance, and its usage of those limits
+func (kBroker *KafkaServiceBroker) GetInstance(ctx context.Context, instanceID string) (InstanceDetails, error) {
+	instance := InstanceDetails{Parameters: map[string]interface{}{}}
+	if !kBroker.instanceExists(ctx, instanceID) {
+		return instance, brokerapi.ErrInstanceDoesNotExist
+	}
+
+	if kBroker.QuotaManager != nil {
+		quota, err := withContext(ctx, kBroker.QuotaManager).InstanceQuota(instanceID)
+		if err != nil {
+			return instance, err
+		}
+		if !quota.IsZero() {
+			instance.Parameters["quota"] = quota
+		}
+	}
+	if kBroker.LimitManager != nil {
+		limits, err := withContext(ctx, kBroker.LimitManager).InstanceLimits(instanceID)
+		if err != nil {
+			return instance, err
+		}
+		if !limits.IsZero() {
+			usage, err := withContext(ctx, kBroker.LimitManager).Usage(instanceID)
+			if err != nil {
+				return instance, err
+			}
+			instance.Parameters["topic_limits"] = limits
+			instance.Parameters["topic_usage"] = usage
+			instance.Parameters["topic_limit_violations"] = limits.Violations(usage)
+		}
+	}
+	return instance, nil
+}
+
+// Update changes the quotas, topic limits and set of topics of a service instance, and resets
+// the offsets of its consumer groups; plans cannot be changed
 func (kBroker *KafkaServiceBroker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
-	return brokerapi.UpdateServiceSpec{}, nil
+	spec := brokerapi.UpdateServiceSpec{}
+
+	if details.PlanID != "" && details.PreviousValues.PlanID != "" && details.PlanID != details.PreviousValues.PlanID {
+		return spec, brokerapi.ErrPlanChangeNotSupported
+	}
+	planID := details.PlanID
+	if planID == "" {
+		planID = details.PreviousValues.PlanID
+	}
+	planIdentifier, err := kBroker.planIdentifier(ctx, planID)
+	if err != nil {
+		return spec, err
+	}
+
+	if !kBroker.instanceExists(ctx, instanceID) {
+		return spec, brokerapi.ErrInstanceDoesNotExist
+	}
+
+	topicConfigParams, err := parseTopicConfig(details.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+	if len(topicConfigParams) > 0 {
+		return spec, invalidParameters(errors.New("topic_config can only be set when creating a service instance"))
+	}
+	hasCompanions, err := hasCompanionTopicParameters(details.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+	if hasCompanions {
+		return spec, invalidParameters(errors.New("retry and dead letter topics can only be set when creating a service instance"))
+	}
+	reset, err := parseOffsetReset(details.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+	if reset != nil {
+		if err = reset.Validate(instanceID); err != nil {
+			return spec, invalidParameters(err)
+		}
+		if kBroker.OffsetResetter == nil {
+			return spec, invalidParameters(errors.New("this broker cannot reset offsets"))
+		}
+	}
+	rotateCredentials, err := parseRotateCredentials(details.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+	if rotateCredentials && kBroker.CredentialRotator == nil {
+		return spec, invalidParameters(errNoCredentialRotator)
+	}
+
+	topics, err := parseTopicSet(details.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+	var topicSetUpdater TopicSetUpdater
+	if topics != nil {
+		var ok bool
+		topicSetUpdater, ok = kBroker.InstanceCreators[planIdentifier].(TopicSetUpdater)
+		if !ok {
+			return spec, invalidParameters(fmt.Errorf("the '%s' plan does not take a 'topics' parameter", planIdentifier))
+		}
+	}
+
+	// validate the new quota and topic limits before changing anything
+	planSettings := kBroker.Catalog().Plans[planIdentifier]
+	quota, err := kBroker.updatedQuota(ctx, instanceID, planSettings.Quota, details.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+	limits, err := kBroker.updatedTopicLimits(ctx, instanceID, planSettings.TopicLimits, details.RawParameters)
+	if err != nil {
+		return spec, err
+	}
+
+	// once the instance is being changed, the request runs to completion even if it is cancelled
+	if ctx, err = detach(ctx); err != nil {
+		return spec, err
+	}
+	if topicSetUpdater != nil {
+		if err = withContext(ctx, topicSetUpdater).UpdateTopics(instanceID, topics); err != nil {
+			return spec, err
+		}
+	}
+	if quota != nil {
+		if err = withContext(ctx, kBroker.QuotaManager).SetInstanceQuota(instanceID, *quota); err != nil {
+			return spec, err
+		}
+	}
+	if limits != nil {
+		if err = withContext(ctx, kBroker.LimitManager).SetInstanceLimits(instanceID, *limits); err != nil {
+			return spec, err
+		}
+	}
+	if reset != nil {
+		if _, err = kBroker.ResetOffsets(ctx, instanceID, *reset, apiActor(ctx)); err != nil {
+			return spec, err
+		}
+	}
+	if rotateCredentials {
+		_, err = kBroker.RotateCredentials(ctx, instanceID, kBroker.Config.KafkaConfiguration.CredentialGracePeriod, apiActor(ctx))
+	}
+	return spec, err
+}
+
+// updatedQuota returns the instance's quota with any quotas given in the parameters changed,
+// or nil if there are none to change
+func (kBroker *KafkaServiceBroker) updatedQuota(ctx context.Context, instanceID string, planQuota Quota, rawParameters json.RawMessage) (*Quota, error) {
+	quotaParams := Quota{}
+	if err := parseParameters(rawParameters, &quotaParams); err != nil {
+		return nil, err
+	}
+	if kBroker.QuotaManager == nil || quotaParams.IsZero() {
+		return nil, nil
+	}
+	current, err := withContext(ctx, kBroker.QuotaManager).InstanceQuota(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := planQuota.Override(current.merge(quotaParams))
+	if err != nil {
+		return nil, invalidParameters(err)
+	}
+	return &quota, nil
+}
+
+// updatedTopicLimits returns the instance's topic limits with any limits given in the parameters
+// changed, or nil if there are none to change
+func (kBroker *KafkaServiceBroker) updatedTopicLimits(ctx context.Context, instanceID string, planLimits TopicLimits, rawParameters json.RawMessage) (*TopicLimits, error) {
+	limitParams := TopicLimits{}
+	if err := parseParameters(rawParameters, &limitParams); err != nil {
+		return nil, err
+	}
+	if kBroker.LimitManager == nil || limitParams.IsZero() {
+		return nil, nil
+	}
+	current, err := withContext(ctx, kBroker.LimitManager).InstanceLimits(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := planLimits.Override(current.merge(limitParams))
+	if err != nil {
+		return nil, invalidParameters(err)
+	}
+	return &limits, nil
 }
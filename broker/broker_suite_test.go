@@ -1,13 +1,44 @@
 package broker_test
 
 import (
+	"os"
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
 )
 
 func TestBroker(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Broker Suite")
 }
+
+// brokerEnvironmentPrefixes are the prefixes of the environment variables the broker is configured with
+var brokerEnvironmentPrefixes = []string{"BROKER_", "KAFKA_", "ZOOKEEPER_", "SECRET_STORE", "CREDHUB_", "CREDENTIAL_ROTATION_", "TOPIC_LIMIT_"}
+
+// unsetBrokerEnvironment unsets the broker's configuration, leaving the rest of the environment,
+// e.g. PATH and HOME, to the tests that follow
+func unsetBrokerEnvironment() {
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		for _, prefix := range brokerEnvironmentPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				_ = os.Unsetenv(name)
+			}
+		}
+	}
+}
+
+// withDefaultPlans adds creator as the instance creator of the plans of the default catalog that
+// creators has none for, as LoadCatalog requires an implementation of each plan of the catalog
+func withDefaultPlans(creators map[string]broker.InstanceCreator, creator broker.InstanceCreator) map[string]broker.InstanceCreator {
+	for _, plan := range []string{"topic", "shared", "compacted", "multi-topic"} {
+		if _, ok := creators[plan]; !ok {
+			creators[plan] = creator
+		}
+	}
+	return creators
+}
@@ -1,33 +1,44 @@
 package broker_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/starkandwayne/kafka-service-broker/broker"
 	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/wvanbergen/kazoo-go"
 )
 
 type fakeInstanceCreatorAndBinder struct {
 	createErr            error
 	createdInstanceIds   []string
+	createdTopicConfigs  map[string]map[string]string
 	destroyErr           error
 	destroyedInstanceIds []string
 	instanceCredentials  broker.InstanceCredentials
 	bindingExists        bool
 }
 
-func (fakeInstanceCreatorAndBinder *fakeInstanceCreatorAndBinder) Create(instanceID string) error {
+func (fakeInstanceCreatorAndBinder *fakeInstanceCreatorAndBinder) Create(instanceID string, settings broker.InstanceSettings) error {
 	if fakeInstanceCreatorAndBinder.createErr != nil {
 		return fakeInstanceCreatorAndBinder.createErr
 	}
 	fakeInstanceCreatorAndBinder.createdInstanceIds = append(fakeInstanceCreatorAndBinder.createdInstanceIds, instanceID)
+	if fakeInstanceCreatorAndBinder.createdTopicConfigs == nil {
+		fakeInstanceCreatorAndBinder.createdTopicConfigs = map[string]map[string]string{}
+	}
+	fakeInstanceCreatorAndBinder.createdTopicConfigs[instanceID] = settings.TopicConfig
 	return nil
 }
 
@@ -59,6 +70,364 @@ func (fakeInstanceCreatorAndBinder *fakeInstanceCreatorAndBinder) InstanceExists
 	return false, nil
 }
 
+type fakeTopicSetCreator struct {
+	*fakeInstanceCreatorAndBinder
+	createdTopics map[string][]broker.TopicSpec
+	updateErr     error
+}
+
+func (fakeTopicSetCreator *fakeTopicSetCreator) Create(instanceID string, settings broker.InstanceSettings) error {
+	fakeTopicSetCreator.createdTopics[instanceID] = settings.Topics
+	return fakeTopicSetCreator.fakeInstanceCreatorAndBinder.Create(instanceID, settings)
+}
+
+func (fakeTopicSetCreator *fakeTopicSetCreator) UpdateTopics(instanceID string, topics []broker.TopicSpec) error {
+	if fakeTopicSetCreator.updateErr != nil {
+		return fakeTopicSetCreator.updateErr
+	}
+	fakeTopicSetCreator.createdTopics[instanceID] = topics
+	return nil
+}
+
+type fakeCompanionTopicCreator struct {
+	*fakeInstanceCreatorAndBinder
+	companions map[string]broker.CompanionTopics
+	err        error
+}
+
+func (fakeCompanionTopicCreator *fakeCompanionTopicCreator) CreateCompanionTopics(instanceID string, companions broker.CompanionTopics) error {
+	if fakeCompanionTopicCreator.err != nil {
+		return fakeCompanionTopicCreator.err
+	}
+	fakeCompanionTopicCreator.companions[instanceID] = companions
+	return nil
+}
+
+type fakeContextCreatorAndBinder struct {
+	*fakeInstanceCreatorAndBinder
+	provisions   []broker.ProvisionRequest
+	deprovisions []broker.DeprovisionRequest
+	binds        []broker.BindRequest
+	unbinds      []broker.UnbindRequest
+	requestIDs   []string
+	// onCreate is called as an instance is created, e.g. to cancel the request
+	onCreate func()
+}
+
+func (fake *fakeContextCreatorAndBinder) CreateWithContext(ctx context.Context, request broker.ProvisionRequest) error {
+	fake.provisions = append(fake.provisions, request)
+	fake.requestIDs = append(fake.requestIDs, broker.RequestID(ctx))
+	if fake.onCreate != nil {
+		fake.onCreate()
+	}
+	return fake.Create(request.InstanceID, request.Settings)
+}
+
+func (fake *fakeContextCreatorAndBinder) DestroyWithContext(ctx context.Context, request broker.DeprovisionRequest) error {
+	fake.deprovisions = append(fake.deprovisions, request)
+	fake.requestIDs = append(fake.requestIDs, broker.RequestID(ctx))
+	return fake.Destroy(request.InstanceID)
+}
+
+func (fake *fakeContextCreatorAndBinder) BindWithContext(ctx context.Context, request broker.BindRequest) (broker.InstanceCredentials, error) {
+	fake.binds = append(fake.binds, request)
+	fake.requestIDs = append(fake.requestIDs, broker.RequestID(ctx))
+	return fake.Bind(request.InstanceID, request.BindingID)
+}
+
+func (fake *fakeContextCreatorAndBinder) UnbindWithContext(ctx context.Context, request broker.UnbindRequest) error {
+	fake.unbinds = append(fake.unbinds, request)
+	fake.requestIDs = append(fake.requestIDs, broker.RequestID(ctx))
+	return fake.Unbind(request.InstanceID, request.BindingID)
+}
+
+type fakeConsumerGroupManager struct {
+	groups           map[string]string
+	removedInstances []string
+}
+
+func (fakeConsumerGroupManager *fakeConsumerGroupManager) AddBinding(instanceID, bindingID, scope string) (string, error) {
+	group := instanceID + "." + bindingID
+	if scope == broker.ConsumerGroupPerInstance {
+		group = instanceID
+	}
+	fakeConsumerGroupManager.groups[bindingID] = group
+	return group, nil
+}
+
+func (fakeConsumerGroupManager *fakeConsumerGroupManager) RemoveBinding(instanceID, bindingID string) error {
+	delete(fakeConsumerGroupManager.groups, bindingID)
+	return nil
+}
+
+func (fakeConsumerGroupManager *fakeConsumerGroupManager) RemoveInstance(instanceID string) error {
+	fakeConsumerGroupManager.removedInstances = append(fakeConsumerGroupManager.removedInstances, instanceID)
+	return nil
+}
+
+type fakeSASLManager struct {
+	bindings         map[string]bool
+	removedInstances []string
+}
+
+func (fakeSASLManager *fakeSASLManager) AddBinding(instanceID, bindingID string) (broker.SASLCredentials, error) {
+	fakeSASLManager.bindings[bindingID] = true
+	return broker.SASLCredentials{Mechanism: "SCRAM-SHA-256", Username: bindingID, Password: "secret"}, nil
+}
+
+func (fakeSASLManager *fakeSASLManager) RemoveBinding(instanceID, bindingID string) error {
+	delete(fakeSASLManager.bindings, bindingID)
+	return nil
+}
+
+func (fakeSASLManager *fakeSASLManager) RemoveInstance(instanceID string) error {
+	fakeSASLManager.removedInstances = append(fakeSASLManager.removedInstances, instanceID)
+	return nil
+}
+
+type fakeCertificateIssuer struct {
+	bindings         map[string]bool
+	removedInstances []string
+}
+
+func (fakeCertificateIssuer *fakeCertificateIssuer) AddBinding(instanceID, bindingID string) (broker.ClientCertificate, error) {
+	fakeCertificateIssuer.bindings[bindingID] = true
+	return broker.ClientCertificate{Certificate: "cert " + bindingID, PrivateKey: "key " + bindingID, CAChain: "ca"}, nil
+}
+
+func (fakeCertificateIssuer *fakeCertificateIssuer) RemoveBinding(instanceID, bindingID string) error {
+	delete(fakeCertificateIssuer.bindings, bindingID)
+	return nil
+}
+
+func (fakeCertificateIssuer *fakeCertificateIssuer) RemoveInstance(instanceID string) error {
+	fakeCertificateIssuer.removedInstances = append(fakeCertificateIssuer.removedInstances, instanceID)
+	return nil
+}
+
+type fakeCredentialRotator struct {
+	err              error
+	gracePeriods     []time.Duration
+	removedBindings  []string
+	removedInstances []string
+}
+
+func (fakeCredentialRotator *fakeCredentialRotator) RotateCredentials(instanceID string, gracePeriod time.Duration) ([]broker.RotatedCredentials, error) {
+	fakeCredentialRotator.gracePeriods = append(fakeCredentialRotator.gracePeriods, gracePeriod)
+	if fakeCredentialRotator.err != nil {
+		return nil, fakeCredentialRotator.err
+	}
+	return fakeCredentialRotator.RotatedCredentials(instanceID)
+}
+
+func (fakeCredentialRotator *fakeCredentialRotator) RotatedCredentials(instanceID string) ([]broker.RotatedCredentials, error) {
+	return []broker.RotatedCredentials{{
+		BindingID: "bindingID",
+		SASL:      &broker.SASLCredentials{Mechanism: "SCRAM-SHA-512", Username: "bindingID", Password: "new secret"},
+	}}, nil
+}
+
+func (fakeCredentialRotator *fakeCredentialRotator) RemoveBinding(instanceID, bindingID string) error {
+	fakeCredentialRotator.removedBindings = append(fakeCredentialRotator.removedBindings, bindingID)
+	return nil
+}
+
+func (fakeCredentialRotator *fakeCredentialRotator) RemoveInstance(instanceID string) error {
+	fakeCredentialRotator.removedInstances = append(fakeCredentialRotator.removedInstances, instanceID)
+	return nil
+}
+
+type fakeSecretStore struct {
+	secrets    map[string][]byte
+	references map[string]string
+}
+
+func (fakeSecretStore *fakeSecretStore) Put(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	fakeSecretStore.secrets[name] = data
+	return err
+}
+
+func (fakeSecretStore *fakeSecretStore) Get(name string, value interface{}) error {
+	data, ok := fakeSecretStore.secrets[name]
+	if !ok {
+		return broker.ErrSecretNotFound
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (fakeSecretStore *fakeSecretStore) Delete(name string) error {
+	delete(fakeSecretStore.secrets, name)
+	return nil
+}
+
+func (fakeSecretStore *fakeSecretStore) Reference(name, appGUID string) (string, error) {
+	fakeSecretStore.references[name] = appGUID
+	return "/c/kafka-service-broker/" + name, nil
+}
+
+type fakeOffsetResetter struct {
+	resets []broker.OffsetReset
+	err    error
+}
+
+func (fakeOffsetResetter *fakeOffsetResetter) ResetOffsets(instanceID string, reset broker.OffsetReset) ([]broker.PartitionOffsets, error) {
+	if fakeOffsetResetter.err != nil {
+		return nil, fakeOffsetResetter.err
+	}
+	fakeOffsetResetter.resets = append(fakeOffsetResetter.resets, reset)
+	return []broker.PartitionOffsets{{Topic: instanceID, Partition: 0, Offset: 0}}, nil
+}
+
+type fakeAuditLog struct {
+	entries []broker.AuditEntry
+}
+
+func (fakeAuditLog *fakeAuditLog) Record(entry broker.AuditEntry) error {
+	fakeAuditLog.entries = append(fakeAuditLog.entries, entry)
+	return nil
+}
+
+type fakeQuotaManager struct {
+	instanceQuotas map[string]broker.Quota
+	bindings       map[string][]string
+	err            error
+}
+
+func (fakeQuotaManager *fakeQuotaManager) InstanceQuota(instanceID string) (broker.Quota, error) {
+	return fakeQuotaManager.instanceQuotas[instanceID], fakeQuotaManager.err
+}
+
+func (fakeQuotaManager *fakeQuotaManager) SetInstanceQuota(instanceID string, quota broker.Quota) error {
+	if fakeQuotaManager.err != nil {
+		return fakeQuotaManager.err
+	}
+	fakeQuotaManager.instanceQuotas[instanceID] = quota
+	return nil
+}
+
+func (fakeQuotaManager *fakeQuotaManager) AddBinding(instanceID, bindingID string) (string, error) {
+	fakeQuotaManager.bindings[instanceID] = append(fakeQuotaManager.bindings[instanceID], bindingID)
+	return "client-" + bindingID, fakeQuotaManager.err
+}
+
+func (fakeQuotaManager *fakeQuotaManager) RemoveBinding(instanceID, bindingID string) error {
+	remaining := []string{}
+	for _, existing := range fakeQuotaManager.bindings[instanceID] {
+		if existing != bindingID {
+			remaining = append(remaining, existing)
+		}
+	}
+	fakeQuotaManager.bindings[instanceID] = remaining
+	return fakeQuotaManager.err
+}
+
+func (fakeQuotaManager *fakeQuotaManager) RemoveInstance(instanceID string) error {
+	delete(fakeQuotaManager.instanceQuotas, instanceID)
+	delete(fakeQuotaManager.bindings, instanceID)
+	return fakeQuotaManager.err
+}
+
+type fakeContextQuotaManager struct {
+	*fakeQuotaManager
+	contexts []context.Context
+}
+
+func (fake *fakeContextQuotaManager) WithContext(ctx context.Context) interface{} {
+	fake.contexts = append(fake.contexts, ctx)
+	return fake
+}
+
+type fakeLimitManager struct {
+	instanceLimits map[string]broker.TopicLimits
+	usage          broker.TopicUsage
+	removed        []string
+}
+
+func (fakeLimitManager *fakeLimitManager) InstanceLimits(instanceID string) (broker.TopicLimits, error) {
+	return fakeLimitManager.instanceLimits[instanceID], nil
+}
+
+func (fakeLimitManager *fakeLimitManager) SetInstanceLimits(instanceID string, limits broker.TopicLimits) error {
+	fakeLimitManager.instanceLimits[instanceID] = limits
+	return nil
+}
+
+func (fakeLimitManager *fakeLimitManager) Usage(instanceID string) (broker.TopicUsage, error) {
+	return fakeLimitManager.usage, nil
+}
+
+func (fakeLimitManager *fakeLimitManager) RemoveInstance(instanceID string) error {
+	delete(fakeLimitManager.instanceLimits, instanceID)
+	fakeLimitManager.removed = append(fakeLimitManager.removed, instanceID)
+	return nil
+}
+
+type fakeTopicConfigManager struct {
+	policies map[string]broker.TopicConfigPolicy
+}
+
+func (fakeTopicConfigManager *fakeTopicConfigManager) SetInstanceTopicConfigPolicy(instanceID string, policy broker.TopicConfigPolicy) error {
+	fakeTopicConfigManager.policies[instanceID] = policy
+	return nil
+}
+
+type fakeTopicConfigDefaulter struct {
+	*fakeInstanceCreatorAndBinder
+	defaults map[string]string
+}
+
+func (fake *fakeTopicConfigDefaulter) TopicConfigDefaults() map[string]string {
+	return fake.defaults
+}
+
+type fakeRequestRecorder struct {
+	instances map[string]broker.InstanceAttributes
+	bindings  map[string]broker.BindingAttributes
+}
+
+func (fakeRequestRecorder *fakeRequestRecorder) InstanceAttributes(instanceID string) (*broker.InstanceAttributes, error) {
+	if attributes, ok := fakeRequestRecorder.instances[instanceID]; ok {
+		return &attributes, nil
+	}
+	return nil, nil
+}
+
+func (fakeRequestRecorder *fakeRequestRecorder) CreateInstanceAttributes(instanceID string, attributes broker.InstanceAttributes) (*broker.InstanceAttributes, error) {
+	if existing, ok := fakeRequestRecorder.instances[instanceID]; ok {
+		return &existing, nil
+	}
+	fakeRequestRecorder.instances[instanceID] = attributes
+	return nil, nil
+}
+
+func (fakeRequestRecorder *fakeRequestRecorder) SetInstanceAttributes(instanceID string, attributes broker.InstanceAttributes) error {
+	fakeRequestRecorder.instances[instanceID] = attributes
+	return nil
+}
+
+func (fakeRequestRecorder *fakeRequestRecorder) BindingAttributes(instanceID, bindingID string) (*broker.BindingAttributes, error) {
+	if attributes, ok := fakeRequestRecorder.bindings[instanceID+"/"+bindingID]; ok {
+		return &attributes, nil
+	}
+	return nil, nil
+}
+
+func (fakeRequestRecorder *fakeRequestRecorder) SetBindingAttributes(instanceID, bindingID string, attributes broker.BindingAttributes) error {
+	fakeRequestRecorder.bindings[instanceID+"/"+bindingID] = attributes
+	return nil
+}
+
+func (fakeRequestRecorder *fakeRequestRecorder) RemoveBinding(instanceID, bindingID string) error {
+	delete(fakeRequestRecorder.bindings, instanceID+"/"+bindingID)
+	return nil
+}
+
+func (fakeRequestRecorder *fakeRequestRecorder) RemoveInstance(instanceID string) error {
+	delete(fakeRequestRecorder.instances, instanceID)
+	return nil
+}
+
 var _ = Describe("Kafka SB", func() {
 	ctx := context.Background()
 
@@ -73,7 +442,7 @@ var _ = Describe("Kafka SB", func() {
 	var kafkaHostnames = "localhost:9092,localhost:9093,localhost:9094"
 
 	BeforeEach(func() {
-		os.Clearenv()
+		unsetBrokerEnvironment()
 		someCreatorAndBinder = &fakeInstanceCreatorAndBinder{
 			instanceCredentials: broker.InstanceCredentials{
 				ZookeeperPeers: zkPeers,
@@ -83,9 +452,9 @@ var _ = Describe("Kafka SB", func() {
 		}
 
 		kafkaBroker = &broker.KafkaServiceBroker{
-			InstanceCreators: map[string]broker.InstanceCreator{
+			InstanceCreators: withDefaultPlans(map[string]broker.InstanceCreator{
 				planName: someCreatorAndBinder,
-			},
+			}, &fakeInstanceCreatorAndBinder{}),
 			InstanceBinders: map[string]broker.InstanceBinder{
 				planName: someCreatorAndBinder,
 			},
@@ -97,6 +466,7 @@ var _ = Describe("Kafka SB", func() {
 				},
 			},
 		}
+		Expect(kafkaBroker.LoadCatalog()).To(Succeed())
 	})
 
 	Describe(".Provision", func() {
@@ -181,7 +551,7 @@ var _ = Describe("Kafka SB", func() {
 	Describe(".Bind", func() {
 		Context("when the instance exists", func() {
 			BeforeEach(func() {
-				someCreatorAndBinder.Create(instanceID)
+				someCreatorAndBinder.Create(instanceID, broker.InstanceSettings{})
 			})
 
 			It("returns credentials", func() {
@@ -203,6 +573,14 @@ var _ = Describe("Kafka SB", func() {
 
 				Expect(credentials).To(Equal(expectedCredentials))
 			})
+
+			It("annotates the credentials with the cleanup policy of the topic", func() {
+				someCreatorAndBinder.instanceCredentials.TopicCleanupPolicy = "compact"
+
+				credentials, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(credentials.Credentials).To(HaveKeyWithValue("cleanupPolicy", "compact"))
+			})
 		})
 
 		Context("when the instance does not exist", func() {
@@ -217,7 +595,7 @@ var _ = Describe("Kafka SB", func() {
 
 	Describe(".Unbind", func() {
 		BeforeEach(func() {
-			someCreatorAndBinder.Create(instanceID)
+			someCreatorAndBinder.Create(instanceID, broker.InstanceSettings{})
 			_, err := kafkaBroker.Bind(ctx, instanceID, "EXISTANT-BINDING", brokerapi.BindDetails{PlanID: topicPlanID})
 			Expect(err).NotTo(HaveOccurred())
 		})
@@ -234,4 +612,1281 @@ var _ = Describe("Kafka SB", func() {
 			Expect(err).To(MatchError(brokerapi.ErrBindingDoesNotExist))
 		})
 	})
+
+	Describe("quotas", func() {
+		var quotaManager *fakeQuotaManager
+
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+topicPlanID+`","name":"topic","description":"Topic","kafka":{"quota":{"producer_byte_rate":1048576,"consumer_byte_rate":2097152}}}
+			]}]}`)
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+			quotaManager = &fakeQuotaManager{
+				instanceQuotas: map[string]broker.Quota{},
+				bindings:       map[string][]string{},
+			}
+			kafkaBroker.QuotaManager = quotaManager
+		})
+
+		Describe(".Provision", func() {
+			It("applies the plan quota", func() {
+				_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(quotaManager.instanceQuotas[instanceID]).To(Equal(broker.Quota{
+					ProducerByteRate: 1048576,
+					ConsumerByteRate: 2097152,
+				}))
+			})
+
+			It("lowers the plan quota with parameters", func() {
+				_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+					PlanID:        topicPlanID,
+					RawParameters: []byte(`{"producer_byte_rate":1024,"request_percentage":50}`),
+				}, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(quotaManager.instanceQuotas[instanceID]).To(Equal(broker.Quota{
+					ProducerByteRate:  1024,
+					ConsumerByteRate:  2097152,
+					RequestPercentage: 50,
+				}))
+			})
+
+			It("refuses parameters that exceed the plan quota", func() {
+				_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+					PlanID:        topicPlanID,
+					RawParameters: []byte(`{"consumer_byte_rate":99999999}`),
+				}, false)
+				Expect(err).To(MatchError("consumer_byte_rate must not exceed the plan limit of 2097152"))
+				Expect(someCreatorAndBinder.createdInstanceIds).To(BeEmpty())
+			})
+
+			It("refuses parameters that are not JSON", func() {
+				_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+					PlanID:        topicPlanID,
+					RawParameters: []byte(`not json`),
+				}, false)
+				Expect(err).To(Equal(brokerapi.ErrRawParamsInvalid))
+			})
+		})
+
+		Describe(".Bind", func() {
+			It("returns the client ID of the binding", func() {
+				_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.Credentials).To(HaveKeyWithValue("clientId", "client-bindingID"))
+				Expect(quotaManager.bindings[instanceID]).To(ConsistOf("bindingID"))
+			})
+		})
+
+		Describe(".Unbind and .Deprovision", func() {
+			It("remove the quotas", func() {
+				_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+				Expect(err).NotTo(HaveOccurred())
+
+				someCreatorAndBinder.bindingExists = true
+				Expect(kafkaBroker.Unbind(ctx, instanceID, "bindingID", brokerapi.UnbindDetails{PlanID: topicPlanID})).To(Succeed())
+				Expect(quotaManager.bindings[instanceID]).To(BeEmpty())
+
+				_, err = kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(quotaManager.instanceQuotas).NotTo(HaveKey(instanceID))
+			})
+		})
+
+		Describe(".Update", func() {
+			BeforeEach(func() {
+				_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+					PlanID:        topicPlanID,
+					RawParameters: []byte(`{"producer_byte_rate":1024}`),
+				}, false)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("changes the quotas given as parameters, keeping the others", func() {
+				_, err := kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+					PlanID:        topicPlanID,
+					RawParameters: []byte(`{"consumer_byte_rate":2048}`),
+				}, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(quotaManager.instanceQuotas[instanceID]).To(Equal(broker.Quota{
+					ProducerByteRate: 1024,
+					ConsumerByteRate: 2048,
+				}))
+			})
+
+			It("refuses quotas that exceed the plan", func() {
+				_, err := kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+					PlanID:        topicPlanID,
+					RawParameters: []byte(`{"producer_byte_rate":99999999}`),
+				}, false)
+				Expect(err).To(MatchError("producer_byte_rate must not exceed the plan limit of 1048576"))
+			})
+
+			It("refuses to change plan", func() {
+				_, err := kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+					PlanID:         "another-plan",
+					PreviousValues: brokerapi.PreviousValues{PlanID: topicPlanID},
+				}, false)
+				Expect(err).To(Equal(brokerapi.ErrPlanChangeNotSupported))
+			})
+
+			It("returns brokerapi.ErrInstanceDoesNotExist for an unknown instance", func() {
+				_, err := kafkaBroker.Update(ctx, "unknown", brokerapi.UpdateDetails{PlanID: topicPlanID}, false)
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+		})
+	})
+
+	Describe("topic limits", func() {
+		var limitManager *fakeLimitManager
+
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+topicPlanID+`","name":"topic","description":"Topic","kafka":{"topic_limits":{"max_topics":10,"max_partitions":40,"max_retention_ms":86400000,"action":"revoke"}}}
+			]}]}`)
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+			limitManager = &fakeLimitManager{instanceLimits: map[string]broker.TopicLimits{}}
+			kafkaBroker.LimitManager = limitManager
+		})
+
+		It("records the plan limits, lowered by parameters, on provisioning", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"max_topics":5,"action":"log"}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limitManager.instanceLimits[instanceID]).To(Equal(broker.TopicLimits{
+				MaxTopics:      5,
+				MaxPartitions:  40,
+				MaxRetentionMs: 86400000,
+				Action:         broker.LimitActionRevoke,
+			}))
+		})
+
+		It("refuses parameters that exceed the plan limits", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"max_partitions":100}`),
+			}, false)
+			Expect(err).To(MatchError("max_partitions must not exceed the plan limit of 40"))
+		})
+
+		It("changes limits given as parameters on update", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"max_retention_ms":3600000}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limitManager.instanceLimits[instanceID].MaxRetentionMs).To(Equal(int64(3600000)))
+			Expect(limitManager.instanceLimits[instanceID].MaxTopics).To(Equal(10))
+		})
+
+		It("clears the limits on deprovisioning", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(limitManager.removed).To(ConsistOf(instanceID))
+		})
+
+		Describe(".GetInstance", func() {
+			It("reports the limits, the usage and any violations", func() {
+				_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+				Expect(err).NotTo(HaveOccurred())
+				limitManager.usage = broker.TopicUsage{Topics: 12, Partitions: 24, MaxRetentionMs: -1}
+
+				instance, err := kafkaBroker.GetInstance(ctx, instanceID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(instance.Parameters).To(HaveKeyWithValue("topic_limits", limitManager.instanceLimits[instanceID]))
+				Expect(instance.Parameters).To(HaveKeyWithValue("topic_usage", limitManager.usage))
+				Expect(instance.Parameters).To(HaveKeyWithValue("topic_limit_violations", []string{
+					"12 topics exceeds the limit of 10",
+					"retention.ms of -1 exceeds the limit of 86400000",
+				}))
+			})
+
+			It("returns brokerapi.ErrInstanceDoesNotExist for an unknown instance", func() {
+				_, err := kafkaBroker.GetInstance(ctx, "unknown")
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+		})
+	})
+
+	Describe("topic config", func() {
+		var topicConfigManager *fakeTopicConfigManager
+
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+topicPlanID+`","name":"topic","description":"Topic","kafka":{"topic_config":{
+					"defaults":{"retention.ms":"86400000","cleanup.policy":"delete","min.insync.replicas":"2"},
+					"overridable":{"retention.ms":{"min":60000,"max":604800000},"cleanup.policy":{"values":["delete","compact"]}}
+				}}}
+			]}]}`)
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+			topicConfigManager = &fakeTopicConfigManager{policies: map[string]broker.TopicConfigPolicy{}}
+			kafkaBroker.TopicConfigManager = topicConfigManager
+		})
+
+		It("creates topics with the plan defaults", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(someCreatorAndBinder.createdTopicConfigs[instanceID]).To(Equal(map[string]string{
+				"retention.ms":        "86400000",
+				"cleanup.policy":      "delete",
+				"min.insync.replicas": "2",
+			}))
+			Expect(topicConfigManager.policies[instanceID]).To(Equal(kafkaBroker.Catalog().Plans["topic"].TopicConfig))
+		})
+
+		It("records the topic config defaults of plans that have their own with the policy", func() {
+			creator := &fakeTopicConfigDefaulter{
+				fakeInstanceCreatorAndBinder: someCreatorAndBinder,
+				defaults:                     map[string]string{"segment.ms": "21600000", "cleanup.policy": "compact", "min.insync.replicas": "1"},
+			}
+			kafkaBroker.InstanceCreators[planName] = creator
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(topicConfigManager.policies[instanceID].Defaults).To(Equal(map[string]string{
+				"retention.ms":        "86400000",
+				"cleanup.policy":      "delete",
+				"min.insync.replicas": "2",
+				"segment.ms":          "21600000",
+			}))
+			Expect(topicConfigManager.policies[instanceID].Overridable).To(Equal(kafkaBroker.Catalog().Plans["topic"].TopicConfig.Overridable))
+		})
+
+		It("applies overrides given as parameters", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"topic_config":{"retention.ms":3600000,"cleanup.policy":"compact"}}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(someCreatorAndBinder.createdTopicConfigs[instanceID]).To(Equal(map[string]string{
+				"retention.ms":        "3600000",
+				"cleanup.policy":      "compact",
+				"min.insync.replicas": "2",
+			}))
+		})
+
+		It("refuses keys that are not overridable", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"topic_config":{"min.insync.replicas":"1"}}`),
+			}, false)
+			Expect(err).To(MatchError("topic config 'min.insync.replicas' cannot be overridden; overridable keys: cleanup.policy, retention.ms"))
+			Expect(someCreatorAndBinder.createdInstanceIds).To(BeEmpty())
+		})
+
+		It("refuses values outside the allowed ranges", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"topic_config":{"retention.ms":"1000"}}`),
+			}, false)
+			Expect(err).To(MatchError("topic config 'retention.ms' must be at least 60000, not 1000"))
+
+			_, err = kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"topic_config":{"cleanup.policy":"none"}}`),
+			}, false)
+			Expect(err).To(MatchError("topic config 'cleanup.policy' must be one of delete, compact, not 'none'"))
+		})
+
+		It("cannot be changed by an update", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"topic_config":{"retention.ms":3600000}}`),
+			}, false)
+			Expect(err).To(MatchError("topic_config can only be set when creating a service instance"))
+		})
+	})
+
+	Describe("topic sets", func() {
+		const multiTopicPlanID = "multiTopicPlanID"
+		var topicSetCreator *fakeTopicSetCreator
+
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+topicPlanID+`","name":"topic","description":"Topic"},
+				{"id":"`+multiTopicPlanID+`","name":"multi-topic","description":"Multi-topic"}
+			]}]}`)
+			topicSetCreator = &fakeTopicSetCreator{
+				fakeInstanceCreatorAndBinder: &fakeInstanceCreatorAndBinder{},
+				createdTopics:                map[string][]broker.TopicSpec{},
+			}
+			kafkaBroker.InstanceCreators["multi-topic"] = topicSetCreator
+			kafkaBroker.InstanceBinders["multi-topic"] = topicSetCreator
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+		})
+
+		It("creates the topics listed in the parameters", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        multiTopicPlanID,
+				RawParameters: []byte(`{"topics":[{"name":"orders","partitions":6},{"name":"payments"}]}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(topicSetCreator.createdTopics[instanceID]).To(Equal([]broker.TopicSpec{
+				{Name: "orders", Partitions: 6},
+				{Name: "payments"},
+			}))
+		})
+
+		It("refuses illegal or repeated topic names", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        multiTopicPlanID,
+				RawParameters: []byte(`{"topics":[{"name":"orders/eu"}]}`),
+			}, false)
+			Expect(err).To(MatchError("topic name 'orders/eu' may only contain letters, digits, '.', '_' and '-'"))
+
+			_, err = kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        multiTopicPlanID,
+				RawParameters: []byte(`{"topics":[{"name":"orders"},{"name":"orders"}]}`),
+			}, false)
+			Expect(err).To(MatchError("topic 'orders' is listed more than once"))
+			Expect(topicSetCreator.createdTopics).To(BeEmpty())
+		})
+
+		It("is refused by plans without topic sets", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"topics":[{"name":"orders"}]}`),
+			}, false)
+			Expect(err).To(MatchError("the 'topic' plan does not take a 'topics' parameter"))
+			Expect(someCreatorAndBinder.createdInstanceIds).To(BeEmpty())
+		})
+
+		It("changes the topics with an update", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: multiTopicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+				PlanID:        multiTopicPlanID,
+				RawParameters: []byte(`{"topics":[{"name":"refunds"}]}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(topicSetCreator.createdTopics[instanceID]).To(Equal([]broker.TopicSpec{{Name: "refunds"}}))
+
+			topicSetCreator.updateErr = errors.New("changing the partitions of a topic is not supported")
+			_, err = kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+				PlanID:        multiTopicPlanID,
+				RawParameters: []byte(`{"topics":[{"name":"refunds","partitions":12}]}`),
+			}, false)
+			Expect(err).To(MatchError("changing the partitions of a topic is not supported"))
+		})
+	})
+
+	Describe("companion topics", func() {
+		const companionPlanID = "companionPlanID"
+		var companionCreator *fakeCompanionTopicCreator
+
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+topicPlanID+`","name":"topic","description":"Topic"},
+				{"id":"`+companionPlanID+`","name":"retrying","description":"Retrying","kafka":{"companion_topics":{"retry_topics":1,"retry_retention_ms":60000}}}
+			]}]}`)
+			companionCreator = &fakeCompanionTopicCreator{
+				fakeInstanceCreatorAndBinder: &fakeInstanceCreatorAndBinder{},
+				companions:                   map[string]broker.CompanionTopics{},
+			}
+			kafkaBroker.InstanceCreators["retrying"] = companionCreator
+			kafkaBroker.InstanceBinders["retrying"] = companionCreator
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+		})
+
+		It("creates the plan's companion topics, as changed by the parameters", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        companionPlanID,
+				RawParameters: []byte(`{"retry_topics":3,"dead_letter_topic":true,"dead_letter_retention_ms":-1}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(companionCreator.companions[instanceID]).To(Equal(broker.CompanionTopics{
+				RetryTopics:           3,
+				RetryRetentionMs:      60000,
+				DeadLetterTopic:       true,
+				DeadLetterRetentionMs: -1,
+			}))
+		})
+
+		It("can be turned off by the parameters", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        companionPlanID,
+				RawParameters: []byte(`{"retry_topics":0}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(companionCreator.companions).To(BeEmpty())
+		})
+
+		It("refuses too many retry topics", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        companionPlanID,
+				RawParameters: []byte(`{"retry_topics":11}`),
+			}, false)
+			Expect(err).To(MatchError("retry_topics must be between 0 and 10"))
+			Expect(companionCreator.createdInstanceIds).To(BeEmpty())
+		})
+
+		It("destroys the instance if they cannot be created", func() {
+			companionCreator.err = errors.New("zk unavailable")
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: companionPlanID}, false)
+			Expect(err).To(MatchError("zk unavailable"))
+			Expect(companionCreator.destroyedInstanceIds).To(ConsistOf(instanceID))
+		})
+
+		It("refuses a retention outside the plan's topic config policy", func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+companionPlanID+`","name":"retrying","description":"Retrying","kafka":{
+					"companion_topics":{"retry_topics":1},
+					"topic_config":{"overridable":{"retention.ms":{"min":60000,"max":86400000}}}
+				}}
+			]}]}`)
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        companionPlanID,
+				RawParameters: []byte(`{"retry_retention_ms":-1}`),
+			}, false)
+			Expect(err).To(MatchError("retry_retention_ms: topic config 'retention.ms' must be at least 60000, not -1"))
+			Expect(companionCreator.createdInstanceIds).To(BeEmpty())
+
+			_, err = kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        companionPlanID,
+				RawParameters: []byte(`{"retry_retention_ms":3600000}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("logs a failure to destroy the instance when they cannot be created", func() {
+			log := &bytes.Buffer{}
+			kafkaBroker.Logger = lager.NewLogger("test")
+			kafkaBroker.Logger.RegisterSink(lager.NewWriterSink(log, lager.ERROR))
+			companionCreator.err = errors.New("zk unavailable")
+			companionCreator.destroyErr = errors.New("cannot delete topics")
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: companionPlanID}, false)
+			Expect(err).To(MatchError("zk unavailable"))
+			Expect(log.String()).To(ContainSubstring("cannot delete topics"))
+		})
+
+		It("is refused by plans without companion topics", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"dead_letter_topic":true}`),
+			}, false)
+			Expect(err).To(MatchError("the 'topic' plan does not create retry or dead letter topics"))
+			Expect(someCreatorAndBinder.createdInstanceIds).To(BeEmpty())
+		})
+
+		It("cannot be changed by an update", func() {
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: companionPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+				PlanID:        companionPlanID,
+				RawParameters: []byte(`{"retry_topics":2}`),
+			}, false)
+			Expect(err).To(MatchError("retry and dead letter topics can only be set when creating a service instance"))
+		})
+
+		It("are named in the binding credentials", func() {
+			someCreatorAndBinder.instanceCredentials.RetryTopics = []string{instanceID + ".retry.1"}
+			someCreatorAndBinder.instanceCredentials.DeadLetterTopic = instanceID + ".dlq"
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			credentials := binding.Credentials.(map[string]interface{})
+			Expect(credentials["retry_topics"]).To(Equal([]string{instanceID + ".retry.1"}))
+			Expect(credentials["dead_letter_topic"]).To(Equal(instanceID + ".dlq"))
+		})
+	})
+
+	Describe("consumer groups", func() {
+		const sharedGroupPlanID = "sharedGroupPlanID"
+		var consumerGroupManager *fakeConsumerGroupManager
+
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+topicPlanID+`","name":"topic","description":"Topic"},
+				{"id":"`+sharedGroupPlanID+`","name":"shared-group","description":"Shared-group","kafka":{"consumer_group_scope":"instance"}}
+			]}]}`)
+			consumerGroupManager = &fakeConsumerGroupManager{groups: map[string]string{}}
+			kafkaBroker.ConsumerGroupManager = consumerGroupManager
+			kafkaBroker.InstanceCreators["shared-group"] = someCreatorAndBinder
+			kafkaBroker.InstanceBinders["shared-group"] = someCreatorAndBinder
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the consumer group of each binding", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("consumer_group", "instanceID.bindingID"))
+		})
+
+		It("returns the instance's consumer group for plans that share one", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: sharedGroupPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("consumer_group", instanceID))
+		})
+
+		It("removes the consumer groups on unbind and deprovision", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			someCreatorAndBinder.bindingExists = true
+			Expect(kafkaBroker.Unbind(ctx, instanceID, "bindingID", brokerapi.UnbindDetails{PlanID: topicPlanID})).To(Succeed())
+			Expect(consumerGroupManager.groups).To(BeEmpty())
+
+			_, err = kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(consumerGroupManager.removedInstances).To(ConsistOf(instanceID))
+		})
+	})
+
+	Describe("offset resets", func() {
+		var offsetResetter *fakeOffsetResetter
+		var auditLog *fakeAuditLog
+
+		BeforeEach(func() {
+			offsetResetter = &fakeOffsetResetter{}
+			auditLog = &fakeAuditLog{}
+			kafkaBroker.OffsetResetter = offsetResetter
+			kafkaBroker.AuditLog = auditLog
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		update := func(rawParameters string) error {
+			_, err := kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(rawParameters),
+			}, false)
+			return err
+		}
+
+		It("resets the offsets given in the update parameters and records an audit entry", func() {
+			Expect(update(`{"reset_offsets":{"group":"instanceID.bindingID","to":"earliest"}}`)).To(Succeed())
+			Expect(offsetResetter.resets).To(Equal([]broker.OffsetReset{{Group: "instanceID.bindingID", To: broker.OffsetResetEarliest}}))
+			Expect(auditLog.entries).To(HaveLen(1))
+			Expect(auditLog.entries[0].Action).To(Equal("reset-offsets"))
+			Expect(auditLog.entries[0].InstanceID).To(Equal(instanceID))
+			Expect(auditLog.entries[0].Actor).To(Equal(broker.ActorBrokerAPI))
+			Expect(auditLog.entries[0].Details).To(HaveKeyWithValue("group", "instanceID.bindingID"))
+			Expect(auditLog.entries[0].Time.IsZero()).To(BeFalse())
+		})
+
+		It("refuses incomplete resets and the groups of other instances", func() {
+			Expect(update(`{"reset_offsets":{"group":"instanceID","to":"offset"}}`)).To(MatchError(ContainSubstring("takes an offset")))
+			Expect(update(`{"reset_offsets":{"group":"instanceID","to":"yesterday"}}`)).To(HaveOccurred())
+			Expect(update(`{"reset_offsets":{"group":"other","to":"latest"}}`)).To(MatchError("consumer group 'other' does not belong to the service instance"))
+			Expect(update(`{"reset_offsets":{"group":"instanceID2.bindingID","to":"latest"}}`)).To(MatchError("consumer group 'instanceID2.bindingID' does not belong to the service instance"))
+			Expect(update(`{"reset_offsets":{"group":"instanceID","topic":"instanceIDother","to":"latest"}}`)).To(MatchError("topic 'instanceIDother' does not belong to the service instance"))
+			Expect(offsetResetter.resets).To(BeEmpty())
+		})
+
+		It("refuses to reset a group with active members", func() {
+			offsetResetter.err = broker.ErrConsumerGroupActive
+			err := update(`{"reset_offsets":{"group":"instanceID","to":"latest"}}`)
+			Expect(err).To(MatchError(broker.ErrConsumerGroupActive.Error()))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+			Expect(auditLog.entries).To(BeEmpty())
+		})
+
+		It("returns brokerapi.ErrInstanceDoesNotExist for an unknown instance", func() {
+			_, err := kafkaBroker.ResetOffsets(ctx, "unknown", broker.OffsetReset{Group: "unknown", To: broker.OffsetResetLatest}, "test")
+			Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+		})
+	})
+
+	Describe("credential layouts", func() {
+		const bindingPlanID = "bindingPlanID"
+		var saslManager *fakeSASLManager
+
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+topicPlanID+`","name":"topic","description":"Topic"},
+				{"id":"`+bindingPlanID+`","name":"k8s","description":"K8s","kafka":{"credential_layout":"servicebinding"}}
+			]}]}`)
+			kafkaBroker.InstanceCreators["k8s"] = someCreatorAndBinder
+			kafkaBroker.InstanceBinders["k8s"] = someCreatorAndBinder
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+			kafkaBroker.ConsumerGroupManager = &fakeConsumerGroupManager{groups: map[string]string{}}
+			saslManager = &fakeSASLManager{bindings: map[string]bool{}}
+			kafkaBroker.SASLManager = saslManager
+			kafkaBroker.Config.KafkaConfiguration.SecurityProtocol = brokerconfig.SecurityProtocolSASLSSL
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		servicebindingCredentials := map[string]interface{}{
+			"type":              "kafka",
+			"provider":          broker.ServiceBindingProvider,
+			"bootstrap-servers": kafkaHostnames,
+			"security.protocol": "SASL_SSL",
+			"sasl.mechanism":    "SCRAM-SHA-256",
+			"user":              "bindingID",
+			"password":          "secret",
+			"topic":             instanceID,
+			"consumer-group":    "instanceID.bindingID",
+		}
+
+		It("adds the SASL credentials to the default layout", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("zkPeers", zkPeers))
+			Expect(binding.Credentials).To(HaveKeyWithValue("securityProtocol", "SASL_SSL"))
+			Expect(binding.Credentials).To(HaveKeyWithValue("saslMechanism", "SCRAM-SHA-256"))
+			Expect(binding.Credentials).To(HaveKeyWithValue("user", "bindingID"))
+			Expect(binding.Credentials).To(HaveKeyWithValue("password", "secret"))
+		})
+
+		It("lays out the credentials for servicebinding.io when the plan asks for it", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: bindingPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(Equal(servicebindingCredentials))
+		})
+
+		It("lays out the credentials as asked in the bind parameters", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"credential_layout":"servicebinding"}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(Equal(servicebindingCredentials))
+
+			binding, err = kafkaBroker.Bind(ctx, instanceID, "otherID", brokerapi.BindDetails{
+				PlanID:        bindingPlanID,
+				RawParameters: []byte(`{"credential_layout":"default"}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("topicName", instanceID))
+		})
+
+		It("refuses unknown layouts before binding", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"credential_layout":"yaml"}`),
+			})
+			Expect(err).To(MatchError("credential_layout must be 'default' or 'servicebinding', not 'yaml'"))
+			Expect(saslManager.bindings).To(BeEmpty())
+		})
+
+		It("removes the SASL credentials on unbind and deprovision", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			someCreatorAndBinder.bindingExists = true
+			Expect(kafkaBroker.Unbind(ctx, instanceID, "bindingID", brokerapi.UnbindDetails{PlanID: topicPlanID})).To(Succeed())
+			Expect(saslManager.bindings).To(BeEmpty())
+
+			_, err = kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saslManager.removedInstances).To(ConsistOf(instanceID))
+		})
+	})
+
+	Describe("credential rotation", func() {
+		var rotator *fakeCredentialRotator
+		var auditLog *fakeAuditLog
+
+		BeforeEach(func() {
+			rotator = &fakeCredentialRotator{}
+			auditLog = &fakeAuditLog{}
+			kafkaBroker.CredentialRotator = rotator
+			kafkaBroker.AuditLog = auditLog
+			kafkaBroker.Config.KafkaConfiguration.CredentialGracePeriod = time.Hour
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rotates the credentials of every binding on update, with the configured grace period", func() {
+			_, err := kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"rotate_credentials":true}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotator.gracePeriods).To(Equal([]time.Duration{time.Hour}))
+			Expect(auditLog.entries).To(HaveLen(1))
+			Expect(auditLog.entries[0].Action).To(Equal("rotate-credentials"))
+			Expect(auditLog.entries[0].Actor).To(Equal(broker.ActorBrokerAPI))
+			Expect(auditLog.entries[0].Details).To(Equal(map[string]interface{}{"bindings": []string{"bindingID"}, "grace_period": "1h0m0s"}))
+		})
+
+		It("rotates credentials for admin commands, with their own grace period", func() {
+			rotated, err := kafkaBroker.RotateCredentials(ctx, instanceID, 0, "admin")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotated).To(HaveLen(1))
+			Expect(rotator.gracePeriods).To(Equal([]time.Duration{0}))
+			Expect(auditLog.entries[0].Actor).To(Equal("admin"))
+
+			_, err = kafkaBroker.RotateCredentials(ctx, instanceID, -time.Hour, "admin")
+			Expect(err).To(MatchError("the grace period cannot be negative"))
+			_, err = kafkaBroker.RotateCredentials(ctx, "unknown", time.Hour, "admin")
+			Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+		})
+
+		It("refuses to rotate credentials when bindings are given none", func() {
+			kafkaBroker.CredentialRotator = nil
+			_, err := kafkaBroker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"rotate_credentials":true}`),
+			}, false)
+			Expect(err).To(MatchError("this broker issues no credentials to rotate, or has no secret store to keep new ones in"))
+			Expect(auditLog.entries).To(BeEmpty())
+		})
+
+		It("refuses to rotate credentials again before the grace period of the previous rotation ends", func() {
+			rotator.err = broker.ErrRotationPending
+			_, err := kafkaBroker.RotateCredentials(ctx, instanceID, time.Hour, "admin")
+			Expect(err).To(MatchError(broker.ErrRotationPending.Error()))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+			Expect(auditLog.entries).To(BeEmpty())
+		})
+
+		It("revokes the replaced credentials on unbind and deprovision", func() {
+			someCreatorAndBinder.bindingExists = true
+			Expect(kafkaBroker.Unbind(ctx, instanceID, "bindingID", brokerapi.UnbindDetails{PlanID: topicPlanID})).To(Succeed())
+			Expect(rotator.removedBindings).To(ConsistOf("bindingID"))
+			_, err := kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotator.removedInstances).To(ConsistOf(instanceID))
+		})
+
+		It("revokes the credentials before deleting the topics, so that a failed deprovision can be repeated", func() {
+			someCreatorAndBinder.destroyErr = errors.New("cannot delete topics")
+			_, err := kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false)
+			Expect(err).To(MatchError("cannot delete topics"))
+			Expect(rotator.removedInstances).To(ConsistOf(instanceID))
+
+			someCreatorAndBinder.destroyErr = nil
+			_, err = kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(someCreatorAndBinder.destroyedInstanceIds).To(ConsistOf(instanceID))
+		})
+	})
+
+	Describe("secret store", func() {
+		var secrets *fakeSecretStore
+
+		storedCredentials := func() map[string]interface{} {
+			credentials := map[string]interface{}{}
+			Expect(secrets.Get("instanceID/bindingID/credentials", &credentials)).To(Succeed())
+			return credentials
+		}
+
+		BeforeEach(func() {
+			secrets = &fakeSecretStore{secrets: map[string][]byte{}, references: map[string]string{}}
+			kafkaBroker.SecretStore = secrets
+			kafkaBroker.SASLManager = &fakeSASLManager{bindings: map[string]bool{}}
+			kafkaBroker.Config.SecretStore.CredentialReferences = true
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("gives bindings to applications a reference to their credentials", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:       topicPlanID,
+				BindResource: &brokerapi.BindResource{AppGuid: "appGUID"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(Equal(map[string]interface{}{"credhub-ref": "/c/kafka-service-broker/instanceID/bindingID/credentials"}))
+			Expect(secrets.references).To(Equal(map[string]string{"instanceID/bindingID/credentials": "appGUID"}))
+			credentials := storedCredentials()
+			Expect(credentials["password"]).To(Equal("secret"))
+			Expect(credentials["topicName"]).To(Equal(instanceID))
+		})
+
+		It("gives service keys and bindings without references their credentials", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("password", "secret"))
+
+			kafkaBroker.Config.SecretStore.CredentialReferences = false
+			binding, err = kafkaBroker.Bind(ctx, instanceID, "otherID", brokerapi.BindDetails{PlanID: topicPlanID, AppGUID: "appGUID"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("password", "secret"))
+			Expect(secrets.references).To(BeEmpty())
+			Expect(secrets.secrets).To(HaveLen(2))
+			Expect(secrets.secrets).To(HaveKey("instanceID/bindingID/binding"))
+			Expect(secrets.secrets).To(HaveKey("instanceID/otherID/binding"))
+		})
+
+		It("lays referenced credentials out again when they are rotated", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				AppGUID:       "appGUID",
+				RawParameters: []byte(`{"credential_layout": "servicebinding"}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			kafkaBroker.CredentialRotator = &fakeCredentialRotator{}
+			_, err = kafkaBroker.RotateCredentials(ctx, instanceID, time.Hour, "admin")
+			Expect(err).NotTo(HaveOccurred())
+			credentials := storedCredentials()
+			Expect(credentials["password"]).To(Equal("new secret"))
+			Expect(credentials["sasl.mechanism"]).To(Equal("SCRAM-SHA-512"))
+			Expect(credentials["topic"]).To(Equal(instanceID))
+		})
+
+		It("deletes the referenced credentials of a binding on unbind", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID, AppGUID: "appGUID"})
+			Expect(err).NotTo(HaveOccurred())
+			someCreatorAndBinder.bindingExists = true
+			Expect(kafkaBroker.Unbind(ctx, instanceID, "bindingID", brokerapi.UnbindDetails{PlanID: topicPlanID})).To(Succeed())
+			Expect(secrets.secrets).To(BeEmpty())
+		})
+	})
+
+	Describe("client certificates", func() {
+		var issuer *fakeCertificateIssuer
+
+		BeforeEach(func() {
+			issuer = &fakeCertificateIssuer{bindings: map[string]bool{}}
+			kafkaBroker.CertificateIssuer = issuer
+			kafkaBroker.Config.KafkaConfiguration.SecurityProtocol = brokerconfig.SecurityProtocolSSL
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("adds the binding's certificate, key and CA chain to the credentials and client configs", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"client_config":["java","librdkafka","spring"]}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			credentials := binding.Credentials.(map[string]interface{})
+			Expect(credentials).To(HaveKeyWithValue("securityProtocol", "SSL"))
+			Expect(credentials).To(HaveKeyWithValue("clientCertificate", "cert bindingID"))
+			Expect(credentials).To(HaveKeyWithValue("clientKey", "key bindingID"))
+			Expect(credentials).To(HaveKeyWithValue("caChain", "ca"))
+			Expect(credentials["client_properties"]).To(ContainSubstring("ssl.keystore.type=PEM\n"))
+			Expect(credentials["client_properties"]).To(ContainSubstring("ssl.keystore.key=key bindingID\n"))
+			Expect(credentials["librdkafka"]).To(HaveKeyWithValue("ssl.certificate.pem", "cert bindingID"))
+			Expect(credentials["spring"]).To(HaveKeyWithValue("spring.kafka.ssl.key-store-certificate-chain", "cert bindingID"))
+
+			binding, err = kafkaBroker.Bind(ctx, instanceID, "otherID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"credential_layout":"servicebinding"}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("tls.crt", "cert otherID"))
+			Expect(binding.Credentials).To(HaveKeyWithValue("tls.key", "key otherID"))
+			Expect(binding.Credentials).To(HaveKeyWithValue("ca.crt", "ca"))
+		})
+
+		It("revokes the certificates on unbind and deprovision", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: topicPlanID})
+			Expect(err).NotTo(HaveOccurred())
+			someCreatorAndBinder.bindingExists = true
+			Expect(kafkaBroker.Unbind(ctx, instanceID, "bindingID", brokerapi.UnbindDetails{PlanID: topicPlanID})).To(Succeed())
+			Expect(issuer.bindings).To(BeEmpty())
+
+			_, err = kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(issuer.removedInstances).To(ConsistOf(instanceID))
+		})
+	})
+
+	Describe("ZooKeeper peers", func() {
+		const privatePlanID = "privatePlanID"
+		var log *bytes.Buffer
+
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"service","name":"kafka","description":"Kafka","plans":[
+				{"id":"`+topicPlanID+`","name":"topic","description":"Topic"},
+				{"id":"`+privatePlanID+`","name":"private","description":"Private","kafka":{"omit_zk_peers":true}}
+			]}]}`)
+			kafkaBroker.InstanceCreators["private"] = someCreatorAndBinder
+			kafkaBroker.InstanceBinders["private"] = someCreatorAndBinder
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+			log = &bytes.Buffer{}
+			kafkaBroker.Logger = lager.NewLogger("test")
+			kafkaBroker.Logger.RegisterSink(lager.NewWriterSink(log, lager.INFO))
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("leaves zkPeers out of the credentials of plans that omit them", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{PlanID: privatePlanID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).NotTo(HaveKey("zkPeers"))
+			Expect(binding.Credentials).To(HaveKeyWithValue("hostname", kafkaHostnames))
+			Expect(log.String()).To(BeEmpty())
+		})
+
+		It("still gives zkPeers to bindings that ask for them, with a warning", func() {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        privatePlanID,
+				RawParameters: []byte(`{"include_zk_peers":true}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("zkPeers", zkPeers))
+			Expect(log.String()).To(ContainSubstring("deprecated-include-zk-peers"))
+			Expect(log.String()).To(ContainSubstring(`"binding-id":"bindingID"`))
+		})
+
+		It("refuses zkPeers in the servicebinding layout", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"include_zk_peers":true,"credential_layout":"servicebinding"}`),
+			})
+			Expect(err).To(MatchError("include_zk_peers requires the 'default' credential layout"))
+		})
+	})
+
+	Describe("client configs", func() {
+		BeforeEach(func() {
+			kafkaBroker.ConsumerGroupManager = &fakeConsumerGroupManager{groups: map[string]string{}}
+			kafkaBroker.SASLManager = &fakeSASLManager{bindings: map[string]bool{}}
+			kafkaBroker.Config.KafkaConfiguration.SecurityProtocol = brokerconfig.SecurityProtocolSASLSSL
+			_, err := kafkaBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		bind := func(rawParameters string) map[string]interface{} {
+			binding, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(rawParameters),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return binding.Credentials.(map[string]interface{})
+		}
+
+		It("renders the requested client configurations from the binding's settings", func() {
+			credentials := bind(`{"client_config":["java","librdkafka","spring"]}`)
+			Expect(credentials["client_properties"]).To(Equal("bootstrap.servers=" + kafkaHostnames + "\n" +
+				"group.id=instanceID.bindingID\n" +
+				`sasl.jaas.config=org.apache.kafka.common.security.scram.ScramLoginModule required username="bindingID" password="secret";` + "\n" +
+				"sasl.mechanism=SCRAM-SHA-256\n" +
+				"security.protocol=SASL_SSL\n"))
+			Expect(credentials["librdkafka"]).To(Equal(map[string]string{
+				"bootstrap.servers": kafkaHostnames,
+				"security.protocol": "sasl_ssl",
+				"group.id":          "instanceID.bindingID",
+				"sasl.mechanisms":   "SCRAM-SHA-256",
+				"sasl.username":     "bindingID",
+				"sasl.password":     "secret",
+			}))
+			Expect(credentials["spring"]).To(HaveKeyWithValue("spring.kafka.template.default-topic", instanceID))
+			Expect(credentials["spring"]).To(HaveKeyWithValue("spring.kafka.consumer.group-id", "instanceID.bindingID"))
+			Expect(credentials["spring"]).To(HaveKeyWithValue("spring.kafka.properties.sasl.mechanism", "SCRAM-SHA-256"))
+		})
+
+		It("renders them as files in the servicebinding layout", func() {
+			credentials := bind(`{"client_config":["java","librdkafka","spring"],"credential_layout":"servicebinding"}`)
+			Expect(credentials["client.properties"]).To(ContainSubstring("security.protocol=SASL_SSL\n"))
+			Expect(credentials["librdkafka.json"]).To(ContainSubstring(`"sasl.username":"bindingID"`))
+			Expect(credentials["spring.properties"]).To(ContainSubstring("spring.kafka.template.default-topic=" + instanceID + "\n"))
+		})
+
+		It("includes none unless asked", func() {
+			credentials := bind(`{}`)
+			Expect(credentials).NotTo(HaveKey("client_properties"))
+			Expect(credentials).NotTo(HaveKey("librdkafka"))
+			Expect(credentials).NotTo(HaveKey("spring"))
+		})
+
+		It("refuses unknown client configurations", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"client_config":["yaml"]}`),
+			})
+			Expect(err).To(MatchError("client_config may list 'java', 'librdkafka' and 'spring', not 'yaml'"))
+		})
+	})
+
+	Describe("request context", func() {
+		var contextCreator *fakeContextCreatorAndBinder
+
+		BeforeEach(func() {
+			contextCreator = &fakeContextCreatorAndBinder{fakeInstanceCreatorAndBinder: someCreatorAndBinder}
+			kafkaBroker.InstanceCreators[planName] = contextCreator
+			kafkaBroker.InstanceBinders[planName] = contextCreator
+		})
+
+		It("gives context-aware plans the context and details of each request", func() {
+			requestCtx := broker.WithRequestID(ctx, "request-1")
+			details := brokerapi.ProvisionDetails{
+				PlanID:           topicPlanID,
+				OrganizationGUID: "org",
+				SpaceGUID:        "space",
+			}
+			_, err := kafkaBroker.Provision(requestCtx, instanceID, details, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contextCreator.provisions).To(HaveLen(1))
+			Expect(contextCreator.provisions[0].InstanceID).To(Equal(instanceID))
+			Expect(contextCreator.provisions[0].Plan).To(Equal(planName))
+			Expect(contextCreator.provisions[0].Details).To(Equal(details))
+
+			bindDetails := brokerapi.BindDetails{PlanID: topicPlanID, AppGUID: "app"}
+			_, err = kafkaBroker.Bind(requestCtx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contextCreator.binds).To(Equal([]broker.BindRequest{
+				{InstanceID: instanceID, BindingID: "bindingID", Plan: planName, Details: bindDetails},
+			}))
+
+			someCreatorAndBinder.bindingExists = true
+			unbindDetails := brokerapi.UnbindDetails{PlanID: topicPlanID}
+			Expect(kafkaBroker.Unbind(requestCtx, instanceID, "bindingID", unbindDetails)).To(Succeed())
+			Expect(contextCreator.unbinds).To(Equal([]broker.UnbindRequest{
+				{InstanceID: instanceID, BindingID: "bindingID", Plan: planName, Details: unbindDetails},
+			}))
+
+			deprovisionDetails := brokerapi.DeprovisionDetails{PlanID: topicPlanID}
+			_, err = kafkaBroker.Deprovision(requestCtx, instanceID, deprovisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contextCreator.deprovisions).To(Equal([]broker.DeprovisionRequest{
+				{InstanceID: instanceID, Plan: planName, Details: deprovisionDetails},
+			}))
+			Expect(contextCreator.requestIDs).To(Equal([]string{"request-1", "request-1", "request-1", "request-1"}))
+		})
+
+		It("does not start the operations of other plans once the request is cancelled", func() {
+			kafkaBroker.InstanceCreators[planName] = someCreatorAndBinder
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := kafkaBroker.Provision(cancelled, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).To(Equal(context.Canceled))
+			Expect(someCreatorAndBinder.createdInstanceIds).To(BeEmpty())
+		})
+
+		It("gives context-aware managers the context of each request", func() {
+			quotaManager := &fakeContextQuotaManager{fakeQuotaManager: &fakeQuotaManager{
+				instanceQuotas: map[string]broker.Quota{},
+				bindings:       map[string][]string{},
+			}}
+			kafkaBroker.QuotaManager = quotaManager
+
+			_, err := kafkaBroker.Provision(broker.WithRequestID(ctx, "request-3"), instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quotaManager.contexts).NotTo(BeEmpty())
+			for _, managerCtx := range quotaManager.contexts {
+				Expect(broker.RequestID(managerCtx)).To(Equal("request-3"))
+			}
+			Expect(quotaManager.instanceQuotas).To(HaveKey(instanceID))
+		})
+
+		It("runs a request to completion once it has started to change anything", func() {
+			quotaManager := &fakeContextQuotaManager{fakeQuotaManager: &fakeQuotaManager{
+				instanceQuotas: map[string]broker.Quota{},
+				bindings:       map[string][]string{},
+			}}
+			kafkaBroker.QuotaManager = quotaManager
+			requestCtx, cancel := context.WithCancel(broker.WithRequestID(ctx, "request-4"))
+			contextCreator.onCreate = cancel
+
+			_, err := kafkaBroker.Provision(requestCtx, instanceID, brokerapi.ProvisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requestCtx.Err()).To(Equal(context.Canceled))
+			Expect(quotaManager.instanceQuotas).To(HaveKey(instanceID))
+			managerCtx := quotaManager.contexts[len(quotaManager.contexts)-1]
+			Expect(managerCtx.Err()).NotTo(HaveOccurred())
+			Expect(broker.RequestID(managerCtx)).To(Equal("request-4"))
+		})
+
+		It("logs the request ID", func() {
+			logs := &bytes.Buffer{}
+			logger := lager.NewLogger("test")
+			logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+			kafkaBroker.Logger = logger
+			someCreatorAndBinder.Create(instanceID, broker.InstanceSettings{})
+
+			_, err := kafkaBroker.Bind(broker.WithRequestID(ctx, "request-2"), instanceID, "bindingID", brokerapi.BindDetails{
+				PlanID:        topicPlanID,
+				RawParameters: []byte(`{"include_zk_peers":true}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(logs.String()).To(ContainSubstring(`"request_id":"request-2"`))
+		})
+	})
+
+	Describe("retries", func() {
+		var recorder *fakeRequestRecorder
+		var secrets *fakeSecretStore
+		var quotaManager *fakeQuotaManager
+		var provisionDetails brokerapi.ProvisionDetails
+		var bindDetails brokerapi.BindDetails
+
+		BeforeEach(func() {
+			recorder = &fakeRequestRecorder{
+				instances: map[string]broker.InstanceAttributes{},
+				bindings:  map[string]broker.BindingAttributes{},
+			}
+			kafkaBroker.RequestRecorder = recorder
+			secrets = &fakeSecretStore{secrets: map[string][]byte{}, references: map[string]string{}}
+			kafkaBroker.SecretStore = secrets
+			quotaManager = &fakeQuotaManager{instanceQuotas: map[string]broker.Quota{}, bindings: map[string][]string{}}
+			kafkaBroker.QuotaManager = quotaManager
+			provisionDetails = brokerapi.ProvisionDetails{
+				ServiceID:        "serviceID",
+				PlanID:           topicPlanID,
+				OrganizationGUID: "org",
+				SpaceGUID:        "space",
+				RawParameters:    []byte(`{"producer_byte_rate": 1024, "consumer_byte_rate": 2048}`),
+			}
+			bindDetails = brokerapi.BindDetails{
+				ServiceID:    "serviceID",
+				PlanID:       topicPlanID,
+				BindResource: &brokerapi.BindResource{AppGuid: "app"},
+			}
+			_, err := kafkaBroker.Provision(ctx, instanceID, provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("records the attributes of provision and bind requests until they are undone", func() {
+			Expect(recorder.instances).To(Equal(map[string]broker.InstanceAttributes{instanceID: {
+				ServiceID:        "serviceID",
+				PlanID:           topicPlanID,
+				OrganizationGUID: "org",
+				SpaceGUID:        "space",
+				Parameters:       provisionDetails.RawParameters,
+			}}))
+
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.bindings).To(Equal(map[string]broker.BindingAttributes{instanceID + "/bindingID": {
+				ServiceID: "serviceID",
+				PlanID:    topicPlanID,
+				AppGUID:   "app",
+			}}))
+
+			someCreatorAndBinder.bindingExists = true
+			Expect(kafkaBroker.Unbind(ctx, instanceID, "bindingID", brokerapi.UnbindDetails{PlanID: topicPlanID})).To(Succeed())
+			Expect(recorder.bindings).To(BeEmpty())
+			_, err = kafkaBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{PlanID: topicPlanID}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.instances).To(BeEmpty())
+		})
+
+		It("accepts identical retries of a provision request, whatever the formatting of their parameters", func() {
+			provisionDetails.RawParameters = []byte(`{"consumer_byte_rate":2048,"producer_byte_rate":1024}`)
+			_, err := kafkaBroker.Provision(ctx, instanceID, provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(someCreatorAndBinder.createdInstanceIds).To(Equal([]string{instanceID}))
+		})
+
+		It("refuses provision requests for the same instance with other attributes", func() {
+			provisionDetails.SpaceGUID = "other-space"
+			_, err := kafkaBroker.Provision(ctx, instanceID, provisionDetails, false)
+			Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+
+			provisionDetails.SpaceGUID = "space"
+			provisionDetails.RawParameters = []byte(`{"producer_byte_rate": 512}`)
+			_, err = kafkaBroker.Provision(ctx, instanceID, provisionDetails, false)
+			Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+		})
+
+		It("refuses provision requests for instances whose attributes were not recorded", func() {
+			delete(recorder.instances, instanceID)
+			_, err := kafkaBroker.Provision(ctx, instanceID, provisionDetails, false)
+			Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+		})
+
+		It("completes a provision that failed part way when it is retried", func() {
+			quotaManager.err = errors.New("zookeeper is unavailable")
+			_, err := kafkaBroker.Provision(ctx, "otherInstanceID", provisionDetails, false)
+			Expect(err).To(MatchError("zookeeper is unavailable"))
+			Expect(someCreatorAndBinder.createdInstanceIds).To(ContainElement("otherInstanceID"))
+			Expect(recorder.instances["otherInstanceID"].Incomplete).To(BeTrue())
+
+			conflicting := provisionDetails
+			conflicting.SpaceGUID = "other-space"
+			_, err = kafkaBroker.Provision(ctx, "otherInstanceID", conflicting, false)
+			Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+
+			quotaManager.err = nil
+			_, err = kafkaBroker.Provision(ctx, "otherInstanceID", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(someCreatorAndBinder.createdInstanceIds).To(Equal([]string{instanceID, "otherInstanceID"}))
+			Expect(quotaManager.instanceQuotas["otherInstanceID"]).To(Equal(broker.Quota{ProducerByteRate: 1024, ConsumerByteRate: 2048}))
+			Expect(recorder.instances["otherInstanceID"].Incomplete).To(BeFalse())
+
+			quotaManager.err = errors.New("zookeeper is unavailable")
+			_, err = kafkaBroker.Provision(ctx, "otherInstanceID", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("refuses a provision request while one with other attributes provisions the same instance", func() {
+			concurrent := broker.InstanceAttributes{ServiceID: "serviceID", PlanID: topicPlanID, SpaceGUID: "other-space", Incomplete: true}
+			recorder.instances["otherInstanceID"] = concurrent
+			_, err := kafkaBroker.Provision(ctx, "otherInstanceID", provisionDetails, false)
+			Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+			Expect(someCreatorAndBinder.createdInstanceIds).NotTo(ContainElement("otherInstanceID"))
+			Expect(recorder.instances["otherInstanceID"]).To(Equal(concurrent))
+		})
+
+		It("refuses an identical provision request whose instance was created concurrently", func() {
+			_, err := kafkaBroker.Provision(ctx, "otherInstanceID", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			recorded := recorder.instances["otherInstanceID"]
+			recorded.Incomplete = true
+			recorder.instances["otherInstanceID"] = recorded
+			someCreatorAndBinder.createdInstanceIds = []string{instanceID}
+			someCreatorAndBinder.createErr = kazoo.ErrTopicExists
+
+			_, err = kafkaBroker.Provision(ctx, "otherInstanceID", provisionDetails, false)
+			Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+			Expect(recorder.instances).To(HaveKey("otherInstanceID"))
+		})
+
+		It("forgets the attributes of a provision request whose instance could not be created", func() {
+			someCreatorAndBinder.createErr = errors.New("zookeeper is unavailable")
+			_, err := kafkaBroker.Provision(ctx, "otherInstanceID", provisionDetails, false)
+			Expect(err).To(MatchError("zookeeper is unavailable"))
+			Expect(recorder.instances).NotTo(HaveKey("otherInstanceID"))
+
+			someCreatorAndBinder.createErr = nil
+			provisionDetails.SpaceGUID = "other-space"
+			_, err = kafkaBroker.Provision(ctx, "otherInstanceID", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.instances["otherInstanceID"].SpaceGUID).To(Equal("other-space"))
+		})
+
+		It("gives identical retries of a bind request the credentials of the binding, and refuses those with other attributes", func() {
+			first, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			retry, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retry).To(Equal(first))
+			Expect(quotaManager.bindings[instanceID]).To(Equal([]string{"bindingID"}))
+
+			bindDetails.BindResource = &brokerapi.BindResource{AppGuid: "other-app"}
+			_, err = kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+		})
+
+		It("refers identical retries of a bind request to the credentials of the binding", func() {
+			kafkaBroker.Config.SecretStore.CredentialReferences = true
+			first, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first.Credentials).To(HaveKey("credhub-ref"))
+			retry, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retry).To(Equal(first))
+			Expect(quotaManager.bindings[instanceID]).To(Equal([]string{"bindingID"}))
+		})
+
+		It("binds again identical bind requests without a secret store, as their credentials were not kept", func() {
+			kafkaBroker.SecretStore = nil
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.bindings).To(BeEmpty())
+			_, err = kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quotaManager.bindings[instanceID]).To(Equal([]string{"bindingID", "bindingID"}))
+		})
+
+		It("refuses identical retries of a bind request whose credentials were not kept", func() {
+			_, err := kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			secrets.secrets = map[string][]byte{}
+			_, err = kafkaBroker.Bind(ctx, instanceID, "bindingID", bindDetails)
+			Expect(err).To(MatchError(ContainSubstring("its credentials were not kept")))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusConflict))
+			Expect(quotaManager.bindings[instanceID]).To(Equal([]string{"bindingID"}))
+		})
+	})
 })
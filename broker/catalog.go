@@ -2,57 +2,184 @@ package broker
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/pivotal-cf/brokerapi"
 
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
 	"github.com/starkandwayne/kafka-service-broker/data"
 )
 
 // Catalog contains the service catalog returned via /v2/catalog
 type Catalog struct {
 	Services []brokerapi.Service
+	// Plans holds the broker's own settings for each plan, keyed by plan name
+	Plans map[string]PlanSettings `json:"-"`
 }
 
-// Catalog imports the default /v2/catalog JSON output
-// Can be overridden by environment variables
-func (kBroker *KafkaServiceBroker) Catalog() (catalog *Catalog) {
-	if kBroker.catalog == nil {
-		catalogJSON, err := data.Asset("assets/catalog.json")
-		if err != nil {
-			panic(err)
-		}
+// PlanSettings are declared in the catalog JSON under "kafka" on each plan.
+// They are not part of the /v2/catalog response.
+type PlanSettings struct {
+	Quota       Quota             `json:"quota"`
+	TopicLimits TopicLimits       `json:"topic_limits"`
+	TopicConfig TopicConfigPolicy `json:"topic_config"`
+	// CompanionTopics are created by default with each instance of plans whose InstanceCreator is a CompanionTopicCreator
+	CompanionTopics CompanionTopics `json:"companion_topics"`
+	// ConsumerGroupScope is ConsumerGroupPerBinding (the default) or ConsumerGroupPerInstance
+	ConsumerGroupScope string `json:"consumer_group_scope"`
+	// CredentialLayout is CredentialLayoutDefault (the default) or CredentialLayoutServiceBinding
+	CredentialLayout string `json:"credential_layout"`
+	// OmitZookeeperPeers leaves zkPeers out of the credentials of bindings, unless a binding asks for them
+	OmitZookeeperPeers bool `json:"omit_zk_peers"`
+}
 
-		catalogOverride := os.Getenv("BROKER_CATALOG_JSON")
-		if catalogOverride != "" {
-			if _, err := os.Stat(catalogOverride); !os.IsNotExist(err) {
-				catalogJSON, err = ioutil.ReadFile(catalogOverride)
-				if err != nil {
-					panic(err)
-				}
-			} else {
-				catalogJSON = []byte(catalogOverride)
-			}
-		}
+// catalogPlanSettings is the subset of the catalog JSON that holds PlanSettings
+type catalogPlanSettings struct {
+	Services []struct {
+		Plans []struct {
+			Name  string       `json:"name"`
+			Kafka PlanSettings `json:"kafka"`
+		} `json:"plans"`
+	} `json:"services"`
+}
 
-		catalog = &Catalog{}
-		if err := json.Unmarshal(catalogJSON, catalog); err != nil {
-			panic(err)
-		}
-		if os.Getenv("BROKER_SERVICE_GUID") != "" {
-			catalog.Services[0].ID = os.Getenv("BROKER_SERVICE_GUID")
-		}
-		if os.Getenv("BROKER_SERVICE_NAME") != "" {
-			catalog.Services[0].Name = os.Getenv("BROKER_SERVICE_NAME")
+// Catalog returns the catalog the broker was loaded with by LoadCatalog, or an empty catalog
+// if it was not loaded.
+func (kBroker *KafkaServiceBroker) Catalog() *Catalog {
+	if catalog, ok := kBroker.catalog.Load().(*Catalog); ok {
+		return catalog
+	}
+	return &Catalog{Plans: map[string]PlanSettings{}}
+}
+
+// LoadCatalog loads the catalog from the variables of the broker's configuration, and replaces
+// the broker's if ValidateCatalog finds no problem with it, given the plans of InstanceCreators.
+// Requests already being served keep the catalog they started with.
+func (kBroker *KafkaServiceBroker) LoadCatalog() error {
+	getenv := kBroker.Config.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	catalogJSON, err := CatalogJSON(getenv)
+	if err != nil {
+		return err
+	}
+	if problems := ValidateCatalog(catalogJSON, getenv, kBroker.Plans()); len(problems) > 0 {
+		return catalogProblems(problems)
+	}
+	catalog, err := ParseCatalog(catalogJSON, getenv)
+	if err != nil {
+		return err
+	}
+	kBroker.catalog.Store(catalog)
+	return nil
+}
+
+// Plans returns the names of the plans the broker has an InstanceCreator for, in order
+func (kBroker *KafkaServiceBroker) Plans() []string {
+	plans := make([]string, 0, len(kBroker.InstanceCreators))
+	for plan := range kBroker.InstanceCreators {
+		plans = append(plans, plan)
+	}
+	sort.Strings(plans)
+	return plans
+}
+
+// catalogProblems returns an error of the problems ValidateCatalog found, or the only one
+func catalogProblems(problems []error) error {
+	if len(problems) == 1 {
+		return problems[0]
+	}
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+	return fmt.Errorf("the catalog has %d problems: %s", len(problems), strings.Join(messages, "; "))
+}
+
+// CatalogJSON returns the catalog JSON the broker loads: that of BROKER_CATALOG_JSON, which is
+// either a file or the JSON itself, or else the default catalog
+func CatalogJSON(getenv brokerconfig.Getenv) ([]byte, error) {
+	catalogOverride := getenv("BROKER_CATALOG_JSON")
+	if catalogOverride == "" {
+		return data.Asset("assets/catalog.json")
+	}
+	if _, err := os.Stat(catalogOverride); err == nil {
+		return ioutil.ReadFile(catalogOverride)
+	}
+	return []byte(catalogOverride), nil
+}
+
+// ParseCatalog parses catalog JSON, checks the settings of its plans, and applies the
+// BROKER_SERVICE_GUID, BROKER_SERVICE_NAME and BROKER_PLANn_GUID overrides getenv looks up to its
+// first service
+func ParseCatalog(catalogJSON []byte, getenv brokerconfig.Getenv) (*Catalog, error) {
+	catalog := &Catalog{}
+	if err := json.Unmarshal(catalogJSON, catalog); err != nil {
+		return nil, fmt.Errorf("catalog: %v", err)
+	}
+	if len(catalog.Services) == 0 {
+		return nil, errors.New("catalog: there must be a service")
+	}
+	settings := catalogPlanSettings{}
+	if err := json.Unmarshal(catalogJSON, &settings); err != nil {
+		return nil, fmt.Errorf("catalog: %v", err)
+	}
+	catalog.Plans = map[string]PlanSettings{}
+	for _, service := range settings.Services {
+		for _, plan := range service.Plans {
+			if err := plan.Kafka.validate(); err != nil {
+				return nil, fmt.Errorf("plan '%s': %v", plan.Name, err)
+			}
+			catalog.Plans[plan.Name] = plan.Kafka
 		}
-		for i := range catalog.Services[0].Plans {
-			if os.Getenv(fmt.Sprintf("BROKER_PLAN%d_GUID", i)) != "" {
-				catalog.Services[0].Plans[i].ID = os.Getenv(fmt.Sprintf("BROKER_PLAN%d_GUID", i))
+	}
+	for _, service := range catalog.Services {
+		for i, plan := range service.Plans {
+			settings := catalog.Plans[plan.Name]
+			bullets := append(settings.Quota.Bullets(), settings.TopicLimits.Bullets()...)
+			bullets = append(bullets, settings.TopicConfig.Bullets()...)
+			bullets = append(bullets, settings.CompanionTopics.Bullets()...)
+			if len(bullets) > 0 {
+				if plan.Metadata == nil {
+					service.Plans[i].Metadata = &brokerapi.ServicePlanMetadata{}
+				}
+				service.Plans[i].Metadata.Bullets = append(service.Plans[i].Metadata.Bullets, bullets...)
 			}
 		}
-		kBroker.catalog = catalog
 	}
-	return kBroker.catalog
+	if getenv("BROKER_SERVICE_GUID") != "" {
+		catalog.Services[0].ID = getenv("BROKER_SERVICE_GUID")
+	}
+	if getenv("BROKER_SERVICE_NAME") != "" {
+		catalog.Services[0].Name = getenv("BROKER_SERVICE_NAME")
+	}
+	for i := range catalog.Services[0].Plans {
+		if getenv(fmt.Sprintf("BROKER_PLAN%d_GUID", i)) != "" {
+			catalog.Services[0].Plans[i].ID = getenv(fmt.Sprintf("BROKER_PLAN%d_GUID", i))
+		}
+	}
+	return catalog, nil
+}
+
+// validate checks the settings of a plan
+func (settings PlanSettings) validate() error {
+	if err := settings.TopicLimits.Validate(); err != nil {
+		return err
+	}
+	if err := settings.TopicConfig.Validate(); err != nil {
+		return err
+	}
+	if err := settings.CompanionTopics.Validate(); err != nil {
+		return err
+	}
+	if err := validateConsumerGroupScope(settings.ConsumerGroupScope); err != nil {
+		return err
+	}
+	return validateCredentialLayout(settings.CredentialLayout)
 }
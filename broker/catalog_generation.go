@@ -0,0 +1,90 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// catalogGUIDNamespace is the name-based UUID namespace of the GUIDs of generated catalogs
+var catalogGUIDNamespace = [16]byte{0x6d, 0xca, 0x1c, 0x8c, 0xc4, 0xc4, 0x49, 0x93, 0x8f, 0x12, 0x60, 0x2e, 0x00, 0x2c, 0x8a, 0xf8}
+
+// CatalogGUID returns the GUID of a service, or of a plan of a service, named by names: a name-based
+// (version 5) UUID, so that the GUIDs of a generated catalog stay the same every time it is generated
+func CatalogGUID(names ...string) string {
+	hash := sha1.New()
+	_, _ = hash.Write(catalogGUIDNamespace[:])
+	_, _ = hash.Write([]byte(strings.Join(names, "/")))
+	uuid := hash.Sum(nil)[:16]
+	uuid[6] = uuid[6]&0x0f | 0x50
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// GenerateCatalog generates a catalog of one service from the first service of a template catalog,
+// such as the default one. The service is named serviceName, unless it is empty, and offers the
+// plans of the template or, if plans is not empty, those plans: plans the template does not have
+// are added with a default description. The GUIDs of the service and its plans are derived from
+// their names by CatalogGUID. Everything else, e.g. metadata and plan settings, is kept.
+func GenerateCatalog(template []byte, serviceName string, plans []string) ([]byte, error) {
+	catalog := struct {
+		Services []map[string]interface{} `json:"services"`
+	}{}
+	if err := json.Unmarshal(template, &catalog); err != nil {
+		return nil, fmt.Errorf("catalog: %v", err)
+	}
+	if len(catalog.Services) == 0 {
+		return nil, errors.New("catalog: there must be a service")
+	}
+	service := catalog.Services[0]
+	if serviceName == "" {
+		serviceName, _ = service["name"].(string)
+	}
+	service["name"] = serviceName
+	service["id"] = CatalogGUID(serviceName)
+
+	templatePlans := map[string]map[string]interface{}{}
+	templateOrder := []string{}
+	planList, _ := service["plans"].([]interface{})
+	for _, plan := range planList {
+		plan, ok := plan.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("catalog: plans must be objects")
+		}
+		name, _ := plan["name"].(string)
+		templatePlans[name] = plan
+		templateOrder = append(templateOrder, name)
+	}
+	if len(plans) == 0 {
+		plans = templateOrder
+	}
+
+	generated := []interface{}{}
+	for _, name := range plans {
+		plan, ok := templatePlans[name]
+		if !ok {
+			plan = map[string]interface{}{
+				"name":        name,
+				"description": fmt.Sprintf("The %s plan on shared Kafka", name),
+				"free":        true,
+				"metadata":    map[string]interface{}{"cost": 0},
+			}
+		}
+		plan["id"] = CatalogGUID(serviceName, name)
+		generated = append(generated, plan)
+	}
+	service["plans"] = generated
+	catalog.Services = catalog.Services[:1]
+
+	generatedJSON := &bytes.Buffer{}
+	encoder := json.NewEncoder(generatedJSON)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(catalog); err != nil {
+		return nil, err
+	}
+	return generatedJSON.Bytes(), nil
+}
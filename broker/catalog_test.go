@@ -13,44 +13,122 @@ var _ = Describe("Kafka Catalog", func() {
 	var kafkaBroker *broker.KafkaServiceBroker
 
 	BeforeEach(func() {
-		os.Clearenv()
-		kafkaBroker = &broker.KafkaServiceBroker{}
+		unsetBrokerEnvironment()
+		kafkaBroker = &broker.KafkaServiceBroker{
+			InstanceCreators: withDefaultPlans(map[string]broker.InstanceCreator{}, &fakeInstanceCreatorAndBinder{}),
+		}
 	})
 
+	loadedCatalog := func() *broker.Catalog {
+		Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+		return kafkaBroker.Catalog()
+	}
+
 	Describe(".Catalog", func() {
+		It("is empty until the catalog is loaded", func() {
+			Expect(kafkaBroker.Catalog().Services).To(BeEmpty())
+			Expect(kafkaBroker.Catalog().Plans).To(BeEmpty())
+		})
 		Context("shared kafka/zk cluster only", func() {
-			It("has one service, two plans", func() {
-				catalog := kafkaBroker.Catalog()
+			It("has one service, four plans", func() {
+				catalog := loadedCatalog()
 				Expect(len(catalog.Services)).To(Equal(1))
-				Expect(len(catalog.Services[0].Plans)).To(Equal(2))
+				Expect(len(catalog.Services[0].Plans)).To(Equal(4))
+				Expect(catalog.Services[0].Plans[2].Name).To(Equal("compacted"))
+				Expect(catalog.Services[0].Plans[3].Name).To(Equal("multi-topic"))
 			})
 		})
 		Context("override via $BROKER_CATALOG_JSON", func() {
-			It("has no services", func() {
+			It("is refused if it is invalid", func() {
 				os.Setenv("BROKER_CATALOG_JSON", "{\"services\":[{\"uuid\":\"x\"}]}")
-				catalog := kafkaBroker.Catalog()
-				Expect(len(catalog.Services)).To(Equal(1))
-				Expect(len(catalog.Services[0].Plans)).To(Equal(0))
+				Expect(kafkaBroker.LoadCatalog()).To(MatchError(HavePrefix("the catalog has 4 problems: services[0]: id is required")))
+				Expect(kafkaBroker.Catalog().Services).To(BeEmpty())
+			})
+
+			It("is refused if it has plans the broker does not implement", func() {
+				os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"x","name":"kafka","description":"Kafka","plans":[
+					{"id":"a","name":"gold","description":"Gold"}
+				]}]}`)
+				Expect(kafkaBroker.LoadCatalog()).To(MatchError(ContainSubstring("no implementation of plan 'gold'")))
+			})
+		})
+		Context("plan settings", func() {
+			BeforeEach(func() {
+				os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"x","name":"kafka","description":"Kafka","plans":[
+					{"id":"a","name":"topic","description":"Topic","kafka":{"quota":{"producer_byte_rate":1024,"request_percentage":25}}},
+					{"id":"b","name":"shared","description":"Shared"}
+				]}]}`)
+			})
+
+			It("are read from the 'kafka' key of each plan", func() {
+				catalog := loadedCatalog()
+				Expect(catalog.Plans["topic"].Quota).To(Equal(broker.Quota{ProducerByteRate: 1024, RequestPercentage: 25}))
+				Expect(catalog.Plans["shared"].Quota.IsZero()).To(BeTrue())
+			})
+
+			It("describe quota tiers in the plan metadata", func() {
+				catalog := loadedCatalog()
+				Expect(catalog.Services[0].Plans[0].Metadata.Bullets).To(Equal([]string{
+					"Producer quota: 1024 bytes/sec per binding",
+					"Request quota: 25% of broker request handler time per binding",
+				}))
+				Expect(catalog.Services[0].Plans[1].Metadata).To(BeNil())
+			})
+		})
+		Context("invalid topic config policy", func() {
+			BeforeEach(func() {
+				os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"x","name":"kafka","description":"Kafka","plans":[
+					{"id":"a","name":"topic","description":"Topic","kafka":{"topic_config":{
+						"defaults":{"retention.ms":"1000"},
+						"overridable":{"retention.ms":{"min":60000}}
+					}}}
+				]}]}`)
+			})
+
+			It("is refused", func() {
+				Expect(kafkaBroker.LoadCatalog()).To(HaveOccurred())
+			})
+		})
+		Context("invalid consumer group scope", func() {
+			BeforeEach(func() {
+				os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"x","name":"kafka","description":"Kafka","plans":[
+					{"id":"a","name":"topic","description":"Topic","kafka":{"consumer_group_scope":"topic"}}
+				]}]}`)
+			})
+
+			It("is refused", func() {
+				Expect(kafkaBroker.LoadCatalog()).To(HaveOccurred())
+			})
+		})
+		Context("invalid credential layout", func() {
+			BeforeEach(func() {
+				os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"x","name":"kafka","description":"Kafka","plans":[
+					{"id":"a","name":"topic","description":"Topic","kafka":{"credential_layout":"yaml"}}
+				]}]}`)
+			})
+
+			It("is refused", func() {
+				Expect(kafkaBroker.LoadCatalog()).To(HaveOccurred())
 			})
 		})
 		Context("override $BROKER_SERVICE_GUID", func() {
 			It("has no services", func() {
 				os.Setenv("BROKER_SERVICE_GUID", "XXX")
-				catalog := kafkaBroker.Catalog()
+				catalog := loadedCatalog()
 				Expect(catalog.Services[0].ID).To(Equal("XXX"))
 			})
 		})
 		Context("override $BROKER_SERVICE_NAME", func() {
 			It("has no services", func() {
-				os.Setenv("BROKER_SERVICE_NAME", "XXX")
-				catalog := kafkaBroker.Catalog()
-				Expect(catalog.Services[0].Name).To(Equal("XXX"))
+				os.Setenv("BROKER_SERVICE_NAME", "kafka-xxx")
+				catalog := loadedCatalog()
+				Expect(catalog.Services[0].Name).To(Equal("kafka-xxx"))
 			})
 		})
 		Context("override $BROKER_PLAN0_GUID", func() {
 			It("has no services", func() {
 				os.Setenv("BROKER_PLAN0_GUID", "XXX")
-				catalog := kafkaBroker.Catalog()
+				catalog := loadedCatalog()
 				Expect(catalog.Services[0].Plans[0].ID).To(Equal("XXX"))
 				Expect(catalog.Services[0].Plans[1].ID).ToNot(Equal("XXX"))
 			})
@@ -58,10 +136,116 @@ var _ = Describe("Kafka Catalog", func() {
 		Context("override $BROKER_PLAN1_GUID", func() {
 			It("has no services", func() {
 				os.Setenv("BROKER_PLAN1_GUID", "XXX")
-				catalog := kafkaBroker.Catalog()
+				catalog := loadedCatalog()
 				Expect(catalog.Services[0].Plans[0].ID).ToNot(Equal("XXX"))
 				Expect(catalog.Services[0].Plans[1].ID).To(Equal("XXX"))
 			})
 		})
 	})
+
+	Describe(".LoadCatalog", func() {
+		BeforeEach(func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"x","name":"kafka","description":"Kafka","plans":[{"id":"a","name":"topic","description":"Topic"}]}]}`)
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+		})
+
+		It("replaces the catalog", func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"y","name":"kafka","description":"Kafka","plans":[{"id":"a","name":"topic","description":"Topic"}]}]}`)
+			Expect(kafkaBroker.LoadCatalog()).To(Succeed())
+			Expect(kafkaBroker.Catalog().Services[0].ID).To(Equal("y"))
+		})
+
+		It("keeps the catalog when the new one is invalid", func() {
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[{"id":"y","name":"kafka","description":"Kafka","plans":[
+				{"id":"a","name":"topic","description":"Topic","kafka":{"credential_layout":"yaml"}}
+			]}]}`)
+			Expect(kafkaBroker.LoadCatalog()).To(MatchError("plan 'topic': credential_layout must be 'default' or 'servicebinding', not 'yaml'"))
+
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":[]}`)
+			Expect(kafkaBroker.LoadCatalog()).To(MatchError("catalog: there must be a service"))
+
+			os.Setenv("BROKER_CATALOG_JSON", `{"services":`)
+			Expect(kafkaBroker.LoadCatalog()).To(HaveOccurred())
+			Expect(kafkaBroker.Catalog().Services[0].ID).To(Equal("x"))
+		})
+	})
+
+	Describe("ValidateCatalog", func() {
+		plans := []string{"topic", "shared"}
+
+		It("accepts a catalog that follows the Open Service Broker API", func() {
+			Expect(broker.ValidateCatalog([]byte(`{"services":[{"id":"s","name":"kafka","description":"Kafka","metadata":{"displayName":"Kafka"},"plans":[
+				{"id":"a","name":"topic","description":"A topic","schemas":{"service_instance":{"create":{"parameters":{
+					"$schema":"http://json-schema.org/draft-04/schema#","type":"object","properties":{"partitions":{"type":"integer"}}
+				}}}}},
+				{"id":"b","name":"shared","description":"Topics"}
+			]}]}`), os.Getenv, plans)).To(BeEmpty())
+		})
+
+		It("reports every problem", func() {
+			problems := broker.ValidateCatalog([]byte(`{"services":[{"id":"s","name":"Kafka","plans":[
+				{"id":"s","name":"topic","description":"A topic"},
+				{"id":"b","name":"gold","description":"Gold","schemas":{"service_instance":{"create":{"parameters":{"type":"object"}}}}},
+				{"id":"","name":"topic","description":"Another topic"}
+			]}]}`), os.Getenv, plans)
+			Expect(problems).To(ConsistOf(
+				MatchError("services[0]: name 'Kafka' must be lowercase letters, digits, '-', '_' and '.', without spaces"),
+				MatchError("services[0]: description is required"),
+				MatchError("services[0].plans[0]: id 's' is also the id of services[0]"),
+				MatchError("services[0].plans[1]: no implementation of plan 'gold'; plans must be named after one of [topic shared]"),
+				MatchError("services[0].plans[1]: schemas service_instance.create.parameters must declare its '$schema', e.g. 'http://json-schema.org/draft-04/schema#'"),
+				MatchError("services[0].plans[2]: id is required"),
+				MatchError("services[0].plans[2]: name 'topic' is also the name of services[0].plans[0]; the settings of plans are found by name"),
+			))
+		})
+
+		It("reports catalogs that cannot be loaded", func() {
+			Expect(broker.ValidateCatalog([]byte(`{"services":`), os.Getenv, plans)).To(ConsistOf(MatchError("catalog: unexpected end of JSON input")))
+			Expect(broker.ValidateCatalog([]byte(`{"services":[]}`), os.Getenv, plans)).To(ConsistOf(MatchError("catalog: there must be a service")))
+			Expect(broker.ValidateCatalog([]byte(`{"services":[{"id":"s","name":"kafka","metadata":[]}]}`), os.Getenv, plans)).To(HaveLen(1))
+		})
+
+		It("reports malformed schemas", func() {
+			problems := broker.ValidateCatalog([]byte(`{"services":[{"id":"s","name":"kafka","description":"Kafka","plans":[
+				{"id":"a","name":"topic","description":"A topic","schemas":{"service_binding":{"update":{}}}}
+			]}]}`), os.Getenv, plans)
+			Expect(problems).To(ConsistOf(MatchError("services[0].plans[0]: schemas service_binding has unknown key 'update'; expected one of [create]")))
+		})
+	})
+
+	Describe("GenerateCatalog", func() {
+		template := []byte(`{"services":[{"id":"old","name":"kafka","description":"Kafka & co","plans":[
+			{"id":"a","name":"topic","description":"A topic","kafka":{"quota":{"producer_byte_rate":1024}}},
+			{"id":"b","name":"shared","description":"Topics"}
+		]}]}`)
+
+		It("derives stable GUIDs from the names of the service and plans", func() {
+			catalogJSON, err := broker.GenerateCatalog(template, "", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(broker.GenerateCatalog(template, "", nil)).To(Equal(catalogJSON))
+
+			os.Setenv("BROKER_CATALOG_JSON", string(catalogJSON))
+			catalog := loadedCatalog()
+			Expect(catalog.Services[0].ID).To(Equal(broker.CatalogGUID("kafka")))
+			Expect(catalog.Services[0].Description).To(Equal("Kafka & co"))
+			Expect(catalog.Services[0].Plans[0].ID).To(Equal(broker.CatalogGUID("kafka", "topic")))
+			Expect(catalog.Services[0].Plans[1].ID).To(Equal(broker.CatalogGUID("kafka", "shared")))
+			Expect(catalog.Plans["topic"].Quota.ProducerByteRate).To(Equal(int64(1024)))
+			Expect(broker.CatalogGUID("kafka")).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		})
+
+		It("renames the service and chooses its plans", func() {
+			catalogJSON, err := broker.GenerateCatalog(template, "kafka-eu", []string{"shared", "compacted"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(broker.ValidateCatalog(catalogJSON, os.Getenv, []string{"shared", "compacted"})).To(BeEmpty())
+
+			os.Setenv("BROKER_CATALOG_JSON", string(catalogJSON))
+			catalog := loadedCatalog()
+			Expect(catalog.Services[0].Name).To(Equal("kafka-eu"))
+			Expect(catalog.Services[0].ID).NotTo(Equal(broker.CatalogGUID("kafka")))
+			Expect(catalog.Services[0].Plans).To(HaveLen(2))
+			Expect(catalog.Services[0].Plans[0].Description).To(Equal("Topics"))
+			Expect(catalog.Services[0].Plans[1].ID).To(Equal(broker.CatalogGUID("kafka-eu", "compacted")))
+		})
+	})
 })
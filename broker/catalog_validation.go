@@ -0,0 +1,184 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// cliFriendlyName is the form the Open Service Broker API requires of service and plan names
+var cliFriendlyName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// catalogDocument is the catalog JSON, with the schemas of plans left raw for validation.
+// Metadata are parsed by ParseCatalog.
+type catalogDocument struct {
+	Services []struct {
+		Plans []struct {
+			Schemas json.RawMessage `json:"schemas"`
+		} `json:"plans"`
+	} `json:"services"`
+}
+
+// ValidateCatalog checks catalog JSON, as ParseCatalog would load it, against the rules of the
+// Open Service Broker API: every service and plan has an ID, a CLI-friendly name and a description,
+// IDs are unique, metadata are well-formed and so are the schemas of plans. It also checks that every
+// plan is one of the implementations, since the broker finds the implementation of a plan by name.
+// It returns every problem it finds.
+func ValidateCatalog(catalogJSON []byte, getenv brokerconfig.Getenv, implementations []string) []error {
+	catalog, err := ParseCatalog(catalogJSON, getenv)
+	if err != nil {
+		return []error{err}
+	}
+	document := catalogDocument{}
+	if err = json.Unmarshal(catalogJSON, &document); err != nil {
+		return []error{fmt.Errorf("catalog: %v", err)}
+	}
+
+	problems := []error{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+	implemented := map[string]bool{}
+	for _, name := range implementations {
+		implemented[name] = true
+	}
+	ids := map[string]string{}
+	checkID := func(where, id string) {
+		if id == "" {
+			problem("%s: id is required", where)
+		} else if other, ok := ids[id]; ok {
+			problem("%s: id '%s' is also the id of %s", where, id, other)
+		} else {
+			ids[id] = where
+		}
+	}
+	checkName := func(where, name string) {
+		if name == "" {
+			problem("%s: name is required", where)
+		} else if !cliFriendlyName.MatchString(name) {
+			problem("%s: name '%s' must be lowercase letters, digits, '-', '_' and '.', without spaces", where, name)
+		}
+	}
+
+	serviceNames := map[string]bool{}
+	planNames := map[string]string{}
+	for i, service := range catalog.Services {
+		where := fmt.Sprintf("services[%d]", i)
+		checkID(where, service.ID)
+		checkName(where, service.Name)
+		if serviceNames[service.Name] {
+			problem("%s: name '%s' is also the name of another service", where, service.Name)
+		}
+		serviceNames[service.Name] = true
+		if service.Description == "" {
+			problem("%s: description is required", where)
+		}
+		if len(service.Plans) == 0 {
+			problem("%s: there must be a plan", where)
+		}
+
+		for j, plan := range service.Plans {
+			where := fmt.Sprintf("services[%d].plans[%d]", i, j)
+			checkID(where, plan.ID)
+			checkName(where, plan.Name)
+			if other, ok := planNames[plan.Name]; ok {
+				problem("%s: name '%s' is also the name of %s; the settings of plans are found by name", where, plan.Name, other)
+			}
+			planNames[plan.Name] = where
+			if plan.Name != "" && !implemented[plan.Name] {
+				problem("%s: no implementation of plan '%s'; plans must be named after one of %v", where, plan.Name, implementations)
+			}
+			if plan.Description == "" {
+				problem("%s: description is required", where)
+			}
+			if err = checkSchemas(document.Services[i].Plans[j].Schemas); err != nil {
+				problem("%s: schemas %v", where, err)
+			}
+		}
+	}
+	return problems
+}
+
+// checkObject returns an error unless raw JSON, if any, is an object
+func checkObject(raw json.RawMessage) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return errors.New("must be an object")
+	}
+	return nil
+}
+
+// checkSchemas checks the schemas of a plan: the JSON schemas of the parameters of creating and
+// updating service instances, and of creating service bindings
+func checkSchemas(raw json.RawMessage) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	schemas := map[string]map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &schemas); err != nil {
+		return errors.New("must be an object of 'service_instance' and 'service_binding' schemas")
+	}
+	actions := map[string][]string{
+		"service_instance": {"create", "update"},
+		"service_binding":  {"create"},
+	}
+	for resource, byAction := range schemas {
+		allowed, ok := actions[resource]
+		if !ok {
+			return fmt.Errorf("has unknown key '%s'; expected 'service_instance' or 'service_binding'", resource)
+		}
+		for action, schema := range byAction {
+			if !contains(allowed, action) {
+				return fmt.Errorf("%s has unknown key '%s'; expected one of %v", resource, action, allowed)
+			}
+			for key, value := range schema {
+				if key != "parameters" {
+					return fmt.Errorf("%s.%s has unknown key '%s'; expected 'parameters'", resource, action, key)
+				}
+				if err := checkJSONSchema(value); err != nil {
+					return fmt.Errorf("%s.%s.parameters %v", resource, action, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkJSONSchema checks the keywords of a JSON schema that the platform relies on
+func checkJSONSchema(raw json.RawMessage) error {
+	schema := struct {
+		Schema     *string                    `json:"$schema"`
+		Type       interface{}                `json:"type"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return fmt.Errorf("must be a JSON schema object: %v", err)
+	}
+	if schema.Schema == nil {
+		return errors.New("must declare its '$schema', e.g. 'http://json-schema.org/draft-04/schema#'")
+	}
+	if schema.Type != nil && schema.Type != "object" {
+		return fmt.Errorf("must have type 'object', not '%v'", schema.Type)
+	}
+	for name, property := range schema.Properties {
+		if err := checkObject(property); err != nil {
+			return fmt.Errorf("property '%s' %v", name, err)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, item string) bool {
+	for _, element := range list {
+		if element == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// Client configurations a binding can include in its credentials
+const (
+	// ClientConfigJava is a client.properties file for the Java client and its command line tools
+	ClientConfigJava = "java"
+	// ClientConfigLibrdkafka is the configuration of librdkafka based clients: confluent-kafka-go, -python, -dotnet, etc.
+	ClientConfigLibrdkafka = "librdkafka"
+	// ClientConfigSpring is the spring.kafka.* properties of Spring Boot
+	ClientConfigSpring = "spring"
+)
+
+type clientConfigParameters struct {
+	ClientConfig []string `json:"client_config"`
+}
+
+// parseClientConfigs returns the client configurations requested in the parameters of a bind request
+func parseClientConfigs(rawParameters json.RawMessage) ([]string, error) {
+	params := clientConfigParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return nil, err
+	}
+	for _, format := range params.ClientConfig {
+		switch format {
+		case ClientConfigJava, ClientConfigLibrdkafka, ClientConfigSpring:
+		default:
+			return nil, invalidParameters(fmt.Errorf("client_config may list '%s', '%s' and '%s', not '%s'",
+				ClientConfigJava, ClientConfigLibrdkafka, ClientConfigSpring, format))
+		}
+	}
+	return params.ClientConfig, nil
+}
+
+func (credentials bindingCredentials) securityProtocol() string {
+	if credentials.SecurityProtocol == "" {
+		return brokerconfig.SecurityProtocolPlaintext
+	}
+	return credentials.SecurityProtocol
+}
+
+// javaConfig is the configuration of the Java client
+func (credentials bindingCredentials) javaConfig() map[string]string {
+	config := map[string]string{
+		"bootstrap.servers": credentials.KafkaHostnames,
+		"security.protocol": credentials.securityProtocol(),
+	}
+	if credentials.ClientID != "" {
+		config["client.id"] = credentials.ClientID
+	}
+	if credentials.ConsumerGroup != "" {
+		config["group.id"] = credentials.ConsumerGroup
+	}
+	if credentials.SASL != nil {
+		config["sasl.mechanism"] = credentials.SASL.Mechanism
+		config["sasl.jaas.config"] = credentials.jaasConfig()
+	}
+	if credentials.Certificate != nil {
+		config["ssl.keystore.type"] = "PEM"
+		config["ssl.keystore.certificate.chain"] = credentials.Certificate.Certificate
+		config["ssl.keystore.key"] = credentials.Certificate.PrivateKey
+	}
+	return config
+}
+
+// jaasConfig is the JAAS login configuration of the Java client for the binding's SCRAM credentials
+func (credentials bindingCredentials) jaasConfig() string {
+	return fmt.Sprintf(`org.apache.kafka.common.security.scram.ScramLoginModule required username="%s" password="%s";`,
+		credentials.SASL.Username, credentials.SASL.Password)
+}
+
+// librdkafkaConfig is the configuration of librdkafka, whose property names differ from the Java client's for SASL
+func (credentials bindingCredentials) librdkafkaConfig() map[string]string {
+	config := map[string]string{
+		"bootstrap.servers": credentials.KafkaHostnames,
+		"security.protocol": strings.ToLower(credentials.securityProtocol()),
+	}
+	if credentials.ClientID != "" {
+		config["client.id"] = credentials.ClientID
+	}
+	if credentials.ConsumerGroup != "" {
+		config["group.id"] = credentials.ConsumerGroup
+	}
+	if credentials.SASL != nil {
+		config["sasl.mechanisms"] = credentials.SASL.Mechanism
+		config["sasl.username"] = credentials.SASL.Username
+		config["sasl.password"] = credentials.SASL.Password
+	}
+	if credentials.Certificate != nil {
+		config["ssl.certificate.pem"] = credentials.Certificate.Certificate
+		config["ssl.key.pem"] = credentials.Certificate.PrivateKey
+	}
+	return config
+}
+
+// springConfig is the spring.kafka.* properties of Spring Boot, with the instance's topic as the
+// default topic of KafkaTemplate
+func (credentials bindingCredentials) springConfig() map[string]string {
+	config := map[string]string{
+		"spring.kafka.bootstrap-servers": credentials.KafkaHostnames,
+		"spring.kafka.security.protocol": credentials.securityProtocol(),
+	}
+	if credentials.ClientID != "" {
+		config["spring.kafka.client-id"] = credentials.ClientID
+	}
+	if credentials.ConsumerGroup != "" {
+		config["spring.kafka.consumer.group-id"] = credentials.ConsumerGroup
+	}
+	if credentials.TopicName != "" {
+		config["spring.kafka.template.default-topic"] = credentials.TopicName
+	}
+	if credentials.SASL != nil {
+		config["spring.kafka.properties.sasl.mechanism"] = credentials.SASL.Mechanism
+		config["spring.kafka.properties.sasl.jaas.config"] = credentials.jaasConfig()
+	}
+	if credentials.Certificate != nil {
+		config["spring.kafka.ssl.key-store-type"] = "PEM"
+		config["spring.kafka.ssl.key-store-certificate-chain"] = credentials.Certificate.Certificate
+		config["spring.kafka.ssl.key-store-key"] = credentials.Certificate.PrivateKey
+	}
+	return config
+}
+
+// properties renders a configuration in the Java properties format, sorted by key
+func properties(config map[string]string) string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+"="+escapePropertyValue(config[key]))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// escapePropertyValue escapes the characters of a value that the Java properties format would interpret
+func escapePropertyValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(value)
+}
+
+// addClientConfigs adds the requested client configurations to the credentials in the default layout
+func (credentials bindingCredentials) addClientConfigs(credentialsMap map[string]interface{}) {
+	for _, format := range credentials.ClientConfigs {
+		switch format {
+		case ClientConfigJava:
+			credentialsMap["client_properties"] = properties(credentials.javaConfig())
+		case ClientConfigLibrdkafka:
+			credentialsMap["librdkafka"] = credentials.librdkafkaConfig()
+		case ClientConfigSpring:
+			credentialsMap["spring"] = credentials.springConfig()
+		}
+	}
+}
+
+// addServiceBindingClientConfigs adds the requested client configurations to the credentials in the
+// servicebinding.io layout, each as a file
+func (credentials bindingCredentials) addServiceBindingClientConfigs(credentialsMap map[string]interface{}) {
+	for _, format := range credentials.ClientConfigs {
+		switch format {
+		case ClientConfigJava:
+			credentialsMap["client.properties"] = properties(credentials.javaConfig())
+		case ClientConfigLibrdkafka:
+			data, _ := json.Marshal(credentials.librdkafkaConfig())
+			credentialsMap["librdkafka.json"] = string(data)
+		case ClientConfigSpring:
+			credentialsMap["spring.properties"] = properties(credentials.springConfig())
+		}
+	}
+}
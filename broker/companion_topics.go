@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// maxRetryTopics is the most retry topics a service instance may have
+const maxRetryTopics = 10
+
+// CompanionTopics are created alongside the topic of a service instance, for
+// consumers that retry failed records and park those that keep failing.
+// A zero retention means the retention of the instance's topic.
+type CompanionTopics struct {
+	RetryTopics           int   `json:"retry_topics,omitempty"`
+	RetryRetentionMs      int64 `json:"retry_retention_ms,omitempty"`
+	DeadLetterTopic       bool  `json:"dead_letter_topic,omitempty"`
+	DeadLetterRetentionMs int64 `json:"dead_letter_retention_ms,omitempty"`
+}
+
+// CompanionTopicCreator is implemented by the InstanceCreator of plans that can create
+// retry and dead letter topics alongside the topic of a service instance
+type CompanionTopicCreator interface {
+	// CreateCompanionTopics creates the companion topics of an existing service instance that do
+	// not exist yet
+	CreateCompanionTopics(instanceID string, companions CompanionTopics) error
+}
+
+// companionTopicParameters are the companion topics in the parameters of a request.
+// Unlike CompanionTopics, they tell a parameter set to zero or false from one not given.
+type companionTopicParameters struct {
+	RetryTopics           *int   `json:"retry_topics"`
+	RetryRetentionMs      *int64 `json:"retry_retention_ms"`
+	DeadLetterTopic       *bool  `json:"dead_letter_topic"`
+	DeadLetterRetentionMs *int64 `json:"dead_letter_retention_ms"`
+}
+
+// IsZero returns true if no companion topics are created
+func (companions CompanionTopics) IsZero() bool {
+	return companions.RetryTopics == 0 && !companions.DeadLetterTopic
+}
+
+// Validate checks the companion topics declared by a plan or requested in parameters
+func (companions CompanionTopics) Validate() error {
+	if companions.RetryTopics < 0 || companions.RetryTopics > maxRetryTopics {
+		return fmt.Errorf("retry_topics must be between 0 and %d", maxRetryTopics)
+	}
+	if companions.RetryRetentionMs < -1 {
+		return fmt.Errorf("retry_retention_ms must be -1 (forever) or more")
+	}
+	if companions.DeadLetterRetentionMs < -1 {
+		return fmt.Errorf("dead_letter_retention_ms must be -1 (forever) or more")
+	}
+	return nil
+}
+
+// CheckTopicConfig returns an error if the retention of the companion topics is outside the
+// topic config policy of the plan, where the topic limit watcher would report it as drift
+func (companions CompanionTopics) CheckTopicConfig(policy TopicConfigPolicy) error {
+	if policy.IsZero() {
+		return nil
+	}
+	retentions := []struct {
+		parameter   string
+		retentionMs int64
+	}{
+		{"retry_retention_ms", companions.RetryRetentionMs},
+		{"dead_letter_retention_ms", companions.DeadLetterRetentionMs},
+	}
+	for _, retention := range retentions {
+		if retention.retentionMs == 0 {
+			continue
+		}
+		config := map[string]string{"retention.ms": strconv.FormatInt(retention.retentionMs, 10)}
+		if drift := policy.Drift(config); len(drift) > 0 {
+			return fmt.Errorf("%s: %s", retention.parameter, drift[0])
+		}
+	}
+	return nil
+}
+
+// Bullets describes the companion topics for the plan metadata in the catalog
+func (companions CompanionTopics) Bullets() []string {
+	bullets := []string{}
+	if companions.RetryTopics != 0 {
+		bullets = append(bullets, fmt.Sprintf("Retry topics: %d", companions.RetryTopics))
+	}
+	if companions.DeadLetterTopic {
+		bullets = append(bullets, "Dead letter topic")
+	}
+	return bullets
+}
+
+// parseCompanionTopics returns the plan's companion topics with any given in the parameters of a request
+func parseCompanionTopics(planCompanions CompanionTopics, rawParameters json.RawMessage) (CompanionTopics, error) {
+	params := companionTopicParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return CompanionTopics{}, err
+	}
+	companions := planCompanions
+	if params.RetryTopics != nil {
+		companions.RetryTopics = *params.RetryTopics
+	}
+	if params.RetryRetentionMs != nil {
+		companions.RetryRetentionMs = *params.RetryRetentionMs
+	}
+	if params.DeadLetterTopic != nil {
+		companions.DeadLetterTopic = *params.DeadLetterTopic
+	}
+	if params.DeadLetterRetentionMs != nil {
+		companions.DeadLetterRetentionMs = *params.DeadLetterRetentionMs
+	}
+	if err := companions.Validate(); err != nil {
+		return CompanionTopics{}, invalidParameters(err)
+	}
+	return companions, nil
+}
+
+// hasCompanionTopicParameters returns true if any companion topics are given in the parameters of a request
+func hasCompanionTopicParameters(rawParameters json.RawMessage) (bool, error) {
+	params := companionTopicParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return false, err
+	}
+	return params != companionTopicParameters{}, nil
+}
@@ -0,0 +1,140 @@
+package broker
+
+import (
+	"expvar"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+var (
+	// configReloads counts the reloads of the configuration that "succeeded" and "failed"
+	configReloads = expvar.NewMap("config_reloads")
+	// configReloadError is the error of the last reload, or empty if it succeeded
+	configReloadError = expvar.NewString("config_reload_error")
+)
+
+// ConfigReloader serves the broker API with a handler built from the latest configuration and
+// catalog. The handler is built again when the configuration is reloaded, e.g. on SIGHUP or when
+// one of its files changes, and only swapped in once it was built without error: an invalid change
+// is logged and the previous configuration kept. Requests being served when the handler is swapped
+// finish with the one they started with.
+type ConfigReloader struct {
+	build  func() (http.Handler, error)
+	files  func() []string
+	logger lager.Logger
+
+	// mutex serializes reloads
+	mutex    sync.Mutex
+	handler  atomic.Value
+	modTimes map[string]time.Time
+}
+
+// NewConfigReloader builds the handler of the broker API. files returns the files the
+// configuration is read from, which are watched for changes.
+func NewConfigReloader(build func() (http.Handler, error), files func() []string, logger lager.Logger) (*ConfigReloader, error) {
+	reloader := &ConfigReloader{build: build, files: files, logger: logger}
+	if _, err := reloader.Reload(true); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// ServeHTTP serves a request with the latest handler
+func (reloader *ConfigReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reloader.handler.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// Reload builds the handler again if force is true or any of the files changed since the
+// configuration was last loaded, and returns true if it was replaced
+func (reloader *ConfigReloader) Reload(force bool) (bool, error) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	// the modification times are read first, so that a change made while loading is loaded again
+	modTimes, err := reloader.fileModTimes()
+	if err != nil {
+		return false, err
+	}
+	if !force && !reloader.changed(modTimes) {
+		return false, nil
+	}
+	handler, err := reloader.build()
+	// the files are not loaded again until they change, even if they are invalid
+	reloader.modTimes = modTimes
+	if err != nil {
+		configReloads.Add("failed", 1)
+		configReloadError.Set(err.Error())
+		return false, err
+	}
+	reloader.handler.Store(handler)
+	configReloads.Add("succeeded", 1)
+	configReloadError.Set("")
+	return true, nil
+}
+
+// Run reloads the configuration whenever a signal is received on hangup, and when its files
+// change, which are checked every interval, until stop is closed
+func (reloader *ConfigReloader) Run(interval time.Duration, hangup <-chan os.Signal, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case signal := <-hangup:
+			reloaded, err := reloader.Reload(true)
+			reloader.log(signal.String(), reloaded, err)
+		case <-ticker.C:
+			reloaded, err := reloader.Reload(false)
+			reloader.log("file-change", reloaded, err)
+		}
+	}
+}
+
+func (reloader *ConfigReloader) log(trigger string, reloaded bool, err error) {
+	if err != nil {
+		reloader.logger.Error("reload-config", err, lager.Data{
+			"trigger": trigger,
+			"message": "Failed to reload the configuration; keeping the previous configuration",
+		})
+	} else if reloaded {
+		reloader.logger.Info("reload-config", lager.Data{
+			"trigger": trigger,
+			"files":   reloader.files(),
+			"message": "Reloaded the configuration",
+		})
+	}
+}
+
+// changed returns true if the files are not those last loaded, or were modified since
+func (reloader *ConfigReloader) changed(modTimes map[string]time.Time) bool {
+	if len(modTimes) != len(reloader.modTimes) {
+		return true
+	}
+	for file, modTime := range modTimes {
+		if !modTime.Equal(reloader.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (reloader *ConfigReloader) fileModTimes() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, file := range reloader.files() {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
@@ -0,0 +1,133 @@
+package broker_test
+
+import (
+	"bytes"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+)
+
+var _ = Describe("ConfigReloader", func() {
+	var dir, configFile string
+	var content string
+	var buildErr error
+	var builds int
+	var logs *bytes.Buffer
+	var reloader *broker.ConfigReloader
+
+	// build serves the content the configuration had when it was built
+	build := func() (http.Handler, error) {
+		builds++
+		if buildErr != nil {
+			return nil, buildErr
+		}
+		served := content
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(served))
+		}), nil
+	}
+
+	get := func() string {
+		recorder := httptest.NewRecorder()
+		reloader.ServeHTTP(recorder, httptest.NewRequest("GET", "/v2/catalog", nil))
+		return recorder.Body.String()
+	}
+
+	reloads := func(result string) int64 {
+		count, _ := expvar.Get("config_reloads").(*expvar.Map).Get(result).(*expvar.Int)
+		if count == nil {
+			return 0
+		}
+		return count.Value()
+	}
+
+	touch := func(modTime time.Time) {
+		Expect(os.Chtimes(configFile, modTime, modTime)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "config-reloader")
+		Expect(err).NotTo(HaveOccurred())
+		configFile = filepath.Join(dir, "broker.env")
+		Expect(ioutil.WriteFile(configFile, []byte("PORT=8100\n"), 0600)).To(Succeed())
+		touch(time.Now().Add(-time.Hour))
+
+		content, buildErr, builds = "first", nil, 0
+		logs = &bytes.Buffer{}
+		logger := lager.NewLogger("test")
+		logger.RegisterSink(lager.NewWriterSink(logs, lager.DEBUG))
+		reloader, err = broker.NewConfigReloader(build, func() []string { return []string{configFile, ""} }, logger)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("refuses to start with an invalid configuration", func() {
+		buildErr = errors.New("ZOOKEEPER_PEERS is required")
+		_, err := broker.NewConfigReloader(build, func() []string { return nil }, lager.NewLogger("test"))
+		Expect(err).To(MatchError("ZOOKEEPER_PEERS is required"))
+	})
+
+	It("builds the handler again when the files change", func() {
+		content = "second"
+		Expect(reloader.Reload(false)).To(BeFalse())
+		Expect(get()).To(Equal("first"))
+
+		touch(time.Now())
+		Expect(reloader.Reload(false)).To(BeTrue())
+		Expect(get()).To(Equal("second"))
+		Expect(builds).To(Equal(2))
+	})
+
+	It("keeps the previous handler when the new configuration is invalid", func() {
+		succeeded, failed := reloads("succeeded"), reloads("failed")
+		buildErr = errors.New("plan 'topic': credential_layout must be 'default' or 'servicebinding', not 'yaml'")
+		touch(time.Now())
+		_, err := reloader.Reload(false)
+		Expect(err).To(Equal(buildErr))
+		Expect(get()).To(Equal("first"))
+		Expect(reloads("failed")).To(Equal(failed + 1))
+		Expect(expvar.Get("config_reload_error").String()).To(ContainSubstring("credential_layout"))
+
+		// an invalid file is only loaded again once it changes
+		Expect(reloader.Reload(false)).To(BeFalse())
+
+		buildErr, content = nil, "fixed"
+		Expect(reloader.Reload(true)).To(BeTrue())
+		Expect(get()).To(Equal("fixed"))
+		Expect(reloads("succeeded")).To(Equal(succeeded + 1))
+		Expect(expvar.Get("config_reload_error").String()).To(Equal(`""`))
+	})
+
+	It("reloads on a signal and logs the result", func() {
+		hangup := make(chan os.Signal, 1)
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			reloader.Run(time.Hour, hangup, stop)
+			close(stopped)
+		}()
+
+		content = "second"
+		hangup <- syscall.SIGHUP
+		Eventually(get).Should(Equal("second"))
+		close(stop)
+		Eventually(stopped).Should(BeClosed())
+		Expect(logs.String()).To(ContainSubstring(`"trigger":"hangup"`))
+	})
+})
@@ -0,0 +1,73 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Scopes of the consumer group allocated to bindings: one per binding, or one shared by
+// every binding of a service instance
+const (
+	ConsumerGroupPerBinding  = "binding"
+	ConsumerGroupPerInstance = "instance"
+)
+
+// ConsumerGroupManager allocates the consumer group each binding must use, and
+// grants the binding access to it
+type ConsumerGroupManager interface {
+	// AddBinding allocates the consumer group of a new binding, and returns its ID
+	AddBinding(instanceID, bindingID, scope string) (group string, err error)
+	// RemoveBinding revokes a binding's access to its consumer group, and deletes the
+	// group and its committed offsets if no other binding uses it
+	RemoveBinding(instanceID, bindingID string) error
+	RemoveInstance(instanceID string) error
+}
+
+// validateConsumerGroupScope checks the consumer group scope declared by a plan
+func validateConsumerGroupScope(scope string) error {
+	switch scope {
+	case "", ConsumerGroupPerBinding, ConsumerGroupPerInstance:
+		return nil
+	}
+	return fmt.Errorf("consumer_group_scope must be '%s' or '%s', not '%s'", ConsumerGroupPerBinding, ConsumerGroupPerInstance, scope)
+}
+
+// ConsumerGroupOffsets reports the offsets a consumer group has committed for the topics
+// of a service instance
+type ConsumerGroupOffsets struct {
+	Group string `json:"group"`
+	// Members is the number of consumers registered in the group
+	Members    int                `json:"members"`
+	Partitions []PartitionOffsets `json:"partitions"`
+	// Lag is the total lag of the group's partitions, if the log-end offsets of all of them are known
+	Lag *int64 `json:"lag,omitempty"`
+}
+
+// PartitionOffsets is the committed offset of a consumer group for a partition, and its lag
+// behind the partition's log-end offset when that is known
+type PartitionOffsets struct {
+	Topic        string `json:"topic"`
+	Partition    int32  `json:"partition"`
+	Offset       int64  `json:"offset"`
+	LogEndOffset *int64 `json:"log_end_offset,omitempty"`
+	Lag          *int64 `json:"lag,omitempty"`
+}
+
+// OffsetReporter reports the consumer groups of the topics of a service instance
+type OffsetReporter interface {
+	ConsumerGroupOffsets(instanceID string) ([]ConsumerGroupOffsets, error)
+}
+
+// ConsumerGroupOffsets reports the consumer groups of the topics of a service instance,
+// with their committed offsets and lag
+func (kBroker *KafkaServiceBroker) ConsumerGroupOffsets(ctx context.Context, instanceID string) ([]ConsumerGroupOffsets, error) {
+	if !kBroker.instanceExists(ctx, instanceID) {
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	}
+	if kBroker.OffsetReporter == nil {
+		return []ConsumerGroupOffsets{}, nil
+	}
+	return withContext(ctx, kBroker.OffsetReporter).ConsumerGroupOffsets(instanceID)
+}
@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// RotatedCredentials are the new secrets of a binding whose credentials were rotated
+type RotatedCredentials struct {
+	BindingID string `json:"binding_id"`
+	// SASL is nil unless the binding has SCRAM credentials
+	SASL *SASLCredentials `json:"sasl,omitempty"`
+	// Certificate is nil unless the binding has a client certificate
+	Certificate *ClientCertificate `json:"certificate,omitempty"`
+	RotatedAt   time.Time          `json:"rotated_at"`
+	// RevokeAt is when the credentials the rotation replaced stop being valid
+	RevokeAt time.Time `json:"revoke_at"`
+}
+
+// CredentialRotator replaces the SCRAM credentials and client certificates of bindings,
+// keeping the replaced ones valid for a grace period
+type CredentialRotator interface {
+	RotateCredentials(instanceID string, gracePeriod time.Duration) ([]RotatedCredentials, error)
+	// RotatedCredentials returns the credentials of the latest rotation of each binding of a service instance
+	RotatedCredentials(instanceID string) ([]RotatedCredentials, error)
+	// RemoveBinding revokes the replaced credentials of a binding straight away
+	RemoveBinding(instanceID, bindingID string) error
+	RemoveInstance(instanceID string) error
+}
+
+type credentialRotationParameters struct {
+	RotateCredentials bool `json:"rotate_credentials"`
+}
+
+// parseRotateCredentials returns whether the parameters of an update request ask for the
+// credentials of the instance's bindings to be rotated
+func parseRotateCredentials(rawParameters json.RawMessage) (bool, error) {
+	params := credentialRotationParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return false, err
+	}
+	return params.RotateCredentials, nil
+}
+
+var errNoCredentialRotator = errors.New("this broker issues no credentials to rotate, or has no secret store to keep new ones in")
+
+// ErrRotationPending is returned when rotating the credentials of a binding whose credentials
+// retired by a previous rotation are still valid
+var ErrRotationPending = errors.New("the credentials replaced by the previous rotation are still valid; wait for the end of its grace period before rotating again")
+
+// RotateCredentials gives every binding of a service instance new SCRAM credentials and client
+// certificates, as it has, and records the rotation in the audit log. The replaced credentials
+// stay valid for gracePeriod, during which the instance cannot be rotated again. Credentials that
+// bindings refer to in the secret store are updated.
+func (kBroker *KafkaServiceBroker) RotateCredentials(ctx context.Context, instanceID string, gracePeriod time.Duration, actor string) ([]RotatedCredentials, error) {
+	if !kBroker.instanceExists(ctx, instanceID) {
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	}
+	if kBroker.CredentialRotator == nil {
+		return nil, invalidParameters(errNoCredentialRotator)
+	}
+	if gracePeriod < 0 {
+		return nil, invalidParameters(errors.New("the grace period cannot be negative"))
+	}
+
+	ctx, err := detach(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := withContext(ctx, kBroker.CredentialRotator).RotateCredentials(instanceID, gracePeriod)
+	if err == ErrRotationPending {
+		return nil, brokerapi.NewFailureResponse(err, http.StatusUnprocessableEntity, "rotation-pending")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bindingIDs := make([]string, 0, len(rotated))
+	for _, credentials := range rotated {
+		bindingIDs = append(bindingIDs, credentials.BindingID)
+	}
+	entry := AuditEntry{Action: "rotate-credentials", InstanceID: instanceID, Actor: actor, Details: map[string]interface{}{
+		"bindings":     bindingIDs,
+		"grace_period": gracePeriod.String(),
+	}}
+	if err = kBroker.audit(ctx, entry); err != nil {
+		return rotated, fmt.Errorf("the credentials were rotated, but the audit entry could not be recorded: %v", err)
+	}
+	if kBroker.SecretStore != nil {
+		if err = kBroker.updateReferencedCredentials(instanceID, rotated); err != nil {
+			return rotated, fmt.Errorf("the credentials were rotated, but the credentials in the secret store could not be updated: %v", err)
+		}
+	}
+	return rotated, nil
+}
+
+// RotatedCredentials returns the credentials of the latest rotation of each binding of a service instance
+func (kBroker *KafkaServiceBroker) RotatedCredentials(ctx context.Context, instanceID string) ([]RotatedCredentials, error) {
+	if !kBroker.instanceExists(ctx, instanceID) {
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	}
+	if kBroker.CredentialRotator == nil {
+		return []RotatedCredentials{}, nil
+	}
+	return withContext(ctx, kBroker.CredentialRotator).RotatedCredentials(instanceID)
+}
@@ -0,0 +1,224 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// Layouts of the credentials of a binding
+const (
+	// CredentialLayoutDefault is the broker's own layout: hostname, uri, topicName, zkPeers, etc.
+	CredentialLayoutDefault = "default"
+	// CredentialLayoutServiceBinding follows the servicebinding.io conventions for Kafka,
+	// understood by Spring Cloud Bindings and Quarkus on Kubernetes
+	CredentialLayoutServiceBinding = "servicebinding"
+)
+
+// ServiceBindingProvider is the provider of the servicebinding.io credentials
+const ServiceBindingProvider = "kafka-service-broker"
+
+// SASLCredentials authenticate a binding to Kafka
+type SASLCredentials struct {
+	Mechanism string `json:"mechanism"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// SASLManager creates the SASL credentials of each binding, when Kafka clients authenticate with SASL
+type SASLManager interface {
+	AddBinding(instanceID, bindingID string) (SASLCredentials, error)
+	RemoveBinding(instanceID, bindingID string) error
+	RemoveInstance(instanceID string) error
+}
+
+// ClientCertificate authenticates a binding to Kafka with mutual TLS; each field is PEM encoded
+type ClientCertificate struct {
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
+	// CAChain is the CA that signed Certificate, followed by any intermediates up to the root
+	CAChain string `json:"ca_chain"`
+}
+
+// CertificateIssuer issues each binding a client certificate, when Kafka clients authenticate with mutual TLS
+type CertificateIssuer interface {
+	AddBinding(instanceID, bindingID string) (ClientCertificate, error)
+	// RemoveBinding revokes the certificate of a binding
+	RemoveBinding(instanceID, bindingID string) error
+	RemoveInstance(instanceID string) error
+}
+
+// bindingCredentials are everything a binding is given, before they are laid out
+type bindingCredentials struct {
+	InstanceCredentials
+	ClientID         string
+	ConsumerGroup    string
+	SecurityProtocol string
+	// SASL is nil unless clients authenticate with SASL
+	SASL *SASLCredentials
+	// Certificate is nil unless clients authenticate with a client certificate
+	Certificate *ClientCertificate
+	// ClientConfigs are the client configurations to include, e.g. ClientConfigJava
+	ClientConfigs []string
+	// OmitZookeeperPeers leaves zkPeers out of the default layout
+	OmitZookeeperPeers bool
+}
+
+type credentialLayoutParameters struct {
+	CredentialLayout string `json:"credential_layout"`
+}
+
+type zookeeperPeersParameters struct {
+	IncludeZookeeperPeers bool `json:"include_zk_peers"`
+}
+
+// validateCredentialLayout checks the credential layout declared by a plan or requested in parameters
+func validateCredentialLayout(layout string) error {
+	switch layout {
+	case "", CredentialLayoutDefault, CredentialLayoutServiceBinding:
+		return nil
+	}
+	return fmt.Errorf("credential_layout must be '%s' or '%s', not '%s'", CredentialLayoutDefault, CredentialLayoutServiceBinding, layout)
+}
+
+// parseCredentialLayout returns the credential layout requested in the parameters of a bind request,
+// or else the plan's
+func parseCredentialLayout(planLayout string, rawParameters json.RawMessage) (string, error) {
+	params := credentialLayoutParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return "", err
+	}
+	if err := validateCredentialLayout(params.CredentialLayout); err != nil {
+		return "", invalidParameters(err)
+	}
+	if params.CredentialLayout != "" {
+		return params.CredentialLayout, nil
+	}
+	if planLayout != "" {
+		return planLayout, nil
+	}
+	return CredentialLayoutDefault, nil
+}
+
+// parseIncludeZookeeperPeers returns whether the parameters of a bind request ask for zkPeers,
+// which legacy applications still connect with even when the plan omits them
+func parseIncludeZookeeperPeers(layout string, rawParameters json.RawMessage) (bool, error) {
+	params := zookeeperPeersParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return false, err
+	}
+	if params.IncludeZookeeperPeers && layout != CredentialLayoutDefault {
+		return false, invalidParameters(fmt.Errorf("include_zk_peers requires the '%s' credential layout", CredentialLayoutDefault))
+	}
+	return params.IncludeZookeeperPeers, nil
+}
+
+// layout returns the credentials in the given layout
+func (credentials bindingCredentials) layout(layout string) map[string]interface{} {
+	if layout == CredentialLayoutServiceBinding {
+		return credentials.serviceBindingLayout()
+	}
+	return credentials.defaultLayout()
+}
+
+func (credentials bindingCredentials) defaultLayout() map[string]interface{} {
+	credentialsMap := map[string]interface{}{
+		"hostname": credentials.KafkaHostnames,
+	}
+	if !credentials.OmitZookeeperPeers {
+		credentialsMap["zkPeers"] = credentials.ZookeeperPeers
+	}
+
+	if credentials.TopicName != "" {
+		credentialsMap["topicName"] = credentials.TopicName
+		credentialsMap["uri"] = fmt.Sprintf("kafka://%s/%s", credentials.KafkaHostnames, credentials.TopicName)
+	}
+	if credentials.TopicCleanupPolicy != "" {
+		credentialsMap["cleanupPolicy"] = credentials.TopicCleanupPolicy
+	}
+	if credentials.TopicNamePrefix != "" {
+		credentialsMap["topicNamePrefix"] = credentials.TopicNamePrefix
+		credentialsMap["uri"] = fmt.Sprintf("kafka://%s", credentials.KafkaHostnames)
+	}
+	if credentials.Topics != nil {
+		credentialsMap["topics"] = credentials.Topics
+		credentialsMap["uri"] = fmt.Sprintf("kafka://%s", credentials.KafkaHostnames)
+	}
+	if len(credentials.RetryTopics) > 0 {
+		credentialsMap["retry_topics"] = credentials.RetryTopics
+	}
+	if credentials.DeadLetterTopic != "" {
+		credentialsMap["dead_letter_topic"] = credentials.DeadLetterTopic
+	}
+	if credentials.ClientID != "" {
+		credentialsMap["clientId"] = credentials.ClientID
+	}
+	if credentials.ConsumerGroup != "" {
+		credentialsMap["consumer_group"] = credentials.ConsumerGroup
+	}
+	if credentials.SecurityProtocol != "" && credentials.SecurityProtocol != brokerconfig.SecurityProtocolPlaintext {
+		credentialsMap["securityProtocol"] = credentials.SecurityProtocol
+	}
+	if credentials.SASL != nil {
+		credentialsMap["saslMechanism"] = credentials.SASL.Mechanism
+		credentialsMap["user"] = credentials.SASL.Username
+		credentialsMap["password"] = credentials.SASL.Password
+	}
+	if credentials.Certificate != nil {
+		credentialsMap["clientCertificate"] = credentials.Certificate.Certificate
+		credentialsMap["clientKey"] = credentials.Certificate.PrivateKey
+		credentialsMap["caChain"] = credentials.Certificate.CAChain
+	}
+	credentials.addClientConfigs(credentialsMap)
+	return credentialsMap
+}
+
+// serviceBindingLayout lays out the credentials as servicebinding.io entries, each of which
+// becomes a file of the binding on Kubernetes, so every value is a string
+func (credentials bindingCredentials) serviceBindingLayout() map[string]interface{} {
+	credentialsMap := map[string]interface{}{
+		"type":              "kafka",
+		"provider":          ServiceBindingProvider,
+		"bootstrap-servers": credentials.KafkaHostnames,
+		"security.protocol": credentials.securityProtocol(),
+	}
+	if credentials.SASL != nil {
+		credentialsMap["sasl.mechanism"] = credentials.SASL.Mechanism
+		credentialsMap["user"] = credentials.SASL.Username
+		credentialsMap["password"] = credentials.SASL.Password
+	}
+	if credentials.Certificate != nil {
+		credentialsMap["tls.crt"] = credentials.Certificate.Certificate
+		credentialsMap["tls.key"] = credentials.Certificate.PrivateKey
+		credentialsMap["ca.crt"] = credentials.Certificate.CAChain
+	}
+
+	if credentials.TopicName != "" {
+		credentialsMap["topic"] = credentials.TopicName
+	}
+	if credentials.TopicNamePrefix != "" {
+		credentialsMap["topic-prefix"] = credentials.TopicNamePrefix
+	}
+	for name, topic := range credentials.Topics {
+		credentialsMap["topic."+name] = topic
+	}
+	if credentials.TopicCleanupPolicy != "" {
+		credentialsMap["cleanup-policy"] = credentials.TopicCleanupPolicy
+	}
+	if len(credentials.RetryTopics) > 0 {
+		credentialsMap["retry-topics"] = strings.Join(credentials.RetryTopics, ",")
+	}
+	if credentials.DeadLetterTopic != "" {
+		credentialsMap["dead-letter-topic"] = credentials.DeadLetterTopic
+	}
+	if credentials.ClientID != "" {
+		credentialsMap["client-id"] = credentials.ClientID
+	}
+	if credentials.ConsumerGroup != "" {
+		credentialsMap["consumer-group"] = credentials.ConsumerGroup
+	}
+	credentials.addServiceBindingClientConfigs(credentialsMap)
+	return credentialsMap
+}
@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InFlightRequests serves the broker API, keeping track of the requests being served, so that
+// the broker can report those that did not finish before it shut down
+type InFlightRequests struct {
+	handler http.Handler
+
+	mutex    sync.Mutex
+	requests map[*http.Request]time.Time
+}
+
+// NewInFlightRequests creates InFlightRequests serving handler
+func NewInFlightRequests(handler http.Handler) *InFlightRequests {
+	return &InFlightRequests{handler: handler, requests: map[*http.Request]time.Time{}}
+}
+
+// ServeHTTP serves a request with the handler, recording it until it is served
+func (inFlight *InFlightRequests) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	inFlight.mutex.Lock()
+	inFlight.requests[r] = time.Now()
+	inFlight.mutex.Unlock()
+	defer func() {
+		inFlight.mutex.Lock()
+		delete(inFlight.requests, r)
+		inFlight.mutex.Unlock()
+	}()
+	inFlight.handler.ServeHTTP(w, r)
+}
+
+// Count returns the number of requests being served
+func (inFlight *InFlightRequests) Count() int {
+	inFlight.mutex.Lock()
+	defer inFlight.mutex.Unlock()
+	return len(inFlight.requests)
+}
+
+// Requests describes the requests being served, oldest first, e.g. "PUT /v2/service_instances/a (for 2s)"
+func (inFlight *InFlightRequests) Requests() []string {
+	inFlight.mutex.Lock()
+	defer inFlight.mutex.Unlock()
+	requests := make([]*http.Request, 0, len(inFlight.requests))
+	for r := range inFlight.requests {
+		requests = append(requests, r)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return inFlight.requests[requests[i]].Before(inFlight.requests[requests[j]])
+	})
+	descriptions := []string{}
+	for _, r := range requests {
+		elapsed := time.Since(inFlight.requests[r]).Round(time.Millisecond)
+		descriptions = append(descriptions, fmt.Sprintf("%s %s (for %s)", r.Method, r.URL.Path, elapsed))
+	}
+	return descriptions
+}
+
+// Instances returns the IDs of the service instances the requests being served are for, in order,
+// e.g. those a shutdown cuts off in the middle of creating or deleting their topics
+func (inFlight *InFlightRequests) Instances() []string {
+	inFlight.mutex.Lock()
+	defer inFlight.mutex.Unlock()
+	seen := map[string]bool{}
+	instanceIDs := []string{}
+	for r := range inFlight.requests {
+		path := strings.TrimPrefix(r.URL.Path, "/v2/service_instances/")
+		if path == r.URL.Path {
+			continue
+		}
+		instanceID := strings.SplitN(path, "/", 2)[0]
+		if instanceID != "" && !seen[instanceID] {
+			seen[instanceID] = true
+			instanceIDs = append(instanceIDs, instanceID)
+		}
+	}
+	sort.Strings(instanceIDs)
+	return instanceIDs
+}
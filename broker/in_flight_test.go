@@ -0,0 +1,90 @@
+package broker_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+)
+
+var _ = Describe("InFlightRequests", func() {
+	var release chan struct{}
+	var inFlight *broker.InFlightRequests
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		inFlight = broker.NewInFlightRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.WriteHeader(http.StatusCreated)
+		}))
+		server = httptest.NewServer(inFlight)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	put := func(path string, statuses chan<- int) {
+		request, err := http.NewRequest("PUT", server.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			_ = response.Body.Close()
+			statuses <- response.StatusCode
+		}()
+	}
+
+	It("tracks the requests being served", func() {
+		statuses := make(chan int, 1)
+		put("/v2/service_instances/a", statuses)
+		Eventually(inFlight.Count).Should(Equal(1))
+		Expect(inFlight.Requests()).To(ConsistOf(MatchRegexp(`^PUT /v2/service_instances/a \(for .*\)$`)))
+		Expect(inFlight.Instances()).To(Equal([]string{"a"}))
+
+		close(release)
+		Eventually(statuses).Should(Receive(Equal(http.StatusCreated)))
+		Expect(inFlight.Count()).To(Equal(0))
+		Expect(inFlight.Requests()).To(BeEmpty())
+		Expect(inFlight.Instances()).To(BeEmpty())
+	})
+
+	It("reports each service instance the requests being served are for once", func() {
+		statuses := make(chan int, 4)
+		put("/v2/service_instances/b/service_bindings/binding", statuses)
+		put("/v2/service_instances/a", statuses)
+		put("/v2/service_instances/b", statuses)
+		put("/v2/catalog", statuses)
+		Eventually(inFlight.Count).Should(Equal(4))
+		Expect(inFlight.Instances()).To(Equal([]string{"a", "b"}))
+
+		close(release)
+		for i := 0; i < 4; i++ {
+			Eventually(statuses).Should(Receive())
+		}
+	})
+
+	It("lets the server drain the requests it is serving when it shuts down", func() {
+		statuses := make(chan int, 1)
+		put("/v2/service_instances/a", statuses)
+		Eventually(inFlight.Count).Should(Equal(1))
+
+		shutdown := make(chan error, 1)
+		go func() { shutdown <- server.Config.Shutdown(context.Background()) }()
+		Consistently(shutdown, 100*time.Millisecond).ShouldNot(Receive())
+
+		_, err := http.Get(server.URL + "/v2/catalog")
+		Expect(err).To(HaveOccurred())
+
+		close(release)
+		Eventually(statuses).Should(Receive(Equal(http.StatusCreated)))
+		Eventually(shutdown, 2*time.Second).Should(Receive(BeNil()))
+	})
+})
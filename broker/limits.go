@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"fmt"
+)
+
+// Actions taken when a service instance exceeds its topic limits
+const (
+	// LimitActionLog only logs and meters the violation
+	LimitActionLog = "log"
+	// LimitActionDelete deletes the topics that exceed the limits
+	LimitActionDelete = "delete"
+	// LimitActionRevoke denies the creation of topics until the instance is back under its limits
+	LimitActionRevoke = "revoke"
+)
+
+// TopicLimits restricts the topics a service instance may create under its topic name prefix.
+// A zero value means no limit.
+type TopicLimits struct {
+	MaxTopics      int   `json:"max_topics,omitempty"`
+	MaxPartitions  int   `json:"max_partitions,omitempty"`
+	MaxRetentionMs int64 `json:"max_retention_ms,omitempty"`
+	// Action is what is done when the limits are exceeded; it is set by the plan only
+	Action string `json:"action,omitempty"`
+}
+
+// TopicUsage is what a service instance currently uses of its topic limits
+type TopicUsage struct {
+	Topics     int `json:"topics"`
+	Partitions int `json:"partitions"`
+	// MaxRetentionMs is the longest retention.ms override of any topic; -1 if a topic is retained forever
+	MaxRetentionMs int64 `json:"max_retention_ms"`
+}
+
+// LimitManager records the topic limits of each service instance, and reports their usage
+type LimitManager interface {
+	InstanceLimits(instanceID string) (TopicLimits, error)
+	SetInstanceLimits(instanceID string, limits TopicLimits) error
+	Usage(instanceID string) (TopicUsage, error)
+	RemoveInstance(instanceID string) error
+}
+
+// IsZero returns true if no limits are set
+func (limits TopicLimits) IsZero() bool {
+	return limits.MaxTopics == 0 && limits.MaxPartitions == 0 && limits.MaxRetentionMs == 0
+}
+
+// Validate checks the limits declared by a plan
+func (limits TopicLimits) Validate() error {
+	switch limits.Action {
+	case "", LimitActionLog, LimitActionDelete, LimitActionRevoke:
+		return nil
+	}
+	return fmt.Errorf("unknown topic limit action '%s'", limits.Action)
+}
+
+// Override returns the limits with any set in overrides replacing their own.
+// The limits are ceilings: overrides may only lower them. The action cannot be overridden.
+func (limits TopicLimits) Override(overrides TopicLimits) (TopicLimits, error) {
+	if overrides.MaxTopics < 0 || overrides.MaxPartitions < 0 || overrides.MaxRetentionMs < 0 {
+		return limits, fmt.Errorf("topic limits must not be negative")
+	}
+	if limits.MaxTopics != 0 && overrides.MaxTopics > limits.MaxTopics {
+		return limits, fmt.Errorf("max_topics must not exceed the plan limit of %d", limits.MaxTopics)
+	}
+	if limits.MaxPartitions != 0 && overrides.MaxPartitions > limits.MaxPartitions {
+		return limits, fmt.Errorf("max_partitions must not exceed the plan limit of %d", limits.MaxPartitions)
+	}
+	if limits.MaxRetentionMs != 0 && overrides.MaxRetentionMs > limits.MaxRetentionMs {
+		return limits, fmt.Errorf("max_retention_ms must not exceed the plan limit of %d", limits.MaxRetentionMs)
+	}
+	return limits.merge(overrides), nil
+}
+
+// merge returns the limits with any set in overrides replacing their own
+func (limits TopicLimits) merge(overrides TopicLimits) TopicLimits {
+	if overrides.MaxTopics != 0 {
+		limits.MaxTopics = overrides.MaxTopics
+	}
+	if overrides.MaxPartitions != 0 {
+		limits.MaxPartitions = overrides.MaxPartitions
+	}
+	if overrides.MaxRetentionMs != 0 {
+		limits.MaxRetentionMs = overrides.MaxRetentionMs
+	}
+	return limits
+}
+
+// RetentionExceeded returns true if a topic with the given retention.ms breaks the limits
+func (limits TopicLimits) RetentionExceeded(retentionMs int64) bool {
+	return limits.MaxRetentionMs != 0 && (retentionMs < 0 || retentionMs > limits.MaxRetentionMs)
+}
+
+// Violations describes each limit that usage exceeds
+func (limits TopicLimits) Violations(usage TopicUsage) []string {
+	violations := []string{}
+	if limits.MaxTopics != 0 && usage.Topics > limits.MaxTopics {
+		violations = append(violations, fmt.Sprintf("%d topics exceeds the limit of %d", usage.Topics, limits.MaxTopics))
+	}
+	if limits.MaxPartitions != 0 && usage.Partitions > limits.MaxPartitions {
+		violations = append(violations, fmt.Sprintf("%d partitions exceeds the limit of %d", usage.Partitions, limits.MaxPartitions))
+	}
+	if limits.RetentionExceeded(usage.MaxRetentionMs) {
+		violations = append(violations, fmt.Sprintf("retention.ms of %d exceeds the limit of %d", usage.MaxRetentionMs, limits.MaxRetentionMs))
+	}
+	return violations
+}
+
+// Bullets describes the limits for the plan metadata in the catalog
+func (limits TopicLimits) Bullets() []string {
+	bullets := []string{}
+	if limits.MaxTopics != 0 {
+		bullets = append(bullets, fmt.Sprintf("Up to %d topics", limits.MaxTopics))
+	}
+	if limits.MaxPartitions != 0 {
+		bullets = append(bullets, fmt.Sprintf("Up to %d partitions in total", limits.MaxPartitions))
+	}
+	if limits.MaxRetentionMs != 0 {
+		bullets = append(bullets, fmt.Sprintf("Retention of up to %d ms", limits.MaxRetentionMs))
+	}
+	return bullets
+}
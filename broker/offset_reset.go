@@ -0,0 +1,140 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Positions a consumer group can be reset to
+const (
+	// OffsetResetEarliest replays every record still held by the partitions
+	OffsetResetEarliest = "earliest"
+	// OffsetResetLatest skips every record already written
+	OffsetResetLatest = "latest"
+	// OffsetResetOffset moves every partition to an explicit offset
+	OffsetResetOffset = "offset"
+	// OffsetResetTimestamp moves each partition to its first record written at or after a timestamp
+	OffsetResetTimestamp = "timestamp"
+)
+
+// ErrConsumerGroupActive is returned when resetting the offsets of a consumer group that has members, without force
+var ErrConsumerGroupActive = errors.New("the consumer group has active members; stop its consumers or force the reset")
+
+// OffsetReset moves the committed offsets of a consumer group on the topics of a service instance
+type OffsetReset struct {
+	Group string `json:"group"`
+	// Topic restricts the reset to one topic; by default every topic of the instance the group has committed offsets for is reset
+	Topic string `json:"topic,omitempty"`
+	To    string `json:"to"`
+	// Offset is required by OffsetResetOffset
+	Offset *int64 `json:"offset,omitempty"`
+	// Timestamp, in milliseconds since the epoch, is required by OffsetResetTimestamp
+	Timestamp *int64 `json:"timestamp,omitempty"`
+	// Force resets the offsets even though the group has members, which may commit over them
+	Force bool `json:"force,omitempty"`
+}
+
+// OffsetResetter resets the committed offsets of the consumer groups of a service instance
+type OffsetResetter interface {
+	// ResetOffsets commits the new offsets of the group, and returns them. It fails with
+	// ErrConsumerGroupActive if the group has members and the reset is not forced.
+	ResetOffsets(instanceID string, reset OffsetReset) ([]PartitionOffsets, error)
+}
+
+type offsetResetParameters struct {
+	ResetOffsets *OffsetReset `json:"reset_offsets"`
+}
+
+// BelongsToInstance returns true if a topic or consumer group is named after a service instance:
+// it is the instance ID, or starts with the instance ID and a dot
+func BelongsToInstance(name, instanceID string) bool {
+	return name == instanceID || strings.HasPrefix(name, instanceID+".")
+}
+
+// Validate checks that a reset is complete and only touches the consumer groups and topics of the instance
+func (reset OffsetReset) Validate(instanceID string) error {
+	if reset.Group == "" {
+		return errors.New("the consumer group to reset is required")
+	}
+	if !BelongsToInstance(reset.Group, instanceID) {
+		return fmt.Errorf("consumer group '%s' does not belong to the service instance", reset.Group)
+	}
+	if reset.Topic != "" && !BelongsToInstance(reset.Topic, instanceID) {
+		return fmt.Errorf("topic '%s' does not belong to the service instance", reset.Topic)
+	}
+	switch reset.To {
+	case OffsetResetEarliest, OffsetResetLatest:
+		if reset.Offset != nil || reset.Timestamp != nil {
+			return fmt.Errorf("a reset to '%s' takes neither an offset nor a timestamp", reset.To)
+		}
+	case OffsetResetOffset:
+		if reset.Offset == nil || *reset.Offset < 0 || reset.Timestamp != nil {
+			return errors.New("a reset to 'offset' takes an offset of 0 or more, and no timestamp")
+		}
+	case OffsetResetTimestamp:
+		if reset.Timestamp == nil || *reset.Timestamp < 0 || reset.Offset != nil {
+			return errors.New("a reset to 'timestamp' takes a timestamp in milliseconds since the epoch, and no offset")
+		}
+	default:
+		return fmt.Errorf("offsets can be reset to '%s', '%s', '%s' or '%s', not '%s'",
+			OffsetResetEarliest, OffsetResetLatest, OffsetResetOffset, OffsetResetTimestamp, reset.To)
+	}
+	return nil
+}
+
+// parseOffsetReset returns the reset_offsets parameter of a request, or nil if it is not given
+func parseOffsetReset(rawParameters json.RawMessage) (*OffsetReset, error) {
+	params := offsetResetParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return nil, err
+	}
+	return params.ResetOffsets, nil
+}
+
+// ResetOffsets resets the committed offsets of a consumer group of a service instance,
+// and records the reset in the audit log
+func (kBroker *KafkaServiceBroker) ResetOffsets(ctx context.Context, instanceID string, reset OffsetReset, actor string) ([]PartitionOffsets, error) {
+	if !kBroker.instanceExists(ctx, instanceID) {
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	}
+	if err := reset.Validate(instanceID); err != nil {
+		return nil, invalidParameters(err)
+	}
+	if kBroker.OffsetResetter == nil {
+		return nil, invalidParameters(errors.New("this broker cannot reset offsets"))
+	}
+
+	ctx, err := detach(ctx)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := withContext(ctx, kBroker.OffsetResetter).ResetOffsets(instanceID, reset)
+	if err == ErrConsumerGroupActive {
+		return nil, brokerapi.NewFailureResponse(err, http.StatusUnprocessableEntity, "consumer-group-active")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]interface{}{"group": reset.Group, "to": reset.To, "force": reset.Force, "offsets": offsets}
+	if reset.Topic != "" {
+		details["topic"] = reset.Topic
+	}
+	if reset.Offset != nil {
+		details["offset"] = *reset.Offset
+	}
+	if reset.Timestamp != nil {
+		details["timestamp"] = *reset.Timestamp
+	}
+	entry := AuditEntry{Action: "reset-offsets", InstanceID: instanceID, Actor: actor, Details: details}
+	if err = kBroker.audit(ctx, entry); err != nil {
+		return offsets, fmt.Errorf("the offsets were reset, but the audit entry could not be recorded: %v", err)
+	}
+	return offsets, nil
+}
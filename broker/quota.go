@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"fmt"
+)
+
+// Quota limits the throughput of each binding of a service instance.
+// A zero value means no limit.
+type Quota struct {
+	ProducerByteRate  int64   `json:"producer_byte_rate,omitempty"`
+	ConsumerByteRate  int64   `json:"consumer_byte_rate,omitempty"`
+	RequestPercentage float64 `json:"request_percentage,omitempty"`
+}
+
+// QuotaManager applies client quotas to every binding of a service instance
+type QuotaManager interface {
+	InstanceQuota(instanceID string) (Quota, error)
+	// SetInstanceQuota records the quota of an instance and applies it to all of its bindings
+	SetInstanceQuota(instanceID string, quota Quota) error
+	// AddBinding applies the instance quota to a new binding and returns the client ID it must use
+	AddBinding(instanceID, bindingID string) (clientID string, err error)
+	RemoveBinding(instanceID, bindingID string) error
+	RemoveInstance(instanceID string) error
+}
+
+// IsZero returns true if the quota places no limits
+func (quota Quota) IsZero() bool {
+	return quota == Quota{}
+}
+
+// Override returns the quota with any limits set in overrides replacing its own.
+// The quota's limits are ceilings: overrides may only lower them.
+func (quota Quota) Override(overrides Quota) (Quota, error) {
+	if overrides.ProducerByteRate < 0 || overrides.ConsumerByteRate < 0 || overrides.RequestPercentage < 0 {
+		return quota, fmt.Errorf("quotas must not be negative")
+	}
+	if quota.ProducerByteRate != 0 && overrides.ProducerByteRate > quota.ProducerByteRate {
+		return quota, fmt.Errorf("producer_byte_rate must not exceed the plan limit of %d", quota.ProducerByteRate)
+	}
+	if quota.ConsumerByteRate != 0 && overrides.ConsumerByteRate > quota.ConsumerByteRate {
+		return quota, fmt.Errorf("consumer_byte_rate must not exceed the plan limit of %d", quota.ConsumerByteRate)
+	}
+	if quota.RequestPercentage != 0 && overrides.RequestPercentage > quota.RequestPercentage {
+		return quota, fmt.Errorf("request_percentage must not exceed the plan limit of %g", quota.RequestPercentage)
+	}
+	return quota.merge(overrides), nil
+}
+
+// merge returns the quota with any limits set in overrides replacing its own
+func (quota Quota) merge(overrides Quota) Quota {
+	if overrides.ProducerByteRate != 0 {
+		quota.ProducerByteRate = overrides.ProducerByteRate
+	}
+	if overrides.ConsumerByteRate != 0 {
+		quota.ConsumerByteRate = overrides.ConsumerByteRate
+	}
+	if overrides.RequestPercentage != 0 {
+		quota.RequestPercentage = overrides.RequestPercentage
+	}
+	return quota
+}
+
+// Bullets describes the quota for the plan metadata in the catalog
+func (quota Quota) Bullets() []string {
+	bullets := []string{}
+	if quota.ProducerByteRate != 0 {
+		bullets = append(bullets, fmt.Sprintf("Producer quota: %d bytes/sec per binding", quota.ProducerByteRate))
+	}
+	if quota.ConsumerByteRate != 0 {
+		bullets = append(bullets, fmt.Sprintf("Consumer quota: %d bytes/sec per binding", quota.ConsumerByteRate))
+	}
+	if quota.RequestPercentage != 0 {
+		bullets = append(bullets, fmt.Sprintf("Request quota: %g%% of broker request handler time per binding", quota.RequestPercentage))
+	}
+	return bullets
+}
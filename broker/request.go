@@ -0,0 +1,172 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// RequestIDHeader is the header of the ID of a broker API request, which the platform may set, e.g.
+// to correlate the logs of the broker with its own; the broker generates IDs for requests without one
+const RequestIDHeader = "X-Broker-API-Request-Identity"
+
+// ProvisionRequest is a request to create a service instance, with the settings the broker
+// derived from it and the details the platform sent
+type ProvisionRequest struct {
+	InstanceID string
+	// Plan is the name of the plan, after which its implementation is named
+	Plan     string
+	Settings InstanceSettings
+	Details  brokerapi.ProvisionDetails
+}
+
+// DeprovisionRequest is a request to delete a service instance
+type DeprovisionRequest struct {
+	InstanceID string
+	Plan       string
+	Details    brokerapi.DeprovisionDetails
+}
+
+// BindRequest is a request to create a binding of a service instance
+type BindRequest struct {
+	InstanceID string
+	BindingID  string
+	Plan       string
+	Details    brokerapi.BindDetails
+}
+
+// UnbindRequest is a request to delete a binding of a service instance
+type UnbindRequest struct {
+	InstanceID string
+	BindingID  string
+	Plan       string
+	Details    brokerapi.UnbindDetails
+}
+
+// ContextInstanceCreator is implemented by the InstanceCreator of plans that take the context of
+// a request, to stop when it is cancelled or times out and to log its request ID, and the details
+// of the request, e.g. its parameters. The broker uses it rather than Create and Destroy.
+type ContextInstanceCreator interface {
+	CreateWithContext(ctx context.Context, request ProvisionRequest) error
+	DestroyWithContext(ctx context.Context, request DeprovisionRequest) error
+}
+
+// ContextInstanceBinder is implemented by the InstanceBinder of plans that take the context and
+// details of a request. The broker uses it rather than Bind and Unbind.
+type ContextInstanceBinder interface {
+	BindWithContext(ctx context.Context, request BindRequest) (InstanceCredentials, error)
+	UnbindWithContext(ctx context.Context, request UnbindRequest) error
+}
+
+// ContextManager is implemented by the managers, InstanceCreators and InstanceBinders that take the
+// context of a request: WithContext returns a copy of the manager for the request, e.g. one that
+// stops once the request is cancelled or times out and that logs its request ID. The broker calls
+// each manager through the copy for the request; WithContext must return a value that implements
+// the same interfaces as the manager.
+type ContextManager interface {
+	WithContext(ctx context.Context) interface{}
+}
+
+// withContext returns the copy of manager for the request of ctx, or manager if it does not take
+// the context of a request
+func withContext[T any](ctx context.Context, manager T) T {
+	if contextManager, ok := any(manager).(ContextManager); ok {
+		if requestManager, ok := contextManager.WithContext(ctx).(T); ok {
+			return requestManager
+		}
+	}
+	return manager
+}
+
+// detach returns a context with the values of ctx, e.g. its request ID, that is never cancelled,
+// or the error of ctx if it is already done. Requests are detached before their first change, so
+// that a cancelled request is either refused or runs to completion, rather than leaving an
+// instance or binding half created or half deleted.
+func detach(ctx context.Context) (context.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return context.WithoutCancel(ctx), nil
+}
+
+// createInstance creates a service instance with the InstanceCreator of its plan
+func createInstance(ctx context.Context, instanceCreator InstanceCreator, request ProvisionRequest) error {
+	if creator, ok := instanceCreator.(ContextInstanceCreator); ok {
+		return creator.CreateWithContext(ctx, request)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return instanceCreator.Create(request.InstanceID, request.Settings)
+}
+
+// destroyInstance deletes a service instance with the InstanceCreator of its plan
+func destroyInstance(ctx context.Context, instanceCreator InstanceCreator, request DeprovisionRequest) error {
+	if creator, ok := instanceCreator.(ContextInstanceCreator); ok {
+		return creator.DestroyWithContext(ctx, request)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return instanceCreator.Destroy(request.InstanceID)
+}
+
+// bindInstance creates a binding with the InstanceBinder of its plan
+func bindInstance(ctx context.Context, instanceBinder InstanceBinder, request BindRequest) (InstanceCredentials, error) {
+	if binder, ok := instanceBinder.(ContextInstanceBinder); ok {
+		return binder.BindWithContext(ctx, request)
+	}
+	if err := ctx.Err(); err != nil {
+		return InstanceCredentials{}, err
+	}
+	return instanceBinder.Bind(request.InstanceID, request.BindingID)
+}
+
+// unbindInstance deletes a binding with the InstanceBinder of its plan
+func unbindInstance(ctx context.Context, instanceBinder InstanceBinder, request UnbindRequest) error {
+	if binder, ok := instanceBinder.(ContextInstanceBinder); ok {
+		return binder.UnbindWithContext(ctx, request)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return instanceBinder.Unbind(request.InstanceID, request.BindingID)
+}
+
+// requestIDKey is the context key of the ID of a broker API request
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of a broker API request
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the ID of the broker API request of a context, or "" outside of one
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// RequestLogger returns a logger that adds the request ID of a context to every line it logs
+func RequestLogger(ctx context.Context, logger lager.Logger) lager.Logger {
+	if requestID := RequestID(ctx); requestID != "" {
+		return logger.WithData(lager.Data{"request_id": requestID})
+	}
+	return logger
+}
+
+// requestID returns the ID of a broker API request given by the platform, or a new one
+func requestID(req *http.Request) string {
+	if requestID := req.Header.Get(RequestIDHeader); requestID != "" {
+		return requestID
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,139 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// InstanceAttributes are the attributes of the request that provisioned a service instance
+type InstanceAttributes struct {
+	ServiceID        string          `json:"service_id"`
+	PlanID           string          `json:"plan_id"`
+	OrganizationGUID string          `json:"organization_guid"`
+	SpaceGUID        string          `json:"space_guid"`
+	Parameters       json.RawMessage `json:"parameters,omitempty"`
+	// Incomplete is true from the start of the provision until it succeeds; it is not compared
+	// with the attributes of other requests
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// BindingAttributes are the attributes of the request that created a binding
+type BindingAttributes struct {
+	ServiceID string `json:"service_id"`
+	PlanID    string `json:"plan_id"`
+	// AppGUID is the application of the binding, from its bind_resource or app_guid; it is empty for service keys
+	AppGUID            string          `json:"app_guid,omitempty"`
+	Route              string          `json:"route,omitempty"`
+	CredentialClientID string          `json:"credential_client_id,omitempty"`
+	Parameters         json.RawMessage `json:"parameters,omitempty"`
+}
+
+// RequestRecorder records the attributes of the requests that provisioned each service instance
+// and created each binding, so that identical retries of those requests can be told from
+// conflicting ones
+type RequestRecorder interface {
+	// InstanceAttributes returns the attributes an instance was provisioned with, or nil if none were recorded
+	InstanceAttributes(instanceID string) (*InstanceAttributes, error)
+	// CreateInstanceAttributes records the attributes an instance is being provisioned with, unless
+	// some are recorded already, which it returns instead; it returns nil if it recorded them
+	CreateInstanceAttributes(instanceID string, attributes InstanceAttributes) (*InstanceAttributes, error)
+	SetInstanceAttributes(instanceID string, attributes InstanceAttributes) error
+	// BindingAttributes returns the attributes a binding was created with, or nil if none were recorded
+	BindingAttributes(instanceID, bindingID string) (*BindingAttributes, error)
+	SetBindingAttributes(instanceID, bindingID string, attributes BindingAttributes) error
+	RemoveBinding(instanceID, bindingID string) error
+	RemoveInstance(instanceID string) error
+}
+
+func provisionAttributes(details brokerapi.ProvisionDetails) InstanceAttributes {
+	return InstanceAttributes{
+		ServiceID:        details.ServiceID,
+		PlanID:           details.PlanID,
+		OrganizationGUID: details.OrganizationGUID,
+		SpaceGUID:        details.SpaceGUID,
+		Parameters:       details.RawParameters,
+	}
+}
+
+func bindAttributes(details brokerapi.BindDetails) BindingAttributes {
+	attributes := BindingAttributes{
+		ServiceID:  details.ServiceID,
+		PlanID:     details.PlanID,
+		AppGUID:    bindingAppGUID(details),
+		Parameters: details.RawParameters,
+	}
+	if details.BindResource != nil {
+		attributes.Route = details.BindResource.Route
+		attributes.CredentialClientID = details.BindResource.CredentialClientID
+	}
+	return attributes
+}
+
+// Matches returns true if a request with these attributes is a retry of one with the other's
+func (attributes InstanceAttributes) Matches(other InstanceAttributes) bool {
+	return attributes.ServiceID == other.ServiceID && attributes.PlanID == other.PlanID &&
+		attributes.OrganizationGUID == other.OrganizationGUID && attributes.SpaceGUID == other.SpaceGUID &&
+		sameParameters(attributes.Parameters, other.Parameters)
+}
+
+// Matches returns true if a request with these attributes is a retry of one with the other's
+func (attributes BindingAttributes) Matches(other BindingAttributes) bool {
+	return attributes.ServiceID == other.ServiceID && attributes.PlanID == other.PlanID &&
+		attributes.AppGUID == other.AppGUID && attributes.Route == other.Route &&
+		attributes.CredentialClientID == other.CredentialClientID &&
+		sameParameters(attributes.Parameters, other.Parameters)
+}
+
+// sameParameters returns true if two sets of parameters are the same JSON, whatever their
+// formatting and the order of their keys; no parameters are the same as null or an empty object
+func sameParameters(a, b json.RawMessage) bool {
+	var decodedA, decodedB interface{}
+	if len(a) > 0 && json.Unmarshal(a, &decodedA) != nil {
+		return false
+	}
+	if len(b) > 0 && json.Unmarshal(b, &decodedB) != nil {
+		return false
+	}
+	if object, ok := decodedA.(map[string]interface{}); ok && len(object) == 0 {
+		decodedA = nil
+	}
+	if object, ok := decodedB.(map[string]interface{}); ok && len(object) == 0 {
+		decodedB = nil
+	}
+	return reflect.DeepEqual(decodedA, decodedB)
+}
+
+// retryKey is the context key of the flag that a provision or bind request is an identical retry
+type retryKey struct{}
+
+// withRetryFlag returns a context in which the broker flags a provision or bind request that is
+// an identical retry of one that already succeeded, and the flag
+func withRetryFlag(ctx context.Context) (context.Context, *bool) {
+	retry := false
+	return context.WithValue(ctx, retryKey{}, &retry), &retry
+}
+
+// flagRetry flags the request of ctx as an identical retry, if its context has a flag
+func flagRetry(ctx context.Context) {
+	if retry, ok := ctx.Value(retryKey{}).(*bool); ok {
+		*retry = true
+	}
+}
+
+// retryRecorder responds 200 rather than 201 to requests flagged as identical retries, as the
+// Open Service Broker API requires
+type retryRecorder struct {
+	http.ResponseWriter
+	retry *bool
+}
+
+func (recorder *retryRecorder) WriteHeader(status int) {
+	if status == http.StatusCreated && *recorder.retry {
+		status = http.StatusOK
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,150 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// SecretStore keeps the secrets of bindings outside of ZooKeeper, encrypted at rest
+type SecretStore interface {
+	// Put stores value, which must marshal to a JSON object, under name, replacing any value it had
+	Put(name string, value interface{}) error
+	// Get unmarshals the value stored under name into value, or returns ErrSecretNotFound
+	Get(name string, value interface{}) error
+	// Delete deletes the value stored under name, if there is one
+	Delete(name string) error
+	// Reference lets an application read the value stored under name, and returns the reference
+	// to it that the platform resolves in the application's credentials
+	Reference(name, appGUID string) (string, error)
+}
+
+// ErrSecretNotFound is returned by SecretStore.Get when nothing is stored under a name
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Secrets of a binding
+const (
+	// SecretCredentials are the credentials of a binding, as they are laid out for the application
+	SecretCredentials = "credentials"
+	// SecretBinding is what a binding was given, so that its credentials can be laid out again
+	// and given again to an identical retry of its bind request
+	SecretBinding = "binding"
+	// SecretRotatedCredentials are the credentials of the latest rotation of a binding
+	SecretRotatedCredentials = "rotated-credentials"
+)
+
+// BindingSecretName returns the name of a secret of a binding in the SecretStore
+func BindingSecretName(instanceID, bindingID, secret string) string {
+	return path.Join(instanceID, bindingID, secret)
+}
+
+// credentialReference is the key of the credentials of a binding that refer to the secret store,
+// which Cloud Foundry resolves when it gives an application its credentials
+const credentialReference = "credhub-ref"
+
+// storedBinding is what a binding was given
+type storedBinding struct {
+	Layout      string             `json:"layout"`
+	Credentials bindingCredentials `json:"credentials"`
+	// AppGUID is the application given a reference to the credentials, if it was
+	AppGUID string `json:"app_guid,omitempty"`
+}
+
+// errCredentialsNotKept is returned to an identical retry of a bind request whose credentials were
+// not kept, e.g. as the binding was created before the broker had a secret store, as they cannot be
+// issued again without replacing those of the binding
+var errCredentialsNotKept = errors.New("the binding already exists, and its credentials were not kept so that they could be given again; unbind it and bind it again")
+
+// bindingAppGUID returns the GUID of the application a bind request is for, or an empty string
+// for service keys
+func bindingAppGUID(details brokerapi.BindDetails) string {
+	if details.BindResource != nil && details.BindResource.AppGuid != "" {
+		return details.BindResource.AppGuid
+	}
+	return details.AppGUID
+}
+
+// storeBinding keeps what a binding was given in the secret store, and returns its credentials
+func (kBroker *KafkaServiceBroker) storeBinding(instanceID, bindingID string, stored storedBinding) (map[string]interface{}, error) {
+	if err := kBroker.SecretStore.Put(BindingSecretName(instanceID, bindingID, SecretBinding), stored); err != nil {
+		return nil, err
+	}
+	return kBroker.storedCredentials(instanceID, bindingID, stored)
+}
+
+// storedCredentials returns the credentials of a binding kept in the secret store: the credentials,
+// laid out, or a reference to them for a binding to an application, which are stored for it to read
+func (kBroker *KafkaServiceBroker) storedCredentials(instanceID, bindingID string, stored storedBinding) (map[string]interface{}, error) {
+	if stored.AppGUID == "" {
+		return stored.Credentials.layout(stored.Layout), nil
+	}
+	name := BindingSecretName(instanceID, bindingID, SecretCredentials)
+	if err := kBroker.SecretStore.Put(name, stored.Credentials.layout(stored.Layout)); err != nil {
+		return nil, err
+	}
+	reference, err := kBroker.SecretStore.Reference(name, stored.AppGUID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{credentialReference: reference}, nil
+}
+
+// retriedCredentials returns the credentials of a binding to an identical retry of the request
+// that created it, as they were given to that request, or refuses the retry if they were not kept
+func (kBroker *KafkaServiceBroker) retriedCredentials(instanceID, bindingID string) (map[string]interface{}, error) {
+	stored := storedBinding{}
+	err := kBroker.SecretStore.Get(BindingSecretName(instanceID, bindingID, SecretBinding), &stored)
+	if err == ErrSecretNotFound {
+		return nil, brokerapi.NewFailureResponse(errCredentialsNotKept, http.StatusConflict, "credentials-not-kept")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kBroker.storedCredentials(instanceID, bindingID, stored)
+}
+
+// updateReferencedCredentials gives the bindings kept in the secret store their new secrets, and lays
+// out the referenced credentials of rotated bindings again, so that applications are given them
+// when they are next started
+func (kBroker *KafkaServiceBroker) updateReferencedCredentials(instanceID string, rotated []RotatedCredentials) error {
+	for _, rotation := range rotated {
+		stored := storedBinding{}
+		err := kBroker.SecretStore.Get(BindingSecretName(instanceID, rotation.BindingID, SecretBinding), &stored)
+		if err == ErrSecretNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if rotation.SASL != nil {
+			stored.Credentials.SASL = rotation.SASL
+		}
+		if rotation.Certificate != nil {
+			stored.Credentials.Certificate = rotation.Certificate
+		}
+		if err = kBroker.SecretStore.Put(BindingSecretName(instanceID, rotation.BindingID, SecretBinding), stored); err != nil {
+			return err
+		}
+		if stored.AppGUID == "" {
+			continue
+		}
+		err = kBroker.SecretStore.Put(BindingSecretName(instanceID, rotation.BindingID, SecretCredentials), stored.Credentials.layout(stored.Layout))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteReferencedCredentials deletes the credentials of a binding from the secret store, if they were stored there
+func (kBroker *KafkaServiceBroker) deleteReferencedCredentials(instanceID, bindingID string) error {
+	for _, secret := range []string{SecretCredentials, SecretBinding} {
+		if err := kBroker.SecretStore.Delete(BindingSecretName(instanceID, bindingID, secret)); err != nil {
+			return fmt.Errorf("could not delete the %s of binding %s from the secret store: %v", secret, bindingID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// CertificateReloader serves the certificate of the broker API, and the CAs its clients'
+// certificates must be signed by, from files that are reloaded when they change.
+// A change that cannot be loaded, e.g. a key written before its certificate, is logged
+// and the previous certificate kept until the files are consistent again.
+type CertificateReloader struct {
+	config brokerconfig.TLSConfiguration
+	logger lager.Logger
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    map[string]time.Time
+}
+
+// NewCertificateReloader loads the certificate files of the broker API
+func NewCertificateReloader(config brokerconfig.TLSConfiguration, logger lager.Logger) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{config: config, logger: logger}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// TLSConfig returns the server TLS configuration, which always uses the latest loaded files
+func (reloader *CertificateReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: reloader.config.MinVersion,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			reloader.mutex.RLock()
+			defer reloader.mutex.RUnlock()
+			return reloader.certificate, nil
+		},
+	}
+	if reloader.config.ClientCAFile == "" {
+		return base
+	}
+	config := base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		reloader.mutex.RLock()
+		defer reloader.mutex.RUnlock()
+		clientConfig := base.Clone()
+		clientConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		clientConfig.ClientCAs = reloader.clientCAs
+		return clientConfig, nil
+	}
+	return config
+}
+
+// Reload loads the files again if any of them changed since they were last loaded,
+// and returns true if they were
+func (reloader *CertificateReloader) Reload() (bool, error) {
+	reloader.mutex.RLock()
+	modTimes := reloader.modTimes
+	reloader.mutex.RUnlock()
+
+	current, err := reloader.fileModTimes()
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for file, modTime := range current {
+		if !modTime.Equal(modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if err = reloader.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run checks the files for changes every interval until stop is closed
+func (reloader *CertificateReloader) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reloaded, err := reloader.Reload()
+			if err != nil {
+				reloader.logger.Error("reload-certificates", err, lager.Data{
+					"message": "Failed to reload certificate files; keeping the previous certificates",
+				})
+			} else if reloaded {
+				reloader.logger.Info("reload-certificates", lager.Data{
+					"cert_file":      reloader.config.CertFile,
+					"client_ca_file": reloader.config.ClientCAFile,
+					"message":        "Reloaded certificate files",
+				})
+			}
+		}
+	}
+}
+
+func (reloader *CertificateReloader) load() error {
+	// the modification times are read first, so that a change made while loading is loaded again
+	modTimes, err := reloader.fileModTimes()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(reloader.config.CertFile, reloader.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if reloader.config.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(reloader.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s has no PEM encoded certificates", reloader.config.ClientCAFile)
+		}
+	}
+
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	reloader.certificate = &certificate
+	reloader.clientCAs = clientCAs
+	reloader.modTimes = modTimes
+	return nil
+}
+
+func (reloader *CertificateReloader) fileModTimes() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, file := range []string{reloader.config.CertFile, reloader.config.KeyFile, reloader.config.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
@@ -0,0 +1,195 @@
+package broker_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// testCertificate is a certificate and its key, signed by parent or else self-signed
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+func newTestCertificate(serial int64, isCA bool, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).NotTo(HaveOccurred())
+	certificate, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (certificate *testCertificate) keyPair() tls.Certificate {
+	keyPair, err := tls.X509KeyPair(certificate.certPEM, certificate.keyPEM)
+	Expect(err).NotTo(HaveOccurred())
+	return keyPair
+}
+
+var _ = Describe("CertificateReloader", func() {
+	var dir string
+	var config brokerconfig.TLSConfiguration
+	var listener net.Listener
+
+	writeFile := func(name string, data []byte, modTime time.Time) {
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), data, 0600)).To(Succeed())
+		Expect(os.Chtimes(filepath.Join(dir, name), modTime, modTime)).To(Succeed())
+	}
+	writeServerCertificate := func(certificate *testCertificate, modTime time.Time) {
+		writeFile("cert.pem", certificate.certPEM, modTime)
+		writeFile("key.pem", certificate.keyPEM, modTime)
+	}
+
+	serve := func(reloader *broker.CertificateReloader) {
+		var err error
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig())
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					if conn.(*tls.Conn).Handshake() == nil {
+						_, _ = conn.Write([]byte("!"))
+					}
+				}()
+			}
+		}()
+	}
+
+	// handshake connects to the listener and returns the serial number of the server's certificate
+	handshake := func(clientConfig *tls.Config) (int64, error) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		// the server may still reject the client's certificate after the client's side of the
+		// handshake, so wait for the byte it writes once the handshake succeeded
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err = conn.Read(make([]byte, 1)); err != nil {
+			return 0, err
+		}
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tls")
+		Expect(err).NotTo(HaveOccurred())
+		config = brokerconfig.TLSConfiguration{
+			CertFile:   filepath.Join(dir, "cert.pem"),
+			KeyFile:    filepath.Join(dir, "key.pem"),
+			MinVersion: tls.VersionTLS12,
+		}
+	})
+
+	AfterEach(func() {
+		if listener != nil {
+			listener.Close()
+		}
+		os.RemoveAll(dir)
+	})
+
+	It("serves the certificate, and reloads it when its files change", func() {
+		writeServerCertificate(newTestCertificate(1, false, nil), time.Now().Add(-time.Minute))
+		reloader, err := broker.NewCertificateReloader(config, lager.NewLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+		serve(reloader)
+		clientConfig := &tls.Config{InsecureSkipVerify: true}
+		Expect(handshake(clientConfig)).To(Equal(int64(1)))
+
+		Expect(reloader.Reload()).To(BeFalse())
+		writeServerCertificate(newTestCertificate(2, false, nil), time.Now())
+		Expect(reloader.Reload()).To(BeTrue())
+		Expect(handshake(clientConfig)).To(Equal(int64(2)))
+	})
+
+	It("keeps the previous certificate when the files cannot be loaded", func() {
+		writeServerCertificate(newTestCertificate(1, false, nil), time.Now().Add(-time.Minute))
+		reloader, err := broker.NewCertificateReloader(config, lager.NewLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+		serve(reloader)
+
+		writeFile("key.pem", newTestCertificate(2, false, nil).keyPEM, time.Now())
+		_, err = reloader.Reload()
+		Expect(err).To(HaveOccurred())
+		Expect(handshake(&tls.Config{InsecureSkipVerify: true})).To(Equal(int64(1)))
+	})
+
+	It("refuses clients below the minimum TLS version", func() {
+		writeServerCertificate(newTestCertificate(1, false, nil), time.Now())
+		reloader, err := broker.NewCertificateReloader(config, lager.NewLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+		serve(reloader)
+
+		_, err = handshake(&tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires client certificates signed by the client CA when one is configured", func() {
+		ca := newTestCertificate(10, true, nil)
+		writeServerCertificate(newTestCertificate(1, false, nil), time.Now())
+		writeFile("client-ca.pem", ca.certPEM, time.Now())
+		config.ClientCAFile = filepath.Join(dir, "client-ca.pem")
+		reloader, err := broker.NewCertificateReloader(config, lager.NewLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+		serve(reloader)
+
+		_, err = handshake(&tls.Config{InsecureSkipVerify: true})
+		Expect(err).To(HaveOccurred())
+
+		untrusted := newTestCertificate(12, false, newTestCertificate(11, true, nil))
+		_, err = handshake(&tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{untrusted.keyPair()}})
+		Expect(err).To(HaveOccurred())
+
+		client := newTestCertificate(13, false, ca)
+		Expect(handshake(&tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{client.keyPair()}})).To(Equal(int64(1)))
+	})
+})
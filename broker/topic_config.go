@@ -0,0 +1,200 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TopicConfigPolicy declares the topic configuration of a plan: the defaults
+// every topic is created with, and the keys tenants may override, within limits.
+type TopicConfigPolicy struct {
+	Defaults    map[string]string          `json:"defaults,omitempty"`
+	Overridable map[string]TopicConfigRule `json:"overridable,omitempty"`
+}
+
+// TopicConfigRule restricts the values of an overridable topic config key.
+// Min and Max apply to numeric values; Values lists the allowed values of others.
+type TopicConfigRule struct {
+	Min    *int64   `json:"min,omitempty"`
+	Max    *int64   `json:"max,omitempty"`
+	Values []string `json:"values,omitempty"`
+}
+
+// TopicConfigManager records the topic configuration policy of each service instance,
+// so that topics can be checked against it after they are created
+type TopicConfigManager interface {
+	SetInstanceTopicConfigPolicy(instanceID string, policy TopicConfigPolicy) error
+}
+
+// TopicConfigDefaulter is implemented by the InstanceCreator of plans that create topics with
+// configuration of their own, besides the defaults of the plan's policy, e.g. a compacted cleanup.policy
+type TopicConfigDefaulter interface {
+	TopicConfigDefaults() map[string]string
+}
+
+// topicConfigParameters are the topic config overrides in the parameters of a request
+type topicConfigParameters struct {
+	TopicConfig map[string]interface{} `json:"topic_config"`
+}
+
+// IsZero returns true if the policy declares nothing
+func (policy TopicConfigPolicy) IsZero() bool {
+	return len(policy.Defaults) == 0 && len(policy.Overridable) == 0
+}
+
+// WithDefaults returns the policy with defaults for the keys it neither sets nor lets be overridden
+func (policy TopicConfigPolicy) WithDefaults(defaults map[string]string) TopicConfigPolicy {
+	merged := TopicConfigPolicy{Defaults: map[string]string{}, Overridable: policy.Overridable}
+	for key, value := range defaults {
+		if _, ok := policy.Overridable[key]; !ok {
+			merged.Defaults[key] = value
+		}
+	}
+	for key, value := range policy.Defaults {
+		merged.Defaults[key] = value
+	}
+	return merged
+}
+
+// Validate checks the policy declared by a plan
+func (policy TopicConfigPolicy) Validate() error {
+	for key, rule := range policy.Overridable {
+		if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+			return fmt.Errorf("topic config '%s' has a min greater than its max", key)
+		}
+		if (rule.Min != nil || rule.Max != nil) && len(rule.Values) > 0 {
+			return fmt.Errorf("topic config '%s' has both a range and a list of values", key)
+		}
+	}
+	for key, value := range policy.Defaults {
+		if _, ok := policy.Overridable[key]; !ok {
+			continue
+		}
+		if err := policy.check(key, value); err != nil {
+			return fmt.Errorf("default %v", err)
+		}
+	}
+	return nil
+}
+
+// Configure returns the plan defaults with overrides applied. Only overridable keys
+// may be given, with values within their limits.
+func (policy TopicConfigPolicy) Configure(overrides map[string]string) (map[string]string, error) {
+	config := map[string]string{}
+	for key, value := range policy.Defaults {
+		config[key] = value
+	}
+	for _, key := range sortedKeys(overrides) {
+		if _, ok := policy.Overridable[key]; !ok {
+			return nil, fmt.Errorf("topic config '%s' cannot be overridden; overridable keys: %s", key, strings.Join(policy.overridableKeys(), ", "))
+		}
+		if err := policy.check(key, overrides[key]); err != nil {
+			return nil, err
+		}
+		config[key] = overrides[key]
+	}
+	return config, nil
+}
+
+// Drift describes how the configuration of a topic is outside the policy: overridable
+// keys with values outside their limits, and other keys that differ from the defaults.
+// Keys that are not set are not checked; Kafka uses the cluster default for them.
+func (policy TopicConfigPolicy) Drift(config map[string]string) []string {
+	drift := []string{}
+	for _, key := range sortedKeys(config) {
+		value := config[key]
+		if _, ok := policy.Overridable[key]; ok {
+			if err := policy.check(key, value); err != nil {
+				drift = append(drift, err.Error())
+			}
+			continue
+		}
+		defaultValue, ok := policy.Defaults[key]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("topic config '%s' is not allowed", key))
+		} else if value != defaultValue {
+			drift = append(drift, fmt.Sprintf("topic config '%s' must be '%s', not '%s'", key, defaultValue, value))
+		}
+	}
+	return drift
+}
+
+// check returns an error if value is outside the limits of the overridable key
+func (policy TopicConfigPolicy) check(key, value string) error {
+	rule := policy.Overridable[key]
+	if len(rule.Values) > 0 {
+		for _, allowed := range rule.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("topic config '%s' must be one of %s, not '%s'", key, strings.Join(rule.Values, ", "), value)
+	}
+	if rule.Min == nil && rule.Max == nil {
+		return nil
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("topic config '%s' must be a whole number, not '%s'", key, value)
+	}
+	if rule.Min != nil && number < *rule.Min {
+		return fmt.Errorf("topic config '%s' must be at least %d, not %d", key, *rule.Min, number)
+	}
+	if rule.Max != nil && number > *rule.Max {
+		return fmt.Errorf("topic config '%s' must be at most %d, not %d", key, *rule.Max, number)
+	}
+	return nil
+}
+
+func (policy TopicConfigPolicy) overridableKeys() []string {
+	keys := []string{}
+	for key := range policy.Overridable {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Bullets describes the policy for the plan metadata in the catalog
+func (policy TopicConfigPolicy) Bullets() []string {
+	bullets := []string{}
+	if keys := policy.overridableKeys(); len(keys) > 0 {
+		bullets = append(bullets, "Configurable topic settings: "+strings.Join(keys, ", "))
+	}
+	return bullets
+}
+
+// parseTopicConfig reads the topic config overrides from the parameters of a request.
+// Values may be given as JSON strings, numbers or booleans.
+func parseTopicConfig(rawParameters json.RawMessage) (map[string]string, error) {
+	params := topicConfigParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return nil, err
+	}
+	config := map[string]string{}
+	for key, value := range params.TopicConfig {
+		switch value := value.(type) {
+		case string:
+			config[key] = value
+		case float64:
+			config[key] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			config[key] = strconv.FormatBool(value)
+		default:
+			return nil, fmt.Errorf("topic config '%s' must be a string, number or boolean", key)
+		}
+	}
+	return config, nil
+}
+
+func sortedKeys(config map[string]string) []string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
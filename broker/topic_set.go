@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// TopicSpec is a logical topic requested in the parameters of a service instance
+type TopicSpec struct {
+	Name string `json:"name"`
+	// Partitions is optional; the broker's default partition count is used if it is zero
+	Partitions int `json:"partitions,omitempty"`
+}
+
+// TopicSetUpdater is implemented by the InstanceCreator of plans whose service
+// instances hold a set of topics chosen with the "topics" parameter
+type TopicSetUpdater interface {
+	// UpdateTopics creates and deletes topics so that the instance holds exactly the given set
+	UpdateTopics(instanceID string, topics []TopicSpec) error
+}
+
+// topicSetParameters is the set of topics in the parameters of a request.
+// Topics is nil if the parameter was not given.
+type topicSetParameters struct {
+	Topics []TopicSpec `json:"topics"`
+}
+
+// legalTopicName matches the names Kafka accepts for topics
+var legalTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// parseTopicSet reads and validates the set of topics in the parameters of a request
+func parseTopicSet(rawParameters json.RawMessage) ([]TopicSpec, error) {
+	params := topicSetParameters{}
+	if err := parseParameters(rawParameters, &params); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, topic := range params.Topics {
+		if !legalTopicName.MatchString(topic.Name) {
+			return nil, invalidParameters(fmt.Errorf("topic name '%s' may only contain letters, digits, '.', '_' and '-'", topic.Name))
+		}
+		if names[topic.Name] {
+			return nil, invalidParameters(fmt.Errorf("topic '%s' is listed more than once", topic.Name))
+		}
+		if topic.Partitions < 0 {
+			return nil, invalidParameters(fmt.Errorf("topic '%s' must have a positive number of partitions", topic.Name))
+		}
+		names[topic.Name] = true
+	}
+	return params.Topics, nil
+}
@@ -0,0 +1,348 @@
+package broker
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Roles of the users of the broker API
+const (
+	// RolePlatform is the platform, e.g. Cloud Foundry, which may call every endpoint
+	RolePlatform = "platform"
+	// RoleOps may read service instances and call the admin endpoints, e.g. to reset offsets
+	RoleOps = "ops"
+	// RoleReadOnly may read the catalog and service instances, but no secrets
+	RoleReadOnly = "read-only"
+)
+
+// permission is what an endpoint of the broker API requires of its users
+type permission int
+
+const (
+	// permissionRead is required by the catalog and GET endpoints
+	permissionRead permission = iota
+	// permissionWrite is required by the Open Service Broker endpoints that change service instances and bindings
+	permissionWrite
+	// permissionAdmin is required by the admin endpoints, and those returning secrets
+	permissionAdmin
+)
+
+var rolePermissions = map[string][]permission{
+	RolePlatform: {permissionRead, permissionWrite, permissionAdmin},
+	RoleOps:      {permissionRead, permissionAdmin},
+	RoleReadOnly: {permissionRead},
+}
+
+func (required permission) String() string {
+	return [...]string{"read", "write", "admin"}[required]
+}
+
+// APIUser is an account of the broker API
+type APIUser struct {
+	Username string `json:"username"`
+	// PasswordHashes are hashes of the passwords the user authenticates with, made by HashPassword.
+	// A user has several while its password is rotated, until its clients all use the new one.
+	PasswordHashes []string `json:"password_hashes"`
+	Role           string   `json:"role"`
+}
+
+// allows returns true if the role of the user grants it the permission
+func (user APIUser) allows(required permission) bool {
+	for _, granted := range rolePermissions[user.Role] {
+		if granted == required {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordHashIterations is the PBKDF2 iteration count of new password hashes
+const passwordHashIterations = 100000
+
+// passwordHashScheme prefixes password hashes: pbkdf2-sha256$<iterations>$<salt>$<key>
+const passwordHashScheme = "pbkdf2-sha256"
+
+// HashPassword returns a salted PBKDF2-SHA256 hash of a password, for the users file
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordHashIterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	encode := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, passwordHashIterations, encode(salt), encode(key)), nil
+}
+
+// passwordHash is a parsed password hash
+type passwordHash struct {
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func parsePasswordHash(hash string) (passwordHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return passwordHash{}, fmt.Errorf("password hashes must be made with 'hash-password', e.g. %s$<iterations>$<salt>$<key>", passwordHashScheme)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return passwordHash{}, fmt.Errorf("invalid iteration count '%s' in password hash", parts[1])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return passwordHash{}, fmt.Errorf("invalid salt in password hash: %v", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return passwordHash{}, errors.New("invalid key in password hash")
+	}
+	return passwordHash{iterations: iterations, salt: salt, key: key}, nil
+}
+
+func (hash passwordHash) matches(password string) bool {
+	key, err := pbkdf2.Key(sha256.New, password, hash.salt, hash.iterations, len(hash.key))
+	return err == nil && subtle.ConstantTimeCompare(key, hash.key) == 1
+}
+
+// apiUser is a user of the broker API, with its password hashes parsed
+type apiUser struct {
+	APIUser
+	hashes []passwordHash
+}
+
+// APIUsers authenticates the users of the broker API. The users of a users file are reloaded when
+// it changes, so that users and passwords can be added and removed without a restart; a change
+// that cannot be loaded is logged and the previous users kept.
+type APIUsers struct {
+	file   string
+	static []APIUser
+	logger lager.Logger
+
+	mutex   sync.RWMutex
+	users   map[string]apiUser
+	modTime time.Time
+	// verified caches a digest of the last password each hash was verified against, so that
+	// PBKDF2 is only computed once per password rather than on every request
+	verified map[string][sha256.Size]byte
+	// failed caches digests of the passwords each hash failed to verify, so that PBKDF2 is not
+	// computed again for each retry of a wrong password
+	failed map[string]map[[sha256.Size]byte]bool
+	// unknown is verified against for usernames without a user, so that refusing them takes as
+	// long as refusing a wrong password, and does not tell which usernames exist
+	unknown apiUser
+}
+
+// maxFailedDigests is how many digests of wrong passwords are cached for each hash; the cache of a
+// hash is emptied when it is full
+const maxFailedDigests = 1024
+
+type usersFile struct {
+	Users []APIUser `json:"users"`
+}
+
+// NewAPIUsers loads the users of usersFile, if it is not empty, besides the static users
+func NewAPIUsers(usersFile string, static []APIUser, logger lager.Logger) (*APIUsers, error) {
+	users := &APIUsers{file: usersFile, static: static, logger: logger}
+	if err := users.load(); err != nil {
+		return nil, err
+	}
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	hash, err := HashPassword(base64.RawStdEncoding.EncodeToString(password))
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parsePasswordHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	users.unknown = apiUser{APIUser: APIUser{PasswordHashes: []string{hash}}, hashes: []passwordHash{parsed}}
+	return users, nil
+}
+
+// StaticAPIUser is a user whose password is given in clear, e.g. BROKER_USERNAME and BROKER_PASSWORD
+func StaticAPIUser(username, password, role string) (APIUser, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return APIUser{}, err
+	}
+	return APIUser{Username: username, PasswordHashes: []string{hash}, Role: role}, nil
+}
+
+// Authenticate returns the user with the username and password of a request's basic auth
+func (users *APIUsers) Authenticate(username, password string) (APIUser, bool) {
+	users.mutex.RLock()
+	user, ok := users.users[username]
+	users.mutex.RUnlock()
+	if !ok {
+		user = users.unknown
+	}
+
+	digest := sha256.Sum256([]byte(password))
+	for i, hash := range user.hashes {
+		key := user.PasswordHashes[i]
+		users.mutex.RLock()
+		verified, cached := users.verified[key]
+		failed := users.failed[key][digest]
+		users.mutex.RUnlock()
+		if cached && subtle.ConstantTimeCompare(verified[:], digest[:]) == 1 {
+			return user.APIUser, true
+		}
+		if failed {
+			continue
+		}
+		// the hash of an unknown username is computed too, though it cannot match
+		if hash.matches(password) && ok {
+			users.mutex.Lock()
+			users.verified[key] = digest
+			users.mutex.Unlock()
+			return user.APIUser, true
+		}
+		users.mutex.Lock()
+		if len(users.failed[key]) >= maxFailedDigests || users.failed[key] == nil {
+			users.failed[key] = map[[sha256.Size]byte]bool{}
+		}
+		users.failed[key][digest] = true
+		users.mutex.Unlock()
+	}
+	return APIUser{}, false
+}
+
+// Reload loads the users file again if it changed since it was last loaded, and returns true if it was
+func (users *APIUsers) Reload() (bool, error) {
+	if users.file == "" {
+		return false, nil
+	}
+	info, err := os.Stat(users.file)
+	if err != nil {
+		return false, err
+	}
+	users.mutex.RLock()
+	modTime := users.modTime
+	users.mutex.RUnlock()
+	if info.ModTime().Equal(modTime) {
+		return false, nil
+	}
+	if err = users.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run checks the users file for changes every interval until stop is closed
+func (users *APIUsers) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reloaded, err := users.Reload()
+			if err != nil {
+				users.logger.Error("reload-users", err, lager.Data{
+					"message": "Failed to reload the users file; keeping the previous users",
+				})
+			} else if reloaded {
+				users.logger.Info("reload-users", lager.Data{
+					"users_file": users.file,
+					"message":    "Reloaded the users file",
+				})
+			}
+		}
+	}
+}
+
+func (users *APIUsers) load() error {
+	var all []APIUser
+	var modTime time.Time
+	if users.file != "" {
+		// the modification time is read first, so that a change made while loading is loaded again
+		info, err := os.Stat(users.file)
+		if err != nil {
+			return err
+		}
+		modTime = info.ModTime()
+		data, err := ioutil.ReadFile(users.file)
+		if err != nil {
+			return err
+		}
+		parsed := usersFile{}
+		if err = json.Unmarshal(data, &parsed); err != nil {
+			return fmt.Errorf("users file %s: %v", users.file, err)
+		}
+		for _, user := range parsed.Users {
+			if user.Username == "" {
+				return fmt.Errorf("users file %s: every user needs a username", users.file)
+			}
+		}
+		all = parsed.Users
+	}
+
+	loaded := map[string]apiUser{}
+	for _, user := range append(append([]APIUser{}, users.static...), all...) {
+		if _, ok := loaded[user.Username]; ok {
+			return fmt.Errorf("user '%s' is defined more than once", user.Username)
+		}
+		if _, ok := rolePermissions[user.Role]; !ok {
+			return fmt.Errorf("user '%s' must have role '%s', '%s' or '%s', not '%s'", user.Username, RolePlatform, RoleOps, RoleReadOnly, user.Role)
+		}
+		if len(user.PasswordHashes) == 0 {
+			return fmt.Errorf("user '%s' needs at least one password hash", user.Username)
+		}
+		parsed := apiUser{APIUser: user}
+		for _, hash := range user.PasswordHashes {
+			passwordHash, err := parsePasswordHash(hash)
+			if err != nil {
+				return fmt.Errorf("user '%s': %v", user.Username, err)
+			}
+			parsed.hashes = append(parsed.hashes, passwordHash)
+		}
+		loaded[user.Username] = parsed
+	}
+
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+	users.users = loaded
+	users.modTime = modTime
+	users.verified = map[string][sha256.Size]byte{}
+	users.failed = map[string]map[[sha256.Size]byte]bool{}
+	return nil
+}
+
+// apiUserKey is the context key of the user of a broker API request
+type apiUserKey struct{}
+
+// withAPIUser returns a context carrying the user of a broker API request
+func withAPIUser(ctx context.Context, user APIUser) context.Context {
+	return context.WithValue(ctx, apiUserKey{}, user)
+}
+
+// apiActor returns the actor of a broker API request recorded in audit entries, which names its user
+func apiActor(ctx context.Context) string {
+	if user, ok := ctx.Value(apiUserKey{}).(APIUser); ok {
+		return ActorBrokerAPI + ":" + user.Username
+	}
+	return ActorBrokerAPI
+}
@@ -1,59 +1,243 @@
 package brokerconfig
 
 import (
-	"os"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/wvanbergen/kazoo-go"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
 )
 
 // Config contains the broker's primary configuration
 type Config struct {
 	Broker             BrokerConfiguration
 	KafkaConfiguration KafkaConfiguration
+	SecretStore        SecretStoreConfiguration
+	// Getenv looks up the variables the configuration was loaded from, which also configure the catalog
+	Getenv Getenv
 }
 
+// Getenv looks up the value of a configuration variable, as os.Getenv does
+type Getenv func(key string) string
+
 // BrokerConfiguration contains the auth credentials
 type BrokerConfiguration struct {
 	ListenPort string
 	Username   string
 	Password   string
+	// UsersFile is optional; it holds more users of the broker API, with hashed passwords and roles
+	UsersFile string
+	// UsersReloadInterval is how often UsersFile is checked for changes
+	UsersReloadInterval time.Duration
+	// ConfigFile is optional; its KEY=value lines override the environment, and it is reloaded when it changes
+	ConfigFile string
+	// ConfigReloadInterval is how often ConfigFile and the catalog file are checked for changes
+	ConfigReloadInterval time.Duration
+	// ShutdownTimeout is how long the broker waits for in-flight requests and background jobs when it is stopped
+	ShutdownTimeout time.Duration
+	TLS             TLSConfiguration
+}
+
+// TLSConfiguration has the broker serve its API over HTTPS when CertFile and KeyFile are set
+type TLSConfiguration struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is optional; when set, clients must present a certificate signed by one of its CAs
+	ClientCAFile string
+	MinVersion   uint16
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval time.Duration
+}
+
+// Enabled returns true if the API is served over HTTPS
+func (tlsConfig TLSConfiguration) Enabled() bool {
+	return tlsConfig.CertFile != ""
+}
+
+// tlsVersions are the values of BROKER_TLS_MIN_VERSION
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
 }
 
 // KafkaConfiguration contains location/credentials for Kafka
 type KafkaConfiguration struct {
-	ZookeeperPeers         string
-	ZookeeperTimeout       time.Duration
+	// ZookeeperPeers is the ZooKeeper connection string, including any chroot
+	ZookeeperPeers   string
+	ZookeeperTimeout time.Duration
+	// ZookeeperSecurity is how the broker authenticates to ZooKeeper and protects the znodes it creates
+	ZookeeperSecurity      zookeeper.Security
 	KafkaHostnames         string
 	KafkaPartitionCount    int
 	KafkaReplicationFactor int
+	// QuotaEntityType is "clients" to apply quotas by client ID, or "users" to apply them by principal
+	QuotaEntityType string
+	// TopicLimitCheckInterval is how often topic limits are checked, besides whenever topics change
+	TopicLimitCheckInterval time.Duration
+	// SecurityProtocol is the protocol of the Kafka listener clients connect to: PLAINTEXT, SSL, SASL_PLAINTEXT or SASL_SSL
+	SecurityProtocol string
+	// SASLMechanism is SCRAM-SHA-256 or SCRAM-SHA-512 when SecurityProtocol uses SASL; each binding is given SCRAM credentials
+	SASLMechanism string
+	// ClientCertificates has each binding issued a client certificate when SecurityProtocol is SSL
+	ClientCertificates ClientCertificateConfiguration
+	// CredentialGracePeriod is how long the credentials of a binding stay valid after they are rotated
+	CredentialGracePeriod time.Duration
 }
 
-// LoadConfig loads environment variables into Config
-func LoadConfig() (config Config, err error) {
-	config.Broker.ListenPort = os.Getenv("PORT")
+// ClientCertificateConfiguration has each binding issued a client certificate, signed by the CA of
+// CACertFile and CAKeyFile, when they are set
+type ClientCertificateConfiguration struct {
+	// CACertFile holds the PEM certificate of the CA, followed by any intermediates up to the root
+	CACertFile string
+	CAKeyFile  string
+	// CRLFile is where the CRL of the certificates of deleted bindings is written
+	CRLFile string
+	// Validity is how long certificates are valid for
+	Validity time.Duration
+}
+
+// Enabled returns true if bindings are issued client certificates
+func (certConfig ClientCertificateConfiguration) Enabled() bool {
+	return certConfig.CACertFile != ""
+}
+
+// SecretStoreConfiguration has the secrets of bindings kept in a secret store, when Type is set
+type SecretStoreConfiguration struct {
+	// Type is SecretStoreFile or SecretStoreCredHub
+	Type string
+	// PathPrefix is the prefix of the names of secrets, e.g. /c/kafka-service-broker
+	PathPrefix string
+	// CredentialReferences has bindings to applications given a reference to their credentials in
+	// the secret store rather than the credentials, for platforms that resolve them
+	CredentialReferences bool
+	File                 FileSecretStoreConfiguration
+	CredHub              CredHubConfiguration
+}
+
+// FileSecretStoreConfiguration keeps secrets in a local file, encrypted with Key
+type FileSecretStoreConfiguration struct {
+	Path string
+	// Key is the 256 bit AES key the file is encrypted with
+	Key []byte
+}
+
+// CredHubConfiguration keeps secrets in CredHub, authenticating with a client certificate or
+// with a UAA client
+type CredHubConfiguration struct {
+	URL string
+	// TLS trusts the CA of CredHub, and holds any client certificate
+	TLS             *tls.Config
+	UAAURL          string
+	UAAClientID     string
+	UAAClientSecret string
+}
+
+// Types of secret store
+const (
+	SecretStoreFile    = "file"
+	SecretStoreCredHub = "credhub"
+)
+
+// Enabled returns true if the secrets of bindings are kept in a secret store
+func (storeConfig SecretStoreConfiguration) Enabled() bool {
+	return storeConfig.Type != ""
+}
+
+// Kafka listener security protocols
+const (
+	SecurityProtocolPlaintext     = "PLAINTEXT"
+	SecurityProtocolSSL           = "SSL"
+	SecurityProtocolSASLPlaintext = "SASL_PLAINTEXT"
+	SecurityProtocolSASLSSL       = "SASL_SSL"
+)
+
+// SASL mechanisms the broker can create credentials for
+const (
+	SASLMechanismScramSHA256 = "SCRAM-SHA-256"
+	SASLMechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+// LoadConfig loads the variables getenv looks up, such as os.Getenv, into Config
+func LoadConfig(getenv Getenv) (config Config, err error) {
+	config.Getenv = getenv
+	config.Broker.ListenPort = getenv("PORT")
 	if config.Broker.ListenPort == "" {
 		config.Broker.ListenPort = "8100"
 	}
-	config.Broker.Username = os.Getenv("BROKER_USERNAME")
-	config.Broker.Password = os.Getenv("BROKER_PASSWORD")
+	config.Broker.Username = getenv("BROKER_USERNAME")
+	config.Broker.Password = getenv("BROKER_PASSWORD")
+	config.Broker.UsersFile = getenv("BROKER_USERS_FILE")
+	config.Broker.UsersReloadInterval = 30 * time.Second
+	if interval := getenv("BROKER_USERS_RELOAD_INTERVAL"); interval != "" {
+		if config.Broker.UsersReloadInterval, err = parseInterval("BROKER_USERS_RELOAD_INTERVAL", interval); err != nil {
+			return
+		}
+	}
+	config.Broker.ConfigFile = getenv("BROKER_CONFIG_FILE")
+	config.Broker.ConfigReloadInterval = 30 * time.Second
+	if interval := getenv("BROKER_CONFIG_RELOAD_INTERVAL"); interval != "" {
+		if config.Broker.ConfigReloadInterval, err = parseInterval("BROKER_CONFIG_RELOAD_INTERVAL", interval); err != nil {
+			return
+		}
+	}
+	config.Broker.ShutdownTimeout = 10 * time.Second
+	if timeout := getenv("BROKER_SHUTDOWN_TIMEOUT"); timeout != "" {
+		if config.Broker.ShutdownTimeout, err = time.ParseDuration(timeout); err != nil {
+			err = fmt.Errorf("BROKER_SHUTDOWN_TIMEOUT must be a duration such as '30s': %v", err)
+			return
+		}
+	}
+	if err = config.Broker.TLS.load(getenv); err != nil {
+		return
+	}
 
-	config.KafkaConfiguration.ZookeeperPeers = os.Getenv("ZOOKEEPER_PEERS")
-	if config.KafkaConfiguration.ZookeeperPeers == "" {
-		config.KafkaConfiguration.ZookeeperPeers = "localhost:2181"
+	config.KafkaConfiguration.ZookeeperPeers, err = zookeeperConnectionString(getenv("ZOOKEEPER_PEERS"), getenv("ZOOKEEPER_CHROOT"))
+	if err != nil {
+		return
 	}
 	config.KafkaConfiguration.ZookeeperTimeout = 1000
+	if config.KafkaConfiguration.ZookeeperSecurity, err = LoadZookeeperSecurity(getenv); err != nil {
+		return
+	}
 
-	zkConf := kazoo.NewConfig()
-	zkConf.Timeout = time.Duration(config.KafkaConfiguration.ZookeeperTimeout) * time.Millisecond
+	config.KafkaConfiguration.QuotaEntityType = getenv("KAFKA_QUOTA_ENTITY_TYPE")
+	switch config.KafkaConfiguration.QuotaEntityType {
+	case "":
+		config.KafkaConfiguration.QuotaEntityType = "clients"
+	case "clients", "users":
+	default:
+		err = fmt.Errorf("KAFKA_QUOTA_ENTITY_TYPE must be 'clients' or 'users', not '%s'", config.KafkaConfiguration.QuotaEntityType)
+		return
+	}
+
+	config.KafkaConfiguration.TopicLimitCheckInterval = time.Minute
+	if interval := getenv("TOPIC_LIMIT_CHECK_INTERVAL"); interval != "" {
+		if config.KafkaConfiguration.TopicLimitCheckInterval, err = parseInterval("TOPIC_LIMIT_CHECK_INTERVAL", interval); err != nil {
+			return
+		}
+	}
+
+	if err = config.KafkaConfiguration.loadSecurity(getenv); err != nil {
+		return
+	}
+	if err = config.SecretStore.load(getenv); err != nil {
+		return
+	}
 
-	kz, err := kazoo.NewKazooFromConnectionString(config.KafkaConfiguration.ZookeeperPeers, zkConf)
+	cluster, err := zookeeper.OpenCluster(config.KafkaConfiguration.ZookeeperConnector())
 	if err != nil {
 		return
 	}
-	defer func() { _ = kz.Close() }()
+	defer func() { _ = cluster.Close() }()
 
-	brokers, err := kz.BrokerList()
+	brokers, err := cluster.BrokerList()
 	if err != nil {
 		return
 	}
@@ -68,3 +252,302 @@ func LoadConfig() (config Config, err error) {
 	config.KafkaConfiguration.KafkaPartitionCount = 2
 	return
 }
+
+// zookeeperConnectionString adds any chroot given separately to the ZooKeeper connection string
+func zookeeperConnectionString(peers, chroot string) (string, error) {
+	if peers == "" {
+		peers = "localhost:2181"
+	}
+	if chroot == "" {
+		return peers, nil
+	}
+	if !strings.HasPrefix(chroot, "/") || strings.HasSuffix(chroot, "/") {
+		return "", fmt.Errorf("ZOOKEEPER_CHROOT must start with '/' and not end with one, e.g. /kafka")
+	}
+	if index := strings.Index(peers, "/"); index >= 0 {
+		if peers[index:] != chroot {
+			return "", fmt.Errorf("ZOOKEEPER_PEERS has chroot '%s', which differs from ZOOKEEPER_CHROOT '%s'", peers[index:], chroot)
+		}
+		return peers, nil
+	}
+	return peers + chroot, nil
+}
+
+// LoadZookeeperSecurity loads how the broker and its commands authenticate to ZooKeeper from the variables getenv looks up
+func LoadZookeeperSecurity(getenv Getenv) (security zookeeper.Security, err error) {
+	security.DigestUsername = getenv("ZOOKEEPER_DIGEST_USERNAME")
+	security.DigestPassword = getenv("ZOOKEEPER_DIGEST_PASSWORD")
+	if (security.DigestUsername == "") != (security.DigestPassword == "") {
+		err = fmt.Errorf("ZOOKEEPER_DIGEST_USERNAME and ZOOKEEPER_DIGEST_PASSWORD must be set together")
+		return
+	}
+	if security.KafkaIDs, err = zookeeper.ParseACLIDs(getenv("ZOOKEEPER_KAFKA_ACL_IDS")); err != nil {
+		err = fmt.Errorf("ZOOKEEPER_KAFKA_ACL_IDS: %v", err)
+		return
+	}
+	if len(security.KafkaIDs) > 0 && security.DigestUsername == "" {
+		err = fmt.Errorf("ZOOKEEPER_KAFKA_ACL_IDS requires ZOOKEEPER_DIGEST_USERNAME and ZOOKEEPER_DIGEST_PASSWORD")
+		return
+	}
+
+	caFile := getenv("ZOOKEEPER_TLS_CA_FILE")
+	certFile := getenv("ZOOKEEPER_TLS_CERT_FILE")
+	keyFile := getenv("ZOOKEEPER_TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		err = fmt.Errorf("ZOOKEEPER_TLS_CERT_FILE and ZOOKEEPER_TLS_KEY_FILE must be set together")
+		return
+	}
+	if caFile == "" {
+		if certFile != "" {
+			err = fmt.Errorf("ZOOKEEPER_TLS_CERT_FILE requires ZOOKEEPER_TLS_CA_FILE")
+		}
+		return
+	}
+	security.TLS = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: x509.NewCertPool()}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return
+	}
+	if !security.TLS.RootCAs.AppendCertsFromPEM(pem) {
+		err = fmt.Errorf("ZOOKEEPER_TLS_CA_FILE %s has no PEM encoded certificates", caFile)
+		return
+	}
+	if certFile != "" {
+		var certificate tls.Certificate
+		if certificate, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return
+		}
+		security.TLS.Certificates = []tls.Certificate{certificate}
+	}
+	return
+}
+
+// load reads the TLS configuration of the broker API
+func (tlsConfig *TLSConfiguration) load(getenv Getenv) error {
+	tlsConfig.CertFile = getenv("BROKER_TLS_CERT_FILE")
+	tlsConfig.KeyFile = getenv("BROKER_TLS_KEY_FILE")
+	tlsConfig.ClientCAFile = getenv("BROKER_TLS_CLIENT_CA_FILE")
+	if (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+		return fmt.Errorf("BROKER_TLS_CERT_FILE and BROKER_TLS_KEY_FILE must be set together")
+	}
+	if tlsConfig.ClientCAFile != "" && !tlsConfig.Enabled() {
+		return fmt.Errorf("BROKER_TLS_CLIENT_CA_FILE requires BROKER_TLS_CERT_FILE and BROKER_TLS_KEY_FILE")
+	}
+
+	minVersion := getenv("BROKER_TLS_MIN_VERSION")
+	if minVersion == "" {
+		minVersion = "1.2"
+	}
+	var ok bool
+	if tlsConfig.MinVersion, ok = tlsVersions[minVersion]; !ok {
+		return fmt.Errorf("BROKER_TLS_MIN_VERSION must be 1.0, 1.1, 1.2 or 1.3, not '%s'", minVersion)
+	}
+
+	tlsConfig.ReloadInterval = 30 * time.Second
+	if interval := getenv("BROKER_TLS_RELOAD_INTERVAL"); interval != "" {
+		var err error
+		if tlsConfig.ReloadInterval, err = parseInterval("BROKER_TLS_RELOAD_INTERVAL", interval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseInterval parses the value of an environment variable that sets how often something runs,
+// which must be positive
+func parseInterval(name, value string) (time.Duration, error) {
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration such as '30s': %v", name, err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("%s must be positive, not '%s'", name, value)
+	}
+	return interval, nil
+}
+
+// loadSecurity reads the security protocol of the Kafka listener and its SASL mechanism
+func (kafkaConfig *KafkaConfiguration) loadSecurity(getenv Getenv) error {
+	kafkaConfig.SecurityProtocol = getenv("KAFKA_SECURITY_PROTOCOL")
+	switch kafkaConfig.SecurityProtocol {
+	case "":
+		kafkaConfig.SecurityProtocol = SecurityProtocolPlaintext
+	case SecurityProtocolPlaintext, SecurityProtocolSSL, SecurityProtocolSASLPlaintext, SecurityProtocolSASLSSL:
+	default:
+		return fmt.Errorf("KAFKA_SECURITY_PROTOCOL must be %s, %s, %s or %s, not '%s'", SecurityProtocolPlaintext,
+			SecurityProtocolSSL, SecurityProtocolSASLPlaintext, SecurityProtocolSASLSSL, kafkaConfig.SecurityProtocol)
+	}
+
+	if err := kafkaConfig.ClientCertificates.load(kafkaConfig.SecurityProtocol, getenv); err != nil {
+		return err
+	}
+	kafkaConfig.CredentialGracePeriod = 24 * time.Hour
+	if gracePeriod := getenv("CREDENTIAL_ROTATION_GRACE_PERIOD"); gracePeriod != "" {
+		var err error
+		if kafkaConfig.CredentialGracePeriod, err = time.ParseDuration(gracePeriod); err != nil || kafkaConfig.CredentialGracePeriod < 0 {
+			return fmt.Errorf("CREDENTIAL_ROTATION_GRACE_PERIOD must be a duration such as '24h'")
+		}
+	}
+
+	kafkaConfig.SASLMechanism = getenv("KAFKA_SASL_MECHANISM")
+	if !kafkaConfig.SASLEnabled() {
+		if kafkaConfig.SASLMechanism != "" {
+			return fmt.Errorf("KAFKA_SASL_MECHANISM requires KAFKA_SECURITY_PROTOCOL %s or %s", SecurityProtocolSASLPlaintext, SecurityProtocolSASLSSL)
+		}
+		return nil
+	}
+	switch kafkaConfig.SASLMechanism {
+	case "":
+		kafkaConfig.SASLMechanism = SASLMechanismScramSHA256
+	case SASLMechanismScramSHA256, SASLMechanismScramSHA512:
+	default:
+		return fmt.Errorf("KAFKA_SASL_MECHANISM must be %s or %s, not '%s'", SASLMechanismScramSHA256, SASLMechanismScramSHA512, kafkaConfig.SASLMechanism)
+	}
+	return nil
+}
+
+// load reads the CA that signs the client certificate of each binding
+func (certConfig *ClientCertificateConfiguration) load(securityProtocol string, getenv Getenv) error {
+	certConfig.CACertFile = getenv("KAFKA_CLIENT_CA_CERT_FILE")
+	certConfig.CAKeyFile = getenv("KAFKA_CLIENT_CA_KEY_FILE")
+	certConfig.CRLFile = getenv("KAFKA_CLIENT_CRL_FILE")
+	if (certConfig.CACertFile == "") != (certConfig.CAKeyFile == "") {
+		return fmt.Errorf("KAFKA_CLIENT_CA_CERT_FILE and KAFKA_CLIENT_CA_KEY_FILE must be set together")
+	}
+	if !certConfig.Enabled() {
+		if certConfig.CRLFile != "" {
+			return fmt.Errorf("KAFKA_CLIENT_CRL_FILE requires KAFKA_CLIENT_CA_CERT_FILE and KAFKA_CLIENT_CA_KEY_FILE")
+		}
+		return nil
+	}
+	if securityProtocol != SecurityProtocolSSL {
+		return fmt.Errorf("KAFKA_CLIENT_CA_CERT_FILE requires KAFKA_SECURITY_PROTOCOL %s", SecurityProtocolSSL)
+	}
+	if certConfig.CRLFile == "" {
+		return fmt.Errorf("KAFKA_CLIENT_CA_CERT_FILE requires KAFKA_CLIENT_CRL_FILE")
+	}
+	if _, err := tls.LoadX509KeyPair(certConfig.CACertFile, certConfig.CAKeyFile); err != nil {
+		return fmt.Errorf("KAFKA_CLIENT_CA_CERT_FILE and KAFKA_CLIENT_CA_KEY_FILE: %v", err)
+	}
+
+	certConfig.Validity = 365 * 24 * time.Hour
+	if validity := getenv("KAFKA_CLIENT_CERT_VALIDITY"); validity != "" {
+		var err error
+		if certConfig.Validity, err = time.ParseDuration(validity); err != nil {
+			return fmt.Errorf("KAFKA_CLIENT_CERT_VALIDITY must be a duration such as '8760h': %v", err)
+		}
+	}
+	return nil
+}
+
+// load reads where the secrets of bindings are kept
+func (storeConfig *SecretStoreConfiguration) load(getenv Getenv) error {
+	storeConfig.Type = getenv("SECRET_STORE")
+	storeConfig.PathPrefix = getenv("SECRET_STORE_PATH_PREFIX")
+	if storeConfig.PathPrefix == "" {
+		storeConfig.PathPrefix = "/c/kafka-service-broker"
+	}
+	if !strings.HasPrefix(storeConfig.PathPrefix, "/") {
+		return fmt.Errorf("SECRET_STORE_PATH_PREFIX must start with '/'")
+	}
+	if references := getenv("SECRET_STORE_CREDENTIAL_REFERENCES"); references != "" {
+		var err error
+		if storeConfig.CredentialReferences, err = strconv.ParseBool(references); err != nil {
+			return fmt.Errorf("SECRET_STORE_CREDENTIAL_REFERENCES must be 'true' or 'false', not '%s'", references)
+		}
+	}
+
+	switch storeConfig.Type {
+	case "":
+		if storeConfig.CredentialReferences {
+			return fmt.Errorf("SECRET_STORE_CREDENTIAL_REFERENCES requires SECRET_STORE")
+		}
+		return nil
+	case SecretStoreFile:
+		return storeConfig.File.load(getenv)
+	case SecretStoreCredHub:
+		return storeConfig.CredHub.load(getenv)
+	}
+	return fmt.Errorf("SECRET_STORE must be '%s' or '%s', not '%s'", SecretStoreFile, SecretStoreCredHub, storeConfig.Type)
+}
+
+// load reads the file secrets are kept in and the key it is encrypted with
+func (fileConfig *FileSecretStoreConfiguration) load(getenv Getenv) error {
+	fileConfig.Path = getenv("SECRET_STORE_FILE")
+	if fileConfig.Path == "" {
+		return fmt.Errorf("SECRET_STORE %s requires SECRET_STORE_FILE", SecretStoreFile)
+	}
+	key, err := base64.StdEncoding.DecodeString(getenv("SECRET_STORE_KEY"))
+	if err != nil || len(key) != 32 {
+		return fmt.Errorf("SECRET_STORE_KEY must be 32 random bytes, base64 encoded, e.g. the output of 'openssl rand -base64 32'")
+	}
+	fileConfig.Key = key
+	return nil
+}
+
+// load reads where CredHub is and how the broker authenticates to it
+func (credhubConfig *CredHubConfiguration) load(getenv Getenv) error {
+	credhubConfig.URL = strings.TrimSuffix(getenv("CREDHUB_URL"), "/")
+	if credhubConfig.URL == "" {
+		return fmt.Errorf("SECRET_STORE %s requires CREDHUB_URL", SecretStoreCredHub)
+	}
+	credhubConfig.UAAURL = strings.TrimSuffix(getenv("CREDHUB_UAA_URL"), "/")
+	credhubConfig.UAAClientID = getenv("CREDHUB_UAA_CLIENT_ID")
+	credhubConfig.UAAClientSecret = getenv("CREDHUB_UAA_CLIENT_SECRET")
+	if credhubConfig.UAAURL != "" && (credhubConfig.UAAClientID == "" || credhubConfig.UAAClientSecret == "") {
+		return fmt.Errorf("CREDHUB_UAA_URL requires CREDHUB_UAA_CLIENT_ID and CREDHUB_UAA_CLIENT_SECRET")
+	}
+
+	credhubConfig.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := getenv("CREDHUB_CA_CERT_FILE"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		credhubConfig.TLS.RootCAs = x509.NewCertPool()
+		if !credhubConfig.TLS.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("CREDHUB_CA_CERT_FILE %s has no PEM encoded certificates", caFile)
+		}
+	}
+	certFile := getenv("CREDHUB_CLIENT_CERT_FILE")
+	keyFile := getenv("CREDHUB_CLIENT_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("CREDHUB_CLIENT_CERT_FILE and CREDHUB_CLIENT_KEY_FILE must be set together")
+	}
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		credhubConfig.TLS.Certificates = []tls.Certificate{certificate}
+	} else if credhubConfig.UAAURL == "" {
+		return fmt.Errorf("SECRET_STORE %s requires CREDHUB_CLIENT_CERT_FILE and CREDHUB_CLIENT_KEY_FILE, or CREDHUB_UAA_URL", SecretStoreCredHub)
+	}
+	return nil
+}
+
+// CredentialsRotatable returns true if bindings are given SCRAM credentials or client certificates,
+// which can be rotated, and there is a secret store to keep the new credentials in
+func (config Config) CredentialsRotatable() bool {
+	kafkaConfig := config.KafkaConfiguration
+	return (kafkaConfig.SASLEnabled() || kafkaConfig.ClientCertificates.Enabled()) && config.SecretStore.Enabled()
+}
+
+// Principal returns the Kafka principal a binding authenticates as: its distinguished name when it
+// is issued a client certificate, or else its binding ID, e.g. its SCRAM username
+func (kafkaConfig KafkaConfiguration) Principal(bindingID string) string {
+	if kafkaConfig.ClientCertificates.Enabled() {
+		return "User:CN=" + bindingID
+	}
+	return "User:" + bindingID
+}
+
+// SASLEnabled returns true if clients authenticate to Kafka with SASL
+func (kafkaConfig KafkaConfiguration) SASLEnabled() bool {
+	return kafkaConfig.SecurityProtocol == SecurityProtocolSASLPlaintext || kafkaConfig.SecurityProtocol == SecurityProtocolSASLSSL
+}
+
+// ZookeeperConnector opens sessions to the ZooKeeper cluster used by Kafka
+func (kafkaConfig KafkaConfiguration) ZookeeperConnector() zookeeper.Connector {
+	return zookeeper.NewSecureConnector(kafkaConfig.ZookeeperPeers, time.Duration(kafkaConfig.ZookeeperTimeout)*time.Millisecond, kafkaConfig.ZookeeperSecurity)
+}
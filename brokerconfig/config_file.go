@@ -0,0 +1,67 @@
+package brokerconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ConfigFileGetenv returns a Getenv that looks up the KEY=value lines of the config file, and
+// else the environment the broker was started with, so that LoadConfig and the catalog read the
+// file's values. Blank lines and lines starting with '#' are ignored. The environment itself is
+// left unchanged, so that variables removed from the file get back their startup value.
+func ConfigFileGetenv(file string) (Getenv, error) {
+	overrides, err := readConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, override := range overrides {
+		values[override[0]] = override[1]
+	}
+	return func(key string) string {
+		if value, ok := values[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	}, nil
+}
+
+// readConfigFile returns the KEY=value pairs of a config file
+func readConfigFile(file string) ([][2]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	overrides := [][2]string{}
+	scanner := bufio.NewScanner(f)
+	// an inline BROKER_CATALOG_JSON can be longer than a line of the default buffer
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(strings.TrimPrefix(parts[0], "export "))
+		if len(parts) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("config file %s, line %d: expected KEY=value", file, number)
+		}
+		overrides = append(overrides, [2]string{key, unquote(strings.TrimSpace(parts[1]))})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// unquote removes the quotes around a value, as a shell would for a simple quoted string
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
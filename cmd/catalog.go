@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+)
+
+// CatalogOpts represents the 'catalog' command
+type CatalogOpts struct {
+	Validate CatalogValidateOpts `command:"validate" description:"Check a catalog against the Open Service Broker API and the plans the broker implements"`
+	Generate CatalogGenerateOpts `command:"generate" description:"Print a catalog with GUIDs derived from the service and plan names"`
+}
+
+// CatalogValidateOpts represents the 'catalog validate' command
+type CatalogValidateOpts struct {
+	File string `long:"file" description:"Catalog JSON file to check; defaults to the catalog the broker loads, from BROKER_CATALOG_JSON or the default catalog"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c CatalogValidateOpts) Execute(_ []string) (err error) {
+	catalogJSON, err := readCatalog(c.File)
+	if err != nil {
+		return err
+	}
+	problems := broker.ValidateCatalog(catalogJSON, os.Getenv, kafka.Plans())
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the catalog has %d problem(s)", len(problems))
+	}
+	fmt.Println("The catalog is valid")
+	return nil
+}
+
+// CatalogGenerateOpts represents the 'catalog generate' command
+type CatalogGenerateOpts struct {
+	ServiceName string   `long:"service-name" env:"BROKER_SERVICE_NAME" description:"Name of the service; defaults to that of the template"`
+	Plans       []string `long:"plan" description:"Plan to offer, which may be given more than once; defaults to the plans of the template"`
+	Template    string   `long:"template" description:"Catalog JSON file whose first service, plans and settings are used; defaults to the catalog the broker loads"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c CatalogGenerateOpts) Execute(_ []string) (err error) {
+	template, err := readCatalog(c.Template)
+	if err != nil {
+		return err
+	}
+	catalogJSON, err := broker.GenerateCatalog(template, c.ServiceName, c.Plans)
+	if err != nil {
+		return err
+	}
+	if problems := broker.ValidateCatalog(catalogJSON, os.Getenv, kafka.Plans()); len(problems) > 0 {
+		return fmt.Errorf("the generated catalog is invalid: %v", problems[0])
+	}
+	_, err = os.Stdout.Write(catalogJSON)
+	return err
+}
+
+// readCatalog reads a catalog file, or else returns the catalog the broker loads
+func readCatalog(file string) ([]byte, error) {
+	if file == "" {
+		return broker.CatalogJSON(os.Getenv)
+	}
+	return ioutil.ReadFile(file)
+}
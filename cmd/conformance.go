@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/starkandwayne/kafka-service-broker/conformance"
+)
+
+// ConformanceOpts represents the 'conformance' command
+type ConformanceOpts struct {
+	URL      string `long:"url" env:"SB_BROKER_URL" default:"http://localhost:8100" description:"URL of the running service broker"`
+	Username string `long:"username" env:"SB_BROKER_USERNAME" description:"Basic auth username of the service broker"`
+	Password string `long:"password" env:"SB_BROKER_PASSWORD" description:"Basic auth password of the service broker"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c ConformanceOpts) Execute(_ []string) (err error) {
+	return conformance.Run(conformance.Target{
+		URL:      c.URL,
+		Username: c.Username,
+		Password: c.Password,
+	}, os.Stdout)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+)
+
+// ConsumerGroupsOpts represents the 'consumer-groups' command
+type ConsumerGroupsOpts struct {
+	InstanceID string `long:"instance-id" required:"true" description:"ID of the service instance"`
+	JSON       bool   `long:"json" description:"Print the consumer groups as JSON"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c ConsumerGroupsOpts) Execute(_ []string) (err error) {
+	logger := lager.NewLogger("kafka-service-broker")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
+
+	config, err := brokerconfig.LoadConfig(os.Getenv)
+	if err != nil {
+		return err
+	}
+	repo := kafka.NewOffsetRepository(config.KafkaConfiguration.ZookeeperConnector(), kafka.ListOffsets, logger)
+	groups, err := repo.ConsumerGroupOffsets(c.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	if c.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(groups)
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "GROUP\tMEMBERS\tTOPIC\tPARTITION\tOFFSET\tLOG-END OFFSET\tLAG")
+	for _, group := range groups {
+		for _, partition := range group.Partitions {
+			logEndOffset, lag := "-", "-"
+			if partition.LogEndOffset != nil {
+				logEndOffset = fmt.Sprint(*partition.LogEndOffset)
+				lag = fmt.Sprint(*partition.Lag)
+			}
+			fmt.Fprintf(table, "%s\t%d\t%s\t%d\t%d\t%s\t%s\n",
+				group.Group, group.Members, partition.Topic, partition.Partition, partition.Offset, logEndOffset, lag)
+		}
+	}
+	return table.Flush()
+}
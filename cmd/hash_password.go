@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+)
+
+// HashPasswordOpts represents the 'hash-password' command
+type HashPasswordOpts struct {
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c HashPasswordOpts) Execute(_ []string) (err error) {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return errors.New("the password is read from the first line of STDIN")
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("the password cannot be empty")
+	}
+
+	hash, err := broker.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
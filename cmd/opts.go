@@ -8,6 +8,12 @@ type BrokerOpts struct {
 	RunBroker            RunBrokerOpts            `command:"run-broker" alias:"b" alias:"bkr" alias:"broker" description:"Run the service broker web app"`
 	SanityTestTopicPlan  SanityTestTopicPlanOpts  `command:"sanity-test-topic-plan" description:"Consume 'topic' service plan credentials JSON via STDIN and perform sanity tests"`
 	SanityTestSharedPlan SanityTestSharedPlanOpts `command:"sanity-test-shared-plan" description:"Consume 'shared' service plan credentials JSON via STDIN and perform sanity tests"`
+	Conformance          ConformanceOpts          `command:"conformance" description:"Provision, bind, unbind and deprovision every plan of a running broker"`
+	ConsumerGroups       ConsumerGroupsOpts       `command:"consumer-groups" description:"Show the committed offsets and lag of the consumer groups of a service instance"`
+	ResetOffsets         ResetOffsetsOpts         `command:"reset-offsets" description:"Reset the committed offsets of a consumer group of a service instance"`
+	RotateCredentials    RotateCredentialsOpts    `command:"rotate-credentials" description:"Give every binding of a service instance new SCRAM credentials or client certificates"`
+	HashPassword         HashPasswordOpts         `command:"hash-password" description:"Hash a password read via STDIN for the users file of the broker API"`
+	Catalog              CatalogOpts              `command:"catalog" description:"Validate or generate the service catalog"`
 }
 
 // Opts carries all the user provided options (from flags or env vars)
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+)
+
+// ResetOffsetsOpts represents the 'reset-offsets' command
+type ResetOffsetsOpts struct {
+	InstanceID string `long:"instance-id" required:"true" description:"ID of the service instance"`
+	Group      string `long:"group" required:"true" description:"Consumer group to reset"`
+	Topic      string `long:"topic" description:"Topic to reset; by default every topic of the instance the group has committed offsets for"`
+	To         string `long:"to" required:"true" choice:"earliest" choice:"latest" choice:"offset" choice:"timestamp" description:"Position to reset the group to"`
+	Offset     *int64 `long:"offset" description:"Offset to reset every partition to, with --to offset"`
+	Timestamp  string `long:"timestamp" description:"Time to reset every partition to, with --to timestamp: RFC 3339 or milliseconds since the epoch"`
+	Force      bool   `long:"force" description:"Reset the offsets even though the group has active members"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c ResetOffsetsOpts) Execute(_ []string) (err error) {
+	logger := lager.NewLogger("kafka-service-broker")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
+
+	reset := broker.OffsetReset{Group: c.Group, Topic: c.Topic, To: c.To, Offset: c.Offset, Force: c.Force}
+	if c.Timestamp != "" {
+		timestamp, err := parseTimestamp(c.Timestamp)
+		if err != nil {
+			return err
+		}
+		reset.Timestamp = &timestamp
+	}
+
+	config, err := brokerconfig.LoadConfig(os.Getenv)
+	if err != nil {
+		return err
+	}
+	kBroker, err := kafka.NewServiceBroker(config, config.KafkaConfiguration.ZookeeperConnector(), logger)
+	if err != nil {
+		return err
+	}
+	offsets, err := kBroker.ResetOffsets(context.Background(), c.InstanceID, reset, commandActor("reset-offsets"))
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "TOPIC\tPARTITION\tOFFSET")
+	for _, partition := range offsets {
+		fmt.Fprintf(table, "%s\t%d\t%d\n", partition.Topic, partition.Partition, partition.Offset)
+	}
+	return table.Flush()
+}
+
+// parseTimestamp reads a time in RFC 3339 format or in milliseconds since the epoch
+func parseTimestamp(value string) (int64, error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return millis, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("timestamp '%s' is neither RFC 3339 nor milliseconds since the epoch", value)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// commandActor identifies an admin command and the user running it in audit entries
+func commandActor(command string) string {
+	if current, err := user.Current(); err == nil {
+		return fmt.Sprintf("%s command (%s)", command, current.Username)
+	}
+	return command + " command"
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+)
+
+// RotateCredentialsOpts represents the 'rotate-credentials' command
+type RotateCredentialsOpts struct {
+	InstanceID  string `long:"instance-id" required:"true" description:"ID of the service instance"`
+	GracePeriod string `long:"grace-period" description:"How long the replaced credentials stay valid, e.g. '1h' or '0s' to revoke them straight away; defaults to CREDENTIAL_ROTATION_GRACE_PERIOD"`
+}
+
+// Execute is callback from go-flags.Commander interface
+func (c RotateCredentialsOpts) Execute(_ []string) (err error) {
+	logger := lager.NewLogger("kafka-service-broker")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
+
+	config, err := brokerconfig.LoadConfig(os.Getenv)
+	if err != nil {
+		return err
+	}
+	gracePeriod := config.KafkaConfiguration.CredentialGracePeriod
+	if c.GracePeriod != "" {
+		if gracePeriod, err = time.ParseDuration(c.GracePeriod); err != nil {
+			return fmt.Errorf("--grace-period must be a duration such as '1h': %v", err)
+		}
+	}
+
+	kBroker, err := kafka.NewServiceBroker(config, config.KafkaConfiguration.ZookeeperConnector(), logger)
+	if err != nil {
+		return err
+	}
+	rotations, err := kBroker.RotateCredentials(context.Background(), c.InstanceID, gracePeriod, commandActor("rotate-credentials"))
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{"bindings": rotations})
+}
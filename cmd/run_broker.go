@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"code.cloudfoundry.org/lager"
-	"github.com/pivotal-cf/brokerapi"
 
 	"github.com/starkandwayne/kafka-service-broker/broker"
 	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
@@ -24,36 +28,175 @@ func (c RunBrokerOpts) Execute(_ []string) (err error) {
 
 	brokerLogger.Info("Starting Kafka service broker")
 
-	config, err := brokerconfig.LoadConfig()
+	configFile := os.Getenv("BROKER_CONFIG_FILE")
+	getenv, err := configGetenv(configFile)
+	if err != nil {
+		panic(err)
+	}
+	config, err := brokerconfig.LoadConfig(getenv)
 	if err != nil {
 		panic(err)
 	}
 
-	topicRepo := kafka.NewTopicPlanRepository(config.KafkaConfiguration, brokerLogger)
-	sharedPlanRepo := kafka.NewSharedPlanRepository(config.KafkaConfiguration, brokerLogger)
+	connector := config.KafkaConfiguration.ZookeeperConnector()
+	serviceBroker, err := kafka.NewServiceBroker(config, connector, brokerLogger)
+	if err != nil {
+		panic(err)
+	}
 
-	serviceBroker := &broker.KafkaServiceBroker{
-		InstanceCreators: map[string]broker.InstanceCreator{
-			"topic":  topicRepo,
-			"shared": sharedPlanRepo,
-		},
-		InstanceBinders: map[string]broker.InstanceBinder{
-			"topic":  topicRepo,
-			"shared": sharedPlanRepo,
-		},
-		Config: config,
+	// the watchers are background jobs, which finish what they are doing and close their
+	// ZooKeeper sessions when stopWatchers is closed on shutdown
+	stopWatchers := make(chan struct{})
+	watchers := &sync.WaitGroup{}
+	runWatcher := func(run func()) {
+		watchers.Add(1)
+		go func() {
+			defer watchers.Done()
+			run()
+		}()
+	}
+	topicLimitWatcher := kafka.NewTopicLimitWatcher(connector, brokerLogger.Session("topic-limits"))
+	runWatcher(func() { topicLimitWatcher.Run(config.KafkaConfiguration.TopicLimitCheckInterval, stopWatchers) })
+	// the credential rotation watcher also keeps the CRL of client certificates from going stale
+	if config.KafkaConfiguration.SASLEnabled() || config.KafkaConfiguration.ClientCertificates.Enabled() {
+		credentialRotation := kafka.NewCredentialRotationRepository(config.KafkaConfiguration, connector, serviceBroker.SecretStore, brokerLogger.Session("credential-rotation"))
+		runWatcher(func() { credentialRotation.Run(time.Minute, stopWatchers) })
 	}
 
-	brokerCredentials := brokerapi.BrokerCredentials{
-		Username: config.Broker.Username,
-		Password: config.Broker.Password,
+	// BROKER_USERNAME and BROKER_PASSWORD are the platform's, unless every user is in the users file
+	staticUsers := []broker.APIUser{}
+	if config.Broker.Username != "" || config.Broker.UsersFile == "" {
+		user, err := broker.StaticAPIUser(config.Broker.Username, config.Broker.Password, broker.RolePlatform)
+		if err != nil {
+			panic(err)
+		}
+		staticUsers = append(staticUsers, user)
+	}
+	users, err := broker.NewAPIUsers(config.Broker.UsersFile, staticUsers, brokerLogger.Session("users"))
+	if err != nil {
+		panic(err)
 	}
+	runWatcher(func() { users.Run(config.Broker.UsersReloadInterval, stopWatchers) })
 
-	brokerAPI := brokerapi.New(serviceBroker, brokerLogger, brokerCredentials)
+	// the broker API is built again from the config file and the catalog when they change or on SIGHUP;
+	// the listener, its TLS files, the users and the watchers above keep their startup configuration
+	configs, err := broker.NewConfigReloader(func() (http.Handler, error) {
+		return buildBrokerAPI(configFile, users, brokerLogger)
+	}, func() []string {
+		return []string{configFile, catalogFile(configFile)}
+	}, brokerLogger.Session("config"))
+	if err != nil {
+		panic(err)
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	runWatcher(func() { configs.Run(config.Broker.ConfigReloadInterval, hangup, stopWatchers) })
 
-	brokerLogger.Info("listening :" + config.Broker.ListenPort)
-	http.Handle("/", brokerAPI)
-	brokerLogger.Fatal("http-listen", http.ListenAndServe("0.0.0.0:"+config.Broker.ListenPort, nil))
+	// the server has its own handler, rather than http.DefaultServeMux, on which expvar publishes
+	// /debug/vars without authentication; the broker API serves it to authenticated users
+	inFlight := broker.NewInFlightRequests(configs)
+	server := &http.Server{Addr: "0.0.0.0:" + config.Broker.ListenPort, Handler: inFlight}
+	serveErrors := make(chan error, 1)
+	if !config.Broker.TLS.Enabled() {
+		brokerLogger.Info("listening :" + config.Broker.ListenPort)
+		go func() { serveErrors <- server.ListenAndServe() }()
+	} else {
+		certificates, err := broker.NewCertificateReloader(config.Broker.TLS, brokerLogger.Session("tls"))
+		if err != nil {
+			panic(err)
+		}
+		runWatcher(func() { certificates.Run(config.Broker.TLS.ReloadInterval, stopWatchers) })
+		server.TLSConfig = certificates.TLSConfig()
+		brokerLogger.Info("listening with TLS :"+config.Broker.ListenPort, lager.Data{
+			"client_certificates": config.Broker.TLS.ClientCAFile != "",
+		})
+		go func() { serveErrors <- server.ListenAndServeTLS("", "") }()
+	}
 
-	return
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err = <-serveErrors:
+		brokerLogger.Fatal("listen", err)
+	case received := <-terminate:
+		brokerLogger.Info("shutdown", lager.Data{
+			"signal":  received.String(),
+			"timeout": config.Broker.ShutdownTimeout.String(),
+			"message": "Stopped accepting requests; waiting for in-flight requests and background jobs",
+		})
+	}
+	shutdown(server, inFlight, stopWatchers, watchers, config.Broker.ShutdownTimeout, brokerLogger)
+	return nil
+}
+
+// shutdown stops the server accepting requests, and waits until the timeout for the requests it
+// is serving to finish, and then for the watchers to stop. Requests cut off by the timeout are logged,
+// with the service instances they were for.
+func shutdown(server *http.Server, inFlight *broker.InFlightRequests, stopWatchers chan struct{}, watchers *sync.WaitGroup, timeout time.Duration, logger lager.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("shutdown", err, lager.Data{
+			"requests":  inFlight.Requests(),
+			"instances": inFlight.Instances(),
+			"message":   "Timed out waiting for in-flight requests; they are cut off, and the platform should retry or clean up these instances",
+		})
+		_ = server.Close()
+	}
+
+	close(stopWatchers)
+	stopped := make(chan struct{})
+	go func() {
+		watchers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		logger.Info("shutdown", lager.Data{"message": "Shut down cleanly"})
+	case <-ctx.Done():
+		logger.Error("shutdown", ctx.Err(), lager.Data{
+			"message": "Timed out waiting for background jobs to stop",
+		})
+	}
+}
+
+// configGetenv returns the Getenv of the broker's configuration: the environment, overridden by
+// the config file if there is one
+func configGetenv(configFile string) (brokerconfig.Getenv, error) {
+	if configFile == "" {
+		return os.Getenv, nil
+	}
+	return brokerconfig.ConfigFileGetenv(configFile)
+}
+
+// buildBrokerAPI loads the configuration and the catalog, and creates the broker API serving them.
+// Neither changes the environment, so that the previous configuration stays in use if either is invalid.
+func buildBrokerAPI(configFile string, users *broker.APIUsers, logger lager.Logger) (http.Handler, error) {
+	getenv, err := configGetenv(configFile)
+	if err != nil {
+		return nil, err
+	}
+	config, err := brokerconfig.LoadConfig(getenv)
+	if err != nil {
+		return nil, err
+	}
+	serviceBroker, err := kafka.NewServiceBroker(config, config.KafkaConfiguration.ZookeeperConnector(), logger)
+	if err != nil {
+		return nil, err
+	}
+	return broker.NewAPIWithUsers(serviceBroker, logger, users), nil
+}
+
+// catalogFile returns the catalog file of BROKER_CATALOG_JSON, if it names one rather than holding the JSON
+func catalogFile(configFile string) string {
+	getenv, err := configGetenv(configFile)
+	if err != nil {
+		return ""
+	}
+	catalog := getenv("BROKER_CATALOG_JSON")
+	if _, err := os.Stat(catalog); catalog == "" || err != nil {
+		return ""
+	}
+	return catalog
 }
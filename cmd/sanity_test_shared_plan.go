@@ -6,7 +6,9 @@ import (
 	"os"
 
 	"github.com/hashicorp/errwrap"
-	"github.com/wvanbergen/kazoo-go"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
 )
 
 // SanityTestSharedPlanOpts represents the 'sanity-test-topic-plan' command
@@ -16,35 +18,29 @@ type SanityTestSharedPlanOpts struct {
 // Execute is callback from go-flags.Commander interface
 func (c SanityTestSharedPlanOpts) Execute(_ []string) (err error) {
 	decoder := json.NewDecoder(os.Stdin)
-	// creds := Credentials{}
-	creds := make(map[string]string)
+	// credentials may hold objects, e.g. retry_topics, topics, librdkafka or spring, besides strings
+	creds := make(map[string]interface{})
 	err = decoder.Decode(&creds)
 	if err != nil {
 		return errwrap.Wrapf("Failed to unmarshal credentials: {{err}}", err)
 	}
 	fmt.Printf("Loaded credentials: %#v\n", creds)
 
-	var topicNamePrefix = creds["topicNamePrefix"]
-	var zkPeers = creds["zkPeers"]
-	var hostname = creds["hostname"]
+	topicNamePrefix, _ := creds["topicNamePrefix"].(string)
+	hostname, _ := creds["hostname"].(string)
 
 	if topicNamePrefix == "" {
 		return fmt.Errorf("'topicNamePrefix' was not provided")
 	}
-	if zkPeers == "" {
-		return fmt.Errorf("'zkPeers' was not provided")
-	}
 	if hostname == "" {
 		return fmt.Errorf("'hostname' was not provided")
 	}
 
-	zkConf := kazoo.NewConfig()
-	kz, err := kazoo.NewKazooFromConnectionString(zkPeers, zkConf)
-	if err != nil {
-		return errwrap.Wrapf("Could not connect to Kafka: {{err}}", err)
+	// The topic is looked up with the bootstrap servers alone, as bindings need not have zkPeers
+	if protocol, _ := creds["securityProtocol"].(string); protocol != "" && protocol != brokerconfig.SecurityProtocolPlaintext {
+		return fmt.Errorf("Only PLAINTEXT listeners can be tested, not %s", protocol)
 	}
-	defer func() { _ = kz.Close() }()
-	exists, err := kz.Topic(topicNamePrefix).Exists()
+	exists, err := kafka.TopicExists(hostname, topicNamePrefix)
 	if err != nil {
 		return errwrap.Wrapf(fmt.Sprintf("Expected that service plan internally provisions topic %s, but could not be looked up: {{err}}", topicNamePrefix), err)
 	}
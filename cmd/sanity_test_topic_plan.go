@@ -6,7 +6,9 @@ import (
 	"os"
 
 	"github.com/hashicorp/errwrap"
-	"github.com/wvanbergen/kazoo-go"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
 )
 
 // SanityTestTopicPlanOpts represents the 'sanity-test-topic-plan' command
@@ -16,35 +18,29 @@ type SanityTestTopicPlanOpts struct {
 // Execute is callback from go-flags.Commander interface
 func (c SanityTestTopicPlanOpts) Execute(_ []string) (err error) {
 	decoder := json.NewDecoder(os.Stdin)
-	// creds := Credentials{}
-	creds := make(map[string]string)
+	// credentials may hold objects, e.g. retry_topics, topics, librdkafka or spring, besides strings
+	creds := make(map[string]interface{})
 	err = decoder.Decode(&creds)
 	if err != nil {
 		return errwrap.Wrapf("Failed to unmarshal credentials: {{err}}", err)
 	}
 	fmt.Printf("Loaded credentials: %#v\n", creds)
 
-	var topicName = creds["topicName"]
-	var zkPeers = creds["zkPeers"]
-	var hostname = creds["hostname"]
+	topicName, _ := creds["topicName"].(string)
+	hostname, _ := creds["hostname"].(string)
 
 	if topicName == "" {
 		return fmt.Errorf("'topicName' was not provided")
 	}
-	if zkPeers == "" {
-		return fmt.Errorf("'zkPeers' was not provided")
-	}
 	if hostname == "" {
 		return fmt.Errorf("'hostname' was not provided")
 	}
 
-	zkConf := kazoo.NewConfig()
-	kz, err := kazoo.NewKazooFromConnectionString(zkPeers, zkConf)
-	if err != nil {
-		return errwrap.Wrapf("Could not connect to Kafka: {{err}}", err)
+	// The topic is looked up with the bootstrap servers alone, as bindings need not have zkPeers
+	if protocol, _ := creds["securityProtocol"].(string); protocol != "" && protocol != brokerconfig.SecurityProtocolPlaintext {
+		return fmt.Errorf("Only PLAINTEXT listeners can be tested, not %s", protocol)
 	}
-	defer func() { _ = kz.Close() }()
-	exists, err := kz.Topic(topicName).Exists()
+	exists, err := kafka.TopicExists(hostname, topicName)
 	if err != nil {
 		return errwrap.Wrapf(fmt.Sprintf("Topic %s could not be looked up: {{err}}", topicName), err)
 	}
@@ -0,0 +1,226 @@
+// Package conformance drives the full Open Service Broker API lifecycle
+// against a running broker, for every plan in its catalog.
+package conformance
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// APIVersion is the X-Broker-API-Version sent with each request
+const APIVersion = "2.13"
+
+// Target describes the broker under test
+type Target struct {
+	URL      string
+	Username string
+	Password string
+}
+
+// Run provisions, binds, unbinds and deprovisions an instance of every plan
+// in the broker's catalog, checking the shape of each set of credentials.
+// Progress is written to out. The first failed expectation is returned.
+func Run(target Target, out io.Writer) error {
+	client := &client{target: target}
+
+	fmt.Fprintln(out, "Catalog")
+	var catalog brokerapi.CatalogResponse
+	if err := client.do("GET", "/v2/catalog", nil, http.StatusOK, &catalog); err != nil {
+		return err
+	}
+	if len(catalog.Services) == 0 {
+		return fmt.Errorf("catalog has no services")
+	}
+
+	for _, service := range catalog.Services {
+		if len(service.Plans) == 0 {
+			return fmt.Errorf("service '%s' has no plans", service.Name)
+		}
+		for _, plan := range service.Plans {
+			if err := runPlan(client, service, plan, out); err != nil {
+				return fmt.Errorf("service '%s' plan '%s': %v", service.Name, plan.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func runPlan(client *client, service brokerapi.Service, plan brokerapi.ServicePlan, out io.Writer) error {
+	instanceID, err := newGUID()
+	if err != nil {
+		return err
+	}
+	bindingID, err := newGUID()
+	if err != nil {
+		return err
+	}
+	instancePath := "/v2/service_instances/" + instanceID
+	bindingPath := instancePath + "/service_bindings/" + bindingID
+	query := "?" + url.Values{"service_id": {service.ID}, "plan_id": {plan.ID}}.Encode()
+
+	fmt.Fprintf(out, "Provision '%s' plan (instance %s)\n", plan.Name, instanceID)
+	provision := brokerapi.ProvisionDetails{
+		ServiceID:        service.ID,
+		PlanID:           plan.ID,
+		OrganizationGUID: "conformance-org",
+		SpaceGUID:        "conformance-space",
+	}
+	if err = client.do("PUT", instancePath, provision, http.StatusCreated, nil); err != nil {
+		return err
+	}
+	// an identical retry succeeds, while a different request for the same instance ID conflicts
+	if err = client.do("PUT", instancePath, provision, http.StatusOK, nil); err != nil {
+		return err
+	}
+	conflicting := provision
+	conflicting.SpaceGUID = "other-space"
+	if err = client.do("PUT", instancePath, conflicting, http.StatusConflict, nil); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Bind '%s' plan (binding %s)\n", plan.Name, bindingID)
+	var binding struct {
+		Credentials map[string]interface{} `json:"credentials"`
+	}
+	bind := brokerapi.BindDetails{
+		ServiceID: service.ID,
+		PlanID:    plan.ID,
+		AppGUID:   "conformance-app",
+	}
+	if err = client.do("PUT", bindingPath, bind, http.StatusCreated, &binding); err != nil {
+		return err
+	}
+	if err = CheckCredentials(instanceID, binding.Credentials); err != nil {
+		return err
+	}
+	// an identical retry is given the same credentials, which the broker keeps in its secret store
+	first := binding.Credentials
+	binding.Credentials = nil
+	if err = client.do("PUT", bindingPath, bind, http.StatusOK, &binding); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(binding.Credentials, first) {
+		return fmt.Errorf("PUT %s: an identical retry was given other credentials than the binding", bindingPath)
+	}
+	conflictingBind := bind
+	conflictingBind.AppGUID = "other-app"
+	if err = client.do("PUT", bindingPath, conflictingBind, http.StatusConflict, nil); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Unbind '%s' plan\n", plan.Name)
+	if err = client.do("DELETE", bindingPath+query, nil, http.StatusOK, nil); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Deprovision '%s' plan\n", plan.Name)
+	return client.do("DELETE", instancePath+query, nil, http.StatusOK, nil)
+}
+
+// CheckCredentials verifies the binding credentials returned for a service instance.
+// Every binding must describe the Kafka brokers via "hostname" and "uri", and
+// name either the instance's topic ("topicName"), its topic prefix ("topicNamePrefix")
+// or its set of topics ("topics").
+func CheckCredentials(instanceID string, credentials map[string]interface{}) error {
+	hostname, _ := credentials["hostname"].(string)
+	uri, _ := credentials["uri"].(string)
+	topicName, _ := credentials["topicName"].(string)
+	topicNamePrefix, _ := credentials["topicNamePrefix"].(string)
+	topics, hasTopics := credentials["topics"].(map[string]interface{})
+
+	if hostname == "" {
+		return fmt.Errorf("'hostname' was not provided")
+	}
+	switch {
+	case topicName != "":
+		if topicName != instanceID {
+			return fmt.Errorf("expected topicName '%s' to equal service instance ID '%s'", topicName, instanceID)
+		}
+		if expected := fmt.Sprintf("kafka://%s/%s", hostname, topicName); uri != expected {
+			return fmt.Errorf("expected uri '%s' to equal '%s'", uri, expected)
+		}
+	case topicNamePrefix != "":
+		if topicNamePrefix != instanceID {
+			return fmt.Errorf("expected topicNamePrefix '%s' to equal service instance ID '%s'", topicNamePrefix, instanceID)
+		}
+		if expected := fmt.Sprintf("kafka://%s", hostname); uri != expected {
+			return fmt.Errorf("expected uri '%s' to equal '%s'", uri, expected)
+		}
+	case hasTopics:
+		for name, topic := range topics {
+			if topic, _ := topic.(string); !strings.HasPrefix(topic, instanceID+".") {
+				return fmt.Errorf("expected topic '%s' to be named '%s.%s'", name, instanceID, name)
+			}
+		}
+		if expected := fmt.Sprintf("kafka://%s", hostname); uri != expected {
+			return fmt.Errorf("expected uri '%s' to equal '%s'", uri, expected)
+		}
+	default:
+		return fmt.Errorf("none of 'topicName', 'topicNamePrefix' or 'topics' was provided")
+	}
+	return nil
+}
+
+type client struct {
+	target Target
+}
+
+// do sends an OSB API request and decodes the JSON response into result, if not nil
+func (c *client) do(method, path string, body interface{}, expectedStatus int, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.target.URL, "/")+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.target.Username, c.target.Password)
+	req.Header.Set("X-Broker-API-Version", APIVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != expectedStatus {
+		return fmt.Errorf("%s %s: expected status %d, got %d: %s", method, path, expectedStatus, resp.StatusCode, respBody)
+	}
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("%s %s: could not decode response: %v", method, path, err)
+		}
+	}
+	return nil
+}
+
+func newGUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
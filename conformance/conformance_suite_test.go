@@ -0,0 +1,31 @@
+package conformance_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestConformance(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Conformance Suite")
+}
+
+// brokerEnvironmentPrefixes are the prefixes of the environment variables the broker is configured with
+var brokerEnvironmentPrefixes = []string{"BROKER_", "KAFKA_", "ZOOKEEPER_", "SECRET_STORE", "CREDHUB_", "CREDENTIAL_ROTATION_", "TOPIC_LIMIT_"}
+
+// unsetBrokerEnvironment unsets the broker's configuration, leaving the rest of the environment,
+// e.g. PATH and HOME, to the tests that follow
+func unsetBrokerEnvironment() {
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		for _, prefix := range brokerEnvironmentPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				_ = os.Unsetenv(name)
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package conformance_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/conformance"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("Conformance", func() {
+	var store *zookeeper.MemoryStore
+	var server *httptest.Server
+	var target conformance.Target
+	var serviceBroker *broker.KafkaServiceBroker
+	var secretsDir string
+
+	BeforeEach(func() {
+		var err error
+		secretsDir, err = ioutil.TempDir("", "conformance")
+		Expect(err).NotTo(HaveOccurred())
+
+		unsetBrokerEnvironment()
+		store = zookeeper.NewMemoryStore()
+		for id := int32(0); id < 3; id++ {
+			Expect(store.RegisterBroker(id, "localhost", 9092+int(id))).To(Succeed())
+		}
+		store.StartController()
+
+		config := brokerconfig.Config{
+			KafkaConfiguration: brokerconfig.KafkaConfiguration{
+				ZookeeperPeers:         "localhost:2181",
+				KafkaHostnames:         "localhost:9092,localhost:9093,localhost:9094",
+				KafkaPartitionCount:    2,
+				KafkaReplicationFactor: 3,
+				QuotaEntityType:        "clients",
+			},
+			SecretStore: brokerconfig.SecretStoreConfiguration{
+				Type:       brokerconfig.SecretStoreFile,
+				PathPrefix: "/c/kafka-service-broker",
+				File: brokerconfig.FileSecretStoreConfiguration{
+					Path: filepath.Join(secretsDir, "secrets"),
+					Key:  bytes.Repeat([]byte{7}, 32),
+				},
+			},
+		}
+		logger := lager.NewLogger("conformance")
+		serviceBroker, err = kafka.NewServiceBroker(config, store.Connect, logger)
+		Expect(err).NotTo(HaveOccurred())
+
+		credentials := brokerapi.BrokerCredentials{Username: "broker", Password: "password"}
+		api, err := broker.NewAPI(serviceBroker, logger, credentials)
+		Expect(err).NotTo(HaveOccurred())
+		server = httptest.NewServer(api)
+		target = conformance.Target{URL: server.URL, Username: "broker", Password: "password"}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		store.StopController()
+		Expect(os.RemoveAll(secretsDir)).To(Succeed())
+	})
+
+	It("implements every plan of the default catalog, which is valid", func() {
+		catalogJSON, err := broker.CatalogJSON(os.Getenv)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(broker.ValidateCatalog(catalogJSON, os.Getenv, kafka.Plans())).To(BeEmpty())
+		for _, plan := range kafka.Plans() {
+			Expect(serviceBroker.InstanceCreators).To(HaveKey(plan))
+			Expect(serviceBroker.InstanceBinders).To(HaveKey(plan))
+		}
+	})
+
+	It("passes the lifecycle of every plan against the in-process broker", func() {
+		Expect(conformance.Run(target, GinkgoWriter)).To(Succeed())
+	})
+
+	It("cleans up every topic it created", func() {
+		Expect(conformance.Run(target, GinkgoWriter)).To(Succeed())
+
+		conn, err := store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() ([]string, error) { return conn.Children("/brokers/topics") }).Should(BeEmpty())
+	})
+
+	It("fails when the broker rejects the credentials", func() {
+		target.Password = "wrong"
+		Expect(conformance.Run(target, GinkgoWriter)).To(MatchError(ContainSubstring("expected status 200, got 401")))
+	})
+
+	Describe("CheckCredentials", func() {
+		const instanceID = "instanceID"
+		const hostname = "localhost:9092"
+
+		It("accepts topic plan credentials", func() {
+			Expect(conformance.CheckCredentials(instanceID, map[string]interface{}{
+				"hostname":  hostname,
+				"topicName": instanceID,
+				"uri":       fmt.Sprintf("kafka://%s/%s", hostname, instanceID),
+			})).To(Succeed())
+		})
+
+		It("accepts shared plan credentials", func() {
+			Expect(conformance.CheckCredentials(instanceID, map[string]interface{}{
+				"hostname":        hostname,
+				"topicNamePrefix": instanceID,
+				"uri":             fmt.Sprintf("kafka://%s", hostname),
+			})).To(Succeed())
+		})
+
+		It("accepts multi-topic plan credentials", func() {
+			Expect(conformance.CheckCredentials(instanceID, map[string]interface{}{
+				"hostname": hostname,
+				"topics":   map[string]interface{}{"orders": instanceID + ".orders"},
+				"uri":      fmt.Sprintf("kafka://%s", hostname),
+			})).To(Succeed())
+		})
+
+		It("rejects a topic that is not named after the instance ID", func() {
+			Expect(conformance.CheckCredentials(instanceID, map[string]interface{}{
+				"hostname": hostname,
+				"topics":   map[string]interface{}{"orders": "orders"},
+				"uri":      fmt.Sprintf("kafka://%s", hostname),
+			})).To(MatchError("expected topic 'orders' to be named 'instanceID.orders'"))
+		})
+
+		It("rejects a topicName that is not the instance ID", func() {
+			Expect(conformance.CheckCredentials(instanceID, map[string]interface{}{
+				"hostname":  hostname,
+				"topicName": "other",
+				"uri":       fmt.Sprintf("kafka://%s/other", hostname),
+			})).To(MatchError(ContainSubstring("expected topicName 'other'")))
+		})
+
+		It("rejects a uri that does not point at the topic", func() {
+			Expect(conformance.CheckCredentials(instanceID, map[string]interface{}{
+				"hostname":  hostname,
+				"topicName": instanceID,
+				"uri":       fmt.Sprintf("kafka://%s", hostname),
+			})).To(MatchError(ContainSubstring("expected uri")))
+		})
+
+		It("rejects credentials without a topic", func() {
+			Expect(conformance.CheckCredentials(instanceID, map[string]interface{}{
+				"hostname": hostname,
+				"uri":      fmt.Sprintf("kafka://%s", hostname),
+			})).To(MatchError("none of 'topicName', 'topicNamePrefix' or 'topics' was provided"))
+		})
+	})
+})
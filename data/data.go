@@ -68,7 +68,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _assetsCatalogJson = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xbc\x93\x4f\x8f\xd3\x30\x10\xc5\xef\xf9\x14\x23\x4b\xe5\x44\x6a\x37\xd9\x6d\x9a\xdc\x60\xf7\x82\x90\x96\xc3\x82\x38\xa0\x3d\x4c\xec\x49\x6a\x35\xb5\x2d\xdb\x6d\x55\x55\xfd\xee\x28\x29\xfd\x13\x76\x81\x1b\x97\x48\x9e\x3c\xdb\xef\xf7\xf2\x72\x48\x00\x58\x20\xbf\xd5\x92\x02\xab\x7e\x24\x00\x00\x87\xe1\x09\xc0\xb4\x62\x15\xbb\xc3\x52\xe5\x34\xc3\x34\x9f\x0b\x4c\x67\x33\x2a\xd2\xfa\xfe\xae\x48\xb3\x7c\x2e\xa5\x14\x6a\xde\x60\xcd\xde\x9f\xb7\x18\x5c\x13\xab\x58\x88\xe8\x57\x68\xd4\x0e\xf7\x86\xd2\x15\x36\x2b\xbc\x6a\x14\x05\xe9\xb5\x8b\xda\x1a\x56\xb1\x0f\x0e\xe5\x92\xe0\xf3\x58\x53\x6b\xa3\xb0\xee\x88\x55\x10\xfd\x86\x2e\xf3\x88\xed\xc5\xe7\x30\x38\x9d\xfd\x6b\xfd\x72\xd1\xad\x29\xa2\xc2\x88\xac\x3a\x5c\xb5\x4a\x07\xd7\xe1\xfe\xe9\xe4\xf1\xb9\xf7\x08\xef\xe0\x7b\xef\xf1\xb7\xfb\x7b\xf8\x35\xb6\xf4\xcd\x77\xac\x62\xcb\x18\x5d\xa8\x38\x0f\x5b\x33\xc5\xc1\xee\xd4\xfa\x96\x7b\x72\x36\x70\x0c\x0d\x1f\x4c\xf0\xa0\x23\xf1\xce\xb6\x36\x70\xeb\x75\xab\x0d\x76\x81\x3b\xd3\xf2\x4f\x0f\x5f\x9e\x26\x99\x48\x27\x99\xf8\xd8\xa1\x5c\x4d\x32\x61\xcd\x24\x13\x5f\x3d\x9a\xe0\xd0\x93\x89\x53\x67\xda\xdb\xdb\x3b\x6b\xda\xc7\x7f\xe7\x04\xc0\x9c\xb7\x5b\xad\xc8\x3f\xfe\x19\xee\x56\xae\xac\xdc\xac\xc9\x44\xec\x8f\x1d\xe3\xed\x76\xbb\xa9\xd2\xa6\xb5\x51\x77\x14\xa6\xd2\xae\xf9\xb0\x4c\x47\x19\x1f\x2f\x19\xbb\x0e\xcd\xe8\x63\x5c\xa3\xbe\x94\x67\x91\x09\x95\xe5\xf2\xa6\x3c\x65\x5f\x1e\x55\x2c\x8a\x42\x60\x9e\xcb\xfb\xfa\xc6\xdd\xb5\x40\xd1\x3a\x2d\xc7\x6f\xc6\xb5\x79\x5e\xa2\x27\x40\x08\xda\xb4\x1d\xc1\xa0\x07\x6b\x20\xf4\x73\xf5\x2a\xa6\x51\x25\x46\x46\x01\x98\xb4\x21\xb2\x0a\xc4\xcd\xf4\x38\xda\xda\x78\x3a\x37\x31\x79\x43\xf1\x9a\x5b\x64\x8d\x2a\x33\xb9\x48\x65\x59\x16\x27\xee\x85\x14\x59\x5a\x17\x72\x21\x55\x39\xab\x9b\xd9\xdd\x9b\xdc\x27\xfb\x7f\x03\x7f\xf0\x84\x91\x60\x6f\x37\x1e\xec\xce\x9c\xc8\xc3\xff\x45\x3f\xff\x6e\xc9\x79\xf5\x92\x1c\x93\x9f\x01\x00\x00\xff\xff\x84\x1d\xbf\xd5\x4c\x04\x00\x00")
+var _assetsCatalogJson = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xcd\x54\xcb\x6e\xdb\x30\x10\xbc\xfb\x2b\x08\x01\xed\x29\xb4\xa8\x87\xf5\xf0\xad\x4d\x2e\x45\x81\xf4\xe0\x16\x3d\x14\x39\xac\x96\x94\x4c\x58\x26\x05\x92\x76\x60\x18\xfe\xf7\x52\x7e\x2b\x71\x83\xa2\x87\x22\x07\x51\xe0\x72\x96\x9c\x19\x8e\xb4\x1d\x11\x12\x58\x61\xd6\x12\x85\x0d\xa6\xbf\xfc\x94\x90\xed\x7e\xf4\x0b\x92\x07\xd3\x20\x85\x92\x27\x22\x02\x9a\x64\x0c\x68\x14\x89\x9c\x56\x93\x34\xa7\x71\x92\x21\x22\xe3\x59\x0d\x55\x70\x77\x6a\x51\xb0\x14\xbe\xc9\x3a\x30\x0b\x50\xfc\x19\x36\x4a\xd0\x05\xd4\x0b\xb8\x60\xb8\xb0\x68\x64\xe7\xa4\x56\x1e\xfa\xa9\x03\x9c\x0b\xf2\x75\x88\xa9\xa4\xe2\x50\xb5\x7e\x2f\xe2\xcc\x4a\x9c\xeb\x0e\x9a\x33\xcf\x7d\xe1\xb0\xf7\x71\xfe\x74\xc6\x2d\x85\x03\x0e\x0e\x82\xe9\xf6\x82\xe5\xd2\x76\x2d\x6c\x1e\x0f\x1c\x67\x3d\x47\xf2\x91\xfc\xec\x39\x92\xc5\xcc\x19\x01\x4b\x4b\x6a\x6d\xc8\x4d\x4e\xbd\x21\x4b\x68\xc4\x0f\xd3\xfa\xee\xb9\x73\x9d\x9d\x86\xa1\x5d\xab\x31\xec\xe1\x63\x6d\x9a\xd0\x88\x4e\xdb\x10\x6c\x1d\xee\x89\x85\x56\x3a\x11\xb6\xba\xf1\x45\x6d\x64\x23\x15\xb4\x36\xec\x54\x13\x7e\xb9\xff\xf6\xf8\x21\x66\xd4\x3f\x9f\x5b\xc0\x85\x7f\x6b\xe5\x87\xef\x06\x94\xed\xc0\x08\xe5\xc6\x1e\x77\x7d\x7a\xab\x55\xf3\x30\xf0\xee\x1f\x24\x74\x46\xaf\x25\x17\xe6\xe1\xcf\x5e\x5c\xc3\xed\xdc\x53\xb9\xba\x88\xe3\xca\xee\x6c\xb4\xdf\x44\x0d\x6e\xe4\xe2\xf7\x39\x41\x45\xcc\x78\x9c\xe0\x55\x82\xca\x3e\x41\x3c\x2f\xf2\x9c\x41\x92\xe0\xa4\xba\x3a\xf3\x92\x22\xa7\x3b\x89\xc3\x95\x61\x76\x66\x3d\x3b\x02\xc4\x4a\xd5\xb4\x82\xec\xf1\x44\x2b\xb2\x67\xcd\x5f\x89\x1f\xe4\x62\x40\xd4\xaf\xa0\xb6\xce\x57\xd9\x55\x75\x37\x68\xad\x8d\x78\xe1\xc2\x00\xf1\x5a\x37\x8b\x6b\x5e\xc6\x58\x50\x2c\xcb\xfc\xa0\xbb\x40\x16\xd3\x2a\xc7\x02\x79\x19\x55\x75\x94\xde\xd4\x7d\xa0\xff\x96\xf0\x7b\x7f\x29\x4e\x90\x8d\x5e\x19\xa2\x9f\xd5\x41\xb9\x7d\x3f\xd2\x0b\x48\x51\x60\x0a\x94\x45\x98\xd1\x34\xc7\x88\x42\xe6\x4d\x10\x09\x8b\x93\x9c\x4f\x32\xcc\x8a\x9b\xd2\x51\x2f\x7d\x70\xdd\xdb\xea\x5f\x5c\xbb\xff\xbc\xc8\xb9\xef\xdd\x85\x00\x6b\xc6\x93\x78\x42\x91\x45\x39\x4d\x0b\x60\xb4\x88\x0b\xa4\x19\x2f\x79\x6f\x0e\x4e\x92\xdb\xe1\x5f\xae\x5a\x27\xe9\xdf\x7f\x02\xc2\x11\x5d\x1f\x83\x70\x47\x5a\x69\x7b\x33\xa4\x22\xfe\x5f\xe2\x37\x74\xc2\xf8\xea\x7f\x75\xe5\xf4\x4f\x1e\x9d\x66\x4f\xa3\xdd\xe8\x37\xf4\xe0\x97\x70\x71\x06\x00\x00")
 
 func assetsCatalogJsonBytes() ([]byte, error) {
 	return bindataRead(
@@ -83,7 +83,7 @@ func assetsCatalogJson() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "assets/catalog.json", size: 1100, mode: os.FileMode(420), modTime: time.Unix(1510703633, 0)}
+	info := bindataFileInfo{name: "assets/catalog.json", size: 1649, mode: os.FileMode(420), modTime: time.Unix(1792391085, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
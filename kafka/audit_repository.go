@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"encoding/json"
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// auditRoot is where the broker records its audit log in ZooKeeper, one sequential znode per entry
+const auditRoot = zookeeper.PrivateRoot + "/audit"
+
+// AuditRepository records audit entries in ZooKeeper, and logs them
+type AuditRepository struct {
+	repository
+}
+
+// NewAuditRepository creates an AuditRepository
+func NewAuditRepository(connect zookeeper.Connector, logger lager.Logger) *AuditRepository {
+	return &AuditRepository{
+		repository: repository{connect: connect, logger: logger},
+	}
+}
+
+// Record appends an entry to the audit log
+func (repo *AuditRepository) Record(entry broker.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	node, err := conn.CreateSequential(auditRoot+"/entry-", data)
+	if err != nil {
+		repo.logger.Error("audit", err, lager.Data{"entry": entry})
+		return err
+	}
+	repo.logger.Info("audit", lager.Data{"entry": entry, "node": node})
+	return nil
+}
+
+// Entries returns the audit entries of a service instance, oldest first
+func (repo *AuditRepository) Entries(instanceID string) ([]broker.AuditEntry, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	nodes, err := conn.Children(auditRoot)
+	if err == zookeeper.ErrNoNode {
+		return []broker.AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// sequential znodes sort in creation order as their counters are zero padded
+	sort.Strings(nodes)
+	entries := []broker.AuditEntry{}
+	for _, node := range nodes {
+		entry := broker.AuditEntry{}
+		if err = readRecord(conn, auditRoot+"/"+node, &entry); err != nil {
+			return nil, err
+		}
+		if entry.InstanceID == instanceID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
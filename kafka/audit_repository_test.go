@@ -0,0 +1,35 @@
+package kafka_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+)
+
+var _ = Describe("AuditRepository", func() {
+	var repo *kafka.AuditRepository
+
+	BeforeEach(func() {
+		repo = kafka.NewAuditRepository(newMemoryStore(1).Connect, lager.NewLogger("test"))
+	})
+
+	It("records the entries of each instance in order", func() {
+		at := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+		first := broker.AuditEntry{Time: at, Action: "reset-offsets", InstanceID: "instanceID", Actor: "admin", Details: map[string]interface{}{"group": "instanceID"}}
+		second := broker.AuditEntry{Time: at.Add(time.Minute), Action: "reset-offsets", InstanceID: "instanceID", Actor: "admin"}
+		Expect(repo.Record(first)).To(Succeed())
+		Expect(repo.Record(broker.AuditEntry{Time: at, Action: "reset-offsets", InstanceID: "other"})).To(Succeed())
+		Expect(repo.Record(second)).To(Succeed())
+
+		Expect(repo.Entries("instanceID")).To(Equal([]broker.AuditEntry{first, second}))
+	})
+
+	It("returns no entries when nothing was recorded", func() {
+		Expect(repo.Entries("instanceID")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,352 @@
+package kafka
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// CertificateRepository issues each binding a client certificate for its principal,
+// User:CN=<binding ID>, signed by the configured CA. The serial number of the certificate is
+// recorded with the binding; when the binding is deleted, it is added to the CRL file, which
+// Kafka brokers or a proxy in front of them can check client certificates against.
+// The CA files are read for every certificate, so that they can be rotated without a restart.
+type CertificateRepository struct {
+	repository
+	kafkaConfig brokerconfig.KafkaConfiguration
+}
+
+// crlLock serializes the updates of the CRL file
+var crlLock sync.Mutex
+
+// NewCertificateRepository creates a CertificateRepository for KafkaConfiguration.ClientCertificates
+func NewCertificateRepository(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, logger lager.Logger) *CertificateRepository {
+	return &CertificateRepository{
+		repository:  repository{connect: connect, logger: logger},
+		kafkaConfig: kafkaConfig,
+	}
+}
+
+// certificateAuthority is the CA that signs client certificates
+type certificateAuthority struct {
+	certificate *x509.Certificate
+	key         crypto.Signer
+	// chain is the PEM encoded certificate of the CA, followed by any intermediates up to the root
+	chain []byte
+}
+
+func (repo *CertificateRepository) loadCA() (certificateAuthority, error) {
+	config := repo.kafkaConfig.ClientCertificates
+	chain, err := ioutil.ReadFile(config.CACertFile)
+	if err != nil {
+		return certificateAuthority{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(config.CAKeyFile)
+	if err != nil {
+		return certificateAuthority{}, err
+	}
+	pair, err := tls.X509KeyPair(chain, keyPEM)
+	if err != nil {
+		return certificateAuthority{}, err
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return certificateAuthority{}, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return certificateAuthority{}, fmt.Errorf("%s does not hold a signing key", config.CAKeyFile)
+	}
+	return certificateAuthority{certificate: certificate, key: key, chain: chain}, nil
+}
+
+// AddBinding issues a new binding a client certificate and records its serial number. Any
+// certificate the binding was issued before is revoked, so that it cannot outlive the record.
+func (repo *CertificateRepository) AddBinding(instanceID, bindingID string) (broker.ClientCertificate, error) {
+	certificate, serial, err := repo.issue(instanceID, bindingID)
+	if err != nil {
+		return broker.ClientCertificate{}, err
+	}
+
+	conn, err := repo.connect()
+	if err != nil {
+		return broker.ClientCertificate{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	binding := bindingRecord{}
+	previous := ""
+	err = updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		previous = binding.CertificateSerial
+		binding.CertificateSerial = serial
+		return nil
+	})
+	if err != nil {
+		return broker.ClientCertificate{}, err
+	}
+	if previous != "" && previous != serial {
+		if err = repo.revokeSerial(previous); err != nil {
+			return broker.ClientCertificate{}, err
+		}
+		repo.logger.Info("replace-binding-certificate", lager.Data{
+			"instance_id": instanceID,
+			"binding_id":  bindingID,
+			"serial":      previous,
+			"message":     "Revoked the client certificate the binding was issued before",
+		})
+	}
+	return certificate, nil
+}
+
+// issue signs a new client certificate for a binding, and returns it with its hexadecimal serial number
+func (repo *CertificateRepository) issue(instanceID, bindingID string) (broker.ClientCertificate, string, error) {
+	ca, err := repo.loadCA()
+	if err != nil {
+		return broker.ClientCertificate{}, "", err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return broker.ClientCertificate{}, "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return broker.ClientCertificate{}, "", err
+	}
+	now := time.Now()
+	notAfter := now.Add(repo.kafkaConfig.ClientCertificates.Validity)
+	if notAfter.After(ca.certificate.NotAfter) {
+		notAfter = ca.certificate.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: bindingID},
+		// allow for clock skew between the broker and Kafka
+		NotBefore:   now.Add(-5 * time.Minute),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, key.Public(), ca.key)
+	if err != nil {
+		return broker.ClientCertificate{}, "", err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return broker.ClientCertificate{}, "", err
+	}
+
+	repo.logger.Info("issue-binding-certificate", lager.Data{
+		"instance_id": instanceID,
+		"binding_id":  bindingID,
+		"serial":      serial.Text(16),
+		"not_after":   notAfter,
+		"message":     "Issued client certificate to binding",
+	})
+	return broker.ClientCertificate{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		CAChain:     string(ca.chain),
+	}, serial.Text(16), nil
+}
+
+// RemoveBinding revokes the client certificate of a binding
+func (repo *CertificateRepository) RemoveBinding(instanceID, bindingID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	return repo.removeBinding(conn, instanceID, bindingID)
+}
+
+// RemoveInstance revokes the client certificates of every binding of a service instance
+func (repo *CertificateRepository) RemoveInstance(instanceID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	bindingIDs, err := recordedBindingIDs(conn, instanceID)
+	if err != nil {
+		return err
+	}
+	for _, bindingID := range bindingIDs {
+		if err = repo.removeBinding(conn, instanceID, bindingID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *CertificateRepository) removeBinding(conn zookeeper.Conn, instanceID, bindingID string) error {
+	binding := bindingRecord{}
+	err := readRecord(conn, bindingRecordPath(instanceID, bindingID), &binding)
+	if err == zookeeper.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if binding.CertificateSerial == "" {
+		return nil
+	}
+
+	if err = repo.revokeSerial(binding.CertificateSerial); err != nil {
+		return err
+	}
+	repo.logger.Info("remove-binding-certificate", lager.Data{
+		"instance_id": instanceID,
+		"binding_id":  bindingID,
+		"serial":      binding.CertificateSerial,
+		"message":     "Revoked client certificate of binding",
+	})
+	serial := binding.CertificateSerial
+	return updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		if binding.CertificateSerial != serial {
+			// replaced by a rotation since it was read
+			return errNoUpdate
+		}
+		binding.CertificateSerial = ""
+		return nil
+	})
+}
+
+// revokeSerial adds a hexadecimal serial number to the CRL file
+func (repo *CertificateRepository) revokeSerial(serialHex string) error {
+	serial, ok := new(big.Int).SetString(serialHex, 16)
+	if !ok {
+		return fmt.Errorf("invalid certificate serial number '%s'", serialHex)
+	}
+	return repo.revoke(serial)
+}
+
+// revoke adds a serial number to the CRL file, creating it if there is none yet
+func (repo *CertificateRepository) revoke(serial *big.Int) error {
+	crlLock.Lock()
+	defer crlLock.Unlock()
+
+	crl, err := repo.readCRL()
+	if err != nil {
+		return err
+	}
+	revoked := []pkix.RevokedCertificate{}
+	if crl != nil {
+		for _, entry := range crl.RevokedCertificates {
+			if entry.SerialNumber.Cmp(serial) == 0 {
+				return nil
+			}
+			revoked = append(revoked, entry)
+		}
+	}
+	now := time.Now()
+	revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: now.UTC()})
+	return repo.writeCRL(crl, revoked, now)
+}
+
+// RefreshCRL signs the CRL file again, with the same revoked certificates, once half of the time
+// until its next update has passed, so that it does not go stale when no certificate is revoked
+// for a while. A CRL file is created, listing no certificates, if there is none yet.
+func (repo *CertificateRepository) RefreshCRL(now time.Time) error {
+	crlLock.Lock()
+	defer crlLock.Unlock()
+
+	crl, err := repo.readCRL()
+	if err != nil {
+		return err
+	}
+	revoked := []pkix.RevokedCertificate{}
+	if crl != nil {
+		if now.Before(crl.ThisUpdate.Add(crl.NextUpdate.Sub(crl.ThisUpdate) / 2)) {
+			return nil
+		}
+		revoked = crl.RevokedCertificates
+	}
+	if err = repo.writeCRL(crl, revoked, now); err != nil {
+		return err
+	}
+	repo.logger.Info("refresh-crl", lager.Data{
+		"revoked":     len(revoked),
+		"next_update": now.Add(repo.kafkaConfig.ClientCertificates.Validity),
+		"message":     "Signed the CRL again",
+	})
+	return nil
+}
+
+// readCRL returns the CRL of the CRL file, or nil if there is none yet
+func (repo *CertificateRepository) readCRL() (*x509.RevocationList, error) {
+	crlFile := repo.kafkaConfig.ClientCertificates.CRLFile
+	data, err := ioutil.ReadFile(crlFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("CRL file %s is not PEM encoded", crlFile)
+	}
+	return x509.ParseRevocationList(block.Bytes)
+}
+
+// writeCRL signs a CRL listing revoked, following previous if there is one, and replaces the CRL
+// file with it. The CRL is valid for as long as the certificates the CA issues.
+func (repo *CertificateRepository) writeCRL(previous *x509.RevocationList, revoked []pkix.RevokedCertificate, now time.Time) error {
+	ca, err := repo.loadCA()
+	if err != nil {
+		return err
+	}
+	number := big.NewInt(1)
+	if previous != nil && previous.Number != nil {
+		number.Add(previous.Number, big.NewInt(1))
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              number,
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(repo.kafkaConfig.ClientCertificates.Validity),
+		RevokedCertificates: revoked,
+	}, ca.certificate, ca.key)
+	if err != nil {
+		return err
+	}
+
+	crlFile := repo.kafkaConfig.ClientCertificates.CRLFile
+
+	// replace the file atomically, so that it is never read half written
+	tempFile, err := ioutil.TempFile(filepath.Dir(crlFile), filepath.Base(crlFile))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tempFile.Name()) }()
+	err = pem.Encode(tempFile, &pem.Block{Type: "X509 CRL", Bytes: der})
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tempFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), crlFile)
+}
@@ -0,0 +1,205 @@
+package kafka_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// writeTestCA writes the certificate and key of a new CA to dir, and returns the CA
+func writeTestCA(dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Kafka clients CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	ca, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	certFile := filepath.Join(dir, "ca.pem")
+	keyFile := filepath.Join(dir, "ca-key.pem")
+	Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)).To(Succeed())
+	return ca, certFile, keyFile
+}
+
+var _ = Describe("CertificateRepository", func() {
+	const instanceID = "instanceID"
+
+	var dir string
+	var ca *x509.Certificate
+	var store *zookeeper.MemoryStore
+	var kafkaConfig brokerconfig.KafkaConfiguration
+	var repo *kafka.CertificateRepository
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "certificates")
+		Expect(err).NotTo(HaveOccurred())
+		store = newMemoryStore(1)
+		kafkaConfig = kafkaConfiguration()
+		kafkaConfig.SecurityProtocol = brokerconfig.SecurityProtocolSSL
+		kafkaConfig.ClientCertificates.Validity = time.Hour
+		ca, kafkaConfig.ClientCertificates.CACertFile, kafkaConfig.ClientCertificates.CAKeyFile = writeTestCA(dir)
+		kafkaConfig.ClientCertificates.CRLFile = filepath.Join(dir, "clients.crl")
+		repo = kafka.NewCertificateRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	parseCertificate := func(data string) *x509.Certificate {
+		block, _ := pem.Decode([]byte(data))
+		Expect(block).NotTo(BeNil())
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		return certificate
+	}
+
+	groupPrincipals := func(group string) []string {
+		conn, err := store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = conn.Close() }()
+		acls, err := zookeeper.NewCluster(conn).ACLs(zookeeper.Resource{Type: zookeeper.ResourceGroup, Name: group, PatternType: zookeeper.PatternLiteral})
+		Expect(err).NotTo(HaveOccurred())
+		principals := []string{}
+		for _, acl := range acls {
+			principals = append(principals, acl.Principal)
+		}
+		return principals
+	}
+
+	revokedSerials := func() (*big.Int, []*big.Int) {
+		data, err := ioutil.ReadFile(kafkaConfig.ClientCertificates.CRLFile)
+		Expect(err).NotTo(HaveOccurred())
+		block, _ := pem.Decode(data)
+		Expect(block).NotTo(BeNil())
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(crl.CheckSignatureFrom(ca)).To(Succeed())
+		serials := []*big.Int{}
+		for _, entry := range crl.RevokedCertificates {
+			serials = append(serials, entry.SerialNumber)
+		}
+		return crl.Number, serials
+	}
+
+	It("issues the binding a client certificate for CN=<binding ID>, signed by the CA", func() {
+		issued, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		certificate := parseCertificate(issued.Certificate)
+		Expect(certificate.Subject.String()).To(Equal("CN=bindingID"))
+		Expect(certificate.NotAfter).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca)
+		_, err = certificate.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parseCertificate(issued.CAChain).Equal(ca)).To(BeTrue())
+
+		block, _ := pem.Decode([]byte(issued.PrivateKey))
+		Expect(block.Type).To(Equal("PRIVATE KEY"))
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key.(*ecdsa.PrivateKey).PublicKey).To(Equal(*certificate.PublicKey.(*ecdsa.PublicKey)))
+	})
+
+	It("does not issue certificates that outlive the CA", func() {
+		kafkaConfig.ClientCertificates.Validity = 365 * 24 * time.Hour
+		repo = kafka.NewCertificateRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+		issued, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parseCertificate(issued.Certificate).NotAfter).To(Equal(ca.NotAfter))
+	})
+
+	It("adds the certificate of a deleted binding to the CRL", func() {
+		issued, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		other, err := repo.AddBinding(instanceID, "otherID")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		number, serials := revokedSerials()
+		Expect(number).To(Equal(big.NewInt(1)))
+		Expect(serials).To(ConsistOf(parseCertificate(issued.Certificate).SerialNumber))
+
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		Expect(repo.RemoveInstance(instanceID)).To(Succeed())
+		number, serials = revokedSerials()
+		Expect(number).To(Equal(big.NewInt(2)))
+		Expect(serials).To(ConsistOf(parseCertificate(issued.Certificate).SerialNumber, parseCertificate(other.Certificate).SerialNumber))
+	})
+
+	It("revokes the certificate a binding was issued before when issuing it another", func() {
+		first, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		second, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		_, serials := revokedSerials()
+		Expect(serials).To(ConsistOf(parseCertificate(first.Certificate).SerialNumber))
+
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		_, serials = revokedSerials()
+		Expect(serials).To(ConsistOf(parseCertificate(first.Certificate).SerialNumber, parseCertificate(second.Certificate).SerialNumber))
+	})
+
+	It("signs the CRL again once half of the time until its next update has passed", func() {
+		now := time.Now()
+		Expect(repo.RefreshCRL(now)).To(Succeed())
+		number, serials := revokedSerials()
+		Expect(number).To(Equal(big.NewInt(1)))
+		Expect(serials).To(BeEmpty())
+
+		issued, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		Expect(repo.RefreshCRL(now.Add(20 * time.Minute))).To(Succeed())
+		number, _ = revokedSerials()
+		Expect(number).To(Equal(big.NewInt(2)))
+
+		Expect(repo.RefreshCRL(now.Add(40 * time.Minute))).To(Succeed())
+		number, serials = revokedSerials()
+		Expect(number).To(Equal(big.NewInt(3)))
+		Expect(serials).To(ConsistOf(parseCertificate(issued.Certificate).SerialNumber))
+	})
+
+	It("keeps the binding's other records when revoking its certificate", func() {
+		groupRepo := kafka.NewConsumerGroupRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+		group, err := groupRepo.AddBinding(instanceID, "bindingID", "")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(groupPrincipals(group)).To(ConsistOf("User:CN=bindingID"))
+
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		Expect(groupRepo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		Expect(groupPrincipals(group)).To(BeEmpty())
+	})
+})
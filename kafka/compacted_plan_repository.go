@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// compactedTopicDefaults is the configuration of a compacted topic, before any plan topic config.
+// Segments are rolled often enough for the log cleaner to compact them, and records are
+// kept uncompacted for long enough that consumers, such as Kafka Streams restoring a
+// state store, see every update to a key.
+var compactedTopicDefaults = map[string]string{
+	"cleanup.policy":        "compact",
+	"min.compaction.lag.ms": "3600000",
+	"segment.ms":            "21600000",
+}
+
+var _ broker.TopicConfigDefaulter = &CompactedPlanRepository{}
+
+// CompactedPlanRepository describes the creation/binding of kafka service instances with
+// a single log compacted topic, such as the changelog or table topics of Kafka Streams apps.
+// Like TopicPlanRepository, the topic is named after the service instance.
+type CompactedPlanRepository struct {
+	repository
+	kafkaConfig brokerconfig.KafkaConfiguration
+}
+
+// NewCompactedPlanRepository creates a CompactedPlanRepository
+func NewCompactedPlanRepository(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, logger lager.Logger) *CompactedPlanRepository {
+	return &CompactedPlanRepository{
+		repository:  repository{connect: connect, logger: logger},
+		kafkaConfig: kafkaConfig,
+	}
+}
+
+// InstanceExists returns true if instanceID belongs to an existing service instance
+func (repo *CompactedPlanRepository) InstanceExists(instanceID string) (bool, error) {
+	cluster, err := zookeeper.OpenCluster(repo.connect)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = cluster.Close() }()
+	return cluster.TopicExists(instanceID)
+}
+
+// Create will create a compacted topic. The topic configuration of the settings is applied over
+// the compacted topic defaults, and must keep "compact" in the cleanup.policy.
+func (repo *CompactedPlanRepository) Create(instanceID string, settings broker.InstanceSettings) error {
+	config, err := repo.topicConfig(settings.TopicConfig)
+	if err != nil {
+		return err
+	}
+
+	cluster, err := zookeeper.OpenCluster(repo.connect)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cluster.Close() }()
+	err = cluster.CreateTopic(instanceID,
+		repo.kafkaConfig.KafkaPartitionCount,
+		repo.kafkaConfig.KafkaReplicationFactor,
+		config)
+	if err != nil {
+		return err
+	}
+
+	repo.logger.Info("provision-instance", lager.Data{
+		"instance_id":  instanceID,
+		"plan":         "compacted",
+		"topic.config": config,
+		"message":      "Successfully provisioned Kafka compacted plan instance",
+	})
+
+	return nil
+}
+
+// TopicConfigDefaults returns the configuration of a compacted topic before the plan's topic config.
+// min.insync.replicas defaults to one less than the replication factor, so that a topic remains
+// writable with acks=all while one broker is down.
+func (repo *CompactedPlanRepository) TopicConfigDefaults() map[string]string {
+	config := map[string]string{}
+	for key, value := range compactedTopicDefaults {
+		config[key] = value
+	}
+	minInSyncReplicas := repo.kafkaConfig.KafkaReplicationFactor - 1
+	if minInSyncReplicas < 1 {
+		minInSyncReplicas = 1
+	}
+	config["min.insync.replicas"] = strconv.Itoa(minInSyncReplicas)
+	return config
+}
+
+// topicConfig returns the configuration of a new compacted topic, the plan's topic config
+// applied over its defaults
+func (repo *CompactedPlanRepository) topicConfig(topicConfig map[string]string) (map[string]string, error) {
+	config := repo.TopicConfigDefaults()
+	for key, value := range topicConfig {
+		config[key] = value
+	}
+
+	if !isCompacted(config["cleanup.policy"]) {
+		return nil, fmt.Errorf("cleanup.policy of a compacted topic must include 'compact', not '%s'", config["cleanup.policy"])
+	}
+	minInSyncReplicas, err := strconv.Atoi(config["min.insync.replicas"])
+	if err != nil || minInSyncReplicas < 1 || minInSyncReplicas > repo.kafkaConfig.KafkaReplicationFactor {
+		return nil, fmt.Errorf("min.insync.replicas must be between 1 and the replication factor of %d, not '%s'",
+			repo.kafkaConfig.KafkaReplicationFactor, config["min.insync.replicas"])
+	}
+	return config, nil
+}
+
+func isCompacted(cleanupPolicy string) bool {
+	for _, policy := range strings.Split(cleanupPolicy, ",") {
+		if strings.TrimSpace(policy) == "compact" {
+			return true
+		}
+	}
+	return false
+}
+
+// Destroy will destroy any topics associated with the service instance
+// Currently "associated with" is inferred - any topic name with instanceID as a prefix
+func (repo *CompactedPlanRepository) Destroy(instanceID string) error {
+	return destroyInstanceTopics(repo.connect, repo.logger, instanceID, "compacted")
+}
+
+// Bind provides the credentials to access the Kafka cluster and the compacted topic.
+// The credentials include the topic's cleanup.policy so clients know it is compacted.
+func (repo *CompactedPlanRepository) Bind(instanceID string, bindingID string) (broker.InstanceCredentials, error) {
+	cluster, err := zookeeper.OpenCluster(repo.connect)
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+	defer func() { _ = cluster.Close() }()
+	config, err := cluster.TopicConfig(instanceID)
+	if err != nil && err != zookeeper.ErrNoNode {
+		return broker.InstanceCredentials{}, err
+	}
+	cleanupPolicy := config["cleanup.policy"]
+	if cleanupPolicy == "" {
+		cleanupPolicy = compactedTopicDefaults["cleanup.policy"]
+	}
+
+	repo.logger.Info("bind-instance", lager.Data{
+		"instance_id": instanceID,
+		"binding_id":  bindingID,
+		"plan":        "compacted",
+		"message":     "Successful bind of Kafka compacted plan instance",
+	})
+	return broker.InstanceCredentials{
+		ZookeeperPeers:     repo.kafkaConfig.ZookeeperPeers,
+		KafkaHostnames:     repo.kafkaConfig.KafkaHostnames,
+		TopicName:          instanceID,
+		TopicCleanupPolicy: cleanupPolicy,
+	}, nil
+}
+
+// Unbind is a no-op as bindings are shared across all instances
+func (repo *CompactedPlanRepository) Unbind(instanceID string, bindingID string) error {
+	repo.logger.Info("unbind-instance", lager.Data{
+		"instance_id": instanceID,
+		"binding_id":  bindingID,
+		"plan":        "compacted",
+		"message":     "Successful unbind of Kafka compacted plan instance",
+	})
+	return nil
+}
@@ -0,0 +1,105 @@
+package kafka_test
+
+import (
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("CompactedPlanRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var kafkaConfig brokerconfig.KafkaConfiguration
+	var repo *kafka.CompactedPlanRepository
+
+	topicConfig := func(name string) map[string]string {
+		conn, err := store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		config, err := zookeeper.NewCluster(conn).TopicConfig(name)
+		Expect(err).NotTo(HaveOccurred())
+		return config
+	}
+
+	BeforeEach(func() {
+		store = newMemoryStore(3)
+		kafkaConfig = kafkaConfiguration()
+		repo = kafka.NewCompactedPlanRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+	})
+
+	Describe(".Create", func() {
+		It("creates a compacted topic named after the instance", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			Expect(topics(store, "/brokers/topics")).To(ConsistOf(instanceID))
+			Expect(repo.InstanceExists(instanceID)).To(BeTrue())
+			Expect(topicConfig(instanceID)).To(Equal(map[string]string{
+				"cleanup.policy":        "compact",
+				"min.compaction.lag.ms": "3600000",
+				"segment.ms":            "21600000",
+				"min.insync.replicas":   "2",
+			}))
+		})
+
+		It("ties min.insync.replicas to the replication factor", func() {
+			kafkaConfig.KafkaReplicationFactor = 1
+			repo = kafka.NewCompactedPlanRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			Expect(topicConfig(instanceID)).To(HaveKeyWithValue("min.insync.replicas", "1"))
+		})
+
+		It("applies the plan topic config over its defaults", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{TopicConfig: map[string]string{
+				"cleanup.policy": "compact,delete",
+				"retention.ms":   "604800000",
+			}})).To(Succeed())
+			Expect(topicConfig(instanceID)).To(HaveKeyWithValue("cleanup.policy", "compact,delete"))
+			Expect(topicConfig(instanceID)).To(HaveKeyWithValue("retention.ms", "604800000"))
+		})
+
+		It("adds its defaults to the topic config policy of the plan, so that they do not drift", func() {
+			maxRetention := int64(604800000)
+			policy := broker.TopicConfigPolicy{
+				Defaults:    map[string]string{"segment.ms": "3600000"},
+				Overridable: map[string]broker.TopicConfigRule{"retention.ms": {Max: &maxRetention}},
+			}
+			config, err := policy.Configure(map[string]string{"retention.ms": "86400000"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repo.Create(instanceID, broker.InstanceSettings{TopicConfig: config})).To(Succeed())
+
+			Expect(policy.Drift(topicConfig(instanceID))).NotTo(BeEmpty())
+			Expect(policy.WithDefaults(repo.TopicConfigDefaults()).Drift(topicConfig(instanceID))).To(BeEmpty())
+		})
+
+		It("refuses topic config that would not compact the topic", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{TopicConfig: map[string]string{"cleanup.policy": "delete"}})).To(
+				MatchError("cleanup.policy of a compacted topic must include 'compact', not 'delete'"))
+			Expect(repo.Create(instanceID, broker.InstanceSettings{TopicConfig: map[string]string{"min.insync.replicas": "4"}})).To(
+				MatchError("min.insync.replicas must be between 1 and the replication factor of 3, not '4'"))
+			Expect(topics(store, "/brokers/topics")).To(BeEmpty())
+		})
+	})
+
+	Describe(".Bind", func() {
+		It("annotates the credentials with the cleanup policy", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.TopicName).To(Equal(instanceID))
+			Expect(credentials.TopicCleanupPolicy).To(Equal("compact"))
+			Expect(credentials.KafkaHostnames).To(Equal(kafkaConfig.KafkaHostnames))
+		})
+	})
+
+	Describe(".Destroy", func() {
+		It("deletes the topic", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf(instanceID))
+		})
+	})
+})
@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// ConsumerGroupRepository allocates a consumer group to each binding: "<instanceID>.<bindingID>",
+// or "<instanceID>" for plans that share one consumer group between the bindings of an instance.
+// Each binding's principal, "User:<bindingID>" or "User:CN=<bindingID>" for bindings issued a
+// client certificate, is allowed to Read its group by a Group ACL.
+type ConsumerGroupRepository struct {
+	repository
+	kafkaConfig brokerconfig.KafkaConfiguration
+}
+
+// NewConsumerGroupRepository creates a ConsumerGroupRepository
+func NewConsumerGroupRepository(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, logger lager.Logger) *ConsumerGroupRepository {
+	return &ConsumerGroupRepository{
+		repository:  repository{connect: connect, logger: logger},
+		kafkaConfig: kafkaConfig,
+	}
+}
+
+// AddBinding records the consumer group of a new binding and allows the binding to use it
+func (repo *ConsumerGroupRepository) AddBinding(instanceID, bindingID, scope string) (string, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if scope == "" {
+		scope = broker.ConsumerGroupPerBinding
+	}
+	binding := bindingRecord{}
+	err = updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		binding.ConsumerGroupScope = scope
+		binding.ConsumerGroup = instanceID + "." + bindingID
+		if scope == broker.ConsumerGroupPerInstance {
+			binding.ConsumerGroup = instanceID
+		}
+		binding.Principal = repo.kafkaConfig.Principal(bindingID)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	err = zookeeper.NewCluster(conn).AddACLs(consumerGroupResource(binding.ConsumerGroup), consumerGroupACL(binding))
+	if err != nil {
+		return "", err
+	}
+
+	repo.logger.Info("add-binding-consumer-group", lager.Data{
+		"instance_id":    instanceID,
+		"binding_id":     bindingID,
+		"consumer_group": binding.ConsumerGroup,
+		"message":        "Allocated consumer group to binding",
+	})
+	return binding.ConsumerGroup, nil
+}
+
+// RemoveBinding revokes a binding's access to its consumer group. A group allocated to the
+// binding alone is deleted from /consumers, with its committed offsets.
+func (repo *ConsumerGroupRepository) RemoveBinding(instanceID, bindingID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	return repo.removeBinding(conn, instanceID, bindingID)
+}
+
+// RemoveInstance removes the consumer groups of every binding of a service instance,
+// and the group shared by its bindings
+func (repo *ConsumerGroupRepository) RemoveInstance(instanceID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	bindingIDs, err := recordedBindingIDs(conn, instanceID)
+	if err != nil {
+		return err
+	}
+	for _, bindingID := range bindingIDs {
+		if err = repo.removeBinding(conn, instanceID, bindingID); err != nil {
+			return err
+		}
+	}
+	repo.deleteConsumerGroup(zookeeper.NewCluster(conn), instanceID, instanceID)
+	return nil
+}
+
+func (repo *ConsumerGroupRepository) removeBinding(conn zookeeper.Conn, instanceID, bindingID string) error {
+	binding := bindingRecord{}
+	err := readRecord(conn, bindingRecordPath(instanceID, bindingID), &binding)
+	if err == zookeeper.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if binding.ConsumerGroup == "" {
+		return nil
+	}
+	if binding.Principal == "" {
+		// recorded before principals were
+		binding.Principal = "User:" + bindingID
+	}
+
+	cluster := zookeeper.NewCluster(conn)
+	if err = cluster.RemoveACLs(consumerGroupResource(binding.ConsumerGroup), consumerGroupACL(binding)); err != nil {
+		return err
+	}
+	if binding.ConsumerGroupScope != broker.ConsumerGroupPerInstance {
+		repo.deleteConsumerGroup(cluster, instanceID, binding.ConsumerGroup)
+	}
+
+	repo.logger.Info("remove-binding-consumer-group", lager.Data{
+		"instance_id":    instanceID,
+		"binding_id":     bindingID,
+		"consumer_group": binding.ConsumerGroup,
+		"message":        "Revoked binding's access to its consumer group",
+	})
+	return updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		binding.ConsumerGroup = ""
+		binding.ConsumerGroupScope = ""
+		binding.Principal = ""
+		return nil
+	})
+}
+
+// deleteConsumerGroup deletes a consumer group and its offsets from /consumers. A group that
+// still has members is left in place and logged, so that unbinding is not blocked by them.
+func (repo *ConsumerGroupRepository) deleteConsumerGroup(cluster *zookeeper.Cluster, instanceID, group string) {
+	err := cluster.DeleteConsumerGroup(group)
+	if err != nil {
+		repo.logger.Error("delete-consumer-group", err, lager.Data{
+			"instance_id":    instanceID,
+			"consumer_group": group,
+			"message":        "Failed to delete consumer group",
+		})
+	}
+}
+
+func consumerGroupResource(group string) zookeeper.Resource {
+	return zookeeper.Resource{
+		Type:        zookeeper.ResourceGroup,
+		Name:        group,
+		PatternType: zookeeper.PatternLiteral,
+	}
+}
+
+// consumerGroupACL allows the principal of a binding to join, commit offsets for and describe a group
+func consumerGroupACL(binding bindingRecord) zookeeper.ACL {
+	return zookeeper.ACL{
+		Principal:      binding.Principal,
+		PermissionType: "Allow",
+		Operation:      "Read",
+		Host:           "*",
+	}
+}
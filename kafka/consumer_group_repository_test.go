@@ -0,0 +1,136 @@
+package kafka_test
+
+import (
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("ConsumerGroupRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var conn zookeeper.Conn
+	var repo *kafka.ConsumerGroupRepository
+
+	groupACLs := func(group string) []zookeeper.ACL {
+		acls, err := zookeeper.NewCluster(conn).ACLs(zookeeper.Resource{
+			Type:        zookeeper.ResourceGroup,
+			Name:        group,
+			PatternType: zookeeper.PatternLiteral,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return acls
+	}
+	allowRead := func(bindingID string) zookeeper.ACL {
+		return zookeeper.ACL{Principal: "User:" + bindingID, PermissionType: "Allow", Operation: "Read", Host: "*"}
+	}
+
+	BeforeEach(func() {
+		store = newMemoryStore(1)
+		var err error
+		conn, err = store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		repo = kafka.NewConsumerGroupRepository(kafkaConfiguration(), store.Connect, lager.NewLogger("test"))
+	})
+
+	Context("with a consumer group per binding", func() {
+		It("allocates a group to each binding and allows it to read the group", func() {
+			Expect(repo.AddBinding(instanceID, "binding1", "")).To(Equal("instanceID.binding1"))
+			Expect(repo.AddBinding(instanceID, "binding2", broker.ConsumerGroupPerBinding)).To(Equal("instanceID.binding2"))
+			Expect(groupACLs("instanceID.binding1")).To(Equal([]zookeeper.ACL{allowRead("binding1")}))
+			Expect(groupACLs("instanceID.binding2")).To(Equal([]zookeeper.ACL{allowRead("binding2")}))
+		})
+
+		It("deletes the group and its committed offsets on unbind", func() {
+			Expect(repo.AddBinding(instanceID, "binding1", "")).To(Equal("instanceID.binding1"))
+			Expect(conn.Create("/consumers/instanceID.binding1/offsets/instanceID/0", []byte("42"))).To(Succeed())
+
+			Expect(repo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+			Expect(conn.Exists("/consumers/instanceID.binding1")).To(BeFalse())
+			Expect(groupACLs("instanceID.binding1")).To(BeEmpty())
+		})
+
+		It("leaves a group with members in place", func() {
+			Expect(repo.AddBinding(instanceID, "binding1", "")).To(Equal("instanceID.binding1"))
+			Expect(conn.Create("/consumers/instanceID.binding1/ids/member", []byte("{}"))).To(Succeed())
+
+			Expect(repo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+			Expect(conn.Exists("/consumers/instanceID.binding1/ids/member")).To(BeTrue())
+			Expect(groupACLs("instanceID.binding1")).To(BeEmpty())
+		})
+	})
+
+	Context("with a consumer group per instance", func() {
+		It("shares the group between bindings until the instance is removed", func() {
+			Expect(repo.AddBinding(instanceID, "binding1", broker.ConsumerGroupPerInstance)).To(Equal(instanceID))
+			Expect(repo.AddBinding(instanceID, "binding2", broker.ConsumerGroupPerInstance)).To(Equal(instanceID))
+			Expect(groupACLs(instanceID)).To(ConsistOf(allowRead("binding1"), allowRead("binding2")))
+			Expect(conn.Create("/consumers/instanceID/offsets/instanceID/0", []byte("42"))).To(Succeed())
+
+			Expect(repo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+			Expect(groupACLs(instanceID)).To(Equal([]zookeeper.ACL{allowRead("binding2")}))
+			Expect(conn.Exists("/consumers/instanceID/offsets/instanceID/0")).To(BeTrue())
+
+			Expect(repo.RemoveInstance(instanceID)).To(Succeed())
+			Expect(groupACLs(instanceID)).To(BeEmpty())
+			Expect(conn.Exists("/consumers/instanceID")).To(BeFalse())
+		})
+
+		It("allows every binding created concurrently to read the group", func() {
+			bindingIDs := []string{"binding1", "binding2", "binding3"}
+			errs := make(chan error, len(bindingIDs))
+			for _, bindingID := range bindingIDs {
+				go func(bindingID string) {
+					defer GinkgoRecover()
+					_, err := repo.AddBinding(instanceID, bindingID, broker.ConsumerGroupPerInstance)
+					errs <- err
+				}(bindingID)
+			}
+			for range bindingIDs {
+				Expect(<-errs).To(Succeed())
+			}
+			Expect(groupACLs(instanceID)).To(ConsistOf(allowRead("binding1"), allowRead("binding2"), allowRead("binding3")))
+		})
+	})
+
+	It("keeps the quota of a binding", func() {
+		quotaRepo := kafka.NewQuotaRepository(kafkaConfiguration(), store.Connect, lager.NewLogger("test"))
+		Expect(quotaRepo.SetInstanceQuota(instanceID, broker.Quota{ProducerByteRate: 1024})).To(Succeed())
+		Expect(quotaRepo.AddBinding(instanceID, "binding1")).To(Equal("binding1"))
+		Expect(repo.AddBinding(instanceID, "binding1", "")).To(Equal("instanceID.binding1"))
+
+		Expect(repo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+		Expect(quotaRepo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+		Expect(topics(store, "/config/clients")).To(BeEmpty())
+	})
+
+	It("keeps the quota of a binding recorded concurrently", func() {
+		kafkaConfig := kafkaConfiguration()
+		kafkaConfig.QuotaEntityType = zookeeper.EntityClients
+		quotaRepo := kafka.NewQuotaRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+		Expect(quotaRepo.SetInstanceQuota(instanceID, broker.Quota{ProducerByteRate: 1024})).To(Succeed())
+		errs := make(chan error, 2)
+		go func() {
+			defer GinkgoRecover()
+			_, err := quotaRepo.AddBinding(instanceID, "binding1")
+			errs <- err
+		}()
+		go func() {
+			defer GinkgoRecover()
+			_, err := repo.AddBinding(instanceID, "binding1", "")
+			errs <- err
+		}()
+		Expect(<-errs).To(Succeed())
+		Expect(<-errs).To(Succeed())
+
+		Expect(repo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+		Expect(topics(store, "/config/clients")).To(ConsistOf("binding1"))
+		Expect(quotaRepo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+		Expect(topics(store, "/config/clients")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,235 @@
+package kafka
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// repository is the ZooKeeper connection and the logger of a repository
+type repository struct {
+	connect zookeeper.Connector
+	logger  lager.Logger
+}
+
+// withContext returns a copy of the repository whose ZooKeeper sessions stop once ctx is done,
+// and whose log lines carry its request ID
+func (repo repository) withContext(ctx context.Context) repository {
+	return repository{
+		connect: zookeeper.ContextConnector(ctx, repo.connect),
+		logger:  broker.RequestLogger(ctx, repo.logger),
+	}
+}
+
+// The repositories implement broker.ContextManager with such a copy of themselves for each
+// request; the plan repositories implement broker.ContextInstanceCreator and
+// broker.ContextInstanceBinder with it too.
+var (
+	_ broker.ContextManager         = &AuditRepository{}
+	_ broker.ContextManager         = &CertificateRepository{}
+	_ broker.ContextManager         = &ConsumerGroupRepository{}
+	_ broker.ContextManager         = &CredentialRotationRepository{}
+	_ broker.ContextManager         = &LimitRepository{}
+	_ broker.ContextManager         = &OffsetRepository{}
+	_ broker.ContextManager         = &QuotaRepository{}
+	_ broker.ContextManager         = &RequestRepository{}
+	_ broker.ContextManager         = &SASLRepository{}
+	_ broker.ContextManager         = &TopicPlanRepository{}
+	_ broker.ContextInstanceCreator = &TopicPlanRepository{}
+	_ broker.ContextInstanceBinder  = &TopicPlanRepository{}
+	_ broker.ContextManager         = &SharedPlanRepository{}
+	_ broker.ContextInstanceCreator = &SharedPlanRepository{}
+	_ broker.ContextInstanceBinder  = &SharedPlanRepository{}
+	_ broker.ContextManager         = &CompactedPlanRepository{}
+	_ broker.ContextInstanceCreator = &CompactedPlanRepository{}
+	_ broker.ContextInstanceBinder  = &CompactedPlanRepository{}
+	_ broker.ContextManager         = &MultiTopicPlanRepository{}
+	_ broker.ContextInstanceCreator = &MultiTopicPlanRepository{}
+	_ broker.ContextInstanceBinder  = &MultiTopicPlanRepository{}
+)
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *AuditRepository) WithContext(ctx context.Context) interface{} {
+	return repo.withContext(ctx)
+}
+
+func (repo *AuditRepository) withContext(ctx context.Context) *AuditRepository {
+	return &AuditRepository{repository: repo.repository.withContext(ctx)}
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *CertificateRepository) WithContext(ctx context.Context) interface{} {
+	return repo.withContext(ctx)
+}
+
+func (repo *CertificateRepository) withContext(ctx context.Context) *CertificateRepository {
+	return &CertificateRepository{kafkaConfig: repo.kafkaConfig, repository: repo.repository.withContext(ctx)}
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *ConsumerGroupRepository) WithContext(ctx context.Context) interface{} {
+	return &ConsumerGroupRepository{kafkaConfig: repo.kafkaConfig, repository: repo.repository.withContext(ctx)}
+}
+
+// WithContext returns a copy of the repository, and of the repositories it rotates and revokes
+// credentials with, for the request of ctx
+func (repo *CredentialRotationRepository) WithContext(ctx context.Context) interface{} {
+	requestRepo := *repo
+	requestRepo.repository = repo.repository.withContext(ctx)
+	requestRepo.audit = repo.audit.withContext(ctx)
+	if repo.sasl != nil {
+		requestRepo.sasl = repo.sasl.withContext(ctx)
+	}
+	if repo.certificates != nil {
+		requestRepo.certificates = repo.certificates.withContext(ctx)
+	}
+	return &requestRepo
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *LimitRepository) WithContext(ctx context.Context) interface{} {
+	return &LimitRepository{repository: repo.repository.withContext(ctx)}
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *OffsetRepository) WithContext(ctx context.Context) interface{} {
+	return &OffsetRepository{listOffsets: repo.listOffsets, repository: repo.repository.withContext(ctx)}
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *QuotaRepository) WithContext(ctx context.Context) interface{} {
+	return &QuotaRepository{kafkaConfig: repo.kafkaConfig, repository: repo.repository.withContext(ctx)}
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *RequestRepository) WithContext(ctx context.Context) interface{} {
+	return &RequestRepository{repository: repo.repository.withContext(ctx)}
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *SASLRepository) WithContext(ctx context.Context) interface{} {
+	return repo.withContext(ctx)
+}
+
+func (repo *SASLRepository) withContext(ctx context.Context) *SASLRepository {
+	return &SASLRepository{kafkaConfig: repo.kafkaConfig, repository: repo.repository.withContext(ctx)}
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *TopicPlanRepository) WithContext(ctx context.Context) interface{} {
+	return repo.withContext(ctx)
+}
+
+func (repo *TopicPlanRepository) withContext(ctx context.Context) *TopicPlanRepository {
+	return &TopicPlanRepository{kafkaConfig: repo.kafkaConfig, repository: repo.repository.withContext(ctx)}
+}
+
+// CreateWithContext is Create for a provision request
+func (repo *TopicPlanRepository) CreateWithContext(ctx context.Context, request broker.ProvisionRequest) error {
+	return repo.withContext(ctx).Create(request.InstanceID, request.Settings)
+}
+
+// DestroyWithContext is Destroy for a deprovision request
+func (repo *TopicPlanRepository) DestroyWithContext(ctx context.Context, request broker.DeprovisionRequest) error {
+	return repo.withContext(ctx).Destroy(request.InstanceID)
+}
+
+// BindWithContext is Bind for a bind request
+func (repo *TopicPlanRepository) BindWithContext(ctx context.Context, request broker.BindRequest) (broker.InstanceCredentials, error) {
+	return repo.withContext(ctx).Bind(request.InstanceID, request.BindingID)
+}
+
+// UnbindWithContext is Unbind for an unbind request
+func (repo *TopicPlanRepository) UnbindWithContext(ctx context.Context, request broker.UnbindRequest) error {
+	return repo.withContext(ctx).Unbind(request.InstanceID, request.BindingID)
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *SharedPlanRepository) WithContext(ctx context.Context) interface{} {
+	return repo.withContext(ctx)
+}
+
+func (repo *SharedPlanRepository) withContext(ctx context.Context) *SharedPlanRepository {
+	return &SharedPlanRepository{kafkaConfig: repo.kafkaConfig, repository: repo.repository.withContext(ctx)}
+}
+
+// CreateWithContext is Create for a provision request
+func (repo *SharedPlanRepository) CreateWithContext(ctx context.Context, request broker.ProvisionRequest) error {
+	return repo.withContext(ctx).Create(request.InstanceID, request.Settings)
+}
+
+// DestroyWithContext is Destroy for a deprovision request
+func (repo *SharedPlanRepository) DestroyWithContext(ctx context.Context, request broker.DeprovisionRequest) error {
+	return repo.withContext(ctx).Destroy(request.InstanceID)
+}
+
+// BindWithContext is Bind for a bind request
+func (repo *SharedPlanRepository) BindWithContext(ctx context.Context, request broker.BindRequest) (broker.InstanceCredentials, error) {
+	return repo.withContext(ctx).Bind(request.InstanceID, request.BindingID)
+}
+
+// UnbindWithContext is Unbind for an unbind request
+func (repo *SharedPlanRepository) UnbindWithContext(ctx context.Context, request broker.UnbindRequest) error {
+	return repo.withContext(ctx).Unbind(request.InstanceID, request.BindingID)
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *CompactedPlanRepository) WithContext(ctx context.Context) interface{} {
+	return repo.withContext(ctx)
+}
+
+func (repo *CompactedPlanRepository) withContext(ctx context.Context) *CompactedPlanRepository {
+	return &CompactedPlanRepository{kafkaConfig: repo.kafkaConfig, repository: repo.repository.withContext(ctx)}
+}
+
+// CreateWithContext is Create for a provision request
+func (repo *CompactedPlanRepository) CreateWithContext(ctx context.Context, request broker.ProvisionRequest) error {
+	return repo.withContext(ctx).Create(request.InstanceID, request.Settings)
+}
+
+// DestroyWithContext is Destroy for a deprovision request
+func (repo *CompactedPlanRepository) DestroyWithContext(ctx context.Context, request broker.DeprovisionRequest) error {
+	return repo.withContext(ctx).Destroy(request.InstanceID)
+}
+
+// BindWithContext is Bind for a bind request
+func (repo *CompactedPlanRepository) BindWithContext(ctx context.Context, request broker.BindRequest) (broker.InstanceCredentials, error) {
+	return repo.withContext(ctx).Bind(request.InstanceID, request.BindingID)
+}
+
+// UnbindWithContext is Unbind for an unbind request
+func (repo *CompactedPlanRepository) UnbindWithContext(ctx context.Context, request broker.UnbindRequest) error {
+	return repo.withContext(ctx).Unbind(request.InstanceID, request.BindingID)
+}
+
+// WithContext returns a copy of the repository for the request of ctx
+func (repo *MultiTopicPlanRepository) WithContext(ctx context.Context) interface{} {
+	return repo.withContext(ctx)
+}
+
+func (repo *MultiTopicPlanRepository) withContext(ctx context.Context) *MultiTopicPlanRepository {
+	return &MultiTopicPlanRepository{kafkaConfig: repo.kafkaConfig, repository: repo.repository.withContext(ctx)}
+}
+
+// CreateWithContext is Create for a provision request
+func (repo *MultiTopicPlanRepository) CreateWithContext(ctx context.Context, request broker.ProvisionRequest) error {
+	return repo.withContext(ctx).Create(request.InstanceID, request.Settings)
+}
+
+// DestroyWithContext is Destroy for a deprovision request
+func (repo *MultiTopicPlanRepository) DestroyWithContext(ctx context.Context, request broker.DeprovisionRequest) error {
+	return repo.withContext(ctx).Destroy(request.InstanceID)
+}
+
+// BindWithContext is Bind for a bind request
+func (repo *MultiTopicPlanRepository) BindWithContext(ctx context.Context, request broker.BindRequest) (broker.InstanceCredentials, error) {
+	return repo.withContext(ctx).Bind(request.InstanceID, request.BindingID)
+}
+
+// UnbindWithContext is Unbind for an unbind request
+func (repo *MultiTopicPlanRepository) UnbindWithContext(ctx context.Context, request broker.UnbindRequest) error {
+	return repo.withContext(ctx).Unbind(request.InstanceID, request.BindingID)
+}
@@ -0,0 +1,446 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// CredentialRotationRepository rotates the SCRAM credentials and client certificates of bindings.
+//
+// Kafka holds one SCRAM credential per mechanism for each user, so a binding's new credentials
+// use the other SCRAM mechanism than its current ones: both stay valid, for the same principal,
+// until the grace period ends. The Kafka listener must enable both SCRAM-SHA-256 and SCRAM-SHA-512.
+// A binding cannot be rotated again before the grace period of its previous rotation ends, as the
+// new rotation would reuse the mechanism still in use by the retired credentials.
+// A binding's new client certificate has the same subject as the one it replaces, whose serial
+// number is added to the CRL when the grace period ends.
+//
+// The replaced credentials are recorded with the binding and revoked by Run once their grace
+// period has ended, each revocation being recorded in the audit log. The new credentials are
+// kept in the secret store, which rotation requires, so that they can be handed to the platform
+// without being written to ZooKeeper.
+type CredentialRotationRepository struct {
+	repository
+	kafkaConfig  brokerconfig.KafkaConfiguration
+	secrets      broker.SecretStore
+	sasl         *SASLRepository
+	certificates *CertificateRepository
+	audit        *AuditRepository
+}
+
+// NewCredentialRotationRepository creates a CredentialRotationRepository; secrets may be nil, in which
+// case credentials cannot be rotated, but those retired by earlier rotations are still revoked
+func NewCredentialRotationRepository(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, secrets broker.SecretStore, logger lager.Logger) *CredentialRotationRepository {
+	repo := &CredentialRotationRepository{
+		repository:  repository{connect: connect, logger: logger},
+		kafkaConfig: kafkaConfig,
+		secrets:     secrets,
+		audit:       NewAuditRepository(connect, logger),
+	}
+	if kafkaConfig.SASLEnabled() {
+		repo.sasl = NewSASLRepository(kafkaConfig, connect, logger)
+	}
+	if kafkaConfig.ClientCertificates.Enabled() {
+		repo.certificates = NewCertificateRepository(kafkaConfig, connect, logger)
+	}
+	return repo
+}
+
+// otherSCRAMMechanism returns the SCRAM mechanism a binding's credentials are rotated to
+func otherSCRAMMechanism(mechanism string) string {
+	if mechanism == brokerconfig.SASLMechanismScramSHA256 {
+		return brokerconfig.SASLMechanismScramSHA512
+	}
+	return brokerconfig.SASLMechanismScramSHA256
+}
+
+// errNoSecretStore is returned when rotating credentials without a secret store to keep the new ones in
+var errNoSecretStore = errors.New("rotating credentials requires a secret store to keep the new credentials in")
+
+// RotateCredentials gives every binding of a service instance new credentials, and revokes the
+// ones they replace once gracePeriod has passed. It fails with broker.ErrRotationPending,
+// rotating no binding, if a binding still has credentials retired by a previous rotation.
+func (repo *CredentialRotationRepository) RotateCredentials(instanceID string, gracePeriod time.Duration) ([]broker.RotatedCredentials, error) {
+	if repo.secrets == nil {
+		return nil, errNoSecretStore
+	}
+	conn, err := repo.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	bindingIDs, err := recordedBindingIDs(conn, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, bindingID := range bindingIDs {
+		binding := bindingRecord{}
+		err = readRecord(conn, bindingRecordPath(instanceID, bindingID), &binding)
+		if err != nil && err != zookeeper.ErrNoNode {
+			return nil, err
+		}
+		if len(binding.Retired) > 0 {
+			return nil, broker.ErrRotationPending
+		}
+	}
+
+	now := time.Now().UTC()
+	rotations := []broker.RotatedCredentials{}
+	for _, bindingID := range bindingIDs {
+		rotated, err := repo.rotateBinding(conn, instanceID, bindingID, now, gracePeriod)
+		if err != nil {
+			return rotations, err
+		}
+		if rotated == nil {
+			continue
+		}
+		rotations = append(rotations, *rotated)
+
+		repo.logger.Info("rotate-credentials", lager.Data{
+			"instance_id": instanceID,
+			"binding_id":  bindingID,
+			"revoke_at":   rotated.RevokeAt,
+			"message":     "Rotated credentials of binding",
+		})
+		if gracePeriod == 0 {
+			if _, err = repo.revokeRetired(conn, instanceID, bindingID, now); err != nil {
+				return rotations, err
+			}
+		}
+	}
+	return rotations, nil
+}
+
+// rotateBinding gives a binding new credentials, and returns them, or nil if it has none to rotate.
+//
+// The rotation is claimed first, by recording the credentials it retires with the binding, so
+// that concurrent rotations are refused with broker.ErrRotationPending rather than replacing
+// each other's credentials. The new credentials are then created, kept in the secret store, and
+// recorded with the binding; each write of the record only succeeds if it was not changed since
+// it was read.
+func (repo *CredentialRotationRepository) rotateBinding(conn zookeeper.Conn, instanceID, bindingID string, now time.Time, gracePeriod time.Duration) (*broker.RotatedCredentials, error) {
+	path := bindingRecordPath(instanceID, bindingID)
+	binding := bindingRecord{}
+	retired := retiredCredentials{}
+	err := updateRecord(conn, path, &binding, func() error {
+		if len(binding.Retired) > 0 {
+			return broker.ErrRotationPending
+		}
+		retired = retiredCredentials{RevokeAt: now.Add(gracePeriod)}
+		if repo.sasl != nil {
+			retired.SASLMechanism = binding.SASLMechanism
+		}
+		if repo.certificates != nil {
+			retired.CertificateSerial = binding.CertificateSerial
+		}
+		if retired.SASLMechanism == "" && retired.CertificateSerial == "" {
+			return errNoUpdate
+		}
+		binding.Retired = []retiredCredentials{retired}
+		return nil
+	})
+	if err != nil || (retired.SASLMechanism == "" && retired.CertificateSerial == "") {
+		return nil, err
+	}
+
+	rotated := broker.RotatedCredentials{BindingID: bindingID, RotatedAt: now, RevokeAt: retired.RevokeAt}
+	serial := ""
+	if retired.SASLMechanism != "" {
+		credentials, err := repo.sasl.createCredentials(conn, instanceID, bindingID, otherSCRAMMechanism(retired.SASLMechanism))
+		if err != nil {
+			return nil, err
+		}
+		rotated.SASL = &credentials
+	}
+	if retired.CertificateSerial != "" {
+		var credentials broker.ClientCertificate
+		if credentials, serial, err = repo.certificates.issue(instanceID, bindingID); err != nil {
+			return nil, err
+		}
+		rotated.Certificate = &credentials
+	}
+	secretName := broker.BindingSecretName(instanceID, bindingID, broker.SecretRotatedCredentials)
+	if err = repo.secrets.Put(secretName, rotated); err != nil {
+		return nil, err
+	}
+
+	err = updateRecord(conn, path, &binding, func() error {
+		if (retired.SASLMechanism != "" && binding.SASLMechanism != retired.SASLMechanism) ||
+			(retired.CertificateSerial != "" && binding.CertificateSerial != retired.CertificateSerial) {
+			return fmt.Errorf("binding %s was deleted while its credentials were rotated", bindingID)
+		}
+		if rotated.SASL != nil {
+			binding.SASLMechanism = rotated.SASL.Mechanism
+		}
+		if serial != "" {
+			binding.CertificateSerial = serial
+		}
+		// the claim may have been dropped if its grace period ended while the credentials were created
+		binding.Retired = []retiredCredentials{retired}
+		binding.RotatedInSecretStore = true
+		return nil
+	})
+	if err != nil {
+		repo.discard(conn, instanceID, bindingID, rotated, serial)
+		return nil, err
+	}
+	return &rotated, nil
+}
+
+// discard revokes the new credentials of a rotation that could not be recorded, logging any failure
+func (repo *CredentialRotationRepository) discard(conn zookeeper.Conn, instanceID, bindingID string, rotated broker.RotatedCredentials, serial string) {
+	errs := []error{}
+	if rotated.SASL != nil {
+		errs = append(errs, repo.sasl.deleteCredentials(conn, instanceID, bindingID, rotated.SASL.Mechanism))
+	}
+	if serial != "" {
+		errs = append(errs, repo.certificates.revokeSerial(serial))
+	}
+	errs = append(errs, repo.secrets.Delete(broker.BindingSecretName(instanceID, bindingID, broker.SecretRotatedCredentials)))
+	for _, err := range errs {
+		if err != nil {
+			repo.logger.Error("rotate-credentials.discard", err, lager.Data{
+				"instance_id": instanceID,
+				"binding_id":  bindingID,
+				"message":     "Failed to revoke the new credentials of a rotation that could not be recorded",
+			})
+		}
+	}
+}
+
+// RotatedCredentials returns the credentials of the latest rotation of each binding of a service instance
+func (repo *CredentialRotationRepository) RotatedCredentials(instanceID string) ([]broker.RotatedCredentials, error) {
+	rotations := []broker.RotatedCredentials{}
+	if repo.secrets == nil {
+		return rotations, nil
+	}
+	conn, err := repo.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	bindingIDs, err := recordedBindingIDs(conn, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, bindingID := range bindingIDs {
+		binding := bindingRecord{}
+		err = readRecord(conn, bindingRecordPath(instanceID, bindingID), &binding)
+		if err == zookeeper.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !binding.RotatedInSecretStore {
+			continue
+		}
+		rotated := broker.RotatedCredentials{}
+		err = repo.secrets.Get(broker.BindingSecretName(instanceID, bindingID, broker.SecretRotatedCredentials), &rotated)
+		if err == broker.ErrSecretNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, rotated)
+	}
+	return rotations, nil
+}
+
+// RemoveBinding revokes the retired credentials of a binding straight away
+func (repo *CredentialRotationRepository) RemoveBinding(instanceID, bindingID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = repo.revokeRetired(conn, instanceID, bindingID, time.Time{})
+	return err
+}
+
+// RemoveInstance revokes the retired credentials of every binding of a service instance
+func (repo *CredentialRotationRepository) RemoveInstance(instanceID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	bindingIDs, err := recordedBindingIDs(conn, instanceID)
+	if err != nil {
+		return err
+	}
+	for _, bindingID := range bindingIDs {
+		if _, err = repo.revokeRetired(conn, instanceID, bindingID, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeExpired revokes the retired credentials of every binding whose grace period has ended
+// by now, and records each revocation in the audit log
+func (repo *CredentialRotationRepository) RevokeExpired(now time.Time) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	instanceIDs, err := recordedInstanceIDs(conn)
+	if err != nil {
+		return err
+	}
+	for _, instanceID := range instanceIDs {
+		bindingIDs, err := recordedBindingIDs(conn, instanceID)
+		if err != nil {
+			return err
+		}
+		for _, bindingID := range bindingIDs {
+			revoked, err := repo.revokeRetired(conn, instanceID, bindingID, now)
+			if err != nil {
+				return err
+			}
+			for _, credentials := range revoked {
+				details := map[string]interface{}{"binding_id": bindingID, "revoke_at": credentials.RevokeAt}
+				if credentials.SASLMechanism != "" {
+					details["sasl_mechanism"] = credentials.SASLMechanism
+				}
+				if credentials.CertificateSerial != "" {
+					details["certificate_serial"] = credentials.CertificateSerial
+				}
+				err = repo.audit.Record(broker.AuditEntry{
+					Time:       now.UTC(),
+					Action:     "revoke-retired-credentials",
+					InstanceID: instanceID,
+					Actor:      broker.ActorCredentialRotation,
+					Details:    details,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// revokeRetired revokes the retired credentials of a binding due by now, or all of them if now
+// is zero, and returns the credentials it revoked. The credentials retired by a rotation that
+// has not finished are still the binding's, and are not revoked. The binding's record is only
+// written if it was not changed since it was read; revocations are repeated if it was.
+func (repo *CredentialRotationRepository) revokeRetired(conn zookeeper.Conn, instanceID, bindingID string, now time.Time) ([]retiredCredentials, error) {
+	binding := bindingRecord{}
+	revoked := []retiredCredentials{}
+	err := updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		revoked = []retiredCredentials{}
+		kept := []retiredCredentials{}
+		for _, credentials := range binding.Retired {
+			if !now.IsZero() && credentials.RevokeAt.After(now) {
+				kept = append(kept, credentials)
+				continue
+			}
+			done, err := repo.revoke(conn, instanceID, bindingID, binding, credentials)
+			if err != nil {
+				return err
+			}
+			if done {
+				revoked = append(revoked, credentials)
+			}
+		}
+		// the binding is being deleted
+		deleted := now.IsZero() && binding.RotatedInSecretStore
+		if len(kept) == len(binding.Retired) && !deleted {
+			return errNoUpdate
+		}
+
+		binding.Retired = kept
+		if len(binding.Retired) == 0 {
+			binding.Retired = nil
+		}
+		if deleted && repo.secrets != nil {
+			err := repo.secrets.Delete(broker.BindingSecretName(instanceID, bindingID, broker.SecretRotatedCredentials))
+			if err != nil && err != broker.ErrSecretNotFound {
+				return err
+			}
+		}
+		if now.IsZero() {
+			binding.RotatedInSecretStore = false
+		}
+		return nil
+	})
+	return revoked, err
+}
+
+// revoke revokes credentials a binding's rotation retired, unless they are still the binding's,
+// and returns whether there were any to revoke
+func (repo *CredentialRotationRepository) revoke(conn zookeeper.Conn, instanceID, bindingID string, binding bindingRecord, credentials retiredCredentials) (bool, error) {
+	if credentials.SASLMechanism == binding.SASLMechanism {
+		credentials.SASLMechanism = ""
+	}
+	if credentials.CertificateSerial == binding.CertificateSerial {
+		credentials.CertificateSerial = ""
+	}
+	if credentials.SASLMechanism != "" {
+		sasl := repo.sasl
+		if sasl == nil {
+			sasl = NewSASLRepository(repo.kafkaConfig, repo.connect, repo.logger)
+		}
+		if err := sasl.deleteCredentials(conn, instanceID, bindingID, credentials.SASLMechanism); err != nil {
+			return false, err
+		}
+	}
+	if credentials.CertificateSerial != "" {
+		certificates := repo.certificates
+		if certificates == nil {
+			certificates = NewCertificateRepository(repo.kafkaConfig, repo.connect, repo.logger)
+		}
+		if err := certificates.revokeSerial(credentials.CertificateSerial); err != nil {
+			return false, err
+		}
+	}
+	if credentials.SASLMechanism == "" && credentials.CertificateSerial == "" {
+		return false, nil
+	}
+	repo.logger.Info("revoke-retired-credentials", lager.Data{
+		"instance_id":        instanceID,
+		"binding_id":         bindingID,
+		"sasl_mechanism":     credentials.SASLMechanism,
+		"certificate_serial": credentials.CertificateSerial,
+		"message":            "Revoked credentials replaced by a rotation",
+	})
+	return true, nil
+}
+
+// Run revokes the retired credentials whose grace period has ended, and signs the CRL again when
+// it is due, every interval until stop is closed
+func (repo *CredentialRotationRepository) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := repo.RevokeExpired(time.Now()); err != nil {
+			repo.logger.Error("revoke-retired-credentials", err)
+		}
+		if repo.certificates != nil {
+			if err := repo.certificates.RefreshCRL(time.Now()); err != nil {
+				repo.logger.Error("refresh-crl", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package kafka_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/secretstore"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("CredentialRotationRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var kafkaConfig brokerconfig.KafkaConfiguration
+	var secretsDir string
+	var secrets *secretstore.FileStore
+	var repo *kafka.CredentialRotationRepository
+
+	auditActions := func() []string {
+		entries, err := kafka.NewAuditRepository(store.Connect, lager.NewLogger("test")).Entries(instanceID)
+		Expect(err).NotTo(HaveOccurred())
+		actions := []string{}
+		for _, entry := range entries {
+			actions = append(actions, entry.Action)
+		}
+		return actions
+	}
+
+	BeforeEach(func() {
+		store = newMemoryStore(1)
+		kafkaConfig = kafkaConfiguration()
+		var err error
+		secretsDir, err = ioutil.TempDir("", "secrets")
+		Expect(err).NotTo(HaveOccurred())
+		secrets = secretstore.NewFileStore(brokerconfig.FileSecretStoreConfiguration{
+			Path: filepath.Join(secretsDir, "secrets"),
+			Key:  bytes.Repeat([]byte{7}, 32),
+		}, "/c/kafka-service-broker")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(secretsDir)).To(Succeed())
+	})
+
+	Context("with SCRAM credentials", func() {
+		var sasl *kafka.SASLRepository
+
+		BeforeEach(func() {
+			kafkaConfig.SecurityProtocol = brokerconfig.SecurityProtocolSASLSSL
+			kafkaConfig.SASLMechanism = brokerconfig.SASLMechanismScramSHA256
+			sasl = kafka.NewSASLRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+			repo = kafka.NewCredentialRotationRepository(kafkaConfig, store.Connect, secrets, lager.NewLogger("test"))
+			_, err := sasl.AddBinding(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("adds credentials with the other mechanism, and revokes the old ones after the grace period", func() {
+			rotations, err := repo.RotateCredentials(instanceID, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotations).To(HaveLen(1))
+			Expect(rotations[0].BindingID).To(Equal("bindingID"))
+			Expect(rotations[0].SASL.Mechanism).To(Equal(brokerconfig.SASLMechanismScramSHA512))
+			Expect(rotations[0].SASL.Username).To(Equal("bindingID"))
+			Expect(rotations[0].RevokeAt).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			config := entityConfig(store, "/config/users/bindingID")
+			Expect(config).To(HaveKey(brokerconfig.SASLMechanismScramSHA256))
+			Expect(config).To(HaveKey(brokerconfig.SASLMechanismScramSHA512))
+			Expect(repo.RotatedCredentials(instanceID)).To(Equal(rotations))
+
+			Expect(repo.RevokeExpired(time.Now())).To(Succeed())
+			Expect(entityConfig(store, "/config/users/bindingID")).To(HaveLen(2))
+			Expect(repo.RevokeExpired(time.Now().Add(2 * time.Hour))).To(Succeed())
+			config = entityConfig(store, "/config/users/bindingID")
+			Expect(config).To(HaveLen(1))
+			Expect(config).To(HaveKey(brokerconfig.SASLMechanismScramSHA512))
+			Expect(auditActions()).To(Equal([]string{"revoke-retired-credentials"}))
+
+			Expect(sasl.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+			Expect(topics(store, "/config/users")).To(BeEmpty())
+		})
+
+		It("refuses to rotate again before the grace period of the previous rotation ends", func() {
+			_, err := repo.RotateCredentials(instanceID, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = repo.RotateCredentials(instanceID, time.Hour)
+			Expect(err).To(Equal(broker.ErrRotationPending))
+			Expect(entityConfig(store, "/config/users/bindingID")).To(HaveLen(2))
+
+			Expect(repo.RevokeExpired(time.Now().Add(2 * time.Hour))).To(Succeed())
+			rotations, err := repo.RotateCredentials(instanceID, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotations[0].SASL.Mechanism).To(Equal(brokerconfig.SASLMechanismScramSHA256))
+			Expect(entityConfig(store, "/config/users/bindingID")).To(HaveLen(2))
+		})
+
+		It("rotates the credentials of a binding once when they are rotated concurrently", func() {
+			errs := make(chan error, 4)
+			for i := 0; i < cap(errs); i++ {
+				go func() {
+					defer GinkgoRecover()
+					_, err := repo.RotateCredentials(instanceID, time.Hour)
+					errs <- err
+				}()
+			}
+			rotated := 0
+			for i := 0; i < cap(errs); i++ {
+				err := <-errs
+				if err == nil {
+					rotated++
+				} else {
+					Expect(err).To(Equal(broker.ErrRotationPending))
+				}
+			}
+			Expect(rotated).To(Equal(1))
+			config := entityConfig(store, "/config/users/bindingID")
+			Expect(config).To(HaveKey(brokerconfig.SASLMechanismScramSHA256))
+			Expect(config).To(HaveKey(brokerconfig.SASLMechanismScramSHA512))
+		})
+
+		It("refuses to rotate credentials without a secret store", func() {
+			repo = kafka.NewCredentialRotationRepository(kafkaConfig, store.Connect, nil, lager.NewLogger("test"))
+			_, err := repo.RotateCredentials(instanceID, time.Hour)
+			Expect(err).To(HaveOccurred())
+			Expect(entityConfig(store, "/config/users/bindingID")).To(HaveLen(1))
+		})
+
+		It("revokes the old credentials straight away without a grace period", func() {
+			_, err := repo.RotateCredentials(instanceID, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entityConfig(store, "/config/users/bindingID")).To(HaveLen(1))
+		})
+
+		It("keeps the new credentials in the secret store rather than in ZooKeeper", func() {
+			rotations, err := repo.RotateCredentials(instanceID, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			conn, err := store.Connect()
+			Expect(err).NotTo(HaveOccurred())
+			record, err := conn.Get(zookeeper.PrivateRoot + "/instances/" + instanceID + "/bindings/bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(record)).NotTo(ContainSubstring(rotations[0].SASL.Password))
+			Expect(repo.RotatedCredentials(instanceID)).To(Equal(rotations))
+
+			Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+			Expect(repo.RotatedCredentials(instanceID)).To(BeEmpty())
+			rotated := broker.RotatedCredentials{}
+			Expect(secrets.Get("instanceID/bindingID/rotated-credentials", &rotated)).To(Equal(broker.ErrSecretNotFound))
+		})
+
+		It("revokes every credential of a binding when it is deleted", func() {
+			_, err := repo.RotateCredentials(instanceID, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+			Expect(repo.RotatedCredentials(instanceID)).To(BeEmpty())
+			Expect(sasl.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+			Expect(topics(store, "/config/users")).To(BeEmpty())
+			Expect(topics(store, zookeeper.PrivateRoot+"/instances/"+instanceID+"/bindings")).To(BeEmpty())
+		})
+	})
+
+	Context("with client certificates", func() {
+		var dir string
+		var certificates *kafka.CertificateRepository
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "certificates")
+			Expect(err).NotTo(HaveOccurred())
+			kafkaConfig.SecurityProtocol = brokerconfig.SecurityProtocolSSL
+			kafkaConfig.ClientCertificates.Validity = time.Hour
+			_, kafkaConfig.ClientCertificates.CACertFile, kafkaConfig.ClientCertificates.CAKeyFile = writeTestCA(dir)
+			kafkaConfig.ClientCertificates.CRLFile = filepath.Join(dir, "clients.crl")
+			certificates = kafka.NewCertificateRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+			repo = kafka.NewCredentialRotationRepository(kafkaConfig, store.Connect, secrets, lager.NewLogger("test"))
+			_, err = certificates.AddBinding(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("issues a new certificate, and revokes the old one after the grace period", func() {
+			rotations, err := repo.RotateCredentials(instanceID, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotations).To(HaveLen(1))
+			Expect(rotations[0].Certificate.Certificate).To(HavePrefix("-----BEGIN CERTIFICATE-----"))
+			Expect(rotations[0].SASL).To(BeNil())
+
+			Expect(repo.RevokeExpired(time.Now())).To(Succeed())
+			_, err = os.Stat(kafkaConfig.ClientCertificates.CRLFile)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(repo.RevokeExpired(time.Now().Add(2 * time.Hour))).To(Succeed())
+			_, err = os.Stat(kafkaConfig.ClientCertificates.CRLFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(auditActions()).To(Equal([]string{"revoke-retired-credentials"}))
+		})
+	})
+
+	It("leaves bindings without SCRAM credentials or certificates alone", func() {
+		repo = kafka.NewCredentialRotationRepository(kafkaConfig, store.Connect, secrets, lager.NewLogger("test"))
+		_, err := kafka.NewQuotaRepository(kafkaConfig, store.Connect, lager.NewLogger("test")).AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.RotateCredentials(instanceID, time.Hour)).To(BeEmpty())
+	})
+})
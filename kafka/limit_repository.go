@@ -0,0 +1,193 @@
+package kafka
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// LimitRepository records the topic limits and topic configuration policy of each service
+// instance, and reports their usage. They apply to every topic whose name starts with the
+// instance ID, i.e. the "topicNamePrefix" of the shared plan. They are enforced by TopicLimitWatcher.
+type LimitRepository struct {
+	repository
+}
+
+// NewLimitRepository creates a LimitRepository
+func NewLimitRepository(connect zookeeper.Connector, logger lager.Logger) *LimitRepository {
+	return &LimitRepository{
+		repository: repository{connect: connect, logger: logger},
+	}
+}
+
+// InstanceLimits returns the topic limits recorded for a service instance
+func (repo *LimitRepository) InstanceLimits(instanceID string) (broker.TopicLimits, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return broker.TopicLimits{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	err = readRecord(conn, instanceRecordPath(instanceID), &record)
+	if err != nil && err != zookeeper.ErrNoNode {
+		return broker.TopicLimits{}, err
+	}
+	return record.TopicLimits, nil
+}
+
+// SetInstanceLimits records the topic limits of a service instance
+func (repo *LimitRepository) SetInstanceLimits(instanceID string, limits broker.TopicLimits) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	err = updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		record.TopicLimits = limits
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	repo.logger.Info("set-instance-topic-limits", lager.Data{
+		"instance_id": instanceID,
+		"limits":      limits,
+		"message":     "Recorded topic limits of service instance",
+	})
+	return nil
+}
+
+// SetInstanceTopicConfigPolicy records the topic configuration policy of a service instance
+func (repo *LimitRepository) SetInstanceTopicConfigPolicy(instanceID string, policy broker.TopicConfigPolicy) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	return updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		record.TopicConfig = policy
+		return nil
+	})
+}
+
+// Usage returns what a service instance currently uses of its topic limits
+func (repo *LimitRepository) Usage(instanceID string) (broker.TopicUsage, error) {
+	cluster, err := zookeeper.OpenCluster(repo.connect)
+	if err != nil {
+		return broker.TopicUsage{}, err
+	}
+	defer func() { _ = cluster.Close() }()
+
+	allTopics, err := cluster.Topics()
+	if err != nil {
+		return broker.TopicUsage{}, err
+	}
+	topics, err := instanceTopics(cluster, allTopics, instanceID)
+	if err != nil {
+		return broker.TopicUsage{}, err
+	}
+	return topicUsage(topics), nil
+}
+
+// RemoveInstance clears the topic limits and topic configuration policy of a service instance,
+// and lifts any denial of topic creation
+func (repo *LimitRepository) RemoveInstance(instanceID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err = zookeeper.NewCluster(conn).RemoveACLs(createDenialResource(instanceID), denyCreateACL); err != nil {
+		return err
+	}
+	record := instanceRecord{}
+	return updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		if record.TopicLimits == (broker.TopicLimits{}) && len(record.TopicConfig.Defaults) == 0 && len(record.TopicConfig.Overridable) == 0 {
+			return errNoUpdate
+		}
+		record.TopicLimits = broker.TopicLimits{}
+		record.TopicConfig = broker.TopicConfigPolicy{}
+		return nil
+	})
+}
+
+// instanceTopic is a topic created under the topic name prefix of a service instance
+type instanceTopic struct {
+	name       string
+	partitions int
+	// config holds the topic's configuration overrides
+	config map[string]string
+	// retentionMs is the topic's retention.ms override; 0 if it has none
+	retentionMs int64
+}
+
+// instanceTopics returns the topics in allTopics whose name starts with instanceID
+func instanceTopics(cluster *zookeeper.Cluster, allTopics []string, instanceID string) ([]instanceTopic, error) {
+	topics := []instanceTopic{}
+	for _, name := range allTopics {
+		if !strings.HasPrefix(name, instanceID) {
+			continue
+		}
+		partitions, err := cluster.PartitionCount(name)
+		if err == zookeeper.ErrNoNode {
+			// deleted since allTopics was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		config, err := cluster.TopicConfig(name)
+		if err != nil && err != zookeeper.ErrNoNode {
+			return nil, err
+		}
+		topic := instanceTopic{name: name, partitions: partitions, config: config}
+		if retention, ok := config["retention.ms"]; ok {
+			if topic.retentionMs, err = strconv.ParseInt(retention, 10, 64); err != nil {
+				return nil, fmt.Errorf("topic %s has an invalid retention.ms '%s'", name, retention)
+			}
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+func topicUsage(topics []instanceTopic) broker.TopicUsage {
+	usage := broker.TopicUsage{}
+	for _, topic := range topics {
+		usage.Topics++
+		usage.Partitions += topic.partitions
+		if usage.MaxRetentionMs >= 0 && (topic.retentionMs < 0 || topic.retentionMs > usage.MaxRetentionMs) {
+			usage.MaxRetentionMs = topic.retentionMs
+		}
+	}
+	return usage
+}
+
+// denyCreateACL denies every principal the creation of topics
+var denyCreateACL = zookeeper.ACL{
+	Principal:      "User:*",
+	PermissionType: "Deny",
+	Operation:      "Create",
+	Host:           "*",
+}
+
+// createDenialResource is every topic under the topic name prefix of a service instance
+func createDenialResource(instanceID string) zookeeper.Resource {
+	return zookeeper.Resource{
+		Type:        zookeeper.ResourceTopic,
+		Name:        instanceID,
+		PatternType: zookeeper.PatternPrefixed,
+	}
+}
@@ -0,0 +1,109 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Special timestamps of a ListOffsets request
+const (
+	// OffsetLatest asks for the log-end offset of a partition, i.e. the offset of the next record
+	OffsetLatest int64 = -1
+	// OffsetEarliest asks for the offset of the oldest record still held by a partition
+	OffsetEarliest int64 = -2
+)
+
+const (
+	listOffsetsAPIKey     = 2
+	listOffsetsAPIVersion = 1
+)
+
+// OffsetLister returns the offset of each of the partitions of a topic led by the Kafka broker
+// at address: the earliest offset whose record has a timestamp at or after timestamp, or the
+// OffsetLatest or OffsetEarliest offset.
+type OffsetLister func(address string, topic string, partitions []int32, timestamp int64) (map[int32]int64, error)
+
+// ListOffsets is an OffsetLister that sends a ListOffsets (v1) request, supported by Kafka 0.10.1
+// and later, over a PLAINTEXT connection
+func ListOffsets(address string, topic string, partitions []int32, timestamp int64) (map[int32]int64, error) {
+	const correlationID = 1
+	var offsets map[int32]int64
+	err := roundTrip(address, listOffsetsRequest(correlationID, topic, partitions, timestamp), func(response io.Reader) error {
+		var err error
+		offsets, err = readListOffsetsResponse(response, correlationID, topic)
+		return err
+	})
+	return offsets, err
+}
+
+// listOffsetsRequest encodes a size delimited ListOffsets (v1) request for the partitions of one topic
+func listOffsetsRequest(correlationID int32, topic string, partitions []int32, timestamp int64) []byte {
+	request := newKafkaRequest(listOffsetsAPIKey, listOffsetsAPIVersion, correlationID)
+	request.write(int32(-1)) // replica ID of a client
+	request.write(int32(1))  // topics
+	request.writeString(topic)
+	request.write(int32(len(partitions)))
+	for _, partition := range partitions {
+		request.write(partition)
+		request.write(timestamp)
+	}
+	return request.bytes()
+}
+
+// readListOffsetsResponse decodes a size delimited ListOffsets (v1) response
+func readListOffsetsResponse(reader io.Reader, correlationID int32, topic string) (map[int32]int64, error) {
+	var size int32
+	if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	response := io.LimitReader(reader, int64(size))
+	var err error
+	read := func(value interface{}) {
+		if err == nil {
+			err = binary.Read(response, binary.BigEndian, value)
+		}
+	}
+	readString := func() string {
+		var length int16
+		read(&length)
+		if err != nil || length < 0 {
+			return ""
+		}
+		value := make([]byte, length)
+		_, err = io.ReadFull(response, value)
+		return string(value)
+	}
+
+	var responseCorrelationID, topicCount int32
+	read(&responseCorrelationID)
+	if err == nil && responseCorrelationID != correlationID {
+		return nil, fmt.Errorf("ListOffsets response has correlation ID %d, expected %d", responseCorrelationID, correlationID)
+	}
+	offsets := map[int32]int64{}
+	read(&topicCount)
+	for i := int32(0); i < topicCount && err == nil; i++ {
+		name := readString()
+		var partitionCount int32
+		read(&partitionCount)
+		for j := int32(0); j < partitionCount && err == nil; j++ {
+			var partition int32
+			var errorCode int16
+			var timestamp, offset int64
+			read(&partition)
+			read(&errorCode)
+			read(&timestamp)
+			read(&offset)
+			if err == nil && errorCode != 0 {
+				return nil, fmt.Errorf("ListOffsets of %s partition %d failed with Kafka error code %d", name, partition, errorCode)
+			}
+			if name == topic {
+				offsets[partition] = offset
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read ListOffsets response: %v", err)
+	}
+	return offsets, nil
+}
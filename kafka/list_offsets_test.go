@@ -0,0 +1,112 @@
+package kafka_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+)
+
+// listOffsetsRequest is what a fake Kafka broker received in a ListOffsets request
+type listOffsetsRequest struct {
+	apiKey, apiVersion int16
+	topic              string
+	timestamps         map[int32]int64
+}
+
+// fakeKafkaBroker answers one ListOffsets (v1) request with offsets, and returns its address
+func fakeKafkaBroker(offsets map[int32]int64, errorCode int16) (string, <-chan listOffsetsRequest) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	requests := make(chan listOffsetsRequest, 1)
+
+	go func() {
+		defer GinkgoRecover()
+		defer func() { _ = listener.Close() }()
+		conn, err := listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = conn.Close() }()
+
+		read := func(value interface{}) { Expect(binary.Read(conn, binary.BigEndian, value)).To(Succeed()) }
+		readString := func() string {
+			var length int16
+			read(&length)
+			value := make([]byte, length)
+			_, err := io.ReadFull(conn, value)
+			Expect(err).NotTo(HaveOccurred())
+			return string(value)
+		}
+		var size, correlationID, replicaID, topicCount, partitionCount int32
+		request := listOffsetsRequest{timestamps: map[int32]int64{}}
+		read(&size)
+		read(&request.apiKey)
+		read(&request.apiVersion)
+		read(&correlationID)
+		readString()
+		read(&replicaID)
+		read(&topicCount)
+		request.topic = readString()
+		read(&partitionCount)
+		for i := int32(0); i < partitionCount; i++ {
+			var partition int32
+			var timestamp int64
+			read(&partition)
+			read(&timestamp)
+			request.timestamps[partition] = timestamp
+		}
+		requests <- request
+
+		body := &bytes.Buffer{}
+		write := func(value interface{}) { _ = binary.Write(body, binary.BigEndian, value) }
+		write(correlationID)
+		write(int32(1))
+		write(int16(len(request.topic)))
+		body.WriteString(request.topic)
+		write(partitionCount)
+		for partition := range request.timestamps {
+			write(partition)
+			write(errorCode)
+			write(int64(-1))
+			write(offsets[partition])
+		}
+		Expect(binary.Write(conn, binary.BigEndian, int32(body.Len()))).To(Succeed())
+		_, err = conn.Write(body.Bytes())
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	return listener.Addr().String(), requests
+}
+
+var _ = Describe("ListOffsets", func() {
+	It("lists the offsets of the partitions of a topic", func() {
+		address, requests := fakeKafkaBroker(map[int32]int64{0: 100, 1: 250}, 0)
+		offsets, err := kafka.ListOffsets(address, "topic", []int32{0, 1}, kafka.OffsetLatest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(offsets).To(Equal(map[int32]int64{0: 100, 1: 250}))
+
+		request := <-requests
+		Expect(request.apiKey).To(Equal(int16(2)))
+		Expect(request.apiVersion).To(Equal(int16(1)))
+		Expect(request.topic).To(Equal("topic"))
+		Expect(request.timestamps).To(Equal(map[int32]int64{0: -1, 1: -1}))
+	})
+
+	It("returns Kafka errors", func() {
+		address, _ := fakeKafkaBroker(map[int32]int64{0: 100}, 6)
+		_, err := kafka.ListOffsets(address, "topic", []int32{0}, kafka.OffsetEarliest)
+		Expect(err).To(MatchError("ListOffsets of topic partition 0 failed with Kafka error code 6"))
+	})
+
+	It("returns connection errors", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+		_, err = kafka.ListOffsets(address, "topic", []int32{0}, kafka.OffsetLatest)
+		Expect(err).To(HaveOccurred())
+	})
+})
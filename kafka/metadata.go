@@ -0,0 +1,120 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	metadataAPIKey     = 3
+	metadataAPIVersion = 0
+)
+
+// ListTopics returns the names of the topics of the cluster of the Kafka broker at address, from a
+// Metadata (v0) request for all topics. Asking for all topics, rather than one, never has the broker
+// auto-create a topic. The request is sent over a PLAINTEXT connection.
+func ListTopics(address string) ([]string, error) {
+	const correlationID = 1
+	var topics []string
+	err := roundTrip(address, metadataRequest(correlationID), func(response io.Reader) error {
+		var err error
+		topics, err = readMetadataResponse(response, correlationID)
+		return err
+	})
+	return topics, err
+}
+
+// TopicExists checks for topic with the first of the comma separated bootstrap servers that answers
+func TopicExists(bootstrapServers string, topic string) (bool, error) {
+	var err error
+	for _, address := range strings.Split(bootstrapServers, ",") {
+		var topics []string
+		if topics, err = ListTopics(strings.TrimSpace(address)); err != nil {
+			continue
+		}
+		for _, name := range topics {
+			if name == topic {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, err
+}
+
+// metadataRequest encodes a size delimited Metadata (v0) request for all topics
+func metadataRequest(correlationID int32) []byte {
+	request := newKafkaRequest(metadataAPIKey, metadataAPIVersion, correlationID)
+	request.write(int32(0)) // no topics, i.e. all topics
+	return request.bytes()
+}
+
+// readMetadataResponse decodes the topic names of a size delimited Metadata (v0) response
+func readMetadataResponse(reader io.Reader, correlationID int32) ([]string, error) {
+	var size int32
+	if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	response := io.LimitReader(reader, int64(size))
+	var err error
+	read := func(value interface{}) {
+		if err == nil {
+			err = binary.Read(response, binary.BigEndian, value)
+		}
+	}
+	readString := func() string {
+		var length int16
+		read(&length)
+		if err != nil || length < 0 {
+			return ""
+		}
+		value := make([]byte, length)
+		_, err = io.ReadFull(response, value)
+		return string(value)
+	}
+	skipInt32s := func() {
+		var count, value int32
+		read(&count)
+		for i := int32(0); i < count && err == nil; i++ {
+			read(&value)
+		}
+	}
+
+	var responseCorrelationID, brokerCount, topicCount int32
+	read(&responseCorrelationID)
+	if err == nil && responseCorrelationID != correlationID {
+		return nil, fmt.Errorf("Metadata response has correlation ID %d, expected %d", responseCorrelationID, correlationID)
+	}
+	read(&brokerCount)
+	for i := int32(0); i < brokerCount && err == nil; i++ {
+		var nodeID, port int32
+		read(&nodeID)
+		readString()
+		read(&port)
+	}
+	topics := []string{}
+	read(&topicCount)
+	for i := int32(0); i < topicCount && err == nil; i++ {
+		var errorCode int16
+		var partitionCount int32
+		read(&errorCode)
+		name := readString()
+		read(&partitionCount)
+		for j := int32(0); j < partitionCount && err == nil; j++ {
+			var partitionErrorCode int16
+			var partition, leader int32
+			read(&partitionErrorCode)
+			read(&partition)
+			read(&leader)
+			skipInt32s() // replicas
+			skipInt32s() // in-sync replicas
+		}
+		topics = append(topics, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Metadata response: %v", err)
+	}
+	return topics, nil
+}
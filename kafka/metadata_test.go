@@ -0,0 +1,96 @@
+package kafka_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+)
+
+// fakeMetadataBroker answers one Metadata (v0) request with topics, each with one partition,
+// and returns its address and the number of topics requested
+func fakeMetadataBroker(topics ...string) (string, <-chan int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	requested := make(chan int32, 1)
+
+	go func() {
+		defer GinkgoRecover()
+		defer func() { _ = listener.Close() }()
+		conn, err := listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { _ = conn.Close() }()
+
+		read := func(value interface{}) { Expect(binary.Read(conn, binary.BigEndian, value)).To(Succeed()) }
+		var size, correlationID, topicCount int32
+		var apiKey, apiVersion, clientIDLength int16
+		read(&size)
+		read(&apiKey)
+		read(&apiVersion)
+		Expect(apiKey).To(Equal(int16(3)))
+		Expect(apiVersion).To(Equal(int16(0)))
+		read(&correlationID)
+		read(&clientIDLength)
+		_, err = io.ReadFull(conn, make([]byte, clientIDLength))
+		Expect(err).NotTo(HaveOccurred())
+		read(&topicCount)
+		requested <- topicCount
+
+		body := &bytes.Buffer{}
+		write := func(value interface{}) { _ = binary.Write(body, binary.BigEndian, value) }
+		writeString := func(value string) {
+			write(int16(len(value)))
+			body.WriteString(value)
+		}
+		write(correlationID)
+		write(int32(1)) // brokers
+		write(int32(0))
+		writeString("localhost")
+		write(int32(9092))
+		write(int32(len(topics)))
+		for _, topic := range topics {
+			write(int16(0))
+			writeString(topic)
+			write(int32(1)) // partitions
+			write(int16(0))
+			write(int32(0))
+			write(int32(0))
+			write(int32(1)) // replicas
+			write(int32(0))
+			write(int32(1)) // in-sync replicas
+			write(int32(0))
+		}
+		Expect(binary.Write(conn, binary.BigEndian, int32(body.Len()))).To(Succeed())
+		_, err = conn.Write(body.Bytes())
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	return listener.Addr().String(), requested
+}
+
+var _ = Describe("Metadata", func() {
+	It("lists every topic of the cluster", func() {
+		address, requested := fakeMetadataBroker("topic-1", "topic-2")
+		Expect(kafka.ListTopics(address)).To(Equal([]string{"topic-1", "topic-2"}))
+		Expect(<-requested).To(BeZero())
+	})
+
+	It("finds topics with the first bootstrap server that answers", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		unavailable := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		address, _ := fakeMetadataBroker("topic-1", "topic-2")
+		Expect(kafka.TopicExists(unavailable+","+address, "topic-2")).To(BeTrue())
+		address, _ = fakeMetadataBroker("topic-1")
+		Expect(kafka.TopicExists(address, "topic-2")).To(BeFalse())
+
+		_, err = kafka.TopicExists(unavailable, "topic-1")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,319 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/wvanbergen/kazoo-go"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// maxTopicNameLength is the longest topic name Kafka accepts
+const maxTopicNameLength = 249
+
+// MultiTopicPlanRepository describes the creation/binding of kafka service instances holding
+// a set of topics named in the "topics" parameter, e.g.
+//
+//	{"topics": [{"name": "orders", "partitions": 6}, {"name": "orders-dlq"}]}
+//
+// Each logical topic is created as "<instanceID>.<name>". The set is recorded on the instance,
+// can be changed by updating the instance, and is returned to bindings as a "topics" map.
+type MultiTopicPlanRepository struct {
+	repository
+	kafkaConfig brokerconfig.KafkaConfiguration
+}
+
+// NewMultiTopicPlanRepository creates a MultiTopicPlanRepository
+func NewMultiTopicPlanRepository(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, logger lager.Logger) *MultiTopicPlanRepository {
+	return &MultiTopicPlanRepository{
+		repository:  repository{connect: connect, logger: logger},
+		kafkaConfig: kafkaConfig,
+	}
+}
+
+// InstanceExists returns true if instanceID belongs to an existing service instance
+func (repo *MultiTopicPlanRepository) InstanceExists(instanceID string) (bool, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	err = readRecord(conn, instanceRecordPath(instanceID), &record)
+	if err == zookeeper.ErrNoNode {
+		return false, nil
+	}
+	return record.Topics != nil, err
+}
+
+// Create will create a topic for each of the requested topics, and record them on the instance.
+// If any topic cannot be created, those already created are deleted.
+func (repo *MultiTopicPlanRepository) Create(instanceID string, settings broker.InstanceSettings) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	// claim the instance's set of topics before creating any, so that concurrent requests
+	// cannot both create it
+	record := instanceRecord{}
+	err = updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		if record.Topics != nil {
+			return kazoo.ErrTopicExists
+		}
+		record.Topics = map[string]string{}
+		record.TopicSetConfig = settings.TopicConfig
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	created := map[string]string{}
+	cluster := zookeeper.NewCluster(conn)
+	for _, topic := range settings.Topics {
+		physical, err := repo.createTopic(cluster, instanceID, topic, settings.TopicConfig)
+		if err != nil {
+			repo.release(conn, cluster, instanceID, created)
+			return err
+		}
+		created[topic.Name] = physical
+	}
+	err = updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		if record.Topics == nil {
+			record.Topics = map[string]string{}
+		}
+		for name, physical := range created {
+			record.Topics[name] = physical
+		}
+		return nil
+	})
+	if err != nil {
+		repo.release(conn, cluster, instanceID, created)
+		return err
+	}
+
+	repo.logger.Info("provision-instance", lager.Data{
+		"instance_id": instanceID,
+		"plan":        "multi-topic",
+		"topics":      record.Topics,
+		"message":     "Successfully provisioned Kafka multi-topic plan instance",
+	})
+
+	return nil
+}
+
+// UpdateTopics creates the requested topics the instance does not have yet, and deletes
+// those no longer requested. The partitions of existing topics cannot be changed.
+// If the instance's topics are changed concurrently, the update is undone and tried again.
+func (repo *MultiTopicPlanRepository) UpdateTopics(instanceID string, topics []broker.TopicSpec) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	for attempt := 0; attempt < maxRecordUpdateAttempts; attempt++ {
+		err = repo.updateTopics(conn, instanceID, topics)
+		if err != zookeeper.ErrBadVersion {
+			return err
+		}
+	}
+	return errRecordConflict
+}
+
+// updateTopics updates the instance's topics once, returning zookeeper.ErrBadVersion, with any
+// topics it created deleted again, if its record was changed in the meantime
+func (repo *MultiTopicPlanRepository) updateTopics(conn zookeeper.Conn, instanceID string, topics []broker.TopicSpec) error {
+	data, version, err := conn.GetVersion(instanceRecordPath(instanceID))
+	if err != nil {
+		return err
+	}
+	record := instanceRecord{}
+	if len(data) == 0 {
+		return zookeeper.ErrNoNode
+	}
+	if err = json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	cluster := zookeeper.NewCluster(conn)
+
+	requested := map[string]bool{}
+	added := []broker.TopicSpec{}
+	for _, topic := range topics {
+		requested[topic.Name] = true
+		physical, ok := record.Topics[topic.Name]
+		if !ok {
+			added = append(added, topic)
+			continue
+		}
+		if topic.Partitions == 0 {
+			continue
+		}
+		partitions, err := cluster.PartitionCount(physical)
+		if err != nil {
+			return err
+		}
+		if partitions != topic.Partitions {
+			return fmt.Errorf("topic '%s' has %d partitions; changing the partitions of a topic is not supported", topic.Name, partitions)
+		}
+	}
+	removed := map[string]string{}
+	for name, physical := range record.Topics {
+		if !requested[name] {
+			removed[name] = physical
+		}
+	}
+
+	created := map[string]string{}
+	for _, topic := range added {
+		physical, err := repo.createTopic(cluster, instanceID, topic, record.TopicSetConfig)
+		if err != nil {
+			repo.deleteTopics(cluster, instanceID, created)
+			return err
+		}
+		created[topic.Name] = physical
+	}
+	for name, physical := range created {
+		record.Topics[name] = physical
+	}
+	for name := range removed {
+		delete(record.Topics, name)
+	}
+	if data, err = json.Marshal(record); err != nil {
+		repo.deleteTopics(cluster, instanceID, created)
+		return err
+	}
+	if err = conn.SetVersion(instanceRecordPath(instanceID), data, version); err != nil {
+		repo.deleteTopics(cluster, instanceID, created)
+		return err
+	}
+	repo.deleteTopics(cluster, instanceID, removed)
+
+	repo.logger.Info("update-instance", lager.Data{
+		"instance_id": instanceID,
+		"plan":        "multi-topic",
+		"added":       created,
+		"removed":     removed,
+		"message":     "Successfully updated the topics of Kafka multi-topic plan instance",
+	})
+	return nil
+}
+
+// createTopic creates the topic on the Kafka cluster for a logical topic, and returns its name
+func (repo *MultiTopicPlanRepository) createTopic(cluster *zookeeper.Cluster, instanceID string, topic broker.TopicSpec, topicConfig map[string]string) (string, error) {
+	physical := instanceID + "." + topic.Name
+	if len(physical) > maxTopicNameLength {
+		return "", fmt.Errorf("topic name '%s' is too long; Kafka topic names are limited to %d characters", physical, maxTopicNameLength)
+	}
+	partitions := topic.Partitions
+	if partitions == 0 {
+		partitions = repo.kafkaConfig.KafkaPartitionCount
+	}
+	config := map[string]string{}
+	for key, value := range topicConfig {
+		config[key] = value
+	}
+	return physical, cluster.CreateTopic(physical, partitions, repo.kafkaConfig.KafkaReplicationFactor, config)
+}
+
+// release deletes the topics created for a failed provision, and gives up the instance's claim
+// on its set of topics, logging any failure
+func (repo *MultiTopicPlanRepository) release(conn zookeeper.Conn, cluster *zookeeper.Cluster, instanceID string, created map[string]string) {
+	repo.deleteTopics(cluster, instanceID, created)
+	record := instanceRecord{}
+	err := updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		record.Topics = nil
+		record.TopicSetConfig = nil
+		return nil
+	})
+	if err != nil {
+		repo.logger.Error("provision-instance", err, lager.Data{
+			"instance_id": instanceID,
+			"plan":        "multi-topic",
+			"message":     "Failed to release the topics of a failed Kafka multi-topic plan instance",
+		})
+	}
+}
+
+// deleteTopics deletes topics on the Kafka cluster, logging any that cannot be deleted
+func (repo *MultiTopicPlanRepository) deleteTopics(cluster *zookeeper.Cluster, instanceID string, topics map[string]string) {
+	for _, physical := range topics {
+		err := cluster.DeleteTopic(physical)
+		if err != nil && err != kazoo.ErrTopicMarkedForDelete {
+			repo.logger.Error("delete-topic", err, lager.Data{
+				"instance_id": instanceID,
+				"plan":        "multi-topic",
+				"topic.name":  physical,
+				"message":     "Failed to delete Kafka topic",
+			})
+		}
+	}
+}
+
+// Destroy will destroy any topics associated with the service instance, and forget its set of topics
+// Currently "associated with" is inferred - any topic name with instanceID as a prefix
+func (repo *MultiTopicPlanRepository) Destroy(instanceID string) error {
+	if err := destroyInstanceTopics(repo.connect, repo.logger, instanceID, "multi-topic"); err != nil {
+		return err
+	}
+
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	return updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		if record.Topics == nil && record.TopicSetConfig == nil {
+			return errNoUpdate
+		}
+		record.Topics = nil
+		record.TopicSetConfig = nil
+		return nil
+	})
+}
+
+// Bind provides the credentials to access the Kafka cluster, and the names of the instance's topics
+func (repo *MultiTopicPlanRepository) Bind(instanceID string, bindingID string) (broker.InstanceCredentials, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	if err = readRecord(conn, instanceRecordPath(instanceID), &record); err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	repo.logger.Info("bind-instance", lager.Data{
+		"instance_id": instanceID,
+		"binding_id":  bindingID,
+		"plan":        "multi-topic",
+		"message":     "Successful bind of Kafka multi-topic plan instance",
+	})
+	return broker.InstanceCredentials{
+		ZookeeperPeers: repo.kafkaConfig.ZookeeperPeers,
+		KafkaHostnames: repo.kafkaConfig.KafkaHostnames,
+		Topics:         record.Topics,
+	}, nil
+}
+
+// Unbind is a no-op as bindings are shared across all instances
+func (repo *MultiTopicPlanRepository) Unbind(instanceID string, bindingID string) error {
+	repo.logger.Info("unbind-instance", lager.Data{
+		"instance_id": instanceID,
+		"binding_id":  bindingID,
+		"plan":        "multi-topic",
+		"message":     "Successful unbind of Kafka multi-topic plan instance",
+	})
+	return nil
+}
@@ -0,0 +1,173 @@
+package kafka_test
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("MultiTopicPlanRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var kafkaConfig brokerconfig.KafkaConfiguration
+	var repo *kafka.MultiTopicPlanRepository
+
+	partitionCount := func(name string) int {
+		conn, err := store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		partitions, err := zookeeper.NewCluster(conn).PartitionCount(name)
+		Expect(err).NotTo(HaveOccurred())
+		return partitions
+	}
+
+	BeforeEach(func() {
+		store = newMemoryStore(3)
+		kafkaConfig = kafkaConfiguration()
+		repo = kafka.NewMultiTopicPlanRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+	})
+
+	Describe(".Create", func() {
+		It("creates each topic prefixed with the instance ID", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{Topics: []broker.TopicSpec{
+				{Name: "orders", Partitions: 6},
+				{Name: "payments"},
+			}})).To(Succeed())
+			Expect(topics(store, "/brokers/topics")).To(ConsistOf("instanceID.orders", "instanceID.payments"))
+			Expect(partitionCount("instanceID.orders")).To(Equal(6))
+			Expect(partitionCount("instanceID.payments")).To(Equal(kafkaConfig.KafkaPartitionCount))
+			Expect(repo.InstanceExists(instanceID)).To(BeTrue())
+		})
+
+		It("records an instance without topics", func() {
+			Expect(repo.InstanceExists(instanceID)).To(BeFalse())
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			Expect(repo.InstanceExists(instanceID)).To(BeTrue())
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).NotTo(Succeed())
+		})
+
+		It("creates an instance once when it is created concurrently", func() {
+			errs := make(chan error, 2)
+			for _, name := range []string{"orders", "payments"} {
+				go func(name string) {
+					defer GinkgoRecover()
+					errs <- repo.Create(instanceID, broker.InstanceSettings{Topics: []broker.TopicSpec{{Name: name}}})
+				}(name)
+			}
+			results := []error{<-errs, <-errs}
+			Expect(results).To(ContainElement(BeNil()))
+			Expect(results).To(ContainElement(HaveOccurred()))
+
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.Topics).To(HaveLen(1))
+		})
+
+		It("deletes the topics it created when one cannot be created", func() {
+			err := repo.Create(instanceID, broker.InstanceSettings{Topics: []broker.TopicSpec{
+				{Name: "orders"},
+				{Name: strings.Repeat("x", 250)},
+			}})
+			Expect(err).To(MatchError(ContainSubstring("is too long")))
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf("instanceID.orders"))
+			Expect(repo.InstanceExists(instanceID)).To(BeFalse())
+		})
+	})
+
+	Describe(".UpdateTopics", func() {
+		BeforeEach(func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{
+				Topics:      []broker.TopicSpec{{Name: "orders", Partitions: 6}, {Name: "payments"}},
+				TopicConfig: map[string]string{"retention.ms": "3600000"},
+			})).To(Succeed())
+		})
+
+		It("creates new topics and deletes those no longer listed", func() {
+			Expect(repo.UpdateTopics(instanceID, []broker.TopicSpec{{Name: "orders"}, {Name: "refunds"}})).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf("instanceID.payments"))
+			Expect(topics(store, "/config/topics")).To(ContainElement("instanceID.refunds"))
+			Expect(entityConfig(store, "/config/topics/instanceID.refunds")).To(HaveKeyWithValue("retention.ms", "3600000"))
+
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.Topics).To(Equal(map[string]string{
+				"orders":  "instanceID.orders",
+				"refunds": "instanceID.refunds",
+			}))
+		})
+
+		It("applies concurrent updates one after the other", func() {
+			errs := make(chan error, 2)
+			for _, name := range []string{"refunds", "invoices"} {
+				go func(name string) {
+					defer GinkgoRecover()
+					errs <- repo.UpdateTopics(instanceID, []broker.TopicSpec{{Name: "orders"}, {Name: name}})
+				}(name)
+			}
+			Expect(<-errs).To(Succeed())
+			Expect(<-errs).To(Succeed())
+
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.Topics).To(HaveLen(2))
+			Expect(credentials.Topics).To(HaveKey("orders"))
+			for name, physical := range credentials.Topics {
+				Expect(topics(store, "/admin/delete_topics")).NotTo(ContainElement(physical), name)
+			}
+		})
+
+		It("keeps a quota set concurrently", func() {
+			quotas := kafka.NewQuotaRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+			quota := broker.Quota{ProducerByteRate: 1024}
+			errs := make(chan error, 2)
+			go func() {
+				defer GinkgoRecover()
+				errs <- repo.UpdateTopics(instanceID, []broker.TopicSpec{{Name: "orders"}, {Name: "refunds"}})
+			}()
+			go func() {
+				defer GinkgoRecover()
+				errs <- quotas.SetInstanceQuota(instanceID, quota)
+			}()
+			Expect(<-errs).To(Succeed())
+			Expect(<-errs).To(Succeed())
+
+			Expect(quotas.InstanceQuota(instanceID)).To(Equal(quota))
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.Topics).To(HaveKey("refunds"))
+		})
+
+		It("refuses to change the partitions of a topic", func() {
+			Expect(repo.UpdateTopics(instanceID, []broker.TopicSpec{{Name: "orders", Partitions: 12}})).To(
+				MatchError("topic 'orders' has 6 partitions; changing the partitions of a topic is not supported"))
+			Expect(topics(store, "/admin/delete_topics")).To(BeEmpty())
+		})
+	})
+
+	Describe(".Bind", func() {
+		It("maps each logical topic name to its topic", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{Topics: []broker.TopicSpec{{Name: "orders"}}})).To(Succeed())
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.Topics).To(Equal(map[string]string{"orders": "instanceID.orders"}))
+			Expect(credentials.TopicName).To(BeEmpty())
+			Expect(credentials.KafkaHostnames).To(Equal(kafkaConfig.KafkaHostnames))
+		})
+	})
+
+	Describe(".Destroy", func() {
+		It("deletes the topics and forgets the instance", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{Topics: []broker.TopicSpec{{Name: "orders"}}})).To(Succeed())
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf("instanceID.orders"))
+			Expect(repo.InstanceExists(instanceID)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,283 @@
+package kafka
+
+import (
+	"fmt"
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// OffsetRepository reports the consumer groups that have committed offsets to ZooKeeper
+// for the topics of a service instance, i.e. every topic whose name starts with the instance ID,
+// and resets those offsets. Their lag is computed from the log-end offsets listed by the leader
+// of each partition.
+type OffsetRepository struct {
+	repository
+	listOffsets OffsetLister
+}
+
+// NewOffsetRepository creates an OffsetRepository; listOffsets is usually ListOffsets
+func NewOffsetRepository(connect zookeeper.Connector, listOffsets OffsetLister, logger lager.Logger) *OffsetRepository {
+	return &OffsetRepository{
+		repository:  repository{connect: connect, logger: logger},
+		listOffsets: listOffsets,
+	}
+}
+
+// ConsumerGroupOffsets reports the committed offsets and lag of each consumer group of the
+// topics of a service instance. Lag is omitted for partitions whose log-end offset cannot be listed.
+func (repo *OffsetRepository) ConsumerGroupOffsets(instanceID string) ([]broker.ConsumerGroupOffsets, error) {
+	cluster, err := zookeeper.OpenCluster(repo.connect)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cluster.Close() }()
+
+	groups, err := cluster.ConsumerGroups()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(groups)
+	logEndOffsets := map[string]map[int32]int64{}
+	reports := []broker.ConsumerGroupOffsets{}
+	for _, group := range groups {
+		committed, err := cluster.ConsumerGroupOffsets(group)
+		if err != nil {
+			return nil, err
+		}
+		report := broker.ConsumerGroupOffsets{Group: group, Partitions: []broker.PartitionOffsets{}}
+		for _, topic := range sortedTopics(committed) {
+			if !broker.BelongsToInstance(topic, instanceID) {
+				continue
+			}
+			if _, ok := logEndOffsets[topic]; !ok {
+				logEndOffsets[topic], err = repo.topicOffsets(cluster, topic, OffsetLatest)
+				if err != nil {
+					repo.logger.Error("list-offsets", err, lager.Data{
+						"instance_id": instanceID,
+						"topic.name":  topic,
+						"message":     "Failed to list log-end offsets; lag is not reported",
+					})
+				}
+			}
+			for _, partition := range sortedPartitions(committed[topic]) {
+				offsets := broker.PartitionOffsets{Topic: topic, Partition: partition, Offset: committed[topic][partition]}
+				if logEndOffset, ok := logEndOffsets[topic][partition]; ok {
+					lag := logEndOffset - offsets.Offset
+					if lag < 0 {
+						lag = 0
+					}
+					offsets.LogEndOffset = &logEndOffset
+					offsets.Lag = &lag
+				}
+				report.Partitions = append(report.Partitions, offsets)
+			}
+		}
+		if len(report.Partitions) == 0 {
+			continue
+		}
+		members, err := cluster.ConsumerGroupMembers(group)
+		if err != nil {
+			return nil, err
+		}
+		report.Members = len(members)
+		report.Lag = totalLag(report.Partitions)
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// ResetOffsets resets the offsets a consumer group has committed for the topics of a service instance.
+// The new offset of every partition is resolved before any is committed, so a reset that cannot
+// be resolved moves no partition; a failure while committing can leave some partitions moved,
+// and repeating the reset moves the rest.
+func (repo *OffsetRepository) ResetOffsets(instanceID string, reset broker.OffsetReset) ([]broker.PartitionOffsets, error) {
+	cluster, err := zookeeper.OpenCluster(repo.connect)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cluster.Close() }()
+
+	members, err := cluster.ConsumerGroupMembers(reset.Group)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) > 0 && !reset.Force {
+		return nil, broker.ErrConsumerGroupActive
+	}
+	topics, err := repo.resetTopics(cluster, instanceID, reset)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := map[string]map[int32]int64{}
+	for _, topic := range topics {
+		if targets[topic], err = repo.resetTargets(cluster, topic, reset); err != nil {
+			return nil, err
+		}
+	}
+	offsets := []broker.PartitionOffsets{}
+	for _, topic := range topics {
+		if err = cluster.ResetOffsets(reset.Group, topic); err != nil {
+			return nil, err
+		}
+		for _, partition := range sortedPartitions(targets[topic]) {
+			offset := targets[topic][partition]
+			if err = cluster.CommitOffset(reset.Group, topic, partition, offset); err != nil {
+				return nil, err
+			}
+			offsets = append(offsets, broker.PartitionOffsets{Topic: topic, Partition: partition, Offset: offset})
+		}
+	}
+	repo.logger.Info("reset-offsets", lager.Data{
+		"instance_id": instanceID,
+		"group":       reset.Group,
+		"to":          reset.To,
+		"force":       reset.Force,
+		"members":     len(members),
+		"offsets":     offsets,
+	})
+	return offsets, nil
+}
+
+// resetTopics returns the topics a reset applies to: the topic it names, or else every topic
+// of the instance the group has committed offsets for
+func (repo *OffsetRepository) resetTopics(cluster *zookeeper.Cluster, instanceID string, reset broker.OffsetReset) ([]string, error) {
+	if reset.Topic != "" {
+		exists, err := cluster.TopicExists(reset.Topic)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("topic '%s' does not exist", reset.Topic)
+		}
+		return []string{reset.Topic}, nil
+	}
+	committed, err := cluster.ConsumerGroupOffsets(reset.Group)
+	if err != nil {
+		return nil, err
+	}
+	topics := []string{}
+	for _, topic := range sortedTopics(committed) {
+		if broker.BelongsToInstance(topic, instanceID) {
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("consumer group '%s' has not committed offsets for any topic of the instance; name the topic to reset", reset.Group)
+	}
+	return topics, nil
+}
+
+// resetTargets resolves the offset each partition of a topic is reset to
+func (repo *OffsetRepository) resetTargets(cluster *zookeeper.Cluster, topic string, reset broker.OffsetReset) (map[int32]int64, error) {
+	partitionCount, err := cluster.PartitionCount(topic)
+	if err != nil {
+		return nil, err
+	}
+	targets := map[int32]int64{}
+	if reset.To == broker.OffsetResetOffset {
+		for partition := int32(0); partition < int32(partitionCount); partition++ {
+			targets[partition] = *reset.Offset
+		}
+		return targets, nil
+	}
+
+	timestamp := OffsetLatest
+	switch reset.To {
+	case broker.OffsetResetEarliest:
+		timestamp = OffsetEarliest
+	case broker.OffsetResetTimestamp:
+		timestamp = *reset.Timestamp
+	}
+	listed, err := repo.topicOffsets(cluster, topic, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	var logEndOffsets map[int32]int64
+	for partition := int32(0); partition < int32(partitionCount); partition++ {
+		offset, ok := listed[partition]
+		if !ok {
+			return nil, fmt.Errorf("the offset of partition %d of %s could not be listed", partition, topic)
+		}
+		if offset < 0 {
+			// no record was written at or after the timestamp, so the group skips to the end of the partition
+			if logEndOffsets == nil {
+				if logEndOffsets, err = repo.topicOffsets(cluster, topic, OffsetLatest); err != nil {
+					return nil, err
+				}
+			}
+			offset = logEndOffsets[partition]
+		}
+		targets[partition] = offset
+	}
+	return targets, nil
+}
+
+// topicOffsets lists the offsets of every partition of a topic at timestamp from their leaders.
+// Partitions whose leader cannot be reached are left out, and the last error is returned with the others.
+func (repo *OffsetRepository) topicOffsets(cluster *zookeeper.Cluster, topic string, timestamp int64) (map[int32]int64, error) {
+	leaders, err := cluster.PartitionLeaders(topic)
+	if err != nil {
+		return map[int32]int64{}, err
+	}
+	brokers, err := cluster.Brokers()
+	if err != nil {
+		return map[int32]int64{}, err
+	}
+	partitionsByLeader := map[int32][]int32{}
+	for partition, leader := range leaders {
+		partitionsByLeader[leader] = append(partitionsByLeader[leader], partition)
+	}
+	offsets := map[int32]int64{}
+	var lastErr error
+	for leader, partitions := range partitionsByLeader {
+		address, ok := brokers[leader]
+		if !ok {
+			lastErr = fmt.Errorf("broker %d, the leader of partitions %v of %s, is not registered", leader, partitions, topic)
+			continue
+		}
+		listed, err := repo.listOffsets(address, topic, partitions, timestamp)
+		if err != nil {
+			lastErr = fmt.Errorf("Failed to list offsets of %s from %s: %v", topic, address, err)
+			continue
+		}
+		for partition, offset := range listed {
+			offsets[partition] = offset
+		}
+	}
+	return offsets, lastErr
+}
+
+// totalLag sums the lag of partitions, or returns nil if the lag of any is unknown
+func totalLag(partitions []broker.PartitionOffsets) *int64 {
+	var total int64
+	for _, partition := range partitions {
+		if partition.Lag == nil {
+			return nil
+		}
+		total += *partition.Lag
+	}
+	return &total
+}
+
+func sortedTopics(offsets map[string]map[int32]int64) []string {
+	topics := make([]string, 0, len(offsets))
+	for topic := range offsets {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func sortedPartitions(offsets map[int32]int64) []int32 {
+	partitions := make([]int32, 0, len(offsets))
+	for partition := range offsets {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	return partitions
+}
@@ -0,0 +1,159 @@
+package kafka_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("OffsetRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var conn zookeeper.Conn
+	var logEndOffsets map[string]map[int32]int64
+	var earliestOffsets, timestampOffsets map[int32]int64
+	var listErr error
+	var repo *kafka.OffsetRepository
+
+	int64Ptr := func(value int64) *int64 { return &value }
+	commit := func(group, topic, partition, offset string) {
+		Expect(conn.Create("/consumers/"+group+"/offsets/"+topic+"/"+partition, []byte(offset))).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		store = newMemoryStore(1)
+		var err error
+		conn, err = store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zookeeper.NewCluster(conn).CreateTopic(instanceID, 2, 1, nil)).To(Succeed())
+		createTopic(store, "other")
+		logEndOffsets = map[string]map[int32]int64{instanceID: {0: 2000000, 1: 50}}
+		earliestOffsets = map[int32]int64{0: 100, 1: 0}
+		timestampOffsets = map[int32]int64{0: 1500, 1: -1}
+		listErr = nil
+		repo = kafka.NewOffsetRepository(store.Connect, func(address, topic string, partitions []int32, timestamp int64) (map[int32]int64, error) {
+			Expect(address).To(Equal("localhost:9092"))
+			offsets := map[int32]int64{}
+			for _, partition := range partitions {
+				switch timestamp {
+				case kafka.OffsetLatest:
+					offsets[partition] = logEndOffsets[topic][partition]
+				case kafka.OffsetEarliest:
+					offsets[partition] = earliestOffsets[partition]
+				default:
+					Expect(timestamp).To(Equal(int64(1500000000000)))
+					offsets[partition] = timestampOffsets[partition]
+				}
+			}
+			return offsets, listErr
+		}, lager.NewLogger("test"))
+	})
+
+	It("reports the committed offsets and lag of the groups of the instance's topics", func() {
+		commit("instanceID.binding", instanceID, "0", "10")
+		commit("instanceID.binding", instanceID, "1", "50")
+		commit("instanceID.binding", "other", "0", "5")
+		commit("unrelated", "other", "0", "5")
+		Expect(conn.Create("/consumers/instanceID.binding/ids/member", []byte("{}"))).To(Succeed())
+
+		groups, err := repo.ConsumerGroupOffsets(instanceID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(groups).To(Equal([]broker.ConsumerGroupOffsets{{
+			Group:   "instanceID.binding",
+			Members: 1,
+			Partitions: []broker.PartitionOffsets{
+				{Topic: instanceID, Partition: 0, Offset: 10, LogEndOffset: int64Ptr(2000000), Lag: int64Ptr(1999990)},
+				{Topic: instanceID, Partition: 1, Offset: 50, LogEndOffset: int64Ptr(50), Lag: int64Ptr(0)},
+			},
+			Lag: int64Ptr(1999990),
+		}}))
+	})
+
+	It("reports offsets without lag when the log-end offsets cannot be listed", func() {
+		commit("instanceID.binding", instanceID, "0", "10")
+		listErr = errors.New("connection refused")
+
+		groups, err := repo.ConsumerGroupOffsets(instanceID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(groups).To(HaveLen(1))
+		Expect(groups[0].Partitions).To(Equal([]broker.PartitionOffsets{{Topic: instanceID, Partition: 0, Offset: 10}}))
+		Expect(groups[0].Lag).To(BeNil())
+	})
+
+	It("reports nothing when no group has committed offsets", func() {
+		Expect(repo.ConsumerGroupOffsets(instanceID)).To(BeEmpty())
+	})
+
+	Describe("ResetOffsets", func() {
+		const group = "instanceID.binding"
+
+		BeforeEach(func() {
+			commit(group, instanceID, "0", "10")
+			commit(group, instanceID, "1", "20")
+			commit(group, "other", "0", "5")
+		})
+
+		committed := func() map[string]map[int32]int64 {
+			offsets, err := zookeeper.NewCluster(conn).ConsumerGroupOffsets(group)
+			Expect(err).NotTo(HaveOccurred())
+			return offsets
+		}
+
+		It("resets the instance's topics to their earliest offsets", func() {
+			offsets, err := repo.ResetOffsets(instanceID, broker.OffsetReset{Group: group, To: broker.OffsetResetEarliest})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(offsets).To(Equal([]broker.PartitionOffsets{
+				{Topic: instanceID, Partition: 0, Offset: 100},
+				{Topic: instanceID, Partition: 1, Offset: 0},
+			}))
+			Expect(committed()).To(Equal(map[string]map[int32]int64{instanceID: {0: 100, 1: 0}, "other": {0: 5}}))
+		})
+
+		It("resets to a timestamp, skipping to the end of partitions with no later records", func() {
+			timestamp := int64(1500000000000)
+			_, err := repo.ResetOffsets(instanceID, broker.OffsetReset{Group: group, To: broker.OffsetResetTimestamp, Timestamp: &timestamp})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(committed()[instanceID]).To(Equal(map[int32]int64{0: 1500, 1: 50}))
+		})
+
+		It("resets a named topic to an explicit offset", func() {
+			offset := int64(7)
+			_, err := repo.ResetOffsets(instanceID, broker.OffsetReset{Group: group, Topic: instanceID, To: broker.OffsetResetOffset, Offset: &offset})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(committed()[instanceID]).To(Equal(map[int32]int64{0: 7, 1: 7}))
+		})
+
+		It("refuses to reset a group with members unless forced", func() {
+			Expect(conn.Create("/consumers/"+group+"/ids/member", []byte("{}"))).To(Succeed())
+			_, err := repo.ResetOffsets(instanceID, broker.OffsetReset{Group: group, To: broker.OffsetResetLatest})
+			Expect(err).To(Equal(broker.ErrConsumerGroupActive))
+			Expect(committed()[instanceID]).To(Equal(map[int32]int64{0: 10, 1: 20}))
+
+			_, err = repo.ResetOffsets(instanceID, broker.OffsetReset{Group: group, To: broker.OffsetResetLatest, Force: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(committed()[instanceID]).To(Equal(map[int32]int64{0: 2000000, 1: 50}))
+		})
+
+		It("commits nothing if an offset cannot be listed", func() {
+			listErr = errors.New("connection refused")
+			_, err := repo.ResetOffsets(instanceID, broker.OffsetReset{Group: group, To: broker.OffsetResetEarliest})
+			Expect(err).To(HaveOccurred())
+			Expect(committed()[instanceID]).To(Equal(map[int32]int64{0: 10, 1: 20}))
+		})
+
+		It("needs a topic for a group without offsets for the instance", func() {
+			_, err := repo.ResetOffsets(instanceID, broker.OffsetReset{Group: "instanceID.new", To: broker.OffsetResetEarliest})
+			Expect(err).To(MatchError(ContainSubstring("name the topic to reset")))
+
+			_, err = repo.ResetOffsets(instanceID, broker.OffsetReset{Group: "instanceID.new", Topic: instanceID, To: broker.OffsetResetEarliest})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,66 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// clientID identifies the broker in the requests it sends to Kafka
+const clientID = "kafka-service-broker"
+
+// The few Kafka requests the broker sends itself, ListOffsets and Metadata, are sent over a
+// PLAINTEXT connection, with neither TLS nor SASL, so the Kafka brokers need a PLAINTEXT
+// listener at the addresses they are sent to.
+
+// kafkaRequest encodes the body of a Kafka request, after its header
+type kafkaRequest struct {
+	body *bytes.Buffer
+}
+
+// newKafkaRequest starts a request with its header
+func newKafkaRequest(apiKey, apiVersion int16, correlationID int32) *kafkaRequest {
+	request := &kafkaRequest{body: &bytes.Buffer{}}
+	request.write(apiKey)
+	request.write(apiVersion)
+	request.write(correlationID)
+	request.writeString(clientID)
+	return request
+}
+
+func (request *kafkaRequest) write(value interface{}) {
+	_ = binary.Write(request.body, binary.BigEndian, value)
+}
+
+func (request *kafkaRequest) writeString(value string) {
+	request.write(int16(len(value)))
+	request.body.WriteString(value)
+}
+
+// bytes returns the size delimited request
+func (request *kafkaRequest) bytes() []byte {
+	delimited := &bytes.Buffer{}
+	_ = binary.Write(delimited, binary.BigEndian, int32(request.body.Len()))
+	delimited.Write(request.body.Bytes())
+	return delimited.Bytes()
+}
+
+// roundTrip sends a size delimited request to the Kafka broker at address, and has
+// readResponse decode the response
+func roundTrip(address string, request []byte, readResponse func(io.Reader) error) error {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	if err = conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		return err
+	}
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+	return readResponse(bufio.NewReader(conn))
+}
@@ -0,0 +1,190 @@
+package kafka
+
+import (
+	"strconv"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// QuotaRepository applies the client quotas of each service instance to every one of its bindings.
+// Each binding is given its own client ID, which is also its principal name. Quotas are written
+// as Kafka client ID quotas (/config/clients) or user quotas (/config/users) depending on
+// KafkaConfiguration.QuotaEntityType.
+type QuotaRepository struct {
+	repository
+	kafkaConfig brokerconfig.KafkaConfiguration
+}
+
+// NewQuotaRepository creates a QuotaRepository
+func NewQuotaRepository(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, logger lager.Logger) *QuotaRepository {
+	return &QuotaRepository{
+		repository:  repository{connect: connect, logger: logger},
+		kafkaConfig: kafkaConfig,
+	}
+}
+
+// InstanceQuota returns the quota recorded for a service instance
+func (repo *QuotaRepository) InstanceQuota(instanceID string) (broker.Quota, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return broker.Quota{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	err = readRecord(conn, instanceRecordPath(instanceID), &record)
+	if err != nil && err != zookeeper.ErrNoNode {
+		return broker.Quota{}, err
+	}
+	return record.Quota, nil
+}
+
+// SetInstanceQuota records the quota of a service instance and applies it to all of its bindings
+func (repo *QuotaRepository) SetInstanceQuota(instanceID string, quota broker.Quota) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	err = updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		record.Quota = quota
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bindingIDs, err := recordedBindingIDs(conn, instanceID)
+	if err != nil {
+		return err
+	}
+	cluster := zookeeper.NewCluster(conn)
+	for _, bindingID := range bindingIDs {
+		binding := bindingRecord{}
+		if err = readRecord(conn, bindingRecordPath(instanceID, bindingID), &binding); err != nil {
+			return err
+		}
+		if err = applyQuota(cluster, binding, quota); err != nil {
+			return err
+		}
+	}
+
+	repo.logger.Info("set-instance-quota", lager.Data{
+		"instance_id": instanceID,
+		"bindings":    len(bindingIDs),
+		"quota":       quota,
+		"message":     "Applied quota to all bindings of service instance",
+	})
+	return nil
+}
+
+// AddBinding applies the quota of a service instance to a new binding, and returns the client ID the binding must use
+func (repo *QuotaRepository) AddBinding(instanceID, bindingID string) (string, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+
+	instance := instanceRecord{}
+	err = readRecord(conn, instanceRecordPath(instanceID), &instance)
+	if err != nil && err != zookeeper.ErrNoNode {
+		return "", err
+	}
+	binding := bindingRecord{}
+	err = updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		binding.ClientID = bindingID
+		binding.QuotaEntityType = repo.kafkaConfig.QuotaEntityType
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = applyQuota(zookeeper.NewCluster(conn), binding, instance.Quota); err != nil {
+		return "", err
+	}
+
+	repo.logger.Info("add-binding-quota", lager.Data{
+		"instance_id": instanceID,
+		"binding_id":  bindingID,
+		"client_id":   binding.ClientID,
+		"quota":       instance.Quota,
+		"message":     "Applied instance quota to binding",
+	})
+	return binding.ClientID, nil
+}
+
+// RemoveBinding removes the quota of a binding, deleting its record if nothing else is left of it
+func (repo *QuotaRepository) RemoveBinding(instanceID, bindingID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	return removeBindingQuota(conn, instanceID, bindingID)
+}
+
+// RemoveInstance removes the quotas of every binding of a service instance, and its record
+func (repo *QuotaRepository) RemoveInstance(instanceID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	bindingIDs, err := recordedBindingIDs(conn, instanceID)
+	if err != nil {
+		return err
+	}
+	for _, bindingID := range bindingIDs {
+		if err = removeBindingQuota(conn, instanceID, bindingID); err != nil {
+			return err
+		}
+	}
+	return zookeeper.DeleteRecursive(conn, instanceRecordPath(instanceID))
+}
+
+func removeBindingQuota(conn zookeeper.Conn, instanceID, bindingID string) error {
+	binding := bindingRecord{}
+	err := readRecord(conn, bindingRecordPath(instanceID, bindingID), &binding)
+	if err == zookeeper.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = applyQuota(zookeeper.NewCluster(conn), binding, broker.Quota{}); err != nil {
+		return err
+	}
+	return updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		binding.ClientID = ""
+		binding.QuotaEntityType = ""
+		return nil
+	})
+}
+
+// quotaConfigKeys are the keys of the Kafka dynamic configuration of a client ID or user that hold its quotas
+var quotaConfigKeys = []string{"producer_byte_rate", "consumer_byte_rate", "request_percentage"}
+
+// applyQuota writes the quota of a binding as Kafka dynamic configuration, keeping any other
+// configuration of its client ID or user, such as SCRAM credentials
+func applyQuota(cluster *zookeeper.Cluster, binding bindingRecord, quota broker.Quota) error {
+	config := map[string]string{}
+	if quota.ProducerByteRate != 0 {
+		config["producer_byte_rate"] = strconv.FormatInt(quota.ProducerByteRate, 10)
+	}
+	if quota.ConsumerByteRate != 0 {
+		config["consumer_byte_rate"] = strconv.FormatInt(quota.ConsumerByteRate, 10)
+	}
+	if quota.RequestPercentage != 0 {
+		config["request_percentage"] = strconv.FormatFloat(quota.RequestPercentage, 'f', -1, 64)
+	}
+	return cluster.UpdateEntityConfig(binding.QuotaEntityType, binding.ClientID, config, quotaConfigKeys...)
+}
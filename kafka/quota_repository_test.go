@@ -0,0 +1,141 @@
+package kafka_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+func entityConfig(store *zookeeper.MemoryStore, node string) map[string]string {
+	conn, err := store.Connect()
+	Expect(err).NotTo(HaveOccurred())
+	data, err := conn.Get(node)
+	Expect(err).NotTo(HaveOccurred())
+	var entity struct {
+		Config map[string]string `json:"config"`
+	}
+	Expect(json.Unmarshal(data, &entity)).To(Succeed())
+	return entity.Config
+}
+
+var _ = Describe("QuotaRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var repo *kafka.QuotaRepository
+	var quota broker.Quota
+
+	BeforeEach(func() {
+		store = newMemoryStore(1)
+		kafkaConfig := kafkaConfiguration()
+		kafkaConfig.QuotaEntityType = zookeeper.EntityClients
+		repo = kafka.NewQuotaRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+		quota = broker.Quota{ProducerByteRate: 1024, RequestPercentage: 12.5}
+	})
+
+	It("records the quota of an instance", func() {
+		Expect(repo.SetInstanceQuota(instanceID, quota)).To(Succeed())
+		Expect(repo.InstanceQuota(instanceID)).To(Equal(quota))
+		Expect(repo.InstanceQuota("unknown")).To(Equal(broker.Quota{}))
+	})
+
+	It("gives each request a copy that stops once the request is cancelled", func() {
+		requestCtx, cancel := context.WithCancel(context.Background())
+		requestRepo, ok := repo.WithContext(requestCtx).(broker.QuotaManager)
+		Expect(ok).To(BeTrue())
+		Expect(requestRepo.SetInstanceQuota(instanceID, quota)).To(Succeed())
+
+		cancel()
+		Expect(requestRepo.SetInstanceQuota(instanceID, broker.Quota{})).To(Equal(context.Canceled))
+		Expect(repo.InstanceQuota(instanceID)).To(Equal(quota))
+	})
+
+	It("applies the instance quota to a new binding as a client quota", func() {
+		Expect(repo.SetInstanceQuota(instanceID, quota)).To(Succeed())
+		clientID, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clientID).To(Equal("bindingID"))
+
+		Expect(entityConfig(store, "/config/clients/bindingID")).To(Equal(map[string]string{
+			"producer_byte_rate": "1024",
+			"request_percentage": "12.5",
+		}))
+		Expect(topics(store, "/config/changes")).NotTo(BeEmpty())
+	})
+
+	It("writes user quotas when configured to", func() {
+		kafkaConfig := kafkaConfiguration()
+		kafkaConfig.QuotaEntityType = zookeeper.EntityUsers
+		repo = kafka.NewQuotaRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+
+		Expect(repo.SetInstanceQuota(instanceID, quota)).To(Succeed())
+		_, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(topics(store, "/config/users")).To(ConsistOf("bindingID"))
+		Expect(topics(store, "/config/clients")).To(BeEmpty())
+	})
+
+	It("does not write a client quota for an unlimited instance", func() {
+		_, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(topics(store, "/config/clients")).To(BeEmpty())
+	})
+
+	It("applies a changed instance quota to every existing binding", func() {
+		Expect(repo.SetInstanceQuota(instanceID, quota)).To(Succeed())
+		_, err := repo.AddBinding(instanceID, "binding1")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = repo.AddBinding(instanceID, "binding2")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(repo.SetInstanceQuota(instanceID, broker.Quota{ConsumerByteRate: 2048})).To(Succeed())
+		for _, clientID := range []string{"binding1", "binding2"} {
+			Expect(entityConfig(store, "/config/clients/"+clientID)).To(Equal(map[string]string{
+				"consumer_byte_rate": "2048",
+			}))
+		}
+
+		Expect(repo.SetInstanceQuota(instanceID, broker.Quota{})).To(Succeed())
+		Expect(topics(store, "/config/clients")).To(BeEmpty())
+	})
+
+	It("removes the quota of a binding", func() {
+		Expect(repo.SetInstanceQuota(instanceID, quota)).To(Succeed())
+		_, err := repo.AddBinding(instanceID, "binding1")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = repo.AddBinding(instanceID, "binding2")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(repo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+		Expect(topics(store, "/config/clients")).To(ConsistOf("binding2"))
+		Expect(repo.RemoveBinding(instanceID, "unknown")).To(Succeed())
+	})
+
+	It("keeps the record of a binding that has more than a quota", func() {
+		requests := kafka.NewRequestRepository(store.Connect, lager.NewLogger("test"))
+		_, err := repo.AddBinding(instanceID, "binding1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests.SetBindingAttributes(instanceID, "binding1", broker.BindingAttributes{PlanID: "plan"})).To(Succeed())
+
+		Expect(repo.RemoveBinding(instanceID, "binding1")).To(Succeed())
+		Expect(requests.BindingAttributes(instanceID, "binding1")).To(Equal(&broker.BindingAttributes{PlanID: "plan"}))
+	})
+
+	It("removes the quotas of every binding and the record of an instance", func() {
+		Expect(repo.SetInstanceQuota(instanceID, quota)).To(Succeed())
+		_, err := repo.AddBinding(instanceID, "binding1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(repo.RemoveInstance(instanceID)).To(Succeed())
+		Expect(topics(store, "/config/clients")).To(BeEmpty())
+		Expect(topics(store, "/kafka-service-broker/instances")).To(BeEmpty())
+		Expect(repo.InstanceQuota(instanceID)).To(Equal(broker.Quota{}))
+	})
+})
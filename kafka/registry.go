@@ -0,0 +1,165 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// registryRoot is where the broker records its service instances and bindings in ZooKeeper
+const registryRoot = zookeeper.PrivateRoot + "/instances"
+
+// instanceRecord is what the broker records about a service instance
+type instanceRecord struct {
+	Quota       broker.Quota             `json:"quota"`
+	TopicLimits broker.TopicLimits       `json:"topic_limits"`
+	TopicConfig broker.TopicConfigPolicy `json:"topic_config"`
+	// Topics maps the logical names of the topics of a multi-topic plan instance to their
+	// names on the Kafka cluster. It is nil for instances of other plans, and never nil,
+	// even if empty, for multi-topic plan instances.
+	Topics map[string]string `json:"topics"`
+	// TopicSetConfig is the configuration every topic of a multi-topic plan instance is created with
+	TopicSetConfig map[string]string `json:"topic_set_config,omitempty"`
+	// Provisioned are the attributes of the request that provisioned the instance
+	Provisioned *broker.InstanceAttributes `json:"provisioned,omitempty"`
+}
+
+// bindingRecord is what the broker records about a service binding
+type bindingRecord struct {
+	ClientID        string `json:"client_id"`
+	QuotaEntityType string `json:"quota_entity_type"`
+	// ConsumerGroup is the consumer group allocated to the binding, shared by all bindings
+	// of the instance if ConsumerGroupScope is broker.ConsumerGroupPerInstance
+	ConsumerGroup      string `json:"consumer_group,omitempty"`
+	ConsumerGroupScope string `json:"consumer_group_scope,omitempty"`
+	// Principal is the Kafka principal the binding's consumer group ACL was granted to
+	Principal string `json:"principal,omitempty"`
+	// SASLMechanism is the mechanism of the binding's SCRAM credentials, if it was given any
+	SASLMechanism string `json:"sasl_mechanism,omitempty"`
+	// CertificateSerial is the hexadecimal serial number of the binding's client certificate, if it was issued one
+	CertificateSerial string `json:"certificate_serial,omitempty"`
+	// Retired are the credentials replaced by rotations, still valid until their grace period ends
+	Retired []retiredCredentials `json:"retired,omitempty"`
+	// RotatedInSecretStore is true when the credentials of the binding's latest rotation are in the secret store
+	RotatedInSecretStore bool `json:"rotated_in_secret_store,omitempty"`
+	// Bound are the attributes of the request that created the binding
+	Bound *broker.BindingAttributes `json:"bound,omitempty"`
+}
+
+// retiredCredentials are the credentials of a binding replaced by a rotation
+type retiredCredentials struct {
+	// SASLMechanism is the mechanism of the replaced SCRAM credentials, if any
+	SASLMechanism string `json:"sasl_mechanism,omitempty"`
+	// CertificateSerial is the hexadecimal serial number of the replaced client certificate, if any
+	CertificateSerial string    `json:"certificate_serial,omitempty"`
+	RevokeAt          time.Time `json:"revoke_at"`
+}
+
+// empty returns true if nothing is left of a binding's record but its quota
+func (binding bindingRecord) empty() bool {
+	return binding.ClientID == "" && binding.ConsumerGroup == "" && binding.SASLMechanism == "" &&
+		binding.CertificateSerial == "" && len(binding.Retired) == 0 && binding.Bound == nil
+}
+
+func instanceRecordPath(instanceID string) string {
+	return registryRoot + "/" + instanceID
+}
+
+func bindingRecordPath(instanceID, bindingID string) string {
+	return instanceRecordPath(instanceID) + "/bindings/" + bindingID
+}
+
+// readRecord decodes the record at node into record; it returns zookeeper.ErrNoNode if there is none
+func readRecord(conn zookeeper.Conn, node string, record interface{}) error {
+	data, err := conn.Get(node)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		// a parent znode created implicitly for the records below it
+		return zookeeper.ErrNoNode
+	}
+	return json.Unmarshal(data, record)
+}
+
+// maxRecordUpdateAttempts is how many times updateRecord reads and writes a record that keeps
+// being changed concurrently before giving up
+const maxRecordUpdateAttempts = 10
+
+// errRecordConflict is returned by updateRecord when a record keeps being changed concurrently
+var errRecordConflict = errors.New("the record was changed concurrently too many times; try again")
+
+// errNoUpdate is returned by the update function of updateRecord to leave the record as it is
+var errNoUpdate = errors.New("no update")
+
+// updateRecord reads the record at node into record, applies update to it and writes it back,
+// only if the record was not changed in between; otherwise it reads the record again and
+// retries. record is a pointer, reset before each read, and left as the zero record if there
+// is none. If update returns an error, nothing is written and the error is returned, except for
+// errNoUpdate. A record that update leaves empty, e.g. a bindingRecord, is deleted.
+func updateRecord(conn zookeeper.Conn, node string, record interface{}, update func() error) error {
+	for attempt := 0; attempt < maxRecordUpdateAttempts; attempt++ {
+		value := reflect.ValueOf(record).Elem()
+		value.Set(reflect.Zero(value.Type()))
+
+		data, version, err := conn.GetVersion(node)
+		exists := err == nil
+		if err != nil && err != zookeeper.ErrNoNode {
+			return err
+		}
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, record); err != nil {
+				return err
+			}
+		}
+		if err = update(); err == errNoUpdate {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(record); err != nil {
+			return err
+		}
+		emptied := false
+		if record, ok := record.(interface{ empty() bool }); ok {
+			emptied = record.empty()
+		}
+		switch {
+		case emptied && !exists:
+			return nil
+		case emptied:
+			err = conn.DeleteVersion(node, version)
+		case exists:
+			err = conn.SetVersion(node, data, version)
+		default:
+			err = conn.Create(node, data)
+		}
+		if err != zookeeper.ErrBadVersion && err != zookeeper.ErrNodeExists && err != zookeeper.ErrNoNode {
+			return err
+		}
+	}
+	return errRecordConflict
+}
+
+// recordedBindingIDs returns the IDs of all recorded bindings of a service instance
+func recordedBindingIDs(conn zookeeper.Conn, instanceID string) ([]string, error) {
+	bindingIDs, err := conn.Children(instanceRecordPath(instanceID) + "/bindings")
+	if err == zookeeper.ErrNoNode {
+		return []string{}, nil
+	}
+	return bindingIDs, err
+}
+
+// recordedInstanceIDs returns the IDs of all recorded service instances
+func recordedInstanceIDs(conn zookeeper.Conn) ([]string, error) {
+	instanceIDs, err := conn.Children(registryRoot)
+	if err == zookeeper.ErrNoNode {
+		return []string{}, nil
+	}
+	return instanceIDs, err
+}
@@ -0,0 +1,151 @@
+package kafka
+
+import (
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// RequestRepository records the attributes of the requests that provisioned each service instance
+// and created each binding, in their records
+type RequestRepository struct {
+	repository
+}
+
+// NewRequestRepository creates a RequestRepository
+func NewRequestRepository(connect zookeeper.Connector, logger lager.Logger) *RequestRepository {
+	return &RequestRepository{
+		repository: repository{connect: connect, logger: logger},
+	}
+}
+
+// InstanceAttributes returns the attributes a service instance was provisioned with, or nil if
+// none were recorded, e.g. for instances provisioned by earlier versions of the broker
+func (repo *RequestRepository) InstanceAttributes(instanceID string) (*broker.InstanceAttributes, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	err = readRecord(conn, instanceRecordPath(instanceID), &record)
+	if err == zookeeper.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.Provisioned, nil
+}
+
+// CreateInstanceAttributes records the attributes a service instance is being provisioned with,
+// unless some are recorded already, which it returns instead
+func (repo *RequestRepository) CreateInstanceAttributes(instanceID string, attributes broker.InstanceAttributes) (*broker.InstanceAttributes, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	var existing *broker.InstanceAttributes
+	err = updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		existing = record.Provisioned
+		if existing != nil {
+			return errNoUpdate
+		}
+		record.Provisioned = &attributes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// SetInstanceAttributes records the attributes a service instance was provisioned with
+func (repo *RequestRepository) SetInstanceAttributes(instanceID string, attributes broker.InstanceAttributes) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	return updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		record.Provisioned = &attributes
+		return nil
+	})
+}
+
+// BindingAttributes returns the attributes a binding was created with, or nil if none were recorded
+func (repo *RequestRepository) BindingAttributes(instanceID, bindingID string) (*broker.BindingAttributes, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	binding := bindingRecord{}
+	err = readRecord(conn, bindingRecordPath(instanceID, bindingID), &binding)
+	if err == zookeeper.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return binding.Bound, nil
+}
+
+// SetBindingAttributes records the attributes a binding was created with
+func (repo *RequestRepository) SetBindingAttributes(instanceID, bindingID string, attributes broker.BindingAttributes) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	binding := bindingRecord{}
+	return updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		binding.Bound = &attributes
+		return nil
+	})
+}
+
+// RemoveBinding clears the attributes of a binding, deleting its record if nothing else is left of it
+func (repo *RequestRepository) RemoveBinding(instanceID, bindingID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	binding := bindingRecord{}
+	return updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		if binding.Bound == nil {
+			return errNoUpdate
+		}
+		binding.Bound = nil
+		return nil
+	})
+}
+
+// RemoveInstance clears the attributes of a service instance, so that its ID can be provisioned again
+func (repo *RequestRepository) RemoveInstance(instanceID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	record := instanceRecord{}
+	return updateRecord(conn, instanceRecordPath(instanceID), &record, func() error {
+		if record.Provisioned == nil {
+			return errNoUpdate
+		}
+		record.Provisioned = nil
+		return nil
+	})
+}
@@ -0,0 +1,70 @@
+package kafka_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("RequestRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var repo *kafka.RequestRepository
+
+	BeforeEach(func() {
+		store = newMemoryStore(1)
+		repo = kafka.NewRequestRepository(store.Connect, lager.NewLogger("test"))
+	})
+
+	It("records the attributes of service instances alongside their other records", func() {
+		Expect(repo.InstanceAttributes(instanceID)).To(BeNil())
+
+		limits := kafka.NewLimitRepository(store.Connect, lager.NewLogger("test"))
+		Expect(limits.SetInstanceLimits(instanceID, broker.TopicLimits{MaxTopics: 5})).To(Succeed())
+		attributes := broker.InstanceAttributes{
+			ServiceID:  "serviceID",
+			PlanID:     "planID",
+			SpaceGUID:  "space",
+			Parameters: json.RawMessage(`{"max_topics":5}`),
+		}
+		Expect(repo.SetInstanceAttributes(instanceID, attributes)).To(Succeed())
+		Expect(repo.InstanceAttributes(instanceID)).To(Equal(&attributes))
+		Expect(limits.InstanceLimits(instanceID)).To(Equal(broker.TopicLimits{MaxTopics: 5}))
+
+		Expect(repo.RemoveInstance(instanceID)).To(Succeed())
+		Expect(repo.InstanceAttributes(instanceID)).To(BeNil())
+		Expect(limits.InstanceLimits(instanceID)).To(Equal(broker.TopicLimits{MaxTopics: 5}))
+	})
+
+	It("records the attributes of a service instance being provisioned only once", func() {
+		first := broker.InstanceAttributes{ServiceID: "serviceID", PlanID: "planID", SpaceGUID: "space", Incomplete: true}
+		Expect(repo.CreateInstanceAttributes(instanceID, first)).To(BeNil())
+
+		second := first
+		second.SpaceGUID = "other-space"
+		Expect(repo.CreateInstanceAttributes(instanceID, second)).To(Equal(&first))
+		Expect(repo.InstanceAttributes(instanceID)).To(Equal(&first))
+	})
+
+	It("records the attributes of bindings, deleting their record when nothing else is left of it", func() {
+		Expect(repo.BindingAttributes(instanceID, "bindingID")).To(BeNil())
+
+		attributes := broker.BindingAttributes{ServiceID: "serviceID", PlanID: "planID", AppGUID: "app"}
+		Expect(repo.SetBindingAttributes(instanceID, "bindingID", attributes)).To(Succeed())
+		Expect(repo.BindingAttributes(instanceID, "bindingID")).To(Equal(&attributes))
+
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		Expect(repo.BindingAttributes(instanceID, "bindingID")).To(BeNil())
+		conn, err := store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Exists(zookeeper.PrivateRoot + "/instances/instanceID/bindings/bindingID")).To(BeFalse())
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+	})
+})
@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"crypto/rand"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// SASLRepository gives each binding SCRAM credentials for its principal, User:<binding ID>.
+// They are written to the dynamic configuration of the user (/config/users), alongside any
+// user quotas, where Kafka brokers with a SCRAM listener read them. Only the salted keys are
+// stored; the password is returned once, in the binding's credentials.
+type SASLRepository struct {
+	repository
+	kafkaConfig brokerconfig.KafkaConfiguration
+}
+
+// NewSASLRepository creates a SASLRepository for KafkaConfiguration.SASLMechanism
+func NewSASLRepository(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, logger lager.Logger) *SASLRepository {
+	return &SASLRepository{
+		repository:  repository{connect: connect, logger: logger},
+		kafkaConfig: kafkaConfig,
+	}
+}
+
+// AddBinding creates the SCRAM credentials of a new binding
+func (repo *SASLRepository) AddBinding(instanceID, bindingID string) (broker.SASLCredentials, error) {
+	conn, err := repo.connect()
+	if err != nil {
+		return broker.SASLCredentials{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	binding := bindingRecord{}
+	err = updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		binding.SASLMechanism = repo.kafkaConfig.SASLMechanism
+		return nil
+	})
+	if err != nil {
+		return broker.SASLCredentials{}, err
+	}
+	return repo.createCredentials(conn, instanceID, bindingID, binding.SASLMechanism)
+}
+
+// createCredentials writes new SCRAM credentials of a mechanism for a binding, replacing any it had
+func (repo *SASLRepository) createCredentials(conn zookeeper.Conn, instanceID, bindingID, mechanism string) (broker.SASLCredentials, error) {
+	password, err := randomString(24)
+	if err != nil {
+		return broker.SASLCredentials{}, err
+	}
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return broker.SASLCredentials{}, err
+	}
+	credential, err := NewScramCredential(mechanism, password, salt)
+	if err != nil {
+		return broker.SASLCredentials{}, err
+	}
+	cluster := zookeeper.NewCluster(conn)
+	if err = cluster.UpdateEntityConfig(zookeeper.EntityUsers, bindingID, map[string]string{mechanism: credential}); err != nil {
+		return broker.SASLCredentials{}, err
+	}
+
+	repo.logger.Info("add-binding-sasl", lager.Data{
+		"instance_id":    instanceID,
+		"binding_id":     bindingID,
+		"sasl_mechanism": mechanism,
+		"message":        "Created SCRAM credentials for binding",
+	})
+	return broker.SASLCredentials{Mechanism: mechanism, Username: bindingID, Password: password}, nil
+}
+
+// deleteCredentials deletes the SCRAM credentials of a mechanism of a binding
+func (repo *SASLRepository) deleteCredentials(conn zookeeper.Conn, instanceID, bindingID, mechanism string) error {
+	if err := zookeeper.NewCluster(conn).UpdateEntityConfig(zookeeper.EntityUsers, bindingID, nil, mechanism); err != nil {
+		return err
+	}
+	repo.logger.Info("remove-binding-sasl", lager.Data{
+		"instance_id":    instanceID,
+		"binding_id":     bindingID,
+		"sasl_mechanism": mechanism,
+		"message":        "Deleted SCRAM credentials of binding",
+	})
+	return nil
+}
+
+// RemoveBinding deletes the SCRAM credentials of a binding
+func (repo *SASLRepository) RemoveBinding(instanceID, bindingID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	return repo.removeBinding(conn, instanceID, bindingID)
+}
+
+// RemoveInstance deletes the SCRAM credentials of every binding of a service instance
+func (repo *SASLRepository) RemoveInstance(instanceID string) error {
+	conn, err := repo.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	bindingIDs, err := recordedBindingIDs(conn, instanceID)
+	if err != nil {
+		return err
+	}
+	for _, bindingID := range bindingIDs {
+		if err = repo.removeBinding(conn, instanceID, bindingID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *SASLRepository) removeBinding(conn zookeeper.Conn, instanceID, bindingID string) error {
+	binding := bindingRecord{}
+	err := readRecord(conn, bindingRecordPath(instanceID, bindingID), &binding)
+	if err == zookeeper.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if binding.SASLMechanism == "" {
+		return nil
+	}
+
+	if err = repo.deleteCredentials(conn, instanceID, bindingID, binding.SASLMechanism); err != nil {
+		return err
+	}
+	return updateRecord(conn, bindingRecordPath(instanceID, bindingID), &binding, func() error {
+		binding.SASLMechanism = ""
+		return nil
+	})
+}
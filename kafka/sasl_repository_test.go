@@ -0,0 +1,73 @@
+package kafka_test
+
+import (
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("SASLRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var kafkaConfig brokerconfig.KafkaConfiguration
+	var repo *kafka.SASLRepository
+
+	BeforeEach(func() {
+		store = newMemoryStore(1)
+		kafkaConfig = kafkaConfiguration()
+		kafkaConfig.QuotaEntityType = zookeeper.EntityUsers
+		kafkaConfig.SecurityProtocol = brokerconfig.SecurityProtocolSASLSSL
+		kafkaConfig.SASLMechanism = brokerconfig.SASLMechanismScramSHA512
+		repo = kafka.NewSASLRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+	})
+
+	It("creates SCRAM credentials for the binding's principal", func() {
+		credentials, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials.Mechanism).To(Equal(brokerconfig.SASLMechanismScramSHA512))
+		Expect(credentials.Username).To(Equal("bindingID"))
+		Expect(credentials.Password).To(HaveLen(32))
+
+		config := entityConfig(store, "/config/users/bindingID")
+		Expect(config).To(HaveKeyWithValue(brokerconfig.SASLMechanismScramSHA512, MatchRegexp(`^salt=.+,stored_key=.+,server_key=.+,iterations=4096$`)))
+		Expect(config[brokerconfig.SASLMechanismScramSHA512]).NotTo(ContainSubstring(credentials.Password))
+
+		other, err := repo.AddBinding(instanceID, "otherID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Password).NotTo(Equal(credentials.Password))
+	})
+
+	It("keeps user quotas alongside the credentials, and deletes only the credentials", func() {
+		quotaRepo := kafka.NewQuotaRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+		Expect(quotaRepo.SetInstanceQuota(instanceID, broker.Quota{ProducerByteRate: 1024})).To(Succeed())
+		_, err := quotaRepo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quotaRepo.SetInstanceQuota(instanceID, broker.Quota{ProducerByteRate: 2048})).To(Succeed())
+		config := entityConfig(store, "/config/users/bindingID")
+		Expect(config).To(HaveKeyWithValue("producer_byte_rate", "2048"))
+		Expect(config).To(HaveKey(brokerconfig.SASLMechanismScramSHA512))
+
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		Expect(entityConfig(store, "/config/users/bindingID")).To(Equal(map[string]string{"producer_byte_rate": "2048"}))
+		Expect(quotaRepo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+		Expect(topics(store, "/config/users")).To(BeEmpty())
+	})
+
+	It("deletes the credentials of every binding of an instance", func() {
+		_, err := repo.AddBinding(instanceID, "bindingID")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = repo.AddBinding(instanceID, "otherID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.RemoveInstance(instanceID)).To(Succeed())
+		Expect(topics(store, "/config/users")).To(BeEmpty())
+		Expect(repo.RemoveBinding(instanceID, "bindingID")).To(Succeed())
+	})
+})
@@ -0,0 +1,68 @@
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// scramIterations is the iteration count of the SCRAM credentials the broker creates, Kafka's minimum
+const scramIterations = 4096
+
+// NewScramCredential encodes the SCRAM credential of a password the way Kafka stores it in the
+// dynamic configuration of a user (RFC 5802): salt, stored key, server key and iteration count.
+func NewScramCredential(mechanism, password string, salt []byte) (string, error) {
+	var newHash func() hash.Hash
+	switch mechanism {
+	case brokerconfig.SASLMechanismScramSHA256:
+		newHash = sha256.New
+	case brokerconfig.SASLMechanismScramSHA512:
+		newHash = sha512.New
+	default:
+		return "", fmt.Errorf("unsupported SCRAM mechanism '%s'", mechanism)
+	}
+
+	saltedPassword := scramHi(newHash, []byte(password), salt, scramIterations)
+	clientKey := scramHMAC(newHash, saltedPassword, []byte("Client Key"))
+	storedKey := newHash()
+	storedKey.Write(clientKey)
+	serverKey := scramHMAC(newHash, saltedPassword, []byte("Server Key"))
+
+	encode := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("salt=%s,stored_key=%s,server_key=%s,iterations=%d",
+		encode(salt), encode(storedKey.Sum(nil)), encode(serverKey), scramIterations), nil
+}
+
+// scramHi is the Hi function of RFC 5802, i.e. PBKDF2 with HMAC and a single block
+func scramHi(newHash func() hash.Hash, password, salt []byte, iterations int) []byte {
+	u := scramHMAC(newHash, password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = scramHMAC(newHash, password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+func scramHMAC(newHash func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(newHash, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// randomString returns a URL-safe random string of n random bytes
+func randomString(n int) (string, error) {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,31 @@
+package kafka_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+)
+
+var _ = Describe("NewScramCredential", func() {
+	salt := []byte("0123456789abcdef")
+
+	It("encodes SCRAM-SHA-256 credentials like Kafka", func() {
+		Expect(kafka.NewScramCredential(brokerconfig.SASLMechanismScramSHA256, "pencil", salt)).To(Equal(
+			"salt=MDEyMzQ1Njc4OWFiY2RlZg==,stored_key=nQpbZ77WudtqufPwikHXGRt6g2QJ4zns8bZLw273DRM=," +
+				"server_key=jn2amWP1q1h+jgjy0YTO14S6/F02SV7taipOeB7ef20=,iterations=4096"))
+	})
+
+	It("encodes SCRAM-SHA-512 credentials like Kafka", func() {
+		Expect(kafka.NewScramCredential(brokerconfig.SASLMechanismScramSHA512, "pencil", salt)).To(Equal(
+			"salt=MDEyMzQ1Njc4OWFiY2RlZg==," +
+				"stored_key=50q+37QH8XpaNkNxjgBGkxqXkoXVH2Lh3lHJ07g7AOGVlU4FcOi+wjKQc80Fl2VeSw/SniKUPcJigYjLk6Y/2w==," +
+				"server_key=fgiGfVDf7076b3mVdGf4ced4bUxY4L8AjpHsD8KRzbGOujkLSpj40lrcb9IvGqJ4Uv3dBiyDgUgoQnoVamua2Q==,iterations=4096"))
+	})
+
+	It("refuses other mechanisms", func() {
+		_, err := kafka.NewScramCredential("PLAIN", "pencil", salt)
+		Expect(err).To(HaveOccurred())
+	})
+})
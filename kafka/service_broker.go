@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/secretstore"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// planRepository creates and binds the service instances of a plan
+type planRepository interface {
+	broker.InstanceCreator
+	broker.InstanceBinder
+}
+
+// planRepositories returns the repositories of the plans implemented by this package, keyed by plan
+// name; the plans of the catalog are named after them
+func planRepositories(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, logger lager.Logger) map[string]planRepository {
+	return map[string]planRepository{
+		"topic":       NewTopicPlanRepository(kafkaConfig, connect, logger),
+		"shared":      NewSharedPlanRepository(kafkaConfig, connect, logger),
+		"compacted":   NewCompactedPlanRepository(kafkaConfig, connect, logger),
+		"multi-topic": NewMultiTopicPlanRepository(kafkaConfig, connect, logger),
+	}
+}
+
+// Plans returns the names of the plans implemented by this package, in order
+func Plans() []string {
+	plans := []string{}
+	for plan := range planRepositories(brokerconfig.KafkaConfiguration{}, nil, lager.NewLogger("plans")) {
+		plans = append(plans, plan)
+	}
+	sort.Strings(plans)
+	return plans
+}
+
+// NewServiceBroker creates a KafkaServiceBroker offering every plan implemented by this package,
+// and loads its catalog
+func NewServiceBroker(config brokerconfig.Config, connect zookeeper.Connector, logger lager.Logger) (*broker.KafkaServiceBroker, error) {
+	limitRepo := NewLimitRepository(connect, logger)
+	offsetRepo := NewOffsetRepository(connect, ListOffsets, logger)
+	secrets := secretstore.New(config.SecretStore)
+
+	kafkaBroker := &broker.KafkaServiceBroker{
+		InstanceCreators:     map[string]broker.InstanceCreator{},
+		InstanceBinders:      map[string]broker.InstanceBinder{},
+		QuotaManager:         NewQuotaRepository(config.KafkaConfiguration, connect, logger),
+		LimitManager:         limitRepo,
+		TopicConfigManager:   limitRepo,
+		ConsumerGroupManager: NewConsumerGroupRepository(config.KafkaConfiguration, connect, logger),
+		OffsetReporter:       offsetRepo,
+		OffsetResetter:       offsetRepo,
+		SecretStore:          secrets,
+		AuditLog:             NewAuditRepository(connect, logger),
+		RequestRecorder:      NewRequestRepository(connect, logger),
+		Logger:               logger,
+		Config:               config,
+	}
+	for plan, repo := range planRepositories(config.KafkaConfiguration, connect, logger) {
+		kafkaBroker.InstanceCreators[plan] = repo
+		kafkaBroker.InstanceBinders[plan] = repo
+	}
+	if config.KafkaConfiguration.SASLEnabled() {
+		kafkaBroker.SASLManager = NewSASLRepository(config.KafkaConfiguration, connect, logger)
+	}
+	if config.KafkaConfiguration.ClientCertificates.Enabled() {
+		kafkaBroker.CertificateIssuer = NewCertificateRepository(config.KafkaConfiguration, connect, logger)
+	}
+	if config.CredentialsRotatable() {
+		kafkaBroker.CredentialRotator = NewCredentialRotationRepository(config.KafkaConfiguration, connect, secrets, logger)
+	}
+	if err := kafkaBroker.LoadCatalog(); err != nil {
+		return nil, err
+	}
+	return kafkaBroker, nil
+}
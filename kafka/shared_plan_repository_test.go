@@ -0,0 +1,89 @@
+package kafka_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wvanbergen/kazoo-go"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("SharedPlanRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var kafkaConfig brokerconfig.KafkaConfiguration
+	var repo *kafka.SharedPlanRepository
+
+	BeforeEach(func() {
+		store = newMemoryStore(3)
+		kafkaConfig = kafkaConfiguration()
+		repo = kafka.NewSharedPlanRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+	})
+
+	Describe(".Create", func() {
+		It("creates a topic named after the instance as proof of provisioning", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			Expect(topics(store, "/brokers/topics")).To(ConsistOf(instanceID))
+			Expect(repo.InstanceExists(instanceID)).To(BeTrue())
+		})
+
+		It("fails if the instance already exists", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Equal(kazoo.ErrTopicExists))
+		})
+
+		It("returns ZooKeeper errors", func() {
+			store.SetError("/brokers/ids", errors.New("zk unavailable"))
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(MatchError("zk unavailable"))
+		})
+	})
+
+	Describe(".Destroy", func() {
+		BeforeEach(func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+		})
+
+		It("deletes the topics the tenant created under its prefix, and no others", func() {
+			createTopic(store, instanceID+"orders")
+			createTopic(store, instanceID+".payments")
+			createTopic(store, "other-instance")
+
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf(instanceID, instanceID+"orders", instanceID+".payments"))
+
+			store.CompleteTopicDeletions()
+			Expect(topics(store, "/brokers/topics")).To(ConsistOf("other-instance"))
+			Expect(repo.InstanceExists(instanceID)).To(BeFalse())
+		})
+
+		It("returns an error if the topics cannot be listed", func() {
+			store.SetError("/brokers/topics", errors.New("zk unavailable"))
+			Expect(repo.Destroy(instanceID)).To(MatchError("Failed to get Kafka topics from Zookeeper: zk unavailable"))
+		})
+	})
+
+	Describe(".Bind", func() {
+		It("returns the instance topic prefix", func() {
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials).To(Equal(broker.InstanceCredentials{
+				ZookeeperPeers:  kafkaConfig.ZookeeperPeers,
+				KafkaHostnames:  kafkaConfig.KafkaHostnames,
+				TopicNamePrefix: instanceID,
+			}))
+		})
+	})
+
+	Describe(".Unbind", func() {
+		It("succeeds", func() {
+			Expect(repo.Unbind(instanceID, "bindingID")).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,277 @@
+package kafka
+
+import (
+	"errors"
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/wvanbergen/kazoo-go"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// topicLimitViolations counts the topics found to exceed a limit, keyed by the limit.
+// It is published on /debug/vars.
+var topicLimitViolations = expvar.NewMap("topic_limit_violations")
+
+// topicConfigDrift counts the topics found with configuration outside their plan's policy.
+// It is published on /debug/vars.
+var topicConfigDrift = expvar.NewInt("topic_config_drift")
+
+var errTopicLimitExceeded = errors.New("topic exceeds the limits of its service instance")
+
+var errTopicConfigDrift = errors.New("topic configuration is outside the policy of its plan")
+
+// TopicLimitWatcher enforces the topic limits and topic configuration policies recorded by
+// LimitRepository. It checks every service instance whenever a topic is created or deleted,
+// topic configuration changes, or the check interval passes. Topics that exceed the limits
+// are logged and metered; depending on the limit action they are also deleted, or
+// topic creation under the instance's prefix is denied until it is back under its limits.
+// Topics whose configuration has drifted outside the policy are logged and metered.
+type TopicLimitWatcher struct {
+	connect zookeeper.Connector
+	logger  lager.Logger
+
+	mutex sync.Mutex
+	// firstSeen orders topics by when they were first seen, so the newest topics are the ones over the limits
+	firstSeen map[string]int
+	seen      int
+	// offending holds the topics over the limits at the last check, and the limit each exceeds
+	offending map[string]string
+	// drifted holds the topics with configuration outside the policy at the last check
+	drifted map[string]bool
+}
+
+// NewTopicLimitWatcher creates a TopicLimitWatcher
+func NewTopicLimitWatcher(connect zookeeper.Connector, logger lager.Logger) *TopicLimitWatcher {
+	return &TopicLimitWatcher{
+		connect:   connect,
+		logger:    logger,
+		firstSeen: map[string]int{},
+		offending: map[string]string{},
+		drifted:   map[string]bool{},
+	}
+}
+
+// Run checks the topic limits until stop is closed. Each check opens a new ZooKeeper session, which
+// watches for changes until the next check, so that an expired session is replaced at the latest
+// after interval.
+func (watcher *TopicLimitWatcher) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		conn, topicEvents, configEvents, err := watcher.watch()
+		if err != nil {
+			watcher.logger.Error("topic-limits.check", err, lager.Data{
+				"message": "Failed to check topic limits",
+			})
+		}
+
+		stopped := false
+		select {
+		case <-topicEvents:
+		case <-configEvents:
+		case <-ticker.C:
+		case <-stop:
+			stopped = true
+		}
+		if conn != nil {
+			_ = conn.Close()
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// watch opens a session, watches the topics and their configuration changes, and checks the
+// topic limits; the session is returned, even on error, for the caller to close
+func (watcher *TopicLimitWatcher) watch() (conn zookeeper.Conn, topicEvents, configEvents <-chan zookeeper.Event, err error) {
+	if conn, err = watcher.connect(); err != nil {
+		return nil, nil, nil, err
+	}
+	topics, topicEvents, err := conn.ChildrenW("/brokers/topics")
+	if err != nil {
+		return conn, nil, nil, err
+	}
+	if _, configEvents, err = conn.ChildrenW("/config/changes"); err != nil {
+		return conn, topicEvents, nil, err
+	}
+	return conn, topicEvents, configEvents, watcher.check(conn, topics)
+}
+
+// Check checks the topic limits of every service instance once
+func (watcher *TopicLimitWatcher) Check() error {
+	conn, err := watcher.connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	allTopics, err := zookeeper.NewCluster(conn).Topics()
+	if err != nil {
+		return err
+	}
+	return watcher.check(conn, allTopics)
+}
+
+func (watcher *TopicLimitWatcher) check(conn zookeeper.Conn, allTopics []string) error {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	watcher.observe(allTopics)
+
+	cluster := zookeeper.NewCluster(conn)
+	instanceIDs, err := recordedInstanceIDs(conn)
+	if err != nil {
+		return err
+	}
+	offending := map[string]string{}
+	drifted := map[string]bool{}
+	for _, instanceID := range instanceIDs {
+		record := instanceRecord{}
+		err := readRecord(conn, instanceRecordPath(instanceID), &record)
+		if err == zookeeper.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if record.TopicLimits.IsZero() {
+			if err = cluster.RemoveACLs(createDenialResource(instanceID), denyCreateACL); err != nil {
+				return err
+			}
+		}
+		if record.TopicLimits.IsZero() && record.TopicConfig.IsZero() {
+			continue
+		}
+
+		topics, err := instanceTopics(cluster, allTopics, instanceID)
+		if err != nil {
+			return err
+		}
+		if !record.TopicLimits.IsZero() {
+			if err = watcher.checkLimits(cluster, instanceID, topics, record.TopicLimits, offending); err != nil {
+				return err
+			}
+		}
+		watcher.checkTopicConfig(instanceID, topics, record.TopicConfig, drifted)
+	}
+	watcher.offending = offending
+	watcher.drifted = drifted
+	return nil
+}
+
+// observe records the order in which topics are first seen; topics seen together are ordered by name
+func (watcher *TopicLimitWatcher) observe(allTopics []string) {
+	sorted := append([]string{}, allTopics...)
+	sort.Strings(sorted)
+	current := map[string]int{}
+	for _, topic := range sorted {
+		order, ok := watcher.firstSeen[topic]
+		if !ok {
+			watcher.seen++
+			order = watcher.seen
+		}
+		current[topic] = order
+	}
+	watcher.firstSeen = current
+}
+
+func (watcher *TopicLimitWatcher) checkLimits(cluster *zookeeper.Cluster, instanceID string, topics []instanceTopic, limits broker.TopicLimits, offending map[string]string) error {
+	sort.Slice(topics, func(i, j int) bool {
+		return watcher.firstSeen[topics[i].name] < watcher.firstSeen[topics[j].name]
+	})
+
+	accepted := broker.TopicUsage{}
+	for _, topic := range topics {
+		exceeded := ""
+		switch {
+		case topic.name == instanceID:
+			// the topic created on provisioning is never over the limits
+		case limits.RetentionExceeded(topic.retentionMs):
+			exceeded = "max_retention_ms"
+		case limits.MaxTopics != 0 && accepted.Topics+1 > limits.MaxTopics:
+			exceeded = "max_topics"
+		case limits.MaxPartitions != 0 && accepted.Partitions+topic.partitions > limits.MaxPartitions:
+			exceeded = "max_partitions"
+		}
+		if exceeded == "" {
+			accepted.Topics++
+			accepted.Partitions += topic.partitions
+			continue
+		}
+
+		offending[topic.name] = exceeded
+		if watcher.offending[topic.name] != exceeded {
+			topicLimitViolations.Add(exceeded, 1)
+			watcher.logger.Error("topic-limits.exceeded", errTopicLimitExceeded, lager.Data{
+				"instance_id":  instanceID,
+				"topic.name":   topic.name,
+				"partitions":   topic.partitions,
+				"retention_ms": topic.retentionMs,
+				"limit":        exceeded,
+				"limits":       limits,
+				"action":       limits.Action,
+			})
+		}
+		if limits.Action == broker.LimitActionDelete {
+			watcher.deleteTopic(cluster, instanceID, topic.name)
+		}
+	}
+
+	if limits.Action != broker.LimitActionRevoke {
+		return nil
+	}
+	violations := limits.Violations(topicUsage(topics))
+	if len(violations) > 0 {
+		return cluster.AddACLs(createDenialResource(instanceID), denyCreateACL)
+	}
+	return cluster.RemoveACLs(createDenialResource(instanceID), denyCreateACL)
+}
+
+func (watcher *TopicLimitWatcher) checkTopicConfig(instanceID string, topics []instanceTopic, policy broker.TopicConfigPolicy, drifted map[string]bool) {
+	if policy.IsZero() {
+		return
+	}
+	for _, topic := range topics {
+		drift := policy.Drift(topic.config)
+		if len(drift) == 0 {
+			continue
+		}
+		drifted[topic.name] = true
+		if !watcher.drifted[topic.name] {
+			topicConfigDrift.Add(1)
+			watcher.logger.Error("topic-config.drifted", errTopicConfigDrift, lager.Data{
+				"instance_id": instanceID,
+				"topic.name":  topic.name,
+				"drift":       drift,
+			})
+		}
+	}
+}
+
+func (watcher *TopicLimitWatcher) deleteTopic(cluster *zookeeper.Cluster, instanceID, topic string) {
+	err := cluster.DeleteTopic(topic)
+	if err == kazoo.ErrTopicMarkedForDelete {
+		return
+	}
+	if err != nil {
+		watcher.logger.Error("topic-limits.delete-topic", err, lager.Data{
+			"instance_id": instanceID,
+			"topic.name":  topic,
+			"message":     "Failed to delete Kafka topic over the limits",
+		})
+		return
+	}
+	watcher.logger.Info("topic-limits.delete-topic", lager.Data{
+		"instance_id": instanceID,
+		"topic.name":  topic,
+		"message":     "Deleted Kafka topic over the limits",
+	})
+}
@@ -0,0 +1,233 @@
+package kafka_test
+
+import (
+	"bytes"
+	"expvar"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("TopicLimitWatcher", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var limits *kafka.LimitRepository
+	var watcher *kafka.TopicLimitWatcher
+
+	createTopicWith := func(name string, partitions int, config map[string]string) {
+		conn, err := store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zookeeper.NewCluster(conn).CreateTopic(name, partitions, 1, config)).To(Succeed())
+	}
+
+	denials := func() []zookeeper.ACL {
+		conn, err := store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		acls, err := zookeeper.NewCluster(conn).ACLs(zookeeper.Resource{
+			Type:        zookeeper.ResourceTopic,
+			Name:        instanceID,
+			PatternType: zookeeper.PatternPrefixed,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return acls
+	}
+
+	BeforeEach(func() {
+		store = newMemoryStore(1)
+		logger := lager.NewLogger("test")
+		limits = kafka.NewLimitRepository(store.Connect, logger)
+		watcher = kafka.NewTopicLimitWatcher(store.Connect, logger)
+
+		createTopicWith(instanceID, 2, nil)
+		createTopicWith(instanceID+".a", 2, map[string]string{"retention.ms": "1000"})
+		createTopicWith("otherInstance.a", 8, nil)
+	})
+
+	Describe("LimitRepository.Usage", func() {
+		It("counts the topics, partitions and retention under the instance prefix", func() {
+			createTopicWith(instanceID+".forever", 1, map[string]string{"retention.ms": "-1"})
+			Expect(limits.Usage(instanceID)).To(Equal(broker.TopicUsage{Topics: 3, Partitions: 5, MaxRetentionMs: -1}))
+			Expect(limits.Usage("otherInstance")).To(Equal(broker.TopicUsage{Topics: 1, Partitions: 8}))
+		})
+
+		It("refuses a retention it cannot parse rather than taking it as unlimited", func() {
+			createTopicWith(instanceID+".invalid", 1, map[string]string{"retention.ms": "forever"})
+			_, err := limits.Usage(instanceID)
+			Expect(err).To(MatchError("topic instanceID.invalid has an invalid retention.ms 'forever'"))
+		})
+	})
+
+	It("only logs topics over the limits by default", func() {
+		Expect(limits.SetInstanceLimits(instanceID, broker.TopicLimits{MaxTopics: 1})).To(Succeed())
+		Expect(watcher.Check()).To(Succeed())
+		Expect(store.CompleteTopicDeletions()).To(BeEmpty())
+		Expect(denials()).To(BeEmpty())
+	})
+
+	Context("with the delete action", func() {
+		It("deletes the newest topics over the topic and partition limits", func() {
+			Expect(limits.SetInstanceLimits(instanceID, broker.TopicLimits{
+				MaxTopics:     3,
+				MaxPartitions: 5,
+				Action:        broker.LimitActionDelete,
+			})).To(Succeed())
+			Expect(watcher.Check()).To(Succeed())
+
+			createTopicWith(instanceID+".b", 2, nil)
+			createTopicWith(instanceID+".c", 1, nil)
+			Expect(watcher.Check()).To(Succeed())
+			Expect(store.CompleteTopicDeletions()).To(ConsistOf(instanceID + ".b"))
+		})
+
+		It("deletes topics retained for longer than the limit, never the instance's own topic", func() {
+			Expect(limits.SetInstanceLimits(instanceID, broker.TopicLimits{
+				MaxTopics:      1,
+				MaxRetentionMs: 500,
+				Action:         broker.LimitActionDelete,
+			})).To(Succeed())
+			Expect(watcher.Check()).To(Succeed())
+			Expect(store.CompleteTopicDeletions()).To(ConsistOf(instanceID + ".a"))
+		})
+	})
+
+	Context("with the revoke action", func() {
+		BeforeEach(func() {
+			Expect(limits.SetInstanceLimits(instanceID, broker.TopicLimits{
+				MaxPartitions: 4,
+				Action:        broker.LimitActionRevoke,
+			})).To(Succeed())
+		})
+
+		It("denies topic creation under the prefix until the instance is back under its limits", func() {
+			Expect(watcher.Check()).To(Succeed())
+			Expect(denials()).To(BeEmpty())
+
+			createTopicWith(instanceID+".b", 1, nil)
+			Expect(watcher.Check()).To(Succeed())
+			Expect(denials()).To(ConsistOf(zookeeper.ACL{
+				Principal:      "User:*",
+				PermissionType: "Deny",
+				Operation:      "Create",
+				Host:           "*",
+			}))
+
+			conn, _ := store.Connect()
+			Expect(zookeeper.NewCluster(conn).DeleteTopic(instanceID + ".b")).To(Succeed())
+			store.CompleteTopicDeletions()
+			Expect(watcher.Check()).To(Succeed())
+			Expect(denials()).To(BeEmpty())
+		})
+
+		It("lifts the denial when the instance is removed", func() {
+			createTopicWith(instanceID+".b", 1, nil)
+			Expect(watcher.Check()).To(Succeed())
+			Expect(denials()).NotTo(BeEmpty())
+
+			Expect(limits.RemoveInstance(instanceID)).To(Succeed())
+			Expect(denials()).To(BeEmpty())
+			Expect(limits.InstanceLimits(instanceID)).To(Equal(broker.TopicLimits{}))
+		})
+	})
+
+	It("checks the limits whenever topics change while running", func() {
+		Expect(limits.SetInstanceLimits(instanceID, broker.TopicLimits{
+			MaxTopics: 2,
+			Action:    broker.LimitActionDelete,
+		})).To(Succeed())
+		store.StartController()
+		defer store.StopController()
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go watcher.Run(time.Hour, stop)
+
+		createTopicWith(instanceID+".b", 1, nil)
+		Eventually(func() []string { return topics(store, "/brokers/topics") }).Should(ConsistOf(
+			instanceID, instanceID+".a", "otherInstance.a",
+		))
+	})
+
+	It("opens a new session for each check, recovering from a failed one", func() {
+		Expect(limits.SetInstanceLimits(instanceID, broker.TopicLimits{
+			MaxTopics: 1,
+			Action:    broker.LimitActionDelete,
+		})).To(Succeed())
+		store.StartController()
+		defer store.StopController()
+
+		sessions := store
+		connections := make(chan struct{}, 1000)
+		failed := false
+		watcher = kafka.NewTopicLimitWatcher(func() (zookeeper.Conn, error) {
+			connections <- struct{}{}
+			if !failed {
+				failed = true
+				return nil, zookeeper.ErrNoNode
+			}
+			return sessions.Connect()
+		}, lager.NewLogger("test"))
+
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			watcher.Run(10*time.Millisecond, stop)
+		}()
+
+		Eventually(func() []string { return topics(store, "/brokers/topics") }).Should(ConsistOf(
+			instanceID, "otherInstance.a",
+		))
+		Eventually(func() int { return len(connections) }).Should(BeNumerically(">=", 3))
+		close(stop)
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	Describe("topic config drift", func() {
+		var log *bytes.Buffer
+
+		BeforeEach(func() {
+			log = &bytes.Buffer{}
+			logger := lager.NewLogger("test")
+			logger.RegisterSink(lager.NewWriterSink(log, lager.ERROR))
+			watcher = kafka.NewTopicLimitWatcher(store.Connect, logger)
+
+			maxRetention := int64(3600000)
+			Expect(limits.SetInstanceTopicConfigPolicy(instanceID, broker.TopicConfigPolicy{
+				Defaults: map[string]string{"cleanup.policy": "delete"},
+				Overridable: map[string]broker.TopicConfigRule{
+					"retention.ms": {Max: &maxRetention},
+				},
+			})).To(Succeed())
+		})
+
+		It("flags topics whose configuration is outside the policy, once", func() {
+			createTopicWith(instanceID+".b", 1, map[string]string{"cleanup.policy": "compact", "retention.ms": "7200000"})
+			drift := expvar.Get("topic_config_drift").(*expvar.Int).Value()
+
+			Expect(watcher.Check()).To(Succeed())
+			Expect(log.String()).To(ContainSubstring(`"topic.name":"instanceID.b"`))
+			Expect(log.String()).To(ContainSubstring(`topic config 'cleanup.policy' must be 'delete', not 'compact'`))
+			Expect(log.String()).To(ContainSubstring(`topic config 'retention.ms' must be at most 3600000, not 7200000`))
+			Expect(log.String()).NotTo(ContainSubstring(`"topic.name":"instanceID.a"`))
+			Expect(expvar.Get("topic_config_drift").(*expvar.Int).Value()).To(Equal(drift + 1))
+
+			log.Reset()
+			Expect(watcher.Check()).To(Succeed())
+			Expect(log.String()).To(BeEmpty())
+		})
+
+		It("stops checking once the instance is removed", func() {
+			createTopicWith(instanceID+".b", 1, map[string]string{"cleanup.policy": "compact"})
+			Expect(limits.RemoveInstance(instanceID)).To(Succeed())
+			Expect(watcher.Check()).To(Succeed())
+			Expect(log.String()).To(BeEmpty())
+		})
+	})
+})
@@ -2,58 +2,55 @@ package kafka
 
 import (
 	"fmt"
-	"strings"
-	"sync"
-	"time"
+	"strconv"
 
 	"code.cloudfoundry.org/lager"
-	"github.com/wvanbergen/kazoo-go"
 
 	"github.com/starkandwayne/kafka-service-broker/broker"
 	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
 )
 
 // TopicPlanRepository describes the creation/binding of topic-orientated kafka service instances
 type TopicPlanRepository struct {
+	repository
 	kafkaConfig brokerconfig.KafkaConfiguration
-	logger      lager.Logger
 }
 
 // NewTopicPlanRepository creates a TopicPlanRepository
-func NewTopicPlanRepository(kafkaConfig brokerconfig.KafkaConfiguration, logger lager.Logger) *TopicPlanRepository {
+func NewTopicPlanRepository(kafkaConfig brokerconfig.KafkaConfiguration, connect zookeeper.Connector, logger lager.Logger) *TopicPlanRepository {
 	return &TopicPlanRepository{
+		repository:  repository{connect: connect, logger: logger},
 		kafkaConfig: kafkaConfig,
-		logger:      logger,
 	}
 }
 
 // InstanceExists returns true if instanceID belongs to an existing service instance
 func (repo *TopicPlanRepository) InstanceExists(instanceID string) (bool, error) {
-	zkConf := kazoo.NewConfig()
-	zkConf.Timeout = time.Duration(repo.kafkaConfig.ZookeeperTimeout) * time.Millisecond
-	kz, err := kazoo.NewKazooFromConnectionString(repo.kafkaConfig.ZookeeperPeers, zkConf)
+	cluster, err := zookeeper.OpenCluster(repo.connect)
 	if err != nil {
 		return false, err
 	}
-	defer func() { _ = kz.Close() }()
-	return kz.Topic(instanceID).Exists()
+	defer func() { _ = cluster.Close() }()
+	return cluster.TopicExists(instanceID)
 }
 
-// Create will create a topic(s)
-func (repo *TopicPlanRepository) Create(instanceID string) error {
-	zkConf := kazoo.NewConfig()
-	zkConf.Timeout = time.Duration(repo.kafkaConfig.ZookeeperTimeout) * time.Millisecond
-	kz, err := kazoo.NewKazooFromConnectionString(repo.kafkaConfig.ZookeeperPeers, zkConf)
+// Create will create a topic(s) with the topic configuration of the settings
+func (repo *TopicPlanRepository) Create(instanceID string, settings broker.InstanceSettings) error {
+	cluster, err := zookeeper.OpenCluster(repo.connect)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = kz.Close() }()
+	defer func() { _ = cluster.Close() }()
 	// A topic with the name of the instanceID is created, even if it is not returned
 	// via credentials. It is currently used as proof that the service instance exists.
-	kz.CreateTopic(instanceID,
+	err = cluster.CreateTopic(instanceID,
 		repo.kafkaConfig.KafkaPartitionCount,
 		repo.kafkaConfig.KafkaReplicationFactor,
-		map[string]string{})
+		settings.TopicConfig)
+	if err != nil {
+		return err
+	}
 
 	repo.logger.Info("provision-instance", lager.Data{
 		"instance_id": instanceID,
@@ -64,72 +61,110 @@ func (repo *TopicPlanRepository) Create(instanceID string) error {
 	return nil
 }
 
-// Destroy will destroy any topics associated with the service instance
-// Currently "associated with" is inferred - any topic name with instanceID as a prefix
-func (repo *TopicPlanRepository) Destroy(instanceID string) error {
-	zkConf := kazoo.NewConfig()
-	zkConf.Timeout = time.Duration(repo.kafkaConfig.ZookeeperTimeout) * time.Millisecond
-	kz, err := kazoo.NewKazooFromConnectionString(repo.kafkaConfig.ZookeeperPeers, zkConf)
+// CreateCompanionTopics creates the retry topics "<instanceID>.retry.<n>" and the dead letter topic
+// "<instanceID>.dlq". They have the partitions and configuration of the instance's topic, with
+// their own retention.ms if one is given. Topics that exist are left as they are, so that a
+// provision that failed part way can be completed.
+func (repo *TopicPlanRepository) CreateCompanionTopics(instanceID string, companions broker.CompanionTopics) error {
+	cluster, err := zookeeper.OpenCluster(repo.connect)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = kz.Close() }()
-	allTopics, err := kz.Topics()
-	if err != nil {
-		return fmt.Errorf("Failed to get Kafka topics from Zookeeper: %v", err)
+	defer func() { _ = cluster.Close() }()
+	topicConfig, err := cluster.TopicConfig(instanceID)
+	if err != nil && err != zookeeper.ErrNoNode {
+		return err
 	}
 
-	var wg sync.WaitGroup
-	for i, topic := range allTopics {
-		if strings.HasPrefix(topic.Name, instanceID) {
-			wg.Add(1)
-			go func(i int, topic *kazoo.Topic) {
-				defer wg.Done()
-				fmt.Println("Deleting topic", topic.Name)
-				err = kz.DeleteTopic(topic.Name)
-				if err != nil {
-					repo.logger.Error("deprovision-instance.delete-topic", err, lager.Data{
-						"instance_id": instanceID,
-						"plan":        "topic",
-						"topic.name":  topic.Name,
-						"message":     "Failed to delete Kafka topic",
-					})
-				} else {
-					repo.logger.Info("deprovision-instance.delete-topic", lager.Data{
-						"instance_id": instanceID,
-						"plan":        "topic",
-						"topic.name":  topic.Name,
-						"message":     "Successfully deleted Kafka topic",
-					})
-				}
-			}(i, topic)
+	create := func(name string, retentionMs int64) error {
+		exists, err := cluster.TopicExists(name)
+		if err != nil || exists {
+			return err
+		}
+		config := map[string]string{}
+		for key, value := range topicConfig {
+			config[key] = value
+		}
+		if retentionMs != 0 {
+			config["retention.ms"] = strconv.FormatInt(retentionMs, 10)
+		}
+		return cluster.CreateTopic(name,
+			repo.kafkaConfig.KafkaPartitionCount,
+			repo.kafkaConfig.KafkaReplicationFactor,
+			config)
+	}
+	for n := 1; n <= companions.RetryTopics; n++ {
+		if err = create(retryTopicName(instanceID, n), companions.RetryRetentionMs); err != nil {
+			return err
+		}
+	}
+	if companions.DeadLetterTopic {
+		if err = create(deadLetterTopicName(instanceID), companions.DeadLetterRetentionMs); err != nil {
+			return err
 		}
 	}
 
-	wg.Wait()
-
-	repo.logger.Info("deprovision-instance", lager.Data{
+	repo.logger.Info("provision-instance.companion-topics", lager.Data{
 		"instance_id": instanceID,
 		"plan":        "topic",
-		"message":     "Successfully deprovisioned Kafka topic plan instance",
+		"companions":  companions,
+		"message":     "Successfully created retry and dead letter topics of Kafka topic plan instance",
 	})
-
 	return nil
 }
 
-// Bind provides the credentials to access the Kafka cluster and the provided topics
+func retryTopicName(instanceID string, n int) string {
+	return fmt.Sprintf("%s.retry.%d", instanceID, n)
+}
+
+func deadLetterTopicName(instanceID string) string {
+	return instanceID + ".dlq"
+}
+
+// Destroy will destroy any topics associated with the service instance
+// Currently "associated with" is inferred - any topic name with instanceID as a prefix
+func (repo *TopicPlanRepository) Destroy(instanceID string) error {
+	return destroyInstanceTopics(repo.connect, repo.logger, instanceID, "topic")
+}
+
+// Bind provides the credentials to access the Kafka cluster and the provided topics,
+// including any retry and dead letter topics
 func (repo *TopicPlanRepository) Bind(instanceID string, bindingID string) (broker.InstanceCredentials, error) {
+	cluster, err := zookeeper.OpenCluster(repo.connect)
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+	defer func() { _ = cluster.Close() }()
+	credentials := broker.InstanceCredentials{
+		ZookeeperPeers: repo.kafkaConfig.ZookeeperPeers,
+		KafkaHostnames: repo.kafkaConfig.KafkaHostnames,
+		TopicName:      instanceID,
+	}
+	for n := 1; ; n++ {
+		exists, err := cluster.TopicExists(retryTopicName(instanceID, n))
+		if err != nil {
+			return broker.InstanceCredentials{}, err
+		}
+		if !exists {
+			break
+		}
+		credentials.RetryTopics = append(credentials.RetryTopics, retryTopicName(instanceID, n))
+	}
+	exists, err := cluster.TopicExists(deadLetterTopicName(instanceID))
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+	if exists {
+		credentials.DeadLetterTopic = deadLetterTopicName(instanceID)
+	}
+
 	repo.logger.Info("bind-instance", lager.Data{
 		"instance_id": instanceID,
 		"binding_id":  bindingID,
 		"plan":        "topic",
 		"message":     "Successful bind of Kafka topic plan instance",
 	})
-	return broker.InstanceCredentials{
-		ZookeeperPeers: repo.kafkaConfig.ZookeeperPeers,
-		KafkaHostnames: repo.kafkaConfig.KafkaHostnames,
-		TopicName:      instanceID,
-	}, nil
+	return credentials, nil
 }
 
 // Unbind is a no-op as bindings are shared across all instances
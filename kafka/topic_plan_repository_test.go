@@ -0,0 +1,284 @@
+package kafka_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/wvanbergen/kazoo-go"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/kafka"
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+func newMemoryStore(brokerCount int) *zookeeper.MemoryStore {
+	store := zookeeper.NewMemoryStore()
+	for id := 0; id < brokerCount; id++ {
+		Expect(store.RegisterBroker(int32(id), "localhost", 9092+id)).To(Succeed())
+	}
+	return store
+}
+
+func kafkaConfiguration() brokerconfig.KafkaConfiguration {
+	return brokerconfig.KafkaConfiguration{
+		ZookeeperPeers:         "localhost:2181",
+		KafkaHostnames:         "localhost:9092,localhost:9093,localhost:9094",
+		KafkaPartitionCount:    2,
+		KafkaReplicationFactor: 3,
+	}
+}
+
+func topics(store *zookeeper.MemoryStore, parent string) []string {
+	conn, err := store.Connect()
+	Expect(err).NotTo(HaveOccurred())
+	children, err := conn.Children(parent)
+	Expect(err).NotTo(HaveOccurred())
+	return children
+}
+
+func createTopic(store *zookeeper.MemoryStore, name string) {
+	conn, err := store.Connect()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(zookeeper.NewCluster(conn).CreateTopic(name, 1, 1, nil)).To(Succeed())
+}
+
+var _ = Describe("TopicPlanRepository", func() {
+	const instanceID = "instanceID"
+
+	var store *zookeeper.MemoryStore
+	var kafkaConfig brokerconfig.KafkaConfiguration
+	var repo *kafka.TopicPlanRepository
+
+	BeforeEach(func() {
+		store = newMemoryStore(3)
+		kafkaConfig = kafkaConfiguration()
+		repo = kafka.NewTopicPlanRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+	})
+
+	Describe(".Create", func() {
+		It("creates a topic named after the instance", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			Expect(topics(store, "/brokers/topics")).To(ConsistOf(instanceID))
+			Expect(repo.InstanceExists(instanceID)).To(BeTrue())
+		})
+
+		It("spreads the configured partitions and replicas over the brokers", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+
+			conn, _ := store.Connect()
+			data, err := conn.Get("/brokers/topics/" + instanceID)
+			Expect(err).NotTo(HaveOccurred())
+			var metadata struct {
+				Partitions map[string][]int32 `json:"partitions"`
+			}
+			Expect(json.Unmarshal(data, &metadata)).To(Succeed())
+			Expect(metadata.Partitions).To(HaveLen(2))
+			for _, replicas := range metadata.Partitions {
+				Expect(replicas).To(ConsistOf(int32(0), int32(1), int32(2)))
+			}
+		})
+
+		It("writes an empty topic config", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+
+			conn, _ := store.Connect()
+			config, err := zookeeper.NewCluster(conn).TopicConfig(instanceID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).To(BeEmpty())
+		})
+
+		It("fails if the topic already exists", func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Equal(kazoo.ErrTopicExists))
+		})
+
+		It("fails if there are fewer brokers than the replication factor", func() {
+			store = newMemoryStore(2)
+			repo = kafka.NewTopicPlanRepository(kafkaConfig, store.Connect, lager.NewLogger("test"))
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Equal(kazoo.ErrInvalidReplicationFactor))
+			Expect(repo.InstanceExists(instanceID)).To(BeFalse())
+		})
+
+		It("returns ZooKeeper errors", func() {
+			store.SetError("/brokers/topics/"+instanceID, errors.New("zk unavailable"))
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(MatchError("zk unavailable"))
+		})
+
+		It("returns connection errors", func() {
+			repo = kafka.NewTopicPlanRepository(kafkaConfig, func() (zookeeper.Conn, error) {
+				return nil, errors.New("no ensemble")
+			}, lager.NewLogger("test"))
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(MatchError("no ensemble"))
+		})
+
+		It("allows only one of many concurrent creates of the same instance", func() {
+			var wg sync.WaitGroup
+			results := make(chan error, 10)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results <- repo.Create(instanceID, broker.InstanceSettings{})
+				}()
+			}
+			wg.Wait()
+			close(results)
+
+			successes := 0
+			for err := range results {
+				if err == nil {
+					successes++
+				}
+			}
+			Expect(successes).To(Equal(1))
+		})
+
+		It("creates many instances concurrently", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					defer GinkgoRecover()
+					Expect(repo.Create(fmt.Sprintf("instance-%d", i), broker.InstanceSettings{})).To(Succeed())
+				}(i)
+			}
+			wg.Wait()
+			Expect(topics(store, "/brokers/topics")).To(HaveLen(20))
+		})
+	})
+
+	Describe(".InstanceExists", func() {
+		It("is false for an unknown instance", func() {
+			Expect(repo.InstanceExists(instanceID)).To(BeFalse())
+		})
+
+		It("returns ZooKeeper errors", func() {
+			store.SetError("/brokers/topics/"+instanceID, errors.New("zk unavailable"))
+			_, err := repo.InstanceExists(instanceID)
+			Expect(err).To(MatchError("zk unavailable"))
+		})
+	})
+
+	Describe(".Destroy", func() {
+		BeforeEach(func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{})).To(Succeed())
+		})
+
+		It("marks the instance topic for deletion", func() {
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf(instanceID))
+		})
+
+		It("no longer finds the instance once the controller has deleted its topic", func() {
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(store.CompleteTopicDeletions()).To(ConsistOf(instanceID))
+			Expect(repo.InstanceExists(instanceID)).To(BeFalse())
+			Expect(topics(store, "/config/topics")).To(BeEmpty())
+		})
+
+		It("deletes every topic prefixed with the instance ID, and no others", func() {
+			createTopic(store, instanceID+".orders")
+			createTopic(store, instanceID+"-audit")
+			createTopic(store, "other-instance")
+
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf(instanceID, instanceID+".orders", instanceID+"-audit"))
+		})
+
+		It("deletes many prefixed topics concurrently", func() {
+			for i := 0; i < 50; i++ {
+				createTopic(store, fmt.Sprintf("%s.%d", instanceID, i))
+			}
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(HaveLen(51))
+		})
+
+		It("carries on when a topic is already marked for deletion", func() {
+			createTopic(store, instanceID+".orders")
+			conn, _ := store.Connect()
+			Expect(zookeeper.NewCluster(conn).DeleteTopic(instanceID)).To(Succeed())
+
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf(instanceID, instanceID+".orders"))
+		})
+
+		It("deletes the other topics and returns an error if a topic cannot be deleted", func() {
+			createTopic(store, instanceID+".orders")
+			store.SetError("/admin/delete_topics/"+instanceID+".orders", errors.New("zk unavailable"))
+
+			Expect(repo.Destroy(instanceID)).To(MatchError("failed to delete topics of service instance instanceID: instanceID.orders: zk unavailable"))
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf(instanceID))
+		})
+
+		It("returns an error if the topics cannot be listed", func() {
+			store.SetError("/brokers/topics", errors.New("zk unavailable"))
+			Expect(repo.Destroy(instanceID)).To(MatchError("Failed to get Kafka topics from Zookeeper: zk unavailable"))
+		})
+	})
+
+	Describe(".Bind", func() {
+		It("returns the instance topic", func() {
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials).To(Equal(broker.InstanceCredentials{
+				ZookeeperPeers: kafkaConfig.ZookeeperPeers,
+				KafkaHostnames: kafkaConfig.KafkaHostnames,
+				TopicName:      instanceID,
+			}))
+		})
+	})
+
+	Describe("companion topics", func() {
+		companions := broker.CompanionTopics{RetryTopics: 2, RetryRetentionMs: 3600000, DeadLetterTopic: true}
+
+		BeforeEach(func() {
+			Expect(repo.Create(instanceID, broker.InstanceSettings{TopicConfig: map[string]string{
+				"retention.ms":      "86400000",
+				"max.message.bytes": "1048576",
+			}})).To(Succeed())
+		})
+
+		It("creates retry and dead letter topics with the instance topic's config", func() {
+			Expect(repo.CreateCompanionTopics(instanceID, companions)).To(Succeed())
+			Expect(topics(store, "/brokers/topics")).To(ConsistOf(instanceID, instanceID+".retry.1", instanceID+".retry.2", instanceID+".dlq"))
+			Expect(entityConfig(store, "/config/topics/"+instanceID+".retry.2")).To(Equal(map[string]string{
+				"retention.ms":      "3600000",
+				"max.message.bytes": "1048576",
+			}))
+			Expect(entityConfig(store, "/config/topics/"+instanceID+".dlq")).To(HaveKeyWithValue("retention.ms", "86400000"))
+		})
+
+		It("creates only those that do not exist, so that it can be retried", func() {
+			Expect(repo.CreateCompanionTopics(instanceID, broker.CompanionTopics{RetryTopics: 1})).To(Succeed())
+			Expect(repo.CreateCompanionTopics(instanceID, companions)).To(Succeed())
+			Expect(topics(store, "/brokers/topics")).To(ConsistOf(instanceID, instanceID+".retry.1", instanceID+".retry.2", instanceID+".dlq"))
+		})
+
+		It("returns them in the credentials", func() {
+			Expect(repo.CreateCompanionTopics(instanceID, companions)).To(Succeed())
+			credentials, err := repo.Bind(instanceID, "bindingID")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.RetryTopics).To(Equal([]string{instanceID + ".retry.1", instanceID + ".retry.2"}))
+			Expect(credentials.DeadLetterTopic).To(Equal(instanceID + ".dlq"))
+		})
+
+		It("destroys them with the instance topic", func() {
+			Expect(repo.CreateCompanionTopics(instanceID, companions)).To(Succeed())
+			Expect(repo.Destroy(instanceID)).To(Succeed())
+			Expect(topics(store, "/admin/delete_topics")).To(ConsistOf(instanceID, instanceID+".retry.1", instanceID+".retry.2", instanceID+".dlq"))
+		})
+	})
+
+	Describe(".Unbind", func() {
+		It("succeeds", func() {
+			Expect(repo.Unbind(instanceID, "bindingID")).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/wvanbergen/kazoo-go"
+
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+// destroyInstanceTopics deletes every topic associated with a service instance.
+// Currently "associated with" is inferred - any topic name with instanceID as a prefix
+func destroyInstanceTopics(connect zookeeper.Connector, logger lager.Logger, instanceID string, plan string) error {
+	cluster, err := zookeeper.OpenCluster(connect)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cluster.Close() }()
+	allTopics, err := cluster.Topics()
+	if err != nil {
+		return fmt.Errorf("Failed to get Kafka topics from Zookeeper: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	failed := []string{}
+	for _, topic := range allTopics {
+		if strings.HasPrefix(topic, instanceID) {
+			wg.Add(1)
+			go func(topic string) {
+				defer wg.Done()
+				logger.Debug("deprovision-instance.delete-topic", lager.Data{
+					"instance_id": instanceID,
+					"plan":        plan,
+					"topic.name":  topic,
+					"message":     "Deleting Kafka topic",
+				})
+				err := cluster.DeleteTopic(topic)
+				if err != nil && err != kazoo.ErrTopicMarkedForDelete {
+					logger.Error("deprovision-instance.delete-topic", err, lager.Data{
+						"instance_id": instanceID,
+						"plan":        plan,
+						"topic.name":  topic,
+						"message":     "Failed to delete Kafka topic",
+					})
+					mutex.Lock()
+					failed = append(failed, fmt.Sprintf("%s: %v", topic, err))
+					mutex.Unlock()
+				} else {
+					logger.Info("deprovision-instance.delete-topic", lager.Data{
+						"instance_id": instanceID,
+						"plan":        plan,
+						"topic.name":  topic,
+						"message":     "Successfully deleted Kafka topic",
+					})
+				}
+			}(topic)
+		}
+	}
+
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to delete topics of service instance %s: %s", instanceID, strings.Join(failed, "; "))
+	}
+
+	logger.Info("deprovision-instance", lager.Data{
+		"instance_id": instanceID,
+		"plan":        plan,
+		"message":     fmt.Sprintf("Successfully deprovisioned Kafka %s plan instance", plan),
+	})
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package secretstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// CredHubStore keeps secrets in CredHub, as JSON credentials. Applications are given read access
+// to the credentials of their bindings, so that Cloud Foundry can resolve references to them.
+type CredHubStore struct {
+	config     brokerconfig.CredHubConfiguration
+	pathPrefix string
+	client     *http.Client
+
+	// tokenLock guards token and tokenExpiry, the UAA access token of the broker
+	tokenLock   sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewCredHubStore creates a CredHubStore
+func NewCredHubStore(config brokerconfig.CredHubConfiguration, pathPrefix string) *CredHubStore {
+	return &CredHubStore{
+		config:     config,
+		pathPrefix: pathPrefix,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: config.TLS, Proxy: http.ProxyFromEnvironment},
+		},
+	}
+}
+
+type credhubCredential struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type credhubPermission struct {
+	Path       string   `json:"path"`
+	Actor      string   `json:"actor"`
+	Operations []string `json:"operations"`
+}
+
+// Put stores value under name as a JSON credential, replacing any value it had
+func (store *CredHubStore) Put(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	credential := credhubCredential{Name: path.Join(store.pathPrefix, name), Type: "json", Value: data}
+	return store.do("PUT", "/api/v1/data", credential, nil)
+}
+
+// Get unmarshals the current value stored under name into value, or returns broker.ErrSecretNotFound
+func (store *CredHubStore) Get(name string, value interface{}) error {
+	query := url.Values{"name": {path.Join(store.pathPrefix, name)}, "current": {"true"}}
+	response := struct {
+		Data []credhubCredential `json:"data"`
+	}{}
+	if err := store.do("GET", "/api/v1/data?"+query.Encode(), nil, &response); err != nil {
+		return err
+	}
+	if len(response.Data) == 0 {
+		return broker.ErrSecretNotFound
+	}
+	return json.Unmarshal(response.Data[0].Value, value)
+}
+
+// Delete deletes every version of the value stored under name, if there is one
+func (store *CredHubStore) Delete(name string) error {
+	query := url.Values{"name": {path.Join(store.pathPrefix, name)}}
+	err := store.do("DELETE", "/api/v1/data?"+query.Encode(), nil, nil)
+	if err == broker.ErrSecretNotFound {
+		return nil
+	}
+	return err
+}
+
+// Reference grants the application read access to the credential, and returns its name
+func (store *CredHubStore) Reference(name, appGUID string) (string, error) {
+	permission := credhubPermission{
+		Path:       path.Join(store.pathPrefix, name),
+		Actor:      "mtls-app:" + appGUID,
+		Operations: []string{"read"},
+	}
+	err := store.do("POST", "/api/v2/permissions", permission, nil)
+	if err, ok := err.(credhubError); ok && err.status == http.StatusConflict {
+		// the application was already granted access
+		return permission.Path, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return permission.Path, nil
+}
+
+// credhubError is an error response of CredHub or UAA
+type credhubError struct {
+	method string
+	url    string
+	status int
+	body   string
+}
+
+func (err credhubError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", err.method, err.url, err.status, err.body)
+}
+
+// do sends a request to CredHub and decodes its response into response, if it is not nil
+func (store *CredHubStore) do(method, uri string, body, response interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, store.config.URL+uri, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if store.config.UAAURL != "" {
+		token, err := store.accessToken()
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := store.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return broker.ErrSecretNotFound
+	}
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return credhubError{method: method, url: request.URL.Path, status: resp.StatusCode, body: strings.TrimSpace(string(data))}
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// accessToken returns a UAA access token for the broker's client, requesting a new one when the
+// last one is about to expire
+func (store *CredHubStore) accessToken() (string, error) {
+	store.tokenLock.Lock()
+	defer store.tokenLock.Unlock()
+	if store.token != "" && time.Now().Before(store.tokenExpiry) {
+		return store.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	request, err := http.NewRequest("POST", store.config.UAAURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(store.config.UAAClientID), url.QueryEscape(store.config.UAAClientSecret))
+
+	resp, err := store.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", credhubError{method: "POST", url: request.URL.Path, status: resp.StatusCode, body: strings.TrimSpace(string(data))}
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	store.token = token.AccessToken
+	// renew the token a little before it expires, so that it does not expire in flight
+	store.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - 30*time.Second)
+	return store.token, nil
+}
@@ -0,0 +1,161 @@
+package secretstore_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/secretstore"
+)
+
+// stubCredHub serves the parts of the CredHub and UAA APIs the broker uses, from memory
+type stubCredHub struct {
+	sync.Mutex
+	credentials map[string]json.RawMessage
+	permissions map[string][]string
+	tokens      int
+}
+
+func (stub *stubCredHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stub.Lock()
+	defer stub.Unlock()
+
+	if r.URL.Path == "/oauth/token" {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || clientID != "broker" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		stub.tokens++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	switch {
+	case r.Method == "PUT" && r.URL.Path == "/api/v1/data":
+		credential := struct {
+			Name  string          `json:"name"`
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&credential); err != nil || credential.Type != "json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		stub.credentials[credential.Name] = credential.Value
+		_ = json.NewEncoder(w).Encode(credential)
+	case r.Method == "GET" && r.URL.Path == "/api/v1/data":
+		value, ok := stub.credentials[name]
+		if !ok || r.URL.Query().Get("current") != "true" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": "The request could not be completed because the credential does not exist or you do not have sufficient authorization."}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": []interface{}{
+			map[string]interface{}{"name": name, "type": "json", "value": value},
+		}})
+	case r.Method == "DELETE" && r.URL.Path == "/api/v1/data":
+		if _, ok := stub.credentials[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(stub.credentials, name)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" && r.URL.Path == "/api/v2/permissions":
+		permission := struct {
+			Path       string   `json:"path"`
+			Actor      string   `json:"actor"`
+			Operations []string `json:"operations"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&permission); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, actor := range stub.permissions[permission.Path] {
+			if actor == permission.Actor {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		}
+		stub.permissions[permission.Path] = append(stub.permissions[permission.Path], permission.Actor)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+var _ = Describe("CredHubStore", func() {
+	var stub *stubCredHub
+	var server *httptest.Server
+	var config brokerconfig.CredHubConfiguration
+	var store *secretstore.CredHubStore
+
+	BeforeEach(func() {
+		stub = &stubCredHub{credentials: map[string]json.RawMessage{}, permissions: map[string][]string{}}
+		server = httptest.NewTLSServer(stub)
+		config = brokerconfig.CredHubConfiguration{
+			URL:             server.URL,
+			TLS:             server.Client().Transport.(*http.Transport).TLSClientConfig,
+			UAAURL:          server.URL,
+			UAAClientID:     "broker",
+			UAAClientSecret: "secret",
+		}
+		store = secretstore.NewCredHubStore(config, "/c/kafka-service-broker")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("stores, replaces and deletes JSON credentials", func() {
+		value := map[string]string{}
+		Expect(store.Get("instanceID/bindingID/credentials", &value)).To(Equal(broker.ErrSecretNotFound))
+
+		Expect(store.Put("instanceID/bindingID/credentials", map[string]string{"password": "first"})).To(Succeed())
+		Expect(store.Put("instanceID/bindingID/credentials", map[string]string{"password": "second"})).To(Succeed())
+		Expect(stub.credentials).To(HaveKey("/c/kafka-service-broker/instanceID/bindingID/credentials"))
+		Expect(store.Get("instanceID/bindingID/credentials", &value)).To(Succeed())
+		Expect(value).To(Equal(map[string]string{"password": "second"}))
+
+		Expect(store.Delete("instanceID/bindingID/credentials")).To(Succeed())
+		Expect(store.Delete("instanceID/bindingID/credentials")).To(Succeed())
+		Expect(stub.credentials).To(BeEmpty())
+	})
+
+	It("grants applications read access to the credentials they refer to", func() {
+		Expect(store.Put("instanceID/bindingID/credentials", map[string]string{"password": "secret"})).To(Succeed())
+		reference, err := store.Reference("instanceID/bindingID/credentials", "appGUID")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reference).To(Equal("/c/kafka-service-broker/instanceID/bindingID/credentials"))
+		Expect(stub.permissions[reference]).To(Equal([]string{"mtls-app:appGUID"}))
+
+		Expect(store.Reference("instanceID/bindingID/credentials", "appGUID")).To(Equal(reference))
+	})
+
+	It("reuses its UAA access token until it expires", func() {
+		Expect(store.Put("instanceID/bindingID/credentials", map[string]string{})).To(Succeed())
+		Expect(store.Delete("instanceID/bindingID/credentials")).To(Succeed())
+		Expect(stub.tokens).To(Equal(1))
+	})
+
+	It("returns the errors of UAA and CredHub", func() {
+		config.UAAClientSecret = "wrong"
+		err := secretstore.NewCredHubStore(config, "/c/kafka-service-broker").Put("instanceID/bindingID/credentials", map[string]string{})
+		Expect(err).To(MatchError("POST /oauth/token: 401 "))
+
+		config.UAAURL = ""
+		err = secretstore.NewCredHubStore(config, "/c/kafka-service-broker").Put("instanceID/bindingID/credentials", map[string]string{})
+		Expect(err).To(MatchError("PUT /api/v1/data: 401 "))
+	})
+})
@@ -0,0 +1,159 @@
+package secretstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// FileStore keeps secrets in a local file, encrypted with AES-256-GCM. It stands in for CredHub
+// where there is none, e.g. in development: the file is only shared by the processes of one host,
+// and references to its secrets are names that no platform resolves.
+type FileStore struct {
+	file       string
+	key        []byte
+	pathPrefix string
+}
+
+// fileLock serializes the updates of secret files
+var fileLock sync.Mutex
+
+// NewFileStore creates a FileStore
+func NewFileStore(config brokerconfig.FileSecretStoreConfiguration, pathPrefix string) *FileStore {
+	return &FileStore{
+		file:       config.Path,
+		key:        config.Key,
+		pathPrefix: pathPrefix,
+	}
+}
+
+// Put stores value under name, replacing any value it had
+func (store *FileStore) Put(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	secrets, err := store.read()
+	if err != nil {
+		return err
+	}
+	secrets[path.Join(store.pathPrefix, name)] = data
+	return store.write(secrets)
+}
+
+// Get unmarshals the value stored under name into value, or returns broker.ErrSecretNotFound
+func (store *FileStore) Get(name string, value interface{}) error {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	secrets, err := store.read()
+	if err != nil {
+		return err
+	}
+	data, ok := secrets[path.Join(store.pathPrefix, name)]
+	if !ok {
+		return broker.ErrSecretNotFound
+	}
+	return json.Unmarshal(data, value)
+}
+
+// Delete deletes the value stored under name, if there is one
+func (store *FileStore) Delete(name string) error {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	secrets, err := store.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[path.Join(store.pathPrefix, name)]; !ok {
+		return nil
+	}
+	delete(secrets, path.Join(store.pathPrefix, name))
+	return store.write(secrets)
+}
+
+// Reference returns the full name of the secret; the file has no access control of its own
+func (store *FileStore) Reference(name, appGUID string) (string, error) {
+	return path.Join(store.pathPrefix, name), nil
+}
+
+func (store *FileStore) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(store.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// read decrypts the secrets of the file, which has none until it is first written
+func (store *FileStore) read() (map[string]json.RawMessage, error) {
+	secrets := map[string]json.RawMessage{}
+	data, err := ioutil.ReadFile(store.file)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	aead, err := store.cipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("secret file %s is truncated", store.file)
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("secret file " + store.file + " cannot be decrypted with SECRET_STORE_KEY")
+	}
+	if err = json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// write encrypts the secrets with a new nonce, and replaces the file atomically
+func (store *FileStore) write(secrets map[string]json.RawMessage) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	aead, err := store.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(store.file), filepath.Base(store.file))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tempFile.Name()) }()
+	_, err = tempFile.Write(aead.Seal(nonce, nonce, plaintext, nil))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), store.file)
+}
@@ -0,0 +1,73 @@
+package secretstore_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+	"github.com/starkandwayne/kafka-service-broker/secretstore"
+)
+
+var _ = Describe("FileStore", func() {
+	var dir string
+	var config brokerconfig.FileSecretStoreConfiguration
+	var store *secretstore.FileStore
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "secrets")
+		Expect(err).NotTo(HaveOccurred())
+		config = brokerconfig.FileSecretStoreConfiguration{
+			Path: filepath.Join(dir, "secrets"),
+			Key:  bytes.Repeat([]byte{7}, 32),
+		}
+		store = secretstore.NewFileStore(config, "/c/kafka-service-broker")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("stores, replaces and deletes secrets", func() {
+		value := map[string]string{}
+		Expect(store.Get("instanceID/bindingID/credentials", &value)).To(Equal(broker.ErrSecretNotFound))
+
+		Expect(store.Put("instanceID/bindingID/credentials", map[string]string{"password": "first"})).To(Succeed())
+		Expect(store.Put("instanceID/otherID/credentials", map[string]string{"password": "other"})).To(Succeed())
+		Expect(store.Put("instanceID/bindingID/credentials", map[string]string{"password": "second"})).To(Succeed())
+		Expect(store.Get("instanceID/bindingID/credentials", &value)).To(Succeed())
+		Expect(value).To(Equal(map[string]string{"password": "second"}))
+
+		Expect(store.Delete("instanceID/bindingID/credentials")).To(Succeed())
+		Expect(store.Delete("instanceID/bindingID/credentials")).To(Succeed())
+		Expect(store.Get("instanceID/bindingID/credentials", &value)).To(Equal(broker.ErrSecretNotFound))
+		Expect(store.Get("instanceID/otherID/credentials", &value)).To(Succeed())
+		Expect(value).To(Equal(map[string]string{"password": "other"}))
+	})
+
+	It("encrypts the file", func() {
+		Expect(store.Put("instanceID/bindingID/credentials", map[string]string{"password": "secret"})).To(Succeed())
+		data, err := ioutil.ReadFile(config.Path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).NotTo(ContainSubstring("secret"))
+		Expect(string(data)).NotTo(ContainSubstring("bindingID"))
+		info, err := os.Stat(config.Path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		config.Key = bytes.Repeat([]byte{8}, 32)
+		value := map[string]string{}
+		err = secretstore.NewFileStore(config, "/c/kafka-service-broker").Get("instanceID/bindingID/credentials", &value)
+		Expect(err).To(MatchError(ContainSubstring("cannot be decrypted")))
+	})
+
+	It("refers to secrets by their full name", func() {
+		Expect(store.Reference("instanceID/bindingID/credentials", "appGUID")).To(Equal("/c/kafka-service-broker/instanceID/bindingID/credentials"))
+	})
+})
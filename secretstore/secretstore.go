@@ -0,0 +1,18 @@
+// Package secretstore keeps the secrets of bindings in an encrypted file or in CredHub
+package secretstore
+
+import (
+	"github.com/starkandwayne/kafka-service-broker/broker"
+	"github.com/starkandwayne/kafka-service-broker/brokerconfig"
+)
+
+// New creates the secret store of the configuration, or returns nil if there is none
+func New(config brokerconfig.SecretStoreConfiguration) broker.SecretStore {
+	switch config.Type {
+	case brokerconfig.SecretStoreFile:
+		return NewFileStore(config.File, config.PathPrefix)
+	case brokerconfig.SecretStoreCredHub:
+		return NewCredHubStore(config.CredHub, config.PathPrefix)
+	}
+	return nil
+}
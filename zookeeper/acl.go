@@ -0,0 +1,167 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Kafka resource types that ACLs apply to
+const (
+	ResourceTopic = "Topic"
+	ResourceGroup = "Group"
+)
+
+// Pattern types of a Kafka ACL resource: a literal name, or a prefix of names
+const (
+	PatternLiteral  = "Literal"
+	PatternPrefixed = "Prefixed"
+)
+
+// Resource identifies what a Kafka ACL applies to
+type Resource struct {
+	Type        string
+	Name        string
+	PatternType string
+}
+
+// ACL is an entry in the access control list of a Kafka resource, as stored
+// in ZooKeeper by kafka.security.auth.SimpleAclAuthorizer
+type ACL struct {
+	Principal      string `json:"principal"`
+	PermissionType string `json:"permissionType"`
+	Operation      string `json:"operation"`
+	Host           string `json:"host"`
+}
+
+type aclNode struct {
+	Version int   `json:"version"`
+	ACLs    []ACL `json:"acls"`
+}
+
+// ACLs returns the ACLs of a Kafka resource
+func (cluster *Cluster) ACLs(resource Resource) ([]ACL, error) {
+	data, err := cluster.conn.Get(aclPath(resource))
+	if err == ErrNoNode {
+		return []ACL{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	node := aclNode{}
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	return node.ACLs, nil
+}
+
+// AddACLs adds ACLs to a Kafka resource, and notifies the Kafka brokers of the change
+func (cluster *Cluster) AddACLs(resource Resource, acls ...ACL) error {
+	return cluster.updateACLs(resource, func(existing []ACL) []ACL {
+		updated := existing
+		for _, acl := range acls {
+			if !containsACL(updated, acl) {
+				updated = append(updated, acl)
+			}
+		}
+		return updated
+	})
+}
+
+// RemoveACLs removes ACLs from a Kafka resource, and notifies the Kafka brokers of the change
+func (cluster *Cluster) RemoveACLs(resource Resource, acls ...ACL) error {
+	return cluster.updateACLs(resource, func(existing []ACL) []ACL {
+		updated := []ACL{}
+		for _, acl := range existing {
+			if !containsACL(acls, acl) {
+				updated = append(updated, acl)
+			}
+		}
+		return updated
+	})
+}
+
+// maxACLUpdateAttempts is how many times updateACLs reads and writes the ACLs of a resource
+// that keep being changed concurrently before giving up
+const maxACLUpdateAttempts = 10
+
+// ErrACLConflict is returned when the ACLs of a resource keep being changed concurrently
+var ErrACLConflict = errors.New("the ACLs were changed concurrently too many times; try again")
+
+// updateACLs replaces the ACLs of a Kafka resource with those change returns, only if they were
+// not changed in between; otherwise it reads them again and retries. The Kafka brokers are
+// notified if the ACLs changed.
+func (cluster *Cluster) updateACLs(resource Resource, change func(existing []ACL) []ACL) error {
+	for attempt := 0; attempt < maxACLUpdateAttempts; attempt++ {
+		data, version, err := cluster.conn.GetVersion(aclPath(resource))
+		exists := err == nil
+		if err != nil && err != ErrNoNode {
+			return err
+		}
+		node := aclNode{}
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &node); err != nil {
+				return err
+			}
+		}
+		updated := change(append([]ACL{}, node.ACLs...))
+		if len(updated) == len(node.ACLs) {
+			return nil
+		}
+		if data, err = json.Marshal(aclNode{Version: 1, ACLs: updated}); err != nil {
+			return err
+		}
+		switch {
+		case len(updated) == 0:
+			err = cluster.conn.DeleteVersion(aclPath(resource), version)
+		case exists:
+			err = cluster.conn.SetVersion(aclPath(resource), data, version)
+		default:
+			err = cluster.conn.Create(aclPath(resource), data)
+		}
+		if err == nil {
+			return cluster.notifyACLChange(resource)
+		}
+		if err != ErrBadVersion && err != ErrNodeExists && err != ErrNoNode {
+			return err
+		}
+	}
+	return ErrACLConflict
+}
+
+// notifyACLChange adds the sequential change notification that Kafka brokers watch for.
+// Literal resources use the original notification format; prefixed resources the extended one.
+func (cluster *Cluster) notifyACLChange(resource Resource) error {
+	if resource.PatternType == PatternPrefixed {
+		data, err := json.Marshal(map[string]interface{}{
+			"version":      1,
+			"resourceType": resource.Type,
+			"name":         resource.Name,
+			"patternType":  resource.PatternType,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = cluster.conn.CreateSequential("/kafka-acl-extended-changes/acl_changes_", data)
+		return err
+	}
+	data := []byte(fmt.Sprintf("%s:%s", resource.Type, resource.Name))
+	_, err := cluster.conn.CreateSequential("/kafka-acl-changes/acl_changes_", data)
+	return err
+}
+
+func aclPath(resource Resource) string {
+	if resource.PatternType == PatternPrefixed {
+		return fmt.Sprintf("/kafka-acl-extended/prefixed/%s/%s", resource.Type, resource.Name)
+	}
+	return fmt.Sprintf("/kafka-acl/%s/%s", resource.Type, resource.Name)
+}
+
+func containsACL(acls []ACL, acl ACL) bool {
+	for _, existing := range acls {
+		if existing == acl {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package zookeeper_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("ACLs", func() {
+	var conn zookeeper.Conn
+	var cluster *zookeeper.Cluster
+
+	allowRead := zookeeper.ACL{Principal: "User:app", PermissionType: "Allow", Operation: "Read", Host: "*"}
+	denyCreate := zookeeper.ACL{Principal: "User:*", PermissionType: "Deny", Operation: "Create", Host: "*"}
+
+	var store *zookeeper.MemoryStore
+
+	BeforeEach(func() {
+		var err error
+		store = zookeeper.NewMemoryStore()
+		conn, err = store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		cluster = zookeeper.NewCluster(conn)
+	})
+
+	It("stores literal ACLs where SimpleAclAuthorizer reads them, and notifies the brokers", func() {
+		group := zookeeper.Resource{Type: zookeeper.ResourceGroup, Name: "group", PatternType: zookeeper.PatternLiteral}
+		Expect(cluster.AddACLs(group, allowRead, allowRead)).To(Succeed())
+		Expect(cluster.ACLs(group)).To(Equal([]zookeeper.ACL{allowRead}))
+
+		data, err := conn.Get("/kafka-acl/Group/group")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(MatchJSON(`{"version":1,"acls":[{"principal":"User:app","permissionType":"Allow","operation":"Read","host":"*"}]}`))
+
+		changes, err := conn.Children("/kafka-acl-changes")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(HaveLen(1))
+		Expect(conn.Get("/kafka-acl-changes/" + changes[0])).To(Equal([]byte("Group:group")))
+	})
+
+	It("stores prefixed ACLs with the extended change notifications", func() {
+		prefix := zookeeper.Resource{Type: zookeeper.ResourceTopic, Name: "instance", PatternType: zookeeper.PatternPrefixed}
+		Expect(cluster.AddACLs(prefix, denyCreate)).To(Succeed())
+		Expect(conn.Exists("/kafka-acl-extended/prefixed/Topic/instance")).To(BeTrue())
+
+		changes, err := conn.Children("/kafka-acl-extended-changes")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changes).To(HaveLen(1))
+		data, err := conn.Get("/kafka-acl-extended-changes/" + changes[0])
+		Expect(err).NotTo(HaveOccurred())
+		var notification map[string]interface{}
+		Expect(json.Unmarshal(data, &notification)).To(Succeed())
+		Expect(notification).To(HaveKeyWithValue("patternType", "Prefixed"))
+	})
+
+	It("removes ACLs, and the resource once it has none", func() {
+		topic := zookeeper.Resource{Type: zookeeper.ResourceTopic, Name: "topic", PatternType: zookeeper.PatternLiteral}
+		Expect(cluster.AddACLs(topic, allowRead, denyCreate)).To(Succeed())
+
+		Expect(cluster.RemoveACLs(topic, denyCreate)).To(Succeed())
+		Expect(cluster.ACLs(topic)).To(Equal([]zookeeper.ACL{allowRead}))
+
+		Expect(cluster.RemoveACLs(topic, allowRead)).To(Succeed())
+		Expect(conn.Exists("/kafka-acl/Topic/topic")).To(BeFalse())
+		Expect(cluster.RemoveACLs(topic, allowRead)).To(Succeed())
+		Expect(conn.Children("/kafka-acl-changes")).To(HaveLen(3))
+	})
+
+	It("keeps the ACLs added concurrently", func() {
+		group := zookeeper.Resource{Type: zookeeper.ResourceGroup, Name: "group", PatternType: zookeeper.PatternLiteral}
+		principals := []string{"User:a", "User:b", "User:c", "User:d"}
+		errs := make(chan error, len(principals))
+		for _, principal := range principals {
+			go func(principal string) {
+				defer GinkgoRecover()
+				conn, err := store.Connect()
+				Expect(err).NotTo(HaveOccurred())
+				acl := zookeeper.ACL{Principal: principal, PermissionType: "Allow", Operation: "Read", Host: "*"}
+				errs <- zookeeper.NewCluster(conn).AddACLs(group, acl)
+			}(principal)
+		}
+		for range principals {
+			Expect(<-errs).To(Succeed())
+		}
+
+		acls, err := cluster.ACLs(group)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(acls).To(HaveLen(len(principals)))
+		Expect(conn.Children("/kafka-acl-changes")).To(HaveLen(len(principals)))
+	})
+})
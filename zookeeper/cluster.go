@@ -0,0 +1,253 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/wvanbergen/kazoo-go"
+)
+
+// Cluster reads and writes the Kafka cluster metadata stored in ZooKeeper.
+// It follows the same znode layout as kazoo-go.
+type Cluster struct {
+	conn Conn
+}
+
+// NewCluster wraps an open Conn
+func NewCluster(conn Conn) *Cluster {
+	return &Cluster{conn: conn}
+}
+
+// OpenCluster opens a new ZooKeeper session and wraps it in a Cluster
+func OpenCluster(connect Connector) (*Cluster, error) {
+	conn, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return NewCluster(conn), nil
+}
+
+// Close closes the underlying ZooKeeper session
+func (cluster *Cluster) Close() error {
+	return cluster.conn.Close()
+}
+
+// Brokers returns the address of each registered Kafka broker, keyed by broker ID
+func (cluster *Cluster) Brokers() (map[int32]string, error) {
+	children, err := cluster.conn.Children("/brokers/ids")
+	if err != nil {
+		return nil, err
+	}
+
+	type brokerEntry struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+
+	result := make(map[int32]string)
+	for _, child := range children {
+		brokerID, err := strconv.ParseInt(child, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		value, err := cluster.conn.Get("/brokers/ids/" + child)
+		if err != nil {
+			return nil, err
+		}
+		var brokerNode brokerEntry
+		if err := json.Unmarshal(value, &brokerNode); err != nil {
+			return nil, err
+		}
+		result[int32(brokerID)] = fmt.Sprintf("%s:%d", brokerNode.Host, brokerNode.Port)
+	}
+	return result, nil
+}
+
+// BrokerList returns the addresses of all registered Kafka brokers, ordered by broker ID
+func (cluster *Cluster) BrokerList() ([]string, error) {
+	brokers, err := cluster.Brokers()
+	if err != nil {
+		return nil, err
+	}
+	ids := brokerIDs(brokers)
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, brokers[id])
+	}
+	return result, nil
+}
+
+// Topics returns the names of all Kafka topics
+func (cluster *Cluster) Topics() ([]string, error) {
+	return cluster.conn.Children("/brokers/topics")
+}
+
+// WatchTopics returns the names of all Kafka topics, and watches for topics being created or deleted
+func (cluster *Cluster) WatchTopics() ([]string, <-chan Event, error) {
+	return cluster.conn.ChildrenW("/brokers/topics")
+}
+
+// TopicExists returns true if the topic exists on the Kafka cluster
+func (cluster *Cluster) TopicExists(name string) (bool, error) {
+	return cluster.conn.Exists(topicPath(name))
+}
+
+// PartitionCount returns the number of partitions of a topic
+func (cluster *Cluster) PartitionCount(name string) (int, error) {
+	data, err := cluster.conn.Get(topicPath(name))
+	if err != nil {
+		return 0, err
+	}
+	var metadata struct {
+		Partitions map[string][]int32 `json:"partitions"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return 0, err
+	}
+	return len(metadata.Partitions), nil
+}
+
+// PartitionLeaders returns the ID of the broker leading each partition of a topic, as
+// recorded by the Kafka controller. The preferred replica is assumed for partitions
+// without a recorded state.
+func (cluster *Cluster) PartitionLeaders(name string) (map[int32]int32, error) {
+	data, err := cluster.conn.Get(topicPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var metadata struct {
+		Partitions map[string][]int32 `json:"partitions"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	leaders := map[int32]int32{}
+	for partition, replicas := range metadata.Partitions {
+		id, err := strconv.ParseInt(partition, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		data, err := cluster.conn.Get(topicPath(name) + "/partitions/" + partition + "/state")
+		if err == ErrNoNode {
+			if len(replicas) > 0 {
+				leaders[int32(id)] = replicas[0]
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var state struct {
+			Leader int32 `json:"leader"`
+		}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, err
+		}
+		leaders[int32(id)] = state.Leader
+	}
+	return leaders, nil
+}
+
+// CreateTopic creates a Kafka topic with its partitions spread over the registered brokers
+func (cluster *Cluster) CreateTopic(name string, partitionCount int, replicationFactor int, topicConfig map[string]string) error {
+	exists, err := cluster.TopicExists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return kazoo.ErrTopicExists
+	}
+
+	brokers, err := cluster.Brokers()
+	if err != nil {
+		return err
+	}
+	assignment, err := assignPartitions(brokerIDs(brokers), partitionCount, replicationFactor)
+	if err != nil {
+		return err
+	}
+
+	if topicConfig == nil {
+		topicConfig = map[string]string{}
+	}
+	configData, err := json.Marshal(map[string]interface{}{"version": 1, "config": topicConfig})
+	if err != nil {
+		return err
+	}
+	partitionData, err := json.Marshal(map[string]interface{}{"version": 1, "partitions": assignment})
+	if err != nil {
+		return err
+	}
+
+	if err = cluster.createOrUpdate(topicConfigPath(name), configData); err != nil {
+		return err
+	}
+	return cluster.conn.Create(topicPath(name), partitionData)
+}
+
+// DeleteTopic marks a Kafka topic for deletion. Deletion is performed
+// asynchronously by the Kafka controller.
+func (cluster *Cluster) DeleteTopic(name string) error {
+	node := "/admin/delete_topics/" + name
+	exists, err := cluster.conn.Exists(node)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return kazoo.ErrTopicMarkedForDelete
+	}
+	return cluster.conn.Create(node, nil)
+}
+
+// TopicConfig returns the topic-level configuration overrides for a topic
+func (cluster *Cluster) TopicConfig(name string) (map[string]string, error) {
+	return cluster.EntityConfig(EntityTopics, name)
+}
+
+func (cluster *Cluster) createOrUpdate(node string, data []byte) error {
+	err := cluster.conn.Set(node, data)
+	if err == ErrNoNode {
+		return cluster.conn.Create(node, data)
+	}
+	return err
+}
+
+func topicPath(name string) string {
+	return "/brokers/topics/" + name
+}
+
+func topicConfigPath(name string) string {
+	return entityConfigPath(EntityTopics, name)
+}
+
+func brokerIDs(brokers map[int32]string) []int32 {
+	ids := make([]int32, 0, len(brokers))
+	for id := range brokers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// assignPartitions places the replicas of each partition on distinct, randomly chosen brokers
+func assignPartitions(brokers []int32, partitionCount int, replicationFactor int) (map[string][]int32, error) {
+	if partitionCount <= 0 {
+		return nil, kazoo.ErrInvalidPartitionCount
+	}
+	if replicationFactor <= 0 || len(brokers) < replicationFactor {
+		return nil, kazoo.ErrInvalidReplicationFactor
+	}
+
+	assignment := make(map[string][]int32, partitionCount)
+	for p := 0; p < partitionCount; p++ {
+		replicas := make([]int32, replicationFactor)
+		for r, brokerIndex := range rand.Perm(len(brokers))[0:replicationFactor] {
+			replicas[r] = brokers[brokerIndex]
+		}
+		assignment[strconv.Itoa(p)] = replicas
+	}
+	return assignment, nil
+}
@@ -0,0 +1,98 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Kafka entity types with dynamic configuration stored under /config
+const (
+	EntityTopics  = "topics"
+	EntityClients = "clients"
+	EntityUsers   = "users"
+)
+
+// EntityConfig returns the dynamic configuration of a Kafka entity, such as
+// the overrides of a topic or the quotas of a client ID
+func (cluster *Cluster) EntityConfig(entityType, entityName string) (map[string]string, error) {
+	data, err := cluster.conn.Get(entityConfigPath(entityType, entityName))
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Config map[string]string `json:"config"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return config.Config, nil
+}
+
+// SetEntityConfig replaces the dynamic configuration of a Kafka entity,
+// and notifies the Kafka brokers of the change
+func (cluster *Cluster) SetEntityConfig(entityType, entityName string, config map[string]string) error {
+	if config == nil {
+		config = map[string]string{}
+	}
+	data, err := json.Marshal(map[string]interface{}{"version": 1, "config": config})
+	if err != nil {
+		return err
+	}
+	if err = cluster.createOrUpdate(entityConfigPath(entityType, entityName), data); err != nil {
+		return err
+	}
+	return cluster.notifyConfigChange(entityType, entityName)
+}
+
+// UpdateEntityConfig sets and removes some keys of the dynamic configuration of a Kafka entity,
+// keeping the others, so that e.g. the quotas and SCRAM credentials of a user can be managed
+// separately. The configuration is deleted once it has no keys left.
+func (cluster *Cluster) UpdateEntityConfig(entityType, entityName string, set map[string]string, remove ...string) error {
+	config, err := cluster.EntityConfig(entityType, entityName)
+	if err != nil && err != ErrNoNode {
+		return err
+	}
+	if config == nil {
+		config = map[string]string{}
+	}
+	for _, key := range remove {
+		delete(config, key)
+	}
+	for key, value := range set {
+		config[key] = value
+	}
+	if len(config) == 0 {
+		return cluster.DeleteEntityConfig(entityType, entityName)
+	}
+	return cluster.SetEntityConfig(entityType, entityName, config)
+}
+
+// DeleteEntityConfig removes the dynamic configuration of a Kafka entity, if any,
+// and notifies the Kafka brokers of the change
+func (cluster *Cluster) DeleteEntityConfig(entityType, entityName string) error {
+	err := cluster.conn.Delete(entityConfigPath(entityType, entityName))
+	if err == ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return cluster.notifyConfigChange(entityType, entityName)
+}
+
+// notifyConfigChange adds the sequential change notification that Kafka brokers watch for
+func (cluster *Cluster) notifyConfigChange(entityType, entityName string) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"version":     2,
+		"entity_path": fmt.Sprintf("%s/%s", entityType, entityName),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cluster.conn.CreateSequential("/config/changes/config_change_", data)
+	return err
+}
+
+func entityConfigPath(entityType, entityName string) string {
+	return fmt.Sprintf("/config/%s/%s", entityType, entityName)
+}
@@ -0,0 +1,224 @@
+package zookeeper
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/wvanbergen/kazoo-go"
+)
+
+var (
+	// ErrNoNode is returned when an operation targets a znode that does not exist
+	ErrNoNode = zk.ErrNoNode
+	// ErrNodeExists is returned when creating a znode that already exists
+	ErrNodeExists = zk.ErrNodeExists
+	// ErrNotEmpty is returned when deleting a znode that still has children
+	ErrNotEmpty = zk.ErrNotEmpty
+	// ErrConnectionClosed is returned when a closed Conn is used
+	ErrConnectionClosed = zk.ErrConnectionClosed
+	// ErrBadVersion is returned by SetVersion when the znode was changed since its version was read
+	ErrBadVersion = zk.ErrBadVersion
+)
+
+// Event is delivered, once, on the channel returned when setting a watch
+type Event = zk.Event
+
+// EventType identifies what triggered a watch
+type EventType = zk.EventType
+
+// Event types delivered to watches
+const (
+	EventNodeCreated         = zk.EventNodeCreated
+	EventNodeDeleted         = zk.EventNodeDeleted
+	EventNodeDataChanged     = zk.EventNodeDataChanged
+	EventNodeChildrenChanged = zk.EventNodeChildrenChanged
+)
+
+// Conn is the set of ZooKeeper operations used by the broker.
+// Paths are absolute, and relative to any chroot the Conn was opened with.
+type Conn interface {
+	Exists(path string) (bool, error)
+	Get(path string) ([]byte, error)
+	Children(path string) ([]string, error)
+	// ExistsW is like Exists, and watches the znode for creation, deletion or changes to its data
+	ExistsW(path string) (bool, <-chan Event, error)
+	// ChildrenW is like Children, and watches the znode for children being added or removed
+	ChildrenW(path string) ([]string, <-chan Event, error)
+	// Create stores data at a new znode, creating any missing parent znodes
+	Create(path string, data []byte) error
+	// CreateSequential is like Create, appending a monotonically increasing counter
+	// to the name of the znode; the full path is returned
+	CreateSequential(path string, data []byte) (string, error)
+	Set(path string, data []byte) error
+	// GetVersion is like Get, and also returns the version of the znode's data, for SetVersion
+	GetVersion(path string) ([]byte, int32, error)
+	// SetVersion is like Set, but fails with ErrBadVersion if the data is no longer at version,
+	// so that concurrent read-modify-writes of a znode cannot overwrite each other
+	SetVersion(path string, data []byte, version int32) error
+	Delete(path string) error
+	// DeleteVersion is like Delete, but fails with ErrBadVersion if the data is no longer at version
+	DeleteVersion(path string, version int32) error
+	Close() error
+}
+
+// Connector opens a new ZooKeeper session
+type Connector func() (Conn, error)
+
+// NewConnector returns a Connector for a live ZooKeeper ensemble.
+// The connection string may include a chroot, e.g. "zk1:2181,zk2:2181/kafka"
+func NewConnector(connectionString string, timeout time.Duration) Connector {
+	return NewSecureConnector(connectionString, timeout, Security{})
+}
+
+// NewSecureConnector is like NewConnector, authenticating each session and setting
+// ACLs on the znodes it creates as configured by security
+func NewSecureConnector(connectionString string, timeout time.Duration, security Security) Connector {
+	return func() (Conn, error) {
+		servers, chroot := kazoo.ParseConnectionString(connectionString)
+		var conn *zk.Conn
+		var err error
+		if security.TLS != nil {
+			conn, _, err = zk.Connect(servers, timeout, zk.WithDialer(security.dial))
+		} else {
+			conn, _, err = zk.Connect(servers, timeout)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if security.authenticated() {
+			if err = conn.AddAuth("digest", []byte(security.DigestUsername+":"+security.DigestPassword)); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return &zkConn{conn: conn, chroot: chroot, security: security}, nil
+	}
+}
+
+// zkConn is a Conn backed by a live ZooKeeper session
+type zkConn struct {
+	conn     *zk.Conn
+	chroot   string
+	security Security
+}
+
+func (c *zkConn) path(node string) string {
+	return c.chroot + node
+}
+
+func (c *zkConn) Exists(node string) (bool, error) {
+	ok, _, err := c.conn.Exists(c.path(node))
+	return ok, err
+}
+
+func (c *zkConn) Get(node string) ([]byte, error) {
+	data, _, err := c.conn.Get(c.path(node))
+	return data, err
+}
+
+func (c *zkConn) Children(node string) ([]string, error) {
+	children, _, err := c.conn.Children(c.path(node))
+	return children, err
+}
+
+func (c *zkConn) ExistsW(node string) (bool, <-chan Event, error) {
+	ok, _, events, err := c.conn.ExistsW(c.path(node))
+	return ok, events, err
+}
+
+func (c *zkConn) ChildrenW(node string) ([]string, <-chan Event, error) {
+	children, _, events, err := c.conn.ChildrenW(c.path(node))
+	return children, events, err
+}
+
+func (c *zkConn) Create(node string, data []byte) error {
+	if err := c.mkdirRecursive(path.Dir(c.path(node))); err != nil {
+		return err
+	}
+	_, err := c.conn.Create(c.path(node), data, 0, c.security.ACLs(node))
+	return err
+}
+
+func (c *zkConn) CreateSequential(node string, data []byte) (string, error) {
+	if err := c.mkdirRecursive(path.Dir(c.path(node))); err != nil {
+		return "", err
+	}
+	created, err := c.conn.Create(c.path(node), data, zk.FlagSequence, c.security.ACLs(node))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(created, c.chroot), nil
+}
+
+func (c *zkConn) Set(node string, data []byte) error {
+	_, err := c.conn.Set(c.path(node), data, -1)
+	return err
+}
+
+func (c *zkConn) GetVersion(node string) ([]byte, int32, error) {
+	data, stat, err := c.conn.Get(c.path(node))
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, stat.Version, nil
+}
+
+func (c *zkConn) SetVersion(node string, data []byte, version int32) error {
+	_, err := c.conn.Set(c.path(node), data, version)
+	return err
+}
+
+func (c *zkConn) Delete(node string) error {
+	return c.conn.Delete(c.path(node), -1)
+}
+
+func (c *zkConn) DeleteVersion(node string, version int32) error {
+	return c.conn.Delete(c.path(node), version)
+}
+
+func (c *zkConn) Close() error {
+	c.conn.Close()
+	return nil
+}
+
+// mkdirRecursive creates node and any missing parents; node already includes the chroot
+func (c *zkConn) mkdirRecursive(node string) error {
+	if node == "/" {
+		return nil
+	}
+	if err := c.mkdirRecursive(path.Dir(node)); err != nil {
+		return err
+	}
+	exists, _, err := c.conn.Exists(node)
+	if err != nil || exists {
+		return err
+	}
+	_, err = c.conn.Create(node, nil, 0, c.security.ACLs(strings.TrimPrefix(node, c.chroot)))
+	if err == zk.ErrNodeExists {
+		return nil
+	}
+	return err
+}
+
+// DeleteRecursive deletes a znode and all of its children; it is not an error if the znode does not exist
+func DeleteRecursive(conn Conn, node string) error {
+	children, err := conn.Children(node)
+	if err == ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err = DeleteRecursive(conn, path.Join(node, child)); err != nil {
+			return err
+		}
+	}
+	err = conn.Delete(node)
+	if err == ErrNoNode {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package zookeeper
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/wvanbergen/kazoo-go"
+)
+
+// ErrRunningInstances is returned when deleting a consumer group that still has members
+var ErrRunningInstances = kazoo.ErrRunningInstances
+
+// ConsumerGroups returns the names of the ZooKeeper-based consumer groups registered in /consumers
+func (cluster *Cluster) ConsumerGroups() ([]string, error) {
+	groups, err := cluster.conn.Children("/consumers")
+	if err == ErrNoNode {
+		return []string{}, nil
+	}
+	return groups, err
+}
+
+// ConsumerGroupOffsets returns the offsets committed by a consumer group, by topic and partition,
+// like kazoo's Consumergroup.FetchAllOffsets
+func (cluster *Cluster) ConsumerGroupOffsets(group string) (map[string]map[int32]int64, error) {
+	result := map[string]map[int32]int64{}
+	offsetsPath := consumerGroupPath(group) + "/offsets"
+	topics, err := cluster.conn.Children(offsetsPath)
+	if err == ErrNoNode {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range topics {
+		partitions, err := cluster.conn.Children(offsetsPath + "/" + topic)
+		if err == ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[topic] = map[int32]int64{}
+		for _, partition := range partitions {
+			data, err := cluster.conn.Get(offsetsPath + "/" + topic + "/" + partition)
+			if err == ErrNoNode {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			id, err := strconv.ParseInt(partition, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			offset, err := strconv.ParseInt(string(data), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			result[topic][int32(id)] = offset
+		}
+	}
+	return result, nil
+}
+
+// ConsumerGroupMembers returns the IDs of the members registered in a ZooKeeper-based consumer group
+func (cluster *Cluster) ConsumerGroupMembers(group string) ([]string, error) {
+	members, err := cluster.conn.Children(consumerGroupPath(group) + "/ids")
+	if err == ErrNoNode {
+		return []string{}, nil
+	}
+	return members, err
+}
+
+// DeleteConsumerGroup removes a consumer group and its committed offsets from /consumers,
+// like kazoo's Consumergroup.Delete. It fails with ErrRunningInstances if the group has members,
+// and it is not an error if the group does not exist.
+func (cluster *Cluster) DeleteConsumerGroup(group string) error {
+	members, err := cluster.ConsumerGroupMembers(group)
+	if err != nil {
+		return err
+	}
+	if len(members) > 0 {
+		return ErrRunningInstances
+	}
+	return DeleteRecursive(cluster.conn, consumerGroupPath(group))
+}
+
+// CommitOffset records the offset a consumer group resumes consuming a partition from,
+// like kazoo's Consumergroup.CommitOffset
+func (cluster *Cluster) CommitOffset(group, topic string, partition int32, offset int64) error {
+	node := fmt.Sprintf("%s/offsets/%s/%d", consumerGroupPath(group), topic, partition)
+	return cluster.createOrUpdate(node, []byte(strconv.FormatInt(offset, 10)))
+}
+
+// ResetOffsets deletes the offsets a consumer group has committed for a topic, like kazoo's
+// Consumergroup.ResetOffsets does for every topic. It is not an error if there are none.
+func (cluster *Cluster) ResetOffsets(group, topic string) error {
+	return DeleteRecursive(cluster.conn, consumerGroupPath(group)+"/offsets/"+topic)
+}
+
+func consumerGroupPath(group string) string {
+	return "/consumers/" + group
+}
@@ -0,0 +1,57 @@
+package zookeeper_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("Consumer groups", func() {
+	var conn zookeeper.Conn
+	var cluster *zookeeper.Cluster
+
+	BeforeEach(func() {
+		var err error
+		conn, err = zookeeper.NewMemoryStore().Connect()
+		Expect(err).NotTo(HaveOccurred())
+		cluster = zookeeper.NewCluster(conn)
+		Expect(conn.Create("/consumers/group/offsets/topic/0", []byte("42"))).To(Succeed())
+	})
+
+	It("deletes a group and its offsets", func() {
+		Expect(cluster.DeleteConsumerGroup("group")).To(Succeed())
+		Expect(conn.Exists("/consumers/group")).To(BeFalse())
+		Expect(cluster.DeleteConsumerGroup("group")).To(Succeed())
+	})
+
+	It("refuses to delete a group with members", func() {
+		Expect(conn.Create("/consumers/group/ids/member", []byte("{}"))).To(Succeed())
+		Expect(cluster.ConsumerGroupMembers("group")).To(Equal([]string{"member"}))
+		Expect(cluster.DeleteConsumerGroup("group")).To(Equal(zookeeper.ErrRunningInstances))
+		Expect(conn.Exists("/consumers/group/offsets/topic/0")).To(BeTrue())
+	})
+
+	It("lists groups and their committed offsets", func() {
+		Expect(conn.Create("/consumers/group/offsets/topic/1", []byte("7"))).To(Succeed())
+		Expect(conn.Create("/consumers/other/ids/member", []byte("{}"))).To(Succeed())
+		Expect(cluster.ConsumerGroups()).To(ConsistOf("group", "other"))
+		Expect(cluster.ConsumerGroupOffsets("group")).To(Equal(map[string]map[int32]int64{"topic": {0: 42, 1: 7}}))
+		Expect(cluster.ConsumerGroupOffsets("other")).To(BeEmpty())
+	})
+
+	It("commits and resets offsets", func() {
+		Expect(cluster.CommitOffset("group", "topic", 0, 7)).To(Succeed())
+		Expect(cluster.CommitOffset("group", "topic", 1, 3)).To(Succeed())
+		Expect(cluster.ConsumerGroupOffsets("group")).To(Equal(map[string]map[int32]int64{"topic": {0: 7, 1: 3}}))
+		Expect(cluster.ResetOffsets("group", "topic")).To(Succeed())
+		Expect(cluster.ConsumerGroupOffsets("group")).To(BeEmpty())
+		Expect(cluster.ResetOffsets("group", "topic")).To(Succeed())
+	})
+
+	It("finds the leader of each partition", func() {
+		Expect(conn.Create("/brokers/topics/topic", []byte(`{"version":1,"partitions":{"0":[1,2],"1":[2,1]}}`))).To(Succeed())
+		Expect(conn.Create("/brokers/topics/topic/partitions/1/state", []byte(`{"leader":1,"isr":[1]}`))).To(Succeed())
+		Expect(cluster.PartitionLeaders("topic")).To(Equal(map[int32]int32{0: 1, 1: 1}))
+	})
+})
@@ -0,0 +1,114 @@
+package zookeeper
+
+import "context"
+
+// ContextConnector returns a Connector whose sessions refuse every operation once ctx is done,
+// with the error of ctx, so that a request that is cancelled or times out stops at its next
+// ZooKeeper operation. The operations themselves are not interrupted.
+func ContextConnector(ctx context.Context, connect Connector) Connector {
+	return func() (Conn, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		conn, err := connect()
+		if err != nil {
+			return nil, err
+		}
+		return &contextConn{ctx: ctx, conn: conn}, nil
+	}
+}
+
+// contextConn is a Conn that checks its context before each operation
+type contextConn struct {
+	ctx  context.Context
+	conn Conn
+}
+
+func (c *contextConn) Exists(path string) (bool, error) {
+	if err := c.ctx.Err(); err != nil {
+		return false, err
+	}
+	return c.conn.Exists(path)
+}
+
+func (c *contextConn) Get(path string) ([]byte, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.conn.Get(path)
+}
+
+func (c *contextConn) Children(path string) ([]string, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.conn.Children(path)
+}
+
+func (c *contextConn) ExistsW(path string) (bool, <-chan Event, error) {
+	if err := c.ctx.Err(); err != nil {
+		return false, nil, err
+	}
+	return c.conn.ExistsW(path)
+}
+
+func (c *contextConn) ChildrenW(path string) ([]string, <-chan Event, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return c.conn.ChildrenW(path)
+}
+
+func (c *contextConn) Create(path string, data []byte) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.conn.Create(path, data)
+}
+
+func (c *contextConn) CreateSequential(path string, data []byte) (string, error) {
+	if err := c.ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.conn.CreateSequential(path, data)
+}
+
+func (c *contextConn) Set(path string, data []byte) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.conn.Set(path, data)
+}
+
+func (c *contextConn) GetVersion(path string) ([]byte, int32, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	return c.conn.GetVersion(path)
+}
+
+func (c *contextConn) SetVersion(path string, data []byte, version int32) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.conn.SetVersion(path, data, version)
+}
+
+func (c *contextConn) DeleteVersion(path string, version int32) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.conn.DeleteVersion(path, version)
+}
+
+func (c *contextConn) Delete(path string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.conn.Delete(path)
+}
+
+// Close closes the session even once the context is done
+func (c *contextConn) Close() error {
+	return c.conn.Close()
+}
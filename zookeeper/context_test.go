@@ -0,0 +1,35 @@
+package zookeeper_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("ContextConnector", func() {
+	It("refuses the operations of its sessions once the context is cancelled", func() {
+		store := zookeeper.NewMemoryStore()
+		ctx, cancel := context.WithCancel(context.Background())
+		connect := zookeeper.ContextConnector(ctx, store.Connect)
+
+		conn, err := connect()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Create("/before", []byte("data"))).To(Succeed())
+
+		cancel()
+		Expect(conn.Create("/after", []byte("data"))).To(Equal(context.Canceled))
+		_, err = conn.Get("/before")
+		Expect(err).To(Equal(context.Canceled))
+		Expect(conn.Close()).To(Succeed())
+		_, err = connect()
+		Expect(err).To(Equal(context.Canceled))
+
+		conn, err = store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Exists("/before")).To(BeTrue())
+		Expect(conn.Exists("/after")).To(BeFalse())
+	})
+})
@@ -0,0 +1,377 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// kafkaRootPaths are the persistent znodes a Kafka cluster creates on startup
+var kafkaRootPaths = []string{
+	"/brokers/ids",
+	"/brokers/topics",
+	"/config/topics",
+	"/config/clients",
+	"/config/users",
+	"/config/changes",
+	"/admin/delete_topics",
+}
+
+// MemoryStore is an in-memory ZooKeeper tree shared by all of its connections.
+// It is seeded with the znodes of an empty Kafka cluster, supports one-shot
+// watches like ZooKeeper, and can stand in for the Kafka controller that
+// completes topic deletions. It allows the broker to be exercised in tests
+// without a live ensemble.
+type MemoryStore struct {
+	mutex          sync.Mutex
+	nodes          map[string][]byte
+	versions       map[string]int32
+	errors         map[string]error
+	dataWatches    map[string][]chan Event
+	childWatches   map[string][]chan Event
+	sequence       int
+	stopController chan struct{}
+}
+
+// NewMemoryStore creates a MemoryStore containing an empty Kafka cluster
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		nodes:        map[string][]byte{"/": nil},
+		versions:     map[string]int32{},
+		errors:       map[string]error{},
+		dataWatches:  map[string][]chan Event{},
+		childWatches: map[string][]chan Event{},
+	}
+	for _, node := range kafkaRootPaths {
+		store.create(node, nil)
+	}
+	return store
+}
+
+// Connect opens a new session against the store; it satisfies Connector
+func (store *MemoryStore) Connect() (Conn, error) {
+	return &memoryConn{store: store}, nil
+}
+
+// RegisterBroker adds a Kafka broker to /brokers/ids, as a live broker would on startup
+func (store *MemoryStore) RegisterBroker(id int32, host string, port int) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"host": host,
+		"port": port,
+	})
+	if err != nil {
+		return err
+	}
+	conn, _ := store.Connect()
+	return conn.Create(fmt.Sprintf("/brokers/ids/%d", id), data)
+}
+
+// SetError makes every operation on the znode at node fail with err.
+// A nil err clears it.
+func (store *MemoryStore) SetError(node string, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err == nil {
+		delete(store.errors, node)
+	} else {
+		store.errors[node] = err
+	}
+}
+
+// CompleteTopicDeletions does what the Kafka controller does for every topic
+// marked for deletion in /admin/delete_topics: it removes the topic's metadata
+// and configuration, then the deletion marker. It returns the deleted topics.
+func (store *MemoryStore) CompleteTopicDeletions() []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	deleted := []string{}
+	for _, topic := range store.children("/admin/delete_topics") {
+		store.deleteRecursive(topicPath(topic))
+		store.deleteRecursive(topicConfigPath(topic))
+		store.deleteRecursive("/admin/delete_topics/" + topic)
+		deleted = append(deleted, topic)
+	}
+	return deleted
+}
+
+// StartController watches /admin/delete_topics and completes each topic
+// deletion as soon as it is requested, until StopController is called.
+func (store *MemoryStore) StartController() {
+	store.mutex.Lock()
+	stop := make(chan struct{})
+	store.stopController = stop
+	store.mutex.Unlock()
+
+	conn, _ := store.Connect()
+	go func() {
+		for {
+			store.CompleteTopicDeletions()
+			_, events, err := conn.ChildrenW("/admin/delete_topics")
+			if err != nil {
+				return
+			}
+			select {
+			case <-events:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopController stops the controller started by StartController
+func (store *MemoryStore) StopController() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.stopController != nil {
+		close(store.stopController)
+		store.stopController = nil
+	}
+}
+
+func (store *MemoryStore) exists(node string) bool {
+	_, ok := store.nodes[node]
+	return ok
+}
+
+func (store *MemoryStore) children(node string) []string {
+	prefix := strings.TrimSuffix(node, "/") + "/"
+	children := []string{}
+	for existing := range store.nodes {
+		if existing != "/" && strings.HasPrefix(existing, prefix) && !strings.Contains(existing[len(prefix):], "/") {
+			children = append(children, existing[len(prefix):])
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// create adds node, and any missing parents, firing watches as it goes
+func (store *MemoryStore) create(node string, data []byte) {
+	if parent := path.Dir(node); !store.exists(parent) {
+		store.create(parent, nil)
+	}
+	store.nodes[node] = data
+	store.fire(store.dataWatches, node, EventNodeCreated)
+	store.fire(store.childWatches, path.Dir(node), EventNodeChildrenChanged)
+}
+
+func (store *MemoryStore) delete(node string) {
+	delete(store.nodes, node)
+	delete(store.versions, node)
+	store.fire(store.dataWatches, node, EventNodeDeleted)
+	store.fire(store.childWatches, node, EventNodeDeleted)
+	store.fire(store.childWatches, path.Dir(node), EventNodeChildrenChanged)
+}
+
+func (store *MemoryStore) deleteRecursive(node string) {
+	if !store.exists(node) {
+		return
+	}
+	for _, child := range store.children(node) {
+		store.deleteRecursive(path.Join(node, child))
+	}
+	store.delete(node)
+}
+
+// watch registers a one-shot watch on node
+func (store *MemoryStore) watch(watches map[string][]chan Event, node string) <-chan Event {
+	events := make(chan Event, 1)
+	watches[node] = append(watches[node], events)
+	return events
+}
+
+// fire triggers, and removes, all watches of one kind on node
+func (store *MemoryStore) fire(watches map[string][]chan Event, node string, eventType EventType) {
+	for _, events := range watches[node] {
+		events <- Event{Type: eventType, Path: node}
+	}
+	delete(watches, node)
+}
+
+// memoryConn is a Conn onto a MemoryStore
+type memoryConn struct {
+	store  *MemoryStore
+	mutex  sync.Mutex
+	closed bool
+}
+
+// lock locks the store for an operation on node. If the operation must fail,
+// the store is left unlocked and the error is returned.
+func (c *memoryConn) lock(node string) error {
+	c.mutex.Lock()
+	closed := c.closed
+	c.mutex.Unlock()
+	if closed {
+		return ErrConnectionClosed
+	}
+	c.store.mutex.Lock()
+	if err := c.store.errors[node]; err != nil {
+		c.store.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (c *memoryConn) unlock() {
+	c.store.mutex.Unlock()
+}
+
+func (c *memoryConn) Exists(node string) (bool, error) {
+	if err := c.lock(node); err != nil {
+		return false, err
+	}
+	defer c.unlock()
+	return c.store.exists(node), nil
+}
+
+func (c *memoryConn) ExistsW(node string) (bool, <-chan Event, error) {
+	if err := c.lock(node); err != nil {
+		return false, nil, err
+	}
+	defer c.unlock()
+	return c.store.exists(node), c.store.watch(c.store.dataWatches, node), nil
+}
+
+func (c *memoryConn) Get(node string) ([]byte, error) {
+	if err := c.lock(node); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+	data, ok := c.store.nodes[node]
+	if !ok {
+		return nil, ErrNoNode
+	}
+	return data, nil
+}
+
+func (c *memoryConn) Children(node string) ([]string, error) {
+	if err := c.lock(node); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+	if !c.store.exists(node) {
+		return nil, ErrNoNode
+	}
+	return c.store.children(node), nil
+}
+
+func (c *memoryConn) ChildrenW(node string) ([]string, <-chan Event, error) {
+	if err := c.lock(node); err != nil {
+		return nil, nil, err
+	}
+	defer c.unlock()
+	if !c.store.exists(node) {
+		return nil, nil, ErrNoNode
+	}
+	return c.store.children(node), c.store.watch(c.store.childWatches, node), nil
+}
+
+func (c *memoryConn) Create(node string, data []byte) error {
+	if err := c.lock(node); err != nil {
+		return err
+	}
+	defer c.unlock()
+	if c.store.exists(node) {
+		return ErrNodeExists
+	}
+	c.store.create(node, data)
+	return nil
+}
+
+func (c *memoryConn) CreateSequential(node string, data []byte) (string, error) {
+	if err := c.lock(node); err != nil {
+		return "", err
+	}
+	defer c.unlock()
+	created := fmt.Sprintf("%s%010d", node, c.store.sequence)
+	c.store.sequence++
+	c.store.create(created, data)
+	return created, nil
+}
+
+func (c *memoryConn) Set(node string, data []byte) error {
+	if err := c.lock(node); err != nil {
+		return err
+	}
+	defer c.unlock()
+	if !c.store.exists(node) {
+		return ErrNoNode
+	}
+	c.store.nodes[node] = data
+	c.store.versions[node]++
+	c.store.fire(c.store.dataWatches, node, EventNodeDataChanged)
+	return nil
+}
+
+func (c *memoryConn) GetVersion(node string) ([]byte, int32, error) {
+	if err := c.lock(node); err != nil {
+		return nil, 0, err
+	}
+	defer c.unlock()
+	data, ok := c.store.nodes[node]
+	if !ok {
+		return nil, 0, ErrNoNode
+	}
+	return data, c.store.versions[node], nil
+}
+
+func (c *memoryConn) SetVersion(node string, data []byte, version int32) error {
+	if err := c.lock(node); err != nil {
+		return err
+	}
+	defer c.unlock()
+	if !c.store.exists(node) {
+		return ErrNoNode
+	}
+	if c.store.versions[node] != version {
+		return ErrBadVersion
+	}
+	c.store.nodes[node] = data
+	c.store.versions[node]++
+	c.store.fire(c.store.dataWatches, node, EventNodeDataChanged)
+	return nil
+}
+
+func (c *memoryConn) Delete(node string) error {
+	if err := c.lock(node); err != nil {
+		return err
+	}
+	defer c.unlock()
+	if !c.store.exists(node) {
+		return ErrNoNode
+	}
+	if len(c.store.children(node)) > 0 {
+		return ErrNotEmpty
+	}
+	c.store.delete(node)
+	return nil
+}
+
+func (c *memoryConn) DeleteVersion(node string, version int32) error {
+	if err := c.lock(node); err != nil {
+		return err
+	}
+	defer c.unlock()
+	if !c.store.exists(node) {
+		return ErrNoNode
+	}
+	if c.store.versions[node] != version {
+		return ErrBadVersion
+	}
+	if len(c.store.children(node)) > 0 {
+		return ErrNotEmpty
+	}
+	c.store.delete(node)
+	return nil
+}
+
+func (c *memoryConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	return nil
+}
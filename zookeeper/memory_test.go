@@ -0,0 +1,158 @@
+package zookeeper_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("MemoryStore", func() {
+	var store *zookeeper.MemoryStore
+	var conn zookeeper.Conn
+
+	BeforeEach(func() {
+		store = zookeeper.NewMemoryStore()
+		var err error
+		conn, err = store.Connect()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("is seeded with the znodes of an empty Kafka cluster", func() {
+		Expect(conn.Children("/brokers")).To(ConsistOf("ids", "topics"))
+		Expect(conn.Children("/config")).To(ConsistOf("topics", "clients", "users", "changes"))
+		Expect(conn.Children("/admin/delete_topics")).To(BeEmpty())
+	})
+
+	It("registers brokers", func() {
+		Expect(store.RegisterBroker(1, "kafka-1", 9092)).To(Succeed())
+		Expect(zookeeper.NewCluster(conn).BrokerList()).To(Equal([]string{"kafka-1:9092"}))
+	})
+
+	Describe("znodes", func() {
+		It("creates missing parents", func() {
+			Expect(conn.Create("/a/b/c", []byte("data"))).To(Succeed())
+			Expect(conn.Exists("/a/b")).To(BeTrue())
+			Expect(conn.Get("/a/b/c")).To(Equal([]byte("data")))
+		})
+
+		It("refuses to create an existing znode", func() {
+			Expect(conn.Create("/a", nil)).To(Succeed())
+			Expect(conn.Create("/a", nil)).To(Equal(zookeeper.ErrNodeExists))
+		})
+
+		It("refuses to set or delete a missing znode", func() {
+			Expect(conn.Set("/a", nil)).To(Equal(zookeeper.ErrNoNode))
+			Expect(conn.Delete("/a")).To(Equal(zookeeper.ErrNoNode))
+			_, err := conn.Get("/a")
+			Expect(err).To(Equal(zookeeper.ErrNoNode))
+		})
+
+		It("refuses to delete a znode with children", func() {
+			Expect(conn.Create("/a/b", nil)).To(Succeed())
+			Expect(conn.Delete("/a")).To(Equal(zookeeper.ErrNotEmpty))
+		})
+
+		It("refuses to set a znode changed since its version was read", func() {
+			Expect(conn.Create("/a", []byte("one"))).To(Succeed())
+			data, version, err := conn.GetVersion("/a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal([]byte("one")))
+			Expect(conn.Set("/a", []byte("two"))).To(Succeed())
+			Expect(conn.SetVersion("/a", []byte("three"), version)).To(Equal(zookeeper.ErrBadVersion))
+
+			_, version, err = conn.GetVersion("/a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.SetVersion("/a", []byte("three"), version)).To(Succeed())
+			Expect(conn.Get("/a")).To(Equal([]byte("three")))
+			Expect(conn.DeleteVersion("/a", version)).To(Equal(zookeeper.ErrBadVersion))
+			Expect(conn.DeleteVersion("/a", version+1)).To(Succeed())
+		})
+
+		It("is shared between connections", func() {
+			other, _ := store.Connect()
+			Expect(other.Create("/a", []byte("data"))).To(Succeed())
+			Expect(conn.Get("/a")).To(Equal([]byte("data")))
+		})
+
+		It("cannot be used once the connection is closed", func() {
+			Expect(conn.Close()).To(Succeed())
+			_, err := conn.Exists("/")
+			Expect(err).To(Equal(zookeeper.ErrConnectionClosed))
+		})
+	})
+
+	Describe("watches", func() {
+		It("fires a child watch once when a child is created", func() {
+			_, events, err := conn.ChildrenW("/brokers/topics")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(conn.Create("/brokers/topics/a", nil)).To(Succeed())
+			Expect(conn.Create("/brokers/topics/b", nil)).To(Succeed())
+
+			Eventually(events).Should(Receive(Equal(zookeeper.Event{Type: zookeeper.EventNodeChildrenChanged, Path: "/brokers/topics"})))
+			Consistently(events).ShouldNot(Receive())
+		})
+
+		It("fires an exists watch when the znode is created, changed or deleted", func() {
+			exists, events, err := conn.ExistsW("/a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+			Expect(conn.Create("/a", nil)).To(Succeed())
+			Eventually(events).Should(Receive(Equal(zookeeper.Event{Type: zookeeper.EventNodeCreated, Path: "/a"})))
+
+			_, events, _ = conn.ExistsW("/a")
+			Expect(conn.Set("/a", []byte("data"))).To(Succeed())
+			Eventually(events).Should(Receive(Equal(zookeeper.Event{Type: zookeeper.EventNodeDataChanged, Path: "/a"})))
+
+			_, events, _ = conn.ExistsW("/a")
+			Expect(conn.Delete("/a")).To(Succeed())
+			Eventually(events).Should(Receive(Equal(zookeeper.Event{Type: zookeeper.EventNodeDeleted, Path: "/a"})))
+		})
+	})
+
+	Describe("errors", func() {
+		It("fails operations on a znode until the error is cleared", func() {
+			store.SetError("/brokers/topics", errors.New("zk unavailable"))
+			_, err := conn.Children("/brokers/topics")
+			Expect(err).To(MatchError("zk unavailable"))
+
+			store.SetError("/brokers/topics", nil)
+			Expect(conn.Children("/brokers/topics")).To(BeEmpty())
+		})
+	})
+
+	Describe("topic deletion", func() {
+		var cluster *zookeeper.Cluster
+
+		BeforeEach(func() {
+			Expect(store.RegisterBroker(0, "localhost", 9092)).To(Succeed())
+			cluster = zookeeper.NewCluster(conn)
+			Expect(cluster.CreateTopic("orders", 1, 1, map[string]string{"retention.ms": "1000"})).To(Succeed())
+			Expect(cluster.DeleteTopic("orders")).To(Succeed())
+		})
+
+		It("leaves the topic in place until the deletion is completed", func() {
+			Expect(cluster.TopicExists("orders")).To(BeTrue())
+			Expect(cluster.DeleteTopic("orders")).To(MatchError("Topic is already marked for deletion"))
+
+			Expect(store.CompleteTopicDeletions()).To(ConsistOf("orders"))
+			Expect(cluster.TopicExists("orders")).To(BeFalse())
+			Expect(conn.Exists("/config/topics/orders")).To(BeFalse())
+			Expect(conn.Children("/admin/delete_topics")).To(BeEmpty())
+		})
+
+		It("completes deletions as they are requested while the controller is running", func() {
+			store.StartController()
+			defer store.StopController()
+
+			Eventually(func() (bool, error) { return cluster.TopicExists("orders") }).Should(BeFalse())
+
+			Expect(cluster.CreateTopic("payments", 1, 1, nil)).To(Succeed())
+			Expect(cluster.DeleteTopic("payments")).To(Succeed())
+			Eventually(func() (bool, error) { return cluster.TopicExists("payments") }).Should(BeFalse())
+		})
+	})
+})
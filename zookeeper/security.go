@@ -0,0 +1,72 @@
+package zookeeper
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// PrivateRoot is where the broker keeps its own records. When the broker authenticates
+// with a digest, the znodes below it are only accessible to the broker.
+const PrivateRoot = "/kafka-service-broker"
+
+// Security is how the broker authenticates to ZooKeeper, and protects the znodes it creates.
+// The zero value connects in plaintext, without authentication, and creates world-writable znodes.
+type Security struct {
+	// DigestUsername and DigestPassword authenticate the session with the digest scheme. When they are set,
+	// the znodes the broker creates grant it all permissions, and only read permission to everyone else.
+	DigestUsername string
+	DigestPassword string
+	// KafkaIDs are the identities of the Kafka brokers, e.g. "sasl:kafka", which are granted all permissions
+	// on the znodes the broker creates outside PrivateRoot, such as topic assignments and configuration
+	KafkaIDs []string
+	// TLS encrypts the connection when it is not nil
+	TLS *tls.Config
+}
+
+// ParseACLIDs parses a comma separated list of scheme:id identities, e.g. "sasl:kafka,digest:kafka:<hash>"
+func ParseACLIDs(ids string) ([]string, error) {
+	result := []string{}
+	for _, id := range strings.Split(ids, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if parts := strings.SplitN(id, ":", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("ZooKeeper identity '%s' must be scheme:id, e.g. sasl:kafka", id)
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+// authenticated returns true if the session authenticates with a digest
+func (security Security) authenticated() bool {
+	return security.DigestUsername != ""
+}
+
+// ACLs returns the ACLs of a new znode; node excludes the chroot
+func (security Security) ACLs(node string) []zk.ACL {
+	if !security.authenticated() {
+		return zk.WorldACL(zk.PermAll)
+	}
+	acls := zk.DigestACL(zk.PermAll, security.DigestUsername, security.DigestPassword)
+	if node == PrivateRoot || strings.HasPrefix(node, PrivateRoot+"/") {
+		return acls
+	}
+	acls = append(acls, zk.WorldACL(zk.PermRead)...)
+	for _, id := range security.KafkaIDs {
+		parts := strings.SplitN(id, ":", 2)
+		acls = append(acls, zk.ACL{Perms: zk.PermAll, Scheme: parts[0], ID: parts[1]})
+	}
+	return acls
+}
+
+// dial opens the TLS connections of a session
+func (security Security) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, address, security.TLS)
+}
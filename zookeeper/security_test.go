@@ -0,0 +1,41 @@
+package zookeeper_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/samuel/go-zookeeper/zk"
+
+	"github.com/starkandwayne/kafka-service-broker/zookeeper"
+)
+
+var _ = Describe("Security", func() {
+	It("creates world-writable znodes without authentication", func() {
+		Expect(zookeeper.Security{}.ACLs("/brokers/topics/topic")).To(Equal(zk.WorldACL(zk.PermAll)))
+	})
+
+	Context("with digest authentication", func() {
+		security := zookeeper.Security{DigestUsername: "broker", DigestPassword: "secret", KafkaIDs: []string{"sasl:kafka"}}
+		broker := zk.DigestACL(zk.PermAll, "broker", "secret")[0]
+
+		It("lets everyone read the znodes Kafka uses, and the Kafka brokers change them", func() {
+			Expect(security.ACLs("/config/topics/topic")).To(Equal([]zk.ACL{
+				broker,
+				{Perms: zk.PermRead, Scheme: "world", ID: "anyone"},
+				{Perms: zk.PermAll, Scheme: "sasl", ID: "kafka"},
+			}))
+		})
+
+		It("keeps the broker's records private", func() {
+			Expect(security.ACLs(zookeeper.PrivateRoot)).To(Equal([]zk.ACL{broker}))
+			Expect(security.ACLs(zookeeper.PrivateRoot + "/instances/instanceID")).To(Equal([]zk.ACL{broker}))
+			Expect(security.ACLs(zookeeper.PrivateRoot + "-other")).To(HaveLen(3))
+		})
+	})
+
+	It("parses the identities of the Kafka brokers", func() {
+		Expect(zookeeper.ParseACLIDs("sasl:kafka, digest:kafka:abc=")).To(Equal([]string{"sasl:kafka", "digest:kafka:abc="}))
+		Expect(zookeeper.ParseACLIDs("")).To(BeEmpty())
+		_, err := zookeeper.ParseACLIDs("kafka")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,13 @@
+package zookeeper_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestZookeeper(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Zookeeper Suite")
+}